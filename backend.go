@@ -1,19 +1,67 @@
 package main
 
 import (
+	"io"
+	"os"
+	"time"
+
 	ms "github.com/mmcnicol/message-store"
 )
 
 // Backend represents a server side application
 type Backend struct {
-	MessageStore MockableMessageStore
+	MessageStore     MockableMessageStore
+	Tracer           *Tracer
+	Health           *HealthMonitor
+	Offsets          *ConsumerOffsets
+	Personas         *PersonaDirectory
+	RegionOutages    *RegionOutages
+	Consolidator     *DocumentConsolidator
+	Schemas          *SchemaRegistry
+	Codecs           *TopicCodecs
+	Compression      *TopicCompression
+	Encryption       *FieldEncryption
+	Pseudonymiser    *Pseudonymiser
+	Consent          *ConsentService
+	Entitlements     *EntitlementService
+	Catalogue        *DocumentCatalogue
+	Population       *Population
+	ContentStore     *ContentStore
+	ContentAssembler *DocumentContentAssembler
+	Sessions         *UserSessions
+	Log              io.Writer // where the pipeline's progress is logged, replaceable to silence it without touching os.Stdout
 }
 
 // NewBackend creates a new instance of Backend
 func NewBackend() *Backend {
 
-	msgStore := ms.NewMessageStore()
+	schemas := embeddedSchemas
+	compression := NewTopicCompression()
+	encryption := NewFieldEncryption()
+	msgStore := newPipelineMessageStore(NewLockingMessageStore(ms.NewMessageStore()), schemas, encryption, compression)
 	return &Backend{
-		MessageStore: msgStore,
+		MessageStore:     msgStore,
+		Tracer:           NewTracer(SERVICE_NAME, nil),
+		Health:           NewHealthMonitor(30 * time.Second),
+		Offsets:          NewConsumerOffsets(),
+		RegionOutages:    NewRegionOutages(),
+		Consolidator:     NewDocumentConsolidator(time.Minute),
+		Schemas:          schemas,
+		Codecs:           NewTopicCodecs(JSONCodec{}),
+		Compression:      compression,
+		Encryption:       encryption,
+		Catalogue:        embeddedDocumentCatalogue,
+		ContentStore:     NewContentStore(CONTENT_STORE_DIRECTORY),
+		ContentAssembler: NewDocumentContentAssembler(time.Minute),
+		Sessions:         NewUserSessions(DEFAULT_SESSION_TTL),
+		Log:              os.Stdout,
 	}
 }
+
+// newPipelineMessageStore wraps a store in the layers every entry the pipeline saves passes through: it is validated against its
+// topic's schema, its fields are encrypted and its payload compressed before it is saved, and consumers are woken once it is.
+// the store passed in is expected to serialise saves, e.g. a LockingMessageStore
+func newPipelineMessageStore(store MockableMessageStore, schemas *SchemaRegistry, encryption *FieldEncryption, compression *TopicCompression) MockableMessageStore {
+
+	return NewNotifyingMessageStore(NewSchemaValidatingMessageStore(NewEncryptingMessageStore(NewCompressingMessageStore(store, compression), encryption), schemas))
+}
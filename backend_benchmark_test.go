@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// benchmarkSendAndProcess measures sending an event to a topic, then processing it with the topic's consumer
+func benchmarkSendAndProcess(b *testing.B, topic string, send func(backend *Backend, ctx context.Context)) {
+
+	replayHandler, ok := findReplayHandlerForTopic(topic)
+	if !ok {
+		b.Fatalf("no handler consumes topic '%s'", topic)
+	}
+
+	store := newMemoryMessageStore()
+	backend := NewBackend()
+	backend.MessageStore = store
+	// Discard the handlers' logging, which would otherwise dominate
+	backend.Log = io.Discard
+
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		send(backend, ctx)
+		entry, err := store.ReadEntry(topic, int64(i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		replayHandler.Process(backend, ctx, *entry)
+	}
+}
+
+func BenchmarkSystemAuditEvent(b *testing.B) {
+
+	benchmarkSendAndProcess(b, SYSTEM_AUDIT_EVENT_TOPIC, func(backend *Backend, ctx context.Context) {
+		backend.sendSystemAuditEvent(ctx, *NewSystemAuditEvent("jbloggs", "login attempt"))
+	})
+}
+
+func BenchmarkUserLoginAttempt(b *testing.B) {
+
+	benchmarkSendAndProcess(b, USER_LOGIN_ATTEMPT_TOPIC, func(backend *Backend, ctx context.Context) {
+		backend.sendUserLoginAttempt(ctx, *NewUserLoginAttempt("jbloggs", "12345678"))
+	})
+}
+
+func BenchmarkUserLoginAttemptOutcome(b *testing.B) {
+
+	benchmarkSendAndProcess(b, USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, func(backend *Backend, ctx context.Context) {
+		backend.sendUserLoginAttemptOutcome(ctx, *NewUserLoginAttemptOutcome("jbloggs", true))
+	})
+}
+
+func BenchmarkUserSubjectAccessAttempt(b *testing.B) {
+
+	var session UserSession
+	benchmarkSendAndProcess(b, USER_SUBJECT_ACCESS_ATTEMPT_TOPIC, func(backend *Backend, ctx context.Context) {
+		if session.SessionIdentifier == "" {
+			// start the session the attempts are made within, so they are processed rather than rejected
+			session, _ = backend.startUserSession(ctx, "jbloggs")
+		}
+		backend.sendUserSubjectAccessAttempt(ctx, *NewUserSubjectAccessAttempt("jbloggs", "0101700000", session.SessionIdentifier))
+	})
+}
+
+func BenchmarkUserSubjectAccessAttemptOutcome(b *testing.B) {
+
+	benchmarkSendAndProcess(b, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, func(backend *Backend, ctx context.Context) {
+		backend.sendUserSubjectAccessAttemptOutcome(ctx, *NewUserSubjectAccessAttemptOutcome("jbloggs", "0101700000", true))
+	})
+}
+
+func BenchmarkSubjectRegionDocumentRequest(b *testing.B) {
+
+	benchmarkSendAndProcess(b, SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC, func(backend *Backend, ctx context.Context) {
+		backend.sendSubjectRegionDocumentRequest(ctx, *NewSubjectRegionDocumentRequest("0101700000", LOTHIAN_REGION, "jbloggs"))
+	})
+}
+
+func BenchmarkSubjectRegionDocumentResponse(b *testing.B) {
+
+	region := 0
+	var traceCtx context.Context
+	benchmarkSendAndProcess(b, SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC, func(backend *Backend, ctx context.Context) {
+		// cycle through the regions within a trace, so every fourteenth response completes a consolidation
+		if region == 0 {
+			traceCtx, _ = backend.Tracer.Start(ctx, "benchmark", SPAN_KIND_INTERNAL)
+		}
+		documents := backend.Catalogue.Documents("0101700000", region, time.Now())
+		backend.sendSubjectRegionDocumentResponse(traceCtx, *NewSubjectRegionDocumentResponse("0101700000", documents, "jbloggs", region, nil))
+		region = (region + 1) % len(regionNames)
+	})
+}
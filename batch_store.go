@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// BatchMessageStore is implemented by message stores which can save and read several entries of a topic at once
+type BatchMessageStore interface {
+	MockableMessageStore
+	// SaveEntries saves entries to a topic as one batch, returning the offset of the first
+	SaveEntries(topic string, entries []ms.Entry) (int64, error)
+	// ReadEntries reads up to max entries of a topic, starting at offset, returning none at the end of the topic
+	ReadEntries(topic string, offset int64, max int) ([]ms.Entry, error)
+}
+
+// asBatchMessageStore returns store as a BatchMessageStore, shimming it when it only supports single entries
+func asBatchMessageStore(store MockableMessageStore) BatchMessageStore {
+
+	if batchStore, ok := store.(BatchMessageStore); ok {
+		return batchStore
+	}
+	return &singleEntryBatchShim{MockableMessageStore: store}
+}
+
+// singleEntryBatchShim implements the batch operations one entry at a time, for stores which only support single entries.
+// a batch is not atomic: a failure part way through leaves the entries before it saved, and other writers can interleave
+type singleEntryBatchShim struct {
+	MockableMessageStore
+}
+
+// SaveEntries saves each entry in turn
+func (s *singleEntryBatchShim) SaveEntries(topic string, entries []ms.Entry) (int64, error) {
+
+	return saveEntriesOneByOne(s.MockableMessageStore, topic, entries)
+}
+
+// ReadEntries reads each entry in turn
+func (s *singleEntryBatchShim) ReadEntries(topic string, offset int64, max int) ([]ms.Entry, error) {
+
+	return readEntriesOneByOne(s.MockableMessageStore, topic, offset, max)
+}
+
+// saveEntriesOneByOne saves each entry in turn, returning the offset of the first
+func saveEntriesOneByOne(store MockableMessageStore, topic string, entries []ms.Entry) (int64, error) {
+
+	first := int64(-1)
+	for i, entry := range entries {
+		offset, err := store.SaveEntry(topic, entry)
+		if err != nil {
+			return first, fmt.Errorf("SaveEntry for topic '%s' failed after %d of %d entries: %v", topic, i, len(entries), err)
+		}
+		if i == 0 {
+			first = offset
+		}
+	}
+	return first, nil
+}
+
+// readEntriesOneByOne reads each entry in turn, stopping at the end of the topic, or returning the error of a read which failed
+func readEntriesOneByOne(store MockableMessageStore, topic string, offset int64, max int) ([]ms.Entry, error) {
+
+	var entries []ms.Entry
+	for len(entries) < max {
+		// PollForNextEntry tells the end of the topic, where it returns no entry, apart from a failed read
+		entry, err := store.PollForNextEntry(topic, offset+int64(len(entries))-1, 0)
+		if err != nil {
+			return nil, fmt.Errorf("reading topic '%s' failed at offset %d: %v", topic, offset+int64(len(entries)), err)
+		}
+		if entry == nil {
+			break
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// LockingMessageStore serialises every operation on a store which is not safe for concurrent use, so no other user of this store
+// can interleave its entries with a batch or read the batch while it is being saved. a batch is still not atomic: the store cannot
+// take back an entry once appended, so a failure part way through leaves the entries before it saved, as the error reports
+type LockingMessageStore struct {
+	mu    sync.Mutex
+	store MockableMessageStore
+}
+
+// NewLockingMessageStore creates a new instance of LockingMessageStore
+func NewLockingMessageStore(store MockableMessageStore) *LockingMessageStore {
+
+	return &LockingMessageStore{
+		store: store,
+	}
+}
+
+// SaveEntry saves an entry
+func (s *LockingMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.SaveEntry(topic, entry)
+}
+
+// ReadEntry reads an entry
+func (s *LockingMessageStore) ReadEntry(topic string, offset int64) (*ms.Entry, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.ReadEntry(topic, offset)
+}
+
+// PollForNextEntry waits for the poll duration without holding the lock, then reads the next entry
+func (s *LockingMessageStore) PollForNextEntry(topic string, offset int64, pollDuration time.Duration) (*ms.Entry, error) {
+
+	time.Sleep(pollDuration)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.PollForNextEntry(topic, offset, 0)
+}
+
+// SaveEntries saves entries as one batch
+func (s *LockingMessageStore) SaveEntries(topic string, entries []ms.Entry) (int64, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return asBatchMessageStore(s.store).SaveEntries(topic, entries)
+}
+
+// ReadEntries reads up to max entries
+func (s *LockingMessageStore) ReadEntries(topic string, offset int64, max int) ([]ms.Entry, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return asBatchMessageStore(s.store).ReadEntries(topic, offset, max)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// brokenMessageStore fails to read the entry at one offset, and fails every save after a number of them, as a store with a
+// damaged file or a disk filling up might
+type brokenMessageStore struct {
+	*memoryMessageStore
+	unreadable int64
+	saves      int
+}
+
+// PollForNextEntry fails when the next entry is the unreadable one
+func (s *brokenMessageStore) PollForNextEntry(topic string, offset int64, pollDuration time.Duration) (*ms.Entry, error) {
+
+	if offset+1 == s.unreadable {
+		return nil, fmt.Errorf("entry corrupt")
+	}
+	return s.memoryMessageStore.PollForNextEntry(topic, offset, pollDuration)
+}
+
+// SaveEntry fails once the number of saves has been made
+func (s *brokenMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	if s.saves == 0 {
+		return 0, fmt.Errorf("disk full")
+	}
+	s.saves--
+	return s.memoryMessageStore.SaveEntry(topic, entry)
+}
+
+// TestReadEntriesOneByOne checks entries are read up to max or the end of the topic, and a read which fails is returned
+// rather than taken for the end of the topic
+func TestReadEntriesOneByOne(t *testing.T) {
+
+	memory := newMemoryMessageStore()
+	for i := 0; i < 5; i++ {
+		if _, err := memory.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, ms.Entry{Value: []byte(fmt.Sprint(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		store   MockableMessageStore
+		offset  int64
+		max     int
+		entries []string
+		err     string
+	}{
+		{"up to max", memory, 0, 3, []string{"0", "1", "2"}, ""},
+		{"up to the end", memory, 3, 10, []string{"3", "4"}, ""},
+		{"at the end", memory, 5, 10, nil, ""},
+		{"empty topic", newMemoryMessageStore(), 0, 10, nil, ""},
+		{"first read fails", &unreachableMessageStore{memoryMessageStore: memory}, 0, 10, nil, "failed at offset 0: index unreadable"},
+		{"later read fails", &brokenMessageStore{memoryMessageStore: memory, unreadable: 2}, 0, 10, nil, "failed at offset 2: entry corrupt"},
+		{"read before the failure", &brokenMessageStore{memoryMessageStore: memory, unreadable: 2}, 0, 2, []string{"0", "1"}, ""},
+	}
+	for _, test := range tests {
+		entries, err := readEntriesOneByOne(test.store, SYSTEM_AUDIT_EVENT_TOPIC, test.offset, test.max)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+		var values []string
+		for _, entry := range entries {
+			values = append(values, string(entry.Value))
+		}
+		if strings.Join(values, ",") != strings.Join(test.entries, ",") {
+			t.Errorf("%s: read %v, want %v", test.name, values, test.entries)
+		}
+	}
+}
+
+// TestLockingMessageStoreBatches checks no entry saved concurrently is interleaved with a batch, and a batch which fails part way
+// reports how many of its entries were saved, as they are not taken back
+func TestLockingMessageStoreBatches(t *testing.T) {
+
+	memory := newMemoryMessageStore()
+	store := NewLockingMessageStore(memory)
+	batch := make([]ms.Entry, 100)
+	for i := range batch {
+		batch[i] = ms.Entry{Value: []byte("batch")}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, ms.Entry{Value: []byte("single")}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	first, err := store.SaveEntries(SYSTEM_AUDIT_EVENT_TOPIC, batch)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := store.ReadEntries(SYSTEM_AUDIT_EVENT_TOPIC, first, len(batch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range entries {
+		if string(entry.Value) != "batch" {
+			t.Fatalf("entry %d of the batch is %q, want the batch saved without interleaving", i, entry.Value)
+		}
+	}
+	if len(entries) != len(batch) {
+		t.Errorf("read %d entries of the batch, want %d", len(entries), len(batch))
+	}
+
+	broken := &brokenMessageStore{memoryMessageStore: newMemoryMessageStore(), unreadable: -1, saves: 2}
+	first, err = NewLockingMessageStore(broken).SaveEntries(SYSTEM_AUDIT_EVENT_TOPIC, batch[:3])
+	if err == nil || !strings.Contains(err.Error(), "after 2 of 3 entries: disk full") || first != 0 {
+		t.Errorf("first offset %d, error %v, want 0 and the entries saved before the failure reported", first, err)
+	}
+	if length := topicLength(broken, SYSTEM_AUDIT_EVENT_TOPIC); length != 2 {
+		t.Errorf("%d entries saved, want the 2 before the failure", length)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Define constants for payload content types
+const (
+	JSON_CONTENT_TYPE         = "application/json"
+	PROTOBUF_CONTENT_TYPE     = "application/x-protobuf"
+	MESSAGE_PACK_CONTENT_TYPE = "application/msgpack"
+)
+
+// Codec encodes payloads in one wire format, which is recorded in the content-type header of each entry
+type Codec interface {
+	Name() string
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// codecs returns every codec the application can write, and read
+func codecs() []Codec {
+
+	return []Codec{
+		JSONCodec{},
+		ProtobufCodec{},
+		MessagePackCodec{},
+	}
+}
+
+// findCodec returns the codec with the given name
+func findCodec(name string) (Codec, bool) {
+
+	for _, codec := range codecs() {
+		if codec.Name() == name {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// codecForContentType returns the codec of a content type. entries written before content types were recorded are JSON
+func codecForContentType(contentType string) (Codec, error) {
+
+	if contentType == "" {
+		return JSONCodec{}, nil
+	}
+	for _, codec := range codecs() {
+		if codec.ContentType() == contentType {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported content type '%s'", contentType)
+}
+
+// codecNames returns the name of every codec
+func codecNames() []string {
+
+	var names []string
+	for _, codec := range codecs() {
+		names = append(names, codec.Name())
+	}
+	return names
+}
+
+// JSONCodec encodes payloads as JSON, which is embedded in the message envelope as is
+type JSONCodec struct{}
+
+// Name returns the name of the codec
+func (JSONCodec) Name() string {
+
+	return "json"
+}
+
+// ContentType returns the content type of the codec
+func (JSONCodec) ContentType() string {
+
+	return JSON_CONTENT_TYPE
+}
+
+// Marshal encodes a payload
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes a payload
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+
+	return json.Unmarshal(data, v)
+}
+
+// TopicCodecs selects the codec each topic's payloads are written with, so the wire format can be changed one topic at a time
+type TopicCodecs struct {
+	Default Codec
+	Topics  map[string]Codec
+}
+
+// NewTopicCodecs creates a new instance of TopicCodecs, writing every topic with the default codec
+func NewTopicCodecs(defaultCodec Codec) *TopicCodecs {
+
+	return &TopicCodecs{
+		Default: defaultCodec,
+		Topics:  make(map[string]Codec),
+	}
+}
+
+// For returns the codec a topic's payloads are written with
+func (c *TopicCodecs) For(topic string) Codec {
+
+	if c == nil {
+		return JSONCodec{}
+	}
+	if codec, ok := c.Topics[topic]; ok {
+		return codec
+	}
+	return c.Default
+}
+
+// String describes the selection, as ParseTopicCodecs accepts it
+func (c *TopicCodecs) String() string {
+
+	parts := []string{c.Default.Name()}
+	topics := make([]string, 0, len(c.Topics))
+	for topic := range c.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		parts = append(parts, topic+"="+c.Topics[topic].Name())
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseTopicCodecs parses a comma separated list of a default codec name and topic=codec overrides,
+// e.g. "json,subject.region.document.response=protobuf"
+func ParseTopicCodecs(spec string) (*TopicCodecs, error) {
+
+	c := NewTopicCodecs(JSONCodec{})
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		topic, name, isOverride := strings.Cut(part, "=")
+		if !isOverride {
+			name = topic
+		}
+		codec, ok := findCodec(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown codec '%s', expected one of %s", name, strings.Join(codecNames(), ", "))
+		}
+		if !isOverride {
+			c.Default = codec
+			continue
+		}
+		if _, ok := findTopicDefinition(topic); !ok {
+			return nil, fmt.Errorf("unknown topic '%s'", topic)
+		}
+		c.Topics[topic] = codec
+	}
+	return c, nil
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Define the time sample payloads hold, which is before 1970 so its protobuf seconds are negative
+var CODEC_SAMPLE_TIME = time.Date(1969, 7, 20, 20, 17, 40, 0, time.UTC)
+
+// codecTestChild is a nested message
+type codecTestChild struct {
+	Label string `json:"label" protobuf:"1"`
+	Count int    `json:"count" protobuf:"2"`
+}
+
+// codecTestMessage holds a field of every kind the codecs support, with field numbers skipped as a retired field's would be
+type codecTestMessage struct {
+	Name     string           `json:"name" protobuf:"1"`
+	Count    int              `json:"count" protobuf:"2"`
+	Unsigned uint32           `json:"unsigned" protobuf:"3"`
+	Enabled  bool             `json:"enabled" protobuf:"4"`
+	Ratio    float64          `json:"ratio" protobuf:"5"`
+	Scale    float32          `json:"scale" protobuf:"6"`
+	Data     []byte           `json:"data" protobuf:"8"`
+	At       time.Time        `json:"at" protobuf:"9"`
+	Counts   []int            `json:"counts" protobuf:"10"`
+	Labels   []string         `json:"labels" protobuf:"11"`
+	Times    []time.Time      `json:"times" protobuf:"12"`
+	Child    *codecTestChild  `json:"child" protobuf:"13"`
+	Children []codecTestChild `json:"children" protobuf:"14"`
+	Note     string           `json:"note,omitempty" protobuf:"20"`
+}
+
+// sampleCodecPayload fills every field of a payload with a value derived from its field number, so each field's encoding is recognisable:
+// strings are the letter of the field number, ints are the field number, bools are true and times are CODEC_SAMPLE_TIME
+func sampleCodecPayload(t *testing.T, v reflect.Value) {
+
+	t.Helper()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		number, ok := protobufFieldNumber(field)
+		if !ok {
+			t.Fatalf("%s.%s has no protobuf field number", v.Type().Name(), field.Name)
+		}
+		value := v.Field(i)
+		switch {
+		case value.Type() == timeType:
+			value.Set(reflect.ValueOf(CODEC_SAMPLE_TIME))
+		case value.Kind() == reflect.String:
+			value.SetString(string(rune('a' + number - 1)))
+		case value.Kind() == reflect.Int:
+			value.SetInt(int64(number))
+		case value.Kind() == reflect.Bool:
+			value.SetBool(true)
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+			elements := reflect.MakeSlice(value.Type(), 2, 2)
+			for j := 0; j < elements.Len(); j++ {
+				sampleCodecPayload(t, elements.Index(j))
+			}
+			value.Set(elements)
+		default:
+			t.Fatalf("%s.%s: no sample value of %s", v.Type().Name(), field.Name, value.Type())
+		}
+	}
+}
+
+// codecRoundTrip encodes a value and decodes it into a new value of the same type
+func codecRoundTrip(t *testing.T, codec Codec, v interface{}) interface{} {
+
+	t.Helper()
+	data, err := codec.Marshal(v)
+	if err != nil {
+		t.Fatalf("%s: marshal %T: %v", codec.Name(), v, err)
+	}
+	decoded := reflect.New(reflect.TypeOf(v).Elem())
+	if err := codec.Unmarshal(data, decoded.Interface()); err != nil {
+		t.Fatalf("%s: unmarshal %T: %v", codec.Name(), v, err)
+	}
+	return decoded.Interface()
+}
+
+// TestCodecsRoundTripEveryPayload checks every topic's payload, both filled and holding its zero value, decodes to what was encoded
+func TestCodecsRoundTripEveryPayload(t *testing.T) {
+
+	for _, topicDefinition := range topicDefinitions() {
+		sample := topicDefinition.NewPayload()
+		sampleCodecPayload(t, reflect.ValueOf(sample).Elem())
+		zero := topicDefinition.NewPayload()
+		for _, codec := range codecs() {
+			for _, v := range []interface{}{sample, zero} {
+				if decoded := codecRoundTrip(t, codec, v); !reflect.DeepEqual(decoded, v) {
+					t.Errorf("%s: %s decoded %+v, want %+v", topicDefinition.Name, codec.Name(), decoded, v)
+				}
+			}
+		}
+	}
+}
+
+// TestCodecsRoundTripEdgeCases checks negative and extreme ints, times before 1970 and at the zero time, repeated and nested messages,
+// and optional messages holding their zero value all decode to what was encoded
+func TestCodecsRoundTripEdgeCases(t *testing.T) {
+
+	beforeEpoch := time.Date(1969, 12, 31, 23, 59, 59, 500000000, time.UTC)
+	tests := []struct {
+		name    string
+		message codecTestMessage
+	}{
+		{"zero", codecTestMessage{}},
+		{"negative", codecTestMessage{Count: -1, Ratio: -0.5, Scale: -2, Counts: []int{-1, 0, math.MinInt64}}},
+		{"extreme", codecTestMessage{Count: math.MaxInt64, Unsigned: math.MaxUint32, Ratio: math.MaxFloat64, Counts: []int{math.MaxInt64}}},
+		{"before 1970", codecTestMessage{At: beforeEpoch, Times: []time.Time{beforeEpoch, time.Unix(-62135596800, 0).UTC()}}},
+		{"after 2038", codecTestMessage{At: time.Date(2300, 1, 1, 0, 0, 0, 1, time.UTC)}},
+		{"repeated", codecTestMessage{Data: []byte{0, 1, 255}, Counts: []int{3, 1, 2}, Labels: []string{"", "b", strings.Repeat("c", 300)}}},
+		{"nested", codecTestMessage{
+			Child:    &codecTestChild{Label: "a", Count: -7},
+			Children: []codecTestChild{{Label: "b"}, {}, {Count: 1}},
+		}},
+		{"zero child", codecTestMessage{Child: &codecTestChild{}}},
+		{"everything", codecTestMessage{
+			Name: "jbloggs", Count: 300, Unsigned: 1, Enabled: true, Ratio: 0.25, Scale: 1.5, Data: []byte("data"),
+			At: CODEC_SAMPLE_TIME, Counts: []int{1}, Labels: []string{"a"}, Times: []time.Time{CODEC_SAMPLE_TIME},
+			Child: &codecTestChild{Label: "c"}, Children: []codecTestChild{{Label: "d", Count: 2}}, Note: "note",
+		}},
+	}
+	for _, test := range tests {
+		for _, codec := range []Codec{ProtobufCodec{}, MessagePackCodec{}} {
+			if decoded := codecRoundTrip(t, codec, &test.message); !reflect.DeepEqual(decoded, &test.message) {
+				t.Errorf("%s: %s decoded %+v, want %+v", test.name, codec.Name(), decoded, &test.message)
+			}
+		}
+	}
+}
+
+// TestProtobufGoldenPayloads pins the protobuf encoding of every topic's sample payload, and so the field numbers of its protobuf tags,
+// which must not change once entries have been written with them
+func TestProtobufGoldenPayloads(t *testing.T) {
+
+	// a SubjectRegionDocument is 0x12 0x1e, field 1 "a", field 2 the sample time
+	// (0x12 0x0b 0x08, -14182940 as a ten byte varint), fields 3 to 6 "c" to "f", and field 7 7
+	document := "121e" + "0a0161" + "120b08e4ab9ef9ffffffffff01" + "1a0163" + "220164" + "2a0165" + "320166" + "3807"
+	golden := map[string]string{
+		SYSTEM_AUDIT_EVENT_TOPIC:                                "0a0161" + "120162" + "1a0163" + "220164" + "2a0165",
+		USER_LOGIN_ATTEMPT_TOPIC:                                "0a0161" + "120162",
+		USER_LOGIN_ATTEMPT_OUTCOME_TOPIC:                        "0a0161" + "1001",
+		USER_SUBJECT_ACCESS_ATTEMPT_TOPIC:                       "0a0161" + "120162" + "1a0163",
+		USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC:               "0a0161" + "120162" + "1801",
+		SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC:                   "0a0161" + "1002" + "1a0163",
+		SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC:                  "0a0161" + document + document + "1a0163" + "2004" + "2a0165",
+		SUBJECT_DOCUMENTS_TOPIC:                                 "0a0161" + document + document + "1a0163" + "2004" + "2805",
+		DOCUMENT_CONTENT_REQUEST_TOPIC:                          "0a0161" + "120162" + "1803" + "220164",
+		DOCUMENT_CONTENT_RESPONSE_TOPIC:                         "0a0161" + "120162" + "1803" + "220164" + "2a0165" + "3006" + "3a0167" + "4008" + "4809" + "52016a" + "5a016b",
+		USER_SESSION_EVENT_TOPIC:                                "0a0161" + "120162" + "1a0163" + "220b08e4ab9ef9ffffffffff01" + "2a0165",
+		PSEUDONYMISED_SYSTEM_AUDIT_EVENT_TOPIC:                  "0a0161" + "120162" + "1a0163",
+		PSEUDONYMISED_USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC: "0a0161" + "120162" + "1801",
+		PSEUDONYM_VAULT_TOPIC:                                   "0a0161" + "120162" + "1a0163",
+	}
+	for _, topicDefinition := range topicDefinitions() {
+		want, ok := golden[topicDefinition.Name]
+		if !ok {
+			t.Errorf("%s: no golden protobuf encoding", topicDefinition.Name)
+			continue
+		}
+		sample := topicDefinition.NewPayload()
+		sampleCodecPayload(t, reflect.ValueOf(sample).Elem())
+		data, err := ProtobufCodec{}.Marshal(sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(data); got != want {
+			t.Errorf("%s: encoded %s, want %s", topicDefinition.Name, got, want)
+		}
+	}
+}
+
+// TestCodecGoldenWireFormat pins the encoding of each kind of value in both binary wire formats
+func TestCodecGoldenWireFormat(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		message  codecTestMessage
+		protobuf string
+		msgpack  string
+	}{
+		{
+			name:     "negative int",
+			message:  codecTestMessage{Count: -1},
+			protobuf: "10ffffffffffffffffff01",
+			msgpack:  "ff",
+		},
+		{
+			name:     "int",
+			message:  codecTestMessage{Count: 300},
+			protobuf: "10ac02",
+			msgpack:  "cd012c",
+		},
+		{
+			name:     "min int",
+			message:  codecTestMessage{Count: math.MinInt64},
+			protobuf: "1080808080808080808001",
+			msgpack:  "d38000000000000000",
+		},
+		{
+			name:     "float64",
+			message:  codecTestMessage{Ratio: 0.25},
+			protobuf: "29000000000000d03f",
+			msgpack:  "cb3fd0000000000000",
+		},
+		{
+			// seconds -1 and nanos 500000000
+			name:     "time before 1970",
+			message:  codecTestMessage{At: time.Date(1969, 12, 31, 23, 59, 59, 500000000, time.UTC)},
+			protobuf: "4a1108ffffffffffffffffff011080cab5ee01",
+			msgpack:  "c70cff1dcd6500ffffffffffffffff",
+		},
+		{
+			name:     "packed ints",
+			message:  codecTestMessage{Counts: []int{1, -1, 300}},
+			protobuf: "520d01ffffffffffffffffff01ac02",
+			msgpack:  "9301ffcd012c",
+		},
+		{
+			name:     "repeated strings",
+			message:  codecTestMessage{Labels: []string{"a", ""}},
+			protobuf: "5a01615a00",
+			msgpack:  "92a161a0",
+		},
+		{
+			name:     "nested",
+			message:  codecTestMessage{Child: &codecTestChild{Label: "a", Count: 2}},
+			protobuf: "6a050a01611002",
+			msgpack:  "82a56c6162656ca161a5636f756e7402",
+		},
+		{
+			name:     "zero child",
+			message:  codecTestMessage{Child: &codecTestChild{}},
+			protobuf: "6a00",
+			msgpack:  "82a56c6162656ca0a5636f756e7400",
+		},
+	}
+	for _, test := range tests {
+		data, err := ProtobufCodec{}.Marshal(test.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(data); got != test.protobuf {
+			t.Errorf("%s: protobuf %s, want %s", test.name, got, test.protobuf)
+		}
+
+		data, err = MessagePackCodec{}.Marshal(test.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the value is found after the field's key, every other field holding its zero value
+		field := messagePackFieldValue(t, data, test.message)
+		if got := hex.EncodeToString(field); got != test.msgpack {
+			t.Errorf("%s: MessagePack %s, want %s", test.name, got, test.msgpack)
+		}
+	}
+}
+
+// messagePackFieldValue returns the encoding of the single non-zero field of a codecTestMessage, from the MessagePack encoding of the message
+func messagePackFieldValue(t *testing.T, data []byte, message codecTestMessage) []byte {
+
+	t.Helper()
+	v := reflect.ValueOf(message)
+	for i := 0; i < v.NumField(); i++ {
+		name, _, ok := jsonFieldName(v.Type().Field(i))
+		if !ok || v.Field(i).IsZero() {
+			continue
+		}
+		var key, value bytes.Buffer
+		writeMessagePackString(&key, name)
+		if err := encodeMessagePack(&value, v.Field(i)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, append(key.Bytes(), value.Bytes()...)) {
+			t.Fatalf("%s is not encoded as its key and value", name)
+		}
+		return value.Bytes()
+	}
+	t.Fatal("message has no non-zero field")
+	return nil
+}
+
+// TestMessagePackGoldenPayload pins the MessagePack encoding of a payload, a map keyed by its JSON field names in field order
+func TestMessagePackGoldenPayload(t *testing.T) {
+
+	data, err := MessagePackCodec{}.Marshal(UserLoginAttemptOutcome{UserName: "ab", Outcome: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "82" + "a8" + hex.EncodeToString([]byte("userName")) + "a26162" + "a7" + hex.EncodeToString([]byte("outcome")) + "c3"
+	if got := hex.EncodeToString(data); got != want {
+		t.Errorf("encoded %s, want %s", got, want)
+	}
+
+	// omitempty fields holding their zero value are left out, as they are from JSON
+	data, err = MessagePackCodec{}.Marshal(codecTestMessage{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("note")) {
+		t.Error("empty note encoded")
+	}
+}
+
+// TestProtobufSkipsUnknownFields checks fields of every wire type whose number matches no struct field are skipped,
+// as fields added by a newer version of a payload are
+func TestProtobufSkipsUnknownFields(t *testing.T) {
+
+	data, err := hex.DecodeString("0a0161" + // field 1 "a"
+		"310102030405060708" + // field 6, fixed64
+		"3d01020304" + // field 7, fixed32
+		"409601" + // field 8, varint
+		"4a027879" + // field 9, bytes
+		"1003") // field 2 3
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded codecTestChild
+	if err := (ProtobufCodec{}).Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := codecTestChild{Label: "a", Count: 3}
+	if decoded != want {
+		t.Errorf("decoded %+v, want %+v", decoded, want)
+	}
+
+	// a payload written by a newer version with an extra field decodes into the older payload
+	newer := codecTestMessage{Name: "a", Count: 2, Note: "newer"}
+	data, err = ProtobufCodec{}.Marshal(newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var older codecTestChild
+	if err := (ProtobufCodec{}).Unmarshal(data, &older); err != nil {
+		t.Fatal(err)
+	}
+	if older != (codecTestChild{Label: "a", Count: 2}) {
+		t.Errorf("decoded %+v, want the fields the older payload has", older)
+	}
+}
+
+// TestMessagePackIgnoresUnknownKeys checks keys matching no struct field are ignored, and other encoders' timestamp formats are read
+func TestMessagePackIgnoresUnknownKeys(t *testing.T) {
+
+	data, err := MessagePackCodec{}.Marshal(codecTestMessage{Name: "a", Count: 2, Labels: []string{"b"}, Note: "newer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var older codecTestChild
+	if err := (MessagePackCodec{}).Unmarshal(data, &older); err != nil {
+		t.Fatal(err)
+	}
+	if older != (codecTestChild{Count: 2}) {
+		t.Errorf("decoded %+v, want only the count, which both payloads have", older)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want time.Time
+	}{
+		{"timestamp 32", "d6ff00000001", time.Unix(1, 0).UTC()},
+		{"timestamp 64", "d7ff0000000400000001", time.Unix(1, 1).UTC()},
+		{"timestamp 96", "c70cff00000001ffffffffffffffff", time.Unix(-1, 1).UTC()},
+	}
+	for _, test := range tests {
+		data, err := hex.DecodeString(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got time.Time
+		if err := (MessagePackCodec{}).Unmarshal(data, &got); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("%s: decoded %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// TestCodecsRejectInvalidData checks truncated data, mismatched wire types and unsupported values are errors rather than partial payloads
+func TestCodecsRejectInvalidData(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		codec Codec
+		data  string
+	}{
+		{"protobuf truncated string", ProtobufCodec{}, "0a0561"},
+		{"protobuf truncated varint", ProtobufCodec{}, "10ff"},
+		{"protobuf string as varint", ProtobufCodec{}, "0801"},
+		{"protobuf message as varint", ProtobufCodec{}, "6801"},
+		{"protobuf unsupported wire type", ProtobufCodec{}, "0b"},
+		{"protobuf int overflow", ProtobufCodec{}, "1880808080808080808001"},
+		{"msgpack truncated map", MessagePackCodec{}, "82a46e616d65"},
+		{"msgpack trailing bytes", MessagePackCodec{}, "80c0"},
+		{"msgpack string as int", MessagePackCodec{}, "81a5636f756e74a161"},
+		{"msgpack unsupported extension", MessagePackCodec{}, "81a26174d40100"},
+		{"msgpack unsupported format", MessagePackCodec{}, "c1"},
+	}
+	for _, test := range tests {
+		data, err := hex.DecodeString(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var message codecTestMessage
+		if err := test.codec.Unmarshal(data, &message); err == nil {
+			t.Errorf("%s: decoded %+v, want an error", test.name, message)
+		}
+	}
+
+	type unnumbered struct {
+		Name string `json:"name"`
+	}
+	if _, err := (ProtobufCodec{}).Marshal(unnumbered{Name: "a"}); err == nil || !strings.Contains(err.Error(), "no protobuf field number") {
+		t.Errorf("error %v, want the field without a number named", err)
+	}
+	if _, err := (ProtobufCodec{}).Marshal("a"); err == nil {
+		t.Error("encoded a string, want an error as only structs are messages")
+	}
+	if err := (MessagePackCodec{}).Unmarshal([]byte{0xc0}, codecTestMessage{}); err == nil {
+		t.Error("decoded into a struct rather than a pointer, want an error")
+	}
+}
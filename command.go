@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Define the structure of Command
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(args []string) int
+}
+
+// commands returns every command the application supports
+func commands() []Command {
+
+	return []Command{
+		{"run", "run [flags]", "run the simulator (the default when no command is given)", runCommand},
+		{"topics", "topics [--json]", "list topics with their payload type and entry count", topicsCommand},
+		{"describe", "describe <topic> [--json]", "describe a topic's entries, keys and headers", describeCommand},
+		{"read", "read <topic> <offset> [--json]", "print the entry at an offset", readCommand},
+		{"tail", "tail <topic> [--from offset] [--limit n] [--key key] [--follow] [--json]", "print entries from an offset, optionally following new entries", tailCommand},
+		{"replay", "replay <topic> [--from-offset n] [--to-offset n] [--from-time t] [--to-time t] [--handler h] [--shadow prefix] [--dry-run] [--keyring f] [--consent f] [--entitlements f]", "re-feed entries from a topic into a handler", replayCommand},
+		{"produce", "produce <event type> [--user u] [--password p] [--subject s] [--region r] [--document d] [--session s] [--file f] [--keyring f]", "validate an event and publish it onto its topic", produceCommand},
+		{"loadtest", "loadtest [--rate n] [--duration d] [--drain d] [--prefix p] [--codec c] [--compression c] [--keyring f] [--verbose] [--json]", "drive the pipeline at a target or maximum rate and report latency, throughput and lag", loadTestCommand},
+		{"compression", "compression [--json]", "report the space compression saves in each topic", compressionCommand},
+		{"keyring", "keyring create | rotate | list | remove <key id> [--file f]", "manage the keys which encrypt subject identifiers and document metadata", keyringCommand},
+		{"reencrypt", "reencrypt [topic...] [--keyring f] [--full] [--dry-run] [--json]", "rewrap or encrypt existing entries with the primary key, while the application is stopped", reencryptCommand},
+		{"reidentify", "reidentify <pseudonym>... --user u --reason r [--authorisation-file f] [--keyring f] [--json]", "return the identifiers pseudonyms were derived from, for an authorised user, auditing every attempt", reidentifyCommand},
+		{"fhir", "fhir <offset> | --subject s [--keyring f]", "print a subject's consolidated documents as a FHIR R4 Bundle of DocumentReferences", fhirCommand},
+		{"sessions", "sessions [--json] | end <session> [--reason r]", "list the sessions logins started which have not ended or expired, or end one", sessionsCommand},
+		{"population", "population generate [--size n] [--seed n] [--force] | list [--region r] | show <subject> [--file f]", "generate, list and show the synthetic subjects access attempts draw from", populationCommand},
+		{"acl", "acl [--json]", "list the components which may produce to and consume from each topic", aclCommand},
+		{"schema", "schema list | show <topic> [--version v] | diff <topic> [--from v] [--to v] | register [--dir d] | proto", "list, show, diff and register the JSON Schema of each topic payload, or print its protobuf definition", schemaCommand},
+	}
+}
+
+// findCommand returns the command with the given name
+func findCommand(name string) (Command, bool) {
+
+	for _, command := range commands() {
+		if command.Name == name {
+			return command, true
+		}
+	}
+	return Command{}, false
+}
+
+// printUsage prints the usage of every command
+func printUsage() {
+
+	program := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [arguments]\n\ncommands:\n", program)
+	for _, command := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-86s %s\n", command.Usage, command.Description)
+	}
+}
+
+// parseInterspersed parses flags which may appear before, between or after positional arguments,
+// e.g. "tail user.login.attempt --from 0", returning the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
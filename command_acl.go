@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// aclCommand prints the components which may produce to and consume from each topic
+func aclCommand(args []string) int {
+
+	fs := flag.NewFlagSet("acl", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return 2
+	}
+
+	if *asJSON {
+		return printJSON(topicACLs())
+	}
+	for _, topicACL := range topicACLs() {
+		fmt.Println(topicACL.Topic)
+		fmt.Printf("  produce: %s\n", formatComponents(topicACL.Producers))
+		fmt.Printf("  consume: %s\n", formatComponents(topicACL.Consumers))
+	}
+	return 0
+}
+
+// formatComponents joins component names, or describes an empty list
+func formatComponents(components []string) string {
+
+	if len(components) == 0 {
+		return "(none)"
+	}
+	return strings.Join(components, ", ")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the structure of TopicCompressionReport, the space compression saves in a topic
+type TopicCompressionReport struct {
+	Topic             string           `json:"topic"`
+	Entries           int64            `json:"entries"`
+	CompressedEntries int64            `json:"compressedEntries"`
+	Encodings         map[string]int64 `json:"encodings,omitempty"`
+	StoredBytes       int64            `json:"storedBytes"`
+	UncompressedBytes int64            `json:"uncompressedBytes"`
+	SavedBytes        int64            `json:"savedBytes"`
+	SavedPercent      float64          `json:"savedPercent"`
+}
+
+// compressionCommand reports the space compression saves in each topic
+func compressionCommand(args []string) int {
+
+	fs := flag.NewFlagSet("compression", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return 2
+	}
+
+	store := ms.NewMessageStore()
+
+	var reports []TopicCompressionReport
+	for _, topic := range discoverTopics() {
+		reports = append(reports, reportTopicCompression(store, topic))
+	}
+
+	if *asJSON {
+		return printJSON(reports)
+	}
+	fmt.Printf("%-46s %8s %10s %12s %12s %12s %7s  %s\n", "TOPIC", "ENTRIES", "COMPRESSED", "STORED", "UNCOMPRESSED", "SAVED", "SAVED%", "ENCODINGS")
+	for _, report := range reports {
+		var encodings []string
+		for encoding, count := range report.Encodings {
+			encodings = append(encodings, fmt.Sprintf("%s=%d", encoding, count))
+		}
+		sort.Strings(encodings)
+		fmt.Printf("%-46s %8d %10d %12d %12d %12d %6.1f%%  %s\n", report.Topic, report.Entries, report.CompressedEntries,
+			report.StoredBytes, report.UncompressedBytes, report.SavedBytes, report.SavedPercent, strings.Join(encodings, " "))
+	}
+	return 0
+}
+
+// reportTopicCompression compares the size of each entry in a topic with the size it would have had uncompressed
+func reportTopicCompression(store MockableMessageStore, topic string) TopicCompressionReport {
+
+	report := TopicCompressionReport{Topic: topic, Encodings: make(map[string]int64)}
+	end := topicLength(store, topic)
+	for offset := int64(0); offset < end; offset++ {
+		entry, err := store.ReadEntry(topic, offset)
+		if err != nil || entry == nil {
+			continue
+		}
+		report.Entries++
+		stored := int64(len(entry.Value))
+		report.StoredBytes += stored
+		report.UncompressedBytes += stored
+
+		envelope := decodeMessageEnvelope(*entry)
+		encoding := envelope.Headers[CONTENT_ENCODING_HEADER]
+		if encoding == "" {
+			continue
+		}
+		uncompressed, err := uncompressedEntryValue(envelope)
+		if err != nil {
+			fmt.Printf("failed to decompress entry at offset %d of topic '%s': %v\n", offset, topic, err)
+			continue
+		}
+		report.CompressedEntries++
+		report.Encodings[encoding]++
+		report.UncompressedBytes += int64(len(uncompressed)) - stored
+	}
+
+	report.SavedBytes = report.UncompressedBytes - report.StoredBytes
+	if report.UncompressedBytes > 0 {
+		report.SavedPercent = 100 * float64(report.SavedBytes) / float64(report.UncompressedBytes)
+	}
+	return report
+}
+
+// uncompressedEntryValue returns the value an entry would have had if its payload had not been compressed
+func uncompressedEntryValue(envelope MessageEnvelope) ([]byte, error) {
+
+	payload, err := envelope.EncodedPayload()
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string)
+	for name, value := range envelope.Headers {
+		headers[name] = value
+	}
+	delete(headers, CONTENT_ENCODING_HEADER)
+	if codec, _ := envelope.Codec(); codec.ContentType() != JSON_CONTENT_TYPE {
+		payload, _ = json.Marshal(payload)
+	}
+	return json.Marshal(NewMessageEnvelope(headers, payload))
+}
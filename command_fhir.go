@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// fhirCommand prints a subject's consolidated documents as a FHIR R4 Bundle of DocumentReference resources, from the subject.documents
+// entry at an offset, or the latest entry about a subject
+func fhirCommand(args []string) int {
+
+	usage := "usage: fhir <offset> | --subject s [--keyring f]"
+	fs := flag.NewFlagSet("fhir", flag.ContinueOnError)
+	subjectIdentifier := fs.String("subject", "", "export the latest documents consolidated about this subject")
+	keyringFile := fs.String("keyring", KEYRING_FILE, "the keyring file the documents' subject identifiers are encrypted with")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if (len(positional) == 1) == (*subjectIdentifier != "") || len(positional) > 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	keyring, err := loadOptionalKeyring(*keyringFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load keyring: %v\n", err)
+		return 1
+	}
+
+	backend := NewBackend()
+	defer backend.Shutdown(context.Background())
+	backend.useKeyring(keyring)
+	exporter := backend.ForComponent(FHIR_EXPORT_COMPONENT)
+
+	var offset int64
+	var subjectDocuments SubjectDocuments
+	if *subjectIdentifier != "" {
+		var ok bool
+		if offset, subjectDocuments, ok = exporter.findLatestSubjectDocuments(*subjectIdentifier); !ok {
+			fmt.Fprintf(os.Stderr, "topic '%s' holds no documents about subject '%s'\n", SUBJECT_DOCUMENTS_TOPIC, *subjectIdentifier)
+			return 1
+		}
+	} else {
+		if offset, err = strconv.ParseInt(positional[0], 10, 64); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid offset '%s'\n", positional[0])
+			return 2
+		}
+		if subjectDocuments, err = exporter.readSubjectDocuments(offset); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	id := fmt.Sprintf("%s-%d", SUBJECT_DOCUMENTS_TOPIC, offset)
+	return printJSON(NewFHIRDocumentBundle(id, subjectDocuments, time.Now()))
+}
+
+// readSubjectDocuments reads the subject.documents entry at an offset, admits it, then decodes its payload
+func (b *Backend) readSubjectDocuments(offset int64) (SubjectDocuments, error) {
+
+	var subjectDocuments SubjectDocuments
+	entry, err := b.MessageStore.ReadEntry(SUBJECT_DOCUMENTS_TOPIC, offset)
+	if err != nil {
+		return subjectDocuments, fmt.Errorf("ReadEntry for topic '%s', offset %d, failed: %v", SUBJECT_DOCUMENTS_TOPIC, offset, err)
+	}
+	admitted, err := b.admitEntry(SUBJECT_DOCUMENTS_TOPIC, *entry)
+	if err != nil {
+		return subjectDocuments, fmt.Errorf("topic '%s', offset %d, was not admitted: %v", SUBJECT_DOCUMENTS_TOPIC, offset, err)
+	}
+	if err := decodeMessageEnvelope(admitted).DecodePayload(&subjectDocuments); err != nil {
+		return subjectDocuments, fmt.Errorf("failed to decode topic '%s', offset %d: %v", SUBJECT_DOCUMENTS_TOPIC, offset, err)
+	}
+	return subjectDocuments, nil
+}
+
+// findLatestSubjectDocuments returns the offset and documents of the latest subject.documents entry about a subject. entries are keyed
+// by user, so every entry is read, from the latest back
+func (b *Backend) findLatestSubjectDocuments(subjectIdentifier string) (int64, SubjectDocuments, bool) {
+
+	for offset := topicLength(b.MessageStore, SUBJECT_DOCUMENTS_TOPIC) - 1; offset >= 0; offset-- {
+		subjectDocuments, err := b.readSubjectDocuments(offset)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if subjectDocuments.SubjectIdentifier == subjectIdentifier {
+			return offset, subjectDocuments, true
+		}
+	}
+	return -1, SubjectDocuments{}, false
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// keyringCommand creates, rotates, lists and removes the keys of a keyring file
+func keyringCommand(args []string) int {
+
+	usage := "usage: keyring create | rotate | list | remove <key id> [--file keyring.json]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("keyring", flag.ContinueOnError)
+	file := fs.String("file", KEYRING_FILE, "the keyring file")
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return 2
+	}
+
+	switch {
+	case args[0] == "create" && len(positional) == 0:
+		return keyringCreateCommand(*file)
+	case args[0] == "rotate" && len(positional) == 0:
+		return keyringRotateCommand(*file)
+	case args[0] == "list" && len(positional) == 0:
+		return keyringListCommand(*file)
+	case args[0] == "remove" && len(positional) == 1:
+		return keyringRemoveCommand(*file, positional[0])
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// keyringCreateCommand creates a keyring file with one key, refusing to overwrite an existing file
+func keyringCreateCommand(filename string) int {
+
+	if _, err := os.Stat(filename); err == nil {
+		fmt.Fprintf(os.Stderr, "keyring file %s already exists, use rotate to add a key\n", filename)
+		return 1
+	}
+	keyring, err := NewKeyring()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := keyring.Save(filename); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("created keyring %s with primary key %s\n", filename, keyring.Primary)
+	return 0
+}
+
+// keyringRotateCommand adds a key to a keyring file and makes it the primary key. entries already written
+// keep their data key wrapped by the previous key until they are re-encrypted
+func keyringRotateCommand(filename string) int {
+
+	keyring, err := LoadKeyring(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load keyring: %v\n", err)
+		return 1
+	}
+	previous := keyring.Primary
+	key, err := keyring.Rotate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := keyring.Save(filename); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("rotated primary key from %s to %s, restart the application to use it, then run reencrypt to rewrap existing entries\n", previous, key.ID)
+	return 0
+}
+
+// keyringListCommand prints each key of a keyring file with the number of entries whose data key it wraps
+func keyringListCommand(filename string) int {
+
+	keyring, err := LoadKeyring(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load keyring: %v\n", err)
+		return 1
+	}
+	counts := countEntriesByKey(ms.NewMessageStore())
+
+	fmt.Printf("%-8s %-8s %-25s %s\n", "KEY", "PRIMARY", "CREATED", "ENTRIES")
+	for _, key := range keyring.Keys {
+		primary := ""
+		if key.ID == keyring.Primary {
+			primary = "yes"
+		}
+		fmt.Printf("%-8s %-8s %-25s %d\n", key.ID, primary, key.Created.Format(time.RFC3339), counts[key.ID])
+	}
+	for id, count := range counts {
+		if _, ok := keyring.Key(id); !ok {
+			fmt.Printf("%-8s %-8s %-25s %d (not in the keyring, these entries cannot be decrypted)\n", id, "", "", count)
+		}
+	}
+	return 0
+}
+
+// keyringRemoveCommand removes a key from a keyring file, refusing while any entry's data key is still wrapped by it
+func keyringRemoveCommand(filename, id string) int {
+
+	keyring, err := LoadKeyring(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load keyring: %v\n", err)
+		return 1
+	}
+	if count := countEntriesByKey(ms.NewMessageStore())[id]; count > 0 {
+		fmt.Fprintf(os.Stderr, "%d entries are still wrapped by key %s, run reencrypt first\n", count, id)
+		return 1
+	}
+	if err := keyring.Remove(id); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := keyring.Save(filename); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("removed key %s\n", id)
+	return 0
+}
+
+// countEntriesByKey counts the entries of every topic by the ID of the key which wraps their data key
+func countEntriesByKey(store MockableMessageStore) map[string]int64 {
+
+	counts := make(map[string]int64)
+	for _, topic := range discoverTopics() {
+		end := topicLength(store, topic)
+		for offset := int64(0); offset < end; offset++ {
+			entry, err := store.ReadEntry(topic, offset)
+			if err != nil || entry == nil {
+				continue
+			}
+			if wrappedDataKey := decodeMessageEnvelope(*entry).Headers[ENCRYPTION_KEY_HEADER]; wrappedDataKey != "" {
+				counts[wrappedDataKeyID(wrappedDataKey)]++
+			}
+		}
+	}
+	return counts
+}
+
+// Define the structure of ReencryptReport, what re-encryption did to the entries of a topic
+type ReencryptReport struct {
+	Topic     string `json:"topic"`
+	Entries   int64  `json:"entries"`
+	Rewrapped int64  `json:"rewrapped"`
+	Encrypted int64  `json:"encrypted"`
+	Skipped   int64  `json:"skipped"`
+}
+
+// reencryptCommand brings the entries of existing topics up to date with the keyring: data keys wrapped by an older key are rewrapped
+// with the primary key, and entries written in the clear are encrypted. with --full every entry is encrypted again with a new data key
+func reencryptCommand(args []string) int {
+
+	fs := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	keyringFile := fs.String("keyring", KEYRING_FILE, "the keyring file")
+	full := fs.Bool("full", false, "decrypt every entry and encrypt it again with a new data key, rather than rewrapping its data key")
+	dryRun := fs.Bool("dry-run", false, "report what would be re-encrypted without rewriting any topic")
+	asJSON := fs.Bool("json", false, "print as JSON")
+	topics, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	keyring, err := LoadKeyring(*keyringFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load keyring: %v\n", err)
+		return 1
+	}
+	if len(topics) == 0 {
+		for _, topicDefinition := range topicDefinitions() {
+			topics = append(topics, topicDefinition.Name)
+		}
+	}
+
+	encryption := &FieldEncryption{Keyring: keyring}
+	store := ms.NewMessageStore()
+	var reports []ReencryptReport
+	exitCode := 0
+	for _, topic := range topics {
+		// entries kept as they are are reported on standard error, as standard output may be JSON
+		report, err := reencryptTopic(store, encryption, topic, *full, *dryRun, os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to re-encrypt topic '%s': %v\n", topic, err)
+			exitCode = 1
+		}
+		reports = append(reports, report)
+	}
+
+	if *asJSON {
+		printJSON(reports)
+		return exitCode
+	}
+	fmt.Printf("%-46s %8s %10s %10s %8s\n", "TOPIC", "ENTRIES", "REWRAPPED", "ENCRYPTED", "SKIPPED")
+	for _, report := range reports {
+		fmt.Printf("%-46s %8d %10d %10d %8d\n", report.Topic, report.Entries, report.Rewrapped, report.Encrypted, report.Skipped)
+	}
+	if *dryRun {
+		fmt.Println("dry run, no topic was rewritten")
+	}
+	return exitCode
+}
+
+// reencryptTopic rewrites every entry of a topic which is not up to date with the keyring into a staging topic, keeping each entry's
+// key and timestamp so offsets are unchanged, then replaces the topic's files with the staging topic's. entries which cannot be
+// re-encrypted, e.g. because they were written with a schema version the current payload type cannot decode, are kept as they are,
+// and reported to out. the application must not be running, as it would not see the replaced files
+func reencryptTopic(store *ms.MessageStore, encryption *FieldEncryption, topic string, full, dryRun bool, out io.Writer) (ReencryptReport, error) {
+
+	report := ReencryptReport{Topic: topic}
+	end := topicLength(store, topic)
+	entries := make([]ms.Entry, 0, end)
+	for offset := int64(0); offset < end; offset++ {
+		entry, err := store.ReadEntry(topic, offset)
+		if err != nil || entry == nil {
+			return report, fmt.Errorf("ReadEntry for offset %d failed: %v", offset, err)
+		}
+		report.Entries++
+
+		reencrypted, outcome, err := reencryptEntry(encryption, topic, *entry, full)
+		if err != nil {
+			fmt.Fprintf(out, "keeping entry at offset %d of topic '%s' as it is: %v\n", offset, topic, err)
+			report.Skipped++
+			entries = append(entries, *entry)
+			continue
+		}
+		switch outcome {
+		case "rewrapped":
+			report.Rewrapped++
+		case "encrypted":
+			report.Encrypted++
+		}
+		entries = append(entries, reencrypted)
+	}
+	if dryRun || report.Rewrapped+report.Encrypted == 0 {
+		return report, nil
+	}
+
+	staging := topic + ".reencrypting"
+	removeTopicFiles(staging)
+	for offset, entry := range entries {
+		if _, err := store.SaveEntry(staging, entry); err != nil {
+			removeTopicFiles(staging)
+			return report, fmt.Errorf("SaveEntry for offset %d of staging topic '%s' failed: %v", offset, staging, err)
+		}
+	}
+	if length := topicLength(store, staging); length != int64(len(entries)) {
+		removeTopicFiles(staging)
+		return report, fmt.Errorf("staging topic '%s' has %d entries, not %d", staging, length, len(entries))
+	}
+	if err := replaceTopicFiles(topic, staging); err != nil {
+		removeTopicFiles(staging)
+		return report, err
+	}
+	return report, nil
+}
+
+// replaceTopicFiles replaces the files of a topic with those of a staging topic. the topic's files are moved aside first, and put
+// back if any file cannot be replaced, so the topic is either wholly replaced or left as it was, unless putting them back fails too
+func replaceTopicFiles(topic, staging string) error {
+
+	suffixes := []string{".data", ".data.idx"}
+	original := topic + ".original"
+	for i, suffix := range suffixes {
+		if err := os.Rename(topic+suffix, original+suffix); err != nil {
+			return restoreTopicFiles(topic, original, suffixes[:i], fmt.Errorf("failed to move topic file %s aside: %v", topic+suffix, err))
+		}
+	}
+	for _, suffix := range suffixes {
+		if err := os.Rename(staging+suffix, topic+suffix); err != nil {
+			return restoreTopicFiles(topic, original, suffixes, fmt.Errorf("failed to replace topic file with %s: %v", staging+suffix, err))
+		}
+	}
+	removeTopicFiles(original)
+	return nil
+}
+
+// restoreTopicFiles puts back the files of a topic which were moved aside, returning the failure which made that necessary,
+// and saying whether the topic was restored or is left inconsistent
+func restoreTopicFiles(topic, original string, suffixes []string, cause error) error {
+
+	for _, suffix := range suffixes {
+		if err := os.Rename(original+suffix, topic+suffix); err != nil {
+			return fmt.Errorf("%v, and putting back %s failed, so topic '%s' is inconsistent, its original files are %s.*: %v",
+				cause, topic+suffix, topic, original, err)
+		}
+	}
+	return fmt.Errorf("%v, topic '%s' was left as it was", cause, topic)
+}
+
+// reencryptEntry rewraps or encrypts an entry, returning what it did: "rewrapped", "encrypted", or "" when the entry was up to date
+func reencryptEntry(encryption *FieldEncryption, topic string, entry ms.Entry, full bool) (ms.Entry, string, error) {
+
+	envelope := decodeMessageEnvelope(entry)
+	wrappedDataKey := envelope.Headers[ENCRYPTION_KEY_HEADER]
+	if wrappedDataKey != "" && !full {
+		if wrappedDataKeyID(wrappedDataKey) == encryption.Keyring.Primary {
+			return entry, "", nil
+		}
+		rewrapped, err := encryption.RewrapEntry(entry)
+		if err != nil {
+			return entry, "", err
+		}
+		return rewrapped, "rewrapped", nil
+	}
+
+	decrypted, err := encryption.DecryptEntry(topic, entry)
+	if err != nil {
+		return entry, "", err
+	}
+	encrypted, err := encryption.EncryptEntry(topic, decrypted)
+	if err != nil {
+		return entry, "", err
+	}
+	if string(encrypted.Value) == string(decrypted.Value) {
+		// the topic has no encrypted fields, or the entry has no envelope
+		return entry, "", nil
+	}
+	if encoding := envelope.Headers[CONTENT_ENCODING_HEADER]; encoding != "" {
+		// compress the payload again, as it was
+		compressor, _ := findCompressor(encoding)
+		if encrypted, err = compressEntry(CompressionPolicy{Compressor: compressor}, encrypted); err != nil {
+			return entry, "", err
+		}
+	}
+	return encrypted, "encrypted", nil
+}
+
+// removeTopicFiles removes the files of a topic, if it has any
+func removeTopicFiles(topic string) {
+
+	for _, suffix := range []string{".data", ".data.idx"} {
+		os.Remove(topic + suffix)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// saveEncryptedSystemAuditEvent saves a system audit event about a subject, its fields encrypted with the keyring's primary key
+func saveEncryptedSystemAuditEvent(t *testing.T, store MockableMessageStore, encryption *FieldEncryption, subjectIdentifier string) {
+
+	t.Helper()
+	payload, err := JSONCodec{}.Marshal(NewSystemAuditEventWithSubject("jbloggs", subjectIdentifier, "subject accessed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := encryption.EncryptEntry(SYSTEM_AUDIT_EVENT_TOPIC, newMessageStoreEntry(context.Background(), SYSTEM_AUDIT_EVENT_TOPIC, "jbloggs", JSONCodec{}, payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, entry); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReencryptTopic checks entries under an older key are rewrapped with the primary key, and an entry which cannot be
+// rewrapped is kept as it is, reported to the output writer, and counted only as skipped
+func TestReencryptTopic(t *testing.T) {
+
+	inTempDirectory(t)
+	store := ms.NewMessageStore()
+	encryption := &FieldEncryption{Keyring: newTestKeyring(t)}
+	saveEncryptedSystemAuditEvent(t, store, encryption, "0101700008")
+	if _, err := encryption.Keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	saveEncryptedSystemAuditEvent(t, store, encryption, "0202800002")
+	if _, err := encryption.Keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	// the first entry's key is lost, so it cannot be rewrapped
+	if err := encryption.Keyring.Remove("k1"); err != nil {
+		t.Fatal(err)
+	}
+	unrewrappable, err := store.ReadEntry(SYSTEM_AUDIT_EVENT_TOPIC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	report, err := reencryptTopic(store, encryption, SYSTEM_AUDIT_EVENT_TOPIC, false, false, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Entries != 2 || report.Rewrapped != 1 || report.Encrypted != 0 || report.Skipped != 1 {
+		t.Errorf("report %+v, want 2 entries, 1 rewrapped and 1 skipped", report)
+	}
+	if !strings.HasPrefix(out.String(), "keeping entry at offset 0 of topic '"+SYSTEM_AUDIT_EVENT_TOPIC+"' as it is: ") {
+		t.Errorf("reported %q, want the skipped entry", out.String())
+	}
+
+	kept, err := store.ReadEntry(SYSTEM_AUDIT_EVENT_TOPIC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kept.Value, unrewrappable.Value) {
+		t.Error("the entry which could not be rewrapped was changed")
+	}
+	rewrapped, err := store.ReadEntry(SYSTEM_AUDIT_EVENT_TOPIC, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID := wrappedDataKeyID(decodeMessageEnvelope(*rewrapped).Headers[ENCRYPTION_KEY_HEADER]); keyID != encryption.Keyring.Primary {
+		t.Errorf("entry rewrapped with key %s, want the primary key %s", keyID, encryption.Keyring.Primary)
+	}
+	if _, err := os.Stat(SYSTEM_AUDIT_EVENT_TOPIC + ".original.data"); !os.IsNotExist(err) {
+		t.Errorf("original topic files left behind, stat error %v", err)
+	}
+}
+
+// TestReplaceTopicFiles checks a topic's files are replaced with the staging topic's, and left as they were when one cannot be
+func TestReplaceTopicFiles(t *testing.T) {
+
+	inTempDirectory(t)
+	write := func(filename, content string) {
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(filename string) string {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	write("topic.data", "old data")
+	write("topic.data.idx", "old index")
+	// the staging topic has no index, so only its data file can be swapped in
+	write("staging.data", "new data")
+	err := replaceTopicFiles("topic", "staging")
+	if err == nil || !strings.Contains(err.Error(), "topic 'topic' was left as it was") {
+		t.Errorf("error %v, want the topic reported as left as it was", err)
+	}
+	if data, index := read("topic.data"), read("topic.data.idx"); data != "old data" || index != "old index" {
+		t.Errorf("topic files %q and %q, want the originals", data, index)
+	}
+
+	write("staging.data", "new data")
+	write("staging.data.idx", "new index")
+	if err := replaceTopicFiles("topic", "staging"); err != nil {
+		t.Fatal(err)
+	}
+	if data, index := read("topic.data"), read("topic.data.idx"); data != "new data" || index != "new index" {
+		t.Errorf("topic files %q and %q, want the staging topic's", data, index)
+	}
+	for _, filename := range []string{"staging.data", "staging.data.idx", "topic.original.data", "topic.original.data.idx"} {
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("%s left behind, stat error %v", filename, err)
+		}
+	}
+}
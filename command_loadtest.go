@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// loadTestCommand drives the pipeline at a target or maximum rate and reports latency, throughput and consumer lag
+func loadTestCommand(args []string) int {
+
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	rate := fs.Float64("rate", 0, "logins per second, or 0 for as fast as possible")
+	duration := fs.Duration("duration", 30*time.Second, "how long to send logins for")
+	drain := fs.Duration("drain", time.Minute, "the time allowed for consumers to catch up once logins stop")
+	prefix := fs.String("prefix", "loadtest", "run the pipeline against topics with this prefix, so live topics are untouched")
+	verbose := fs.Bool("verbose", false, "keep the handlers' logging, which is otherwise discarded as it slows the pipeline")
+	codecSpec := fs.String("codec", "json", "the codec payloads are written with, then any topic=codec overrides, e.g. json,subject.region.document.response=protobuf")
+	compressionSpec := fs.String("compression", "none", "the compression of payloads, then any topic=compression overrides, e.g. none,subject.region.document.response=zstd:512")
+	keyringFile := fs.String("keyring", KEYRING_FILE, "encrypt subject identifiers and document metadata with the keys in this file, if it exists")
+	asJSON := fs.Bool("json", false, "print as JSON")
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return 2
+	}
+	if *rate < 0 || *duration <= 0 || *prefix == "" {
+		fmt.Fprintln(os.Stderr, "usage: loadtest [--rate n] [--duration d] [--drain d] [--prefix p] [--codec c] [--compression c] [--keyring file] [--verbose] [--json]")
+		return 2
+	}
+	topicCodecs, err := ParseTopicCodecs(*codecSpec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	topicCompression, err := ParseTopicCompression(*compressionSpec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	keyring, err := loadOptionalKeyring(*keyringFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load keyring: %v\n", err)
+		return 1
+	}
+
+	// Create a context that cancels on a termination signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "sending logins for %v, then draining for up to %v\n", *duration, *drain)
+	var log io.Writer = io.Discard
+	if *verbose {
+		log = os.Stdout
+	}
+	report, err := runLoadTest(ctx, ms.NewMessageStore(), LoadTestOptions{
+		Rate:        *rate,
+		Duration:    *duration,
+		Drain:       *drain,
+		Prefix:      *prefix,
+		Codecs:      topicCodecs,
+		Compression: topicCompression,
+		Keyring:     keyring,
+		Log:         log,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load test stopped: %v\n", err)
+	}
+
+	if *asJSON {
+		printJSON(report)
+	} else {
+		printLoadTestReport(report)
+	}
+	if err != nil || !report.Drained {
+		return 1
+	}
+	return 0
+}
+
+// printLoadTestReport prints a load test report as readable text
+func printLoadTestReport(report LoadTestReport) {
+
+	target := "max"
+	if report.TargetRate > 0 {
+		target = fmt.Sprintf("%.1f/s", report.TargetRate)
+	}
+	fmt.Printf("logins sent:  %d (target %s, achieved %.1f/s)\n", report.LoginsSent, target, report.SendRate)
+	fmt.Printf("elapsed:      %.1fs, drained: %v\n", report.Elapsed, report.Drained)
+	fmt.Printf("codecs:       %s\n", report.Codecs)
+	fmt.Printf("compression:  %s\n", report.Compression)
+	fmt.Printf("encryption:   %s\n", report.Encryption)
+	fmt.Printf("end-to-end latency, login attempt to consolidated documents, over %d flows:\n", report.Latency.Count)
+	fmt.Printf("  p50 %.0fms  p90 %.0fms  p99 %.0fms  max %.0fms\n", report.Latency.P50, report.Latency.P90, report.Latency.P99, report.Latency.Max)
+	fmt.Println("throughput:")
+	for _, topic := range report.Topics {
+		fmt.Printf("  %-46s %8d entries %10.1f/s\n", topic.Topic, topic.Entries, topic.PerSecond)
+	}
+	fmt.Println("consumer lag:")
+	for _, consumer := range report.Consumers {
+		fmt.Printf("  %-50s max %6d  final %6d\n", consumer.Name, consumer.MaxLag, consumer.FinalLag)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// populationCommand generates, lists and shows the subjects of a population file
+func populationCommand(args []string) int {
+
+	usage := "usage: population generate [--size n] [--seed n] [--reference-date yyyy-mm-dd] [--force] | list [--region r] [--limit n] | show <subject identifier> [--file population.json] [--json]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("population", flag.ContinueOnError)
+	file := fs.String("file", POPULATION_FILE, "the population file")
+	size := fs.Int("size", DEFAULT_POPULATION_SIZE, "the number of subjects to generate")
+	seed := fs.Int64("seed", DEFAULT_POPULATION_SEED, "the seed subjects are generated from, the same seed generating the same subjects")
+	referenceDate := fs.String("reference-date", DEFAULT_POPULATION_REFERENCE_DATE, "the date subjects are born in the 90 years before")
+	force := fs.Bool("force", false, "replace an existing population file")
+	regionFlag := fs.String("region", "", "only list the subjects whose home is this region")
+	limit := fs.Int("limit", 20, "the most subjects to list, or 0 for every subject")
+	asJSON := fs.Bool("json", false, "print as JSON")
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return 2
+	}
+
+	switch {
+	case args[0] == "generate" && len(positional) == 0:
+		return populationGenerateCommand(*file, *size, *seed, *referenceDate, *force)
+	case args[0] == "list" && len(positional) == 0:
+		return populationListCommand(*file, *regionFlag, *limit, *asJSON)
+	case args[0] == "show" && len(positional) == 1:
+		return populationShowCommand(*file, positional[0], *asJSON)
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// populationGenerateCommand generates a population and saves it, refusing to replace an existing file unless forced, as
+// the topics may already hold documents and audit events of its subjects
+func populationGenerateCommand(filename string, size int, seed int64, referenceDateFlag string, force bool) int {
+
+	if size <= 0 {
+		fmt.Fprintln(os.Stderr, "--size must be positive")
+		return 2
+	}
+	referenceDate, err := time.Parse(REFERENCE_DATE_FORMAT, referenceDateFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--reference-date must be a date such as %s\n", DEFAULT_POPULATION_REFERENCE_DATE)
+		return 2
+	}
+	if _, err := os.Stat(filename); err == nil && !force {
+		fmt.Fprintf(os.Stderr, "population file %s already exists, use --force to replace it\n", filename)
+		return 1
+	}
+	population := GeneratePopulation(size, seed, referenceDate)
+	if err := population.Save(filename); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("generated a population of %d subjects to %s\n", population.Len(), filename)
+	return 0
+}
+
+// populationListCommand prints the number of subjects whose home is each region, then the subjects
+func populationListCommand(filename, regionFlag string, limit int, asJSON bool) int {
+
+	population, err := LoadPopulation(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load population: %v\n", err)
+		return 1
+	}
+	region := -1
+	if regionFlag != "" {
+		if region, err = parseRegion(regionFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	var subjects []Subject
+	for _, subject := range population.Subjects() {
+		if region >= 0 && subject.HomeRegion != region {
+			continue
+		}
+		if limit > 0 && len(subjects) == limit {
+			break
+		}
+		subjects = append(subjects, subject)
+	}
+	if asJSON {
+		return printJSON(subjects)
+	}
+
+	counts := population.CountByRegion()
+	fmt.Printf("%d subjects\n", population.Len())
+	for _, r := range allRegions() {
+		fmt.Printf("  %-26s %6d\n", regionName(r), counts[r])
+	}
+	fmt.Println()
+	fmt.Printf("%-12s %-11s %-7s %-26s %s\n", "SUBJECT", "BORN", "SEX", "HOME REGION", "DOCUMENTS HELD IN")
+	for _, subject := range subjects {
+		fmt.Printf("%-12s %-11s %-7s %-26s %s\n", subject.SubjectIdentifier, subject.DateOfBirth.Format("2006-01-02"), subject.Sex,
+			regionName(subject.HomeRegion), formatRegions(subject.Regions))
+	}
+	return 0
+}
+
+// populationShowCommand prints a subject and the documents each region holds about them
+func populationShowCommand(filename, subjectIdentifier string, asJSON bool) int {
+
+	population, err := LoadPopulation(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load population: %v\n", err)
+		return 1
+	}
+	subject, ok := population.Find(subjectIdentifier)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "subject '%s' is not in the population\n", subjectIdentifier)
+		return 1
+	}
+	var documents []SubjectRegionDocument
+	for _, region := range subject.Regions {
+		documents = append(documents, embeddedDocumentCatalogue.Documents(subject.SubjectIdentifier, region, population.ReferenceDate())...)
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].DocumentDate.Before(documents[j].DocumentDate)
+	})
+
+	if asJSON {
+		return printJSON(struct {
+			Subject
+			Documents []SubjectRegionDocument `json:"documents"`
+		}{subject, documents})
+	}
+	fmt.Printf("subject:           %s\n", subject.SubjectIdentifier)
+	fmt.Printf("born:              %s\n", subject.DateOfBirth.Format("2006-01-02"))
+	fmt.Printf("sex:               %s\n", subject.Sex)
+	fmt.Printf("home region:       %s\n", regionName(subject.HomeRegion))
+	fmt.Printf("documents held in: %s\n", formatRegions(subject.Regions))
+	fmt.Println()
+	fmt.Printf("%-12s %-26s %-32s %s\n", "DATE", "REGION", "CATEGORY", "SPECIALTY")
+	for _, document := range documents {
+		fmt.Printf("%-12s %-26s %-32s %s\n", document.DocumentDate.Format("2006-01-02"), regionName(document.Region), document.DocumentCategory, document.DocumentSpecialty)
+	}
+	return 0
+}
+
+// formatRegions joins the names of regions
+func formatRegions(regions []int) string {
+
+	names := make([]string, 0, len(regions))
+	for _, region := range regions {
+		names = append(names, regionName(region))
+	}
+	return strings.Join(names, ", ")
+}
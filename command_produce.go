@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Validator is implemented by payloads which can check their own fields
+type Validator interface {
+	Validate() error
+}
+
+// Define the structure of ProducibleEvent
+type ProducibleEvent struct {
+	Name       string
+	Topic      string
+	NewPayload func() interface{}
+	FromFlags  func(flags produceFlags) (interface{}, error)
+	Send       func(b *Backend, ctx context.Context, payload interface{}) error
+}
+
+// Define the structure of produceFlags
+type produceFlags struct {
+	UserName           string
+	UserPassword       string
+	SubjectIdentifier  string
+	Region             string
+	DocumentIdentifier string
+	SessionIdentifier  string
+}
+
+// producibleEvents returns every event type which can be produced manually
+func producibleEvents() []ProducibleEvent {
+
+	return []ProducibleEvent{
+		{
+			Name:       "login-attempt",
+			Topic:      USER_LOGIN_ATTEMPT_TOPIC,
+			NewPayload: func() interface{} { return &UserLoginAttempt{} },
+			FromFlags: func(flags produceFlags) (interface{}, error) {
+				return NewUserLoginAttempt(flags.UserName, flags.UserPassword), nil
+			},
+			Send: func(b *Backend, ctx context.Context, payload interface{}) error {
+				return b.sendUserLoginAttempt(ctx, *payload.(*UserLoginAttempt))
+			},
+		},
+		{
+			Name:       "subject-access-attempt",
+			Topic:      USER_SUBJECT_ACCESS_ATTEMPT_TOPIC,
+			NewPayload: func() interface{} { return &UserSubjectAccessAttempt{} },
+			FromFlags: func(flags produceFlags) (interface{}, error) {
+				return NewUserSubjectAccessAttempt(flags.UserName, flags.SubjectIdentifier, flags.SessionIdentifier), nil
+			},
+			Send: func(b *Backend, ctx context.Context, payload interface{}) error {
+				return b.sendUserSubjectAccessAttempt(ctx, *payload.(*UserSubjectAccessAttempt))
+			},
+		},
+		{
+			Name:       "document-request",
+			Topic:      SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC,
+			NewPayload: func() interface{} { return &SubjectRegionDocumentRequest{} },
+			FromFlags: func(flags produceFlags) (interface{}, error) {
+				region, err := parseRegion(flags.Region)
+				if err != nil {
+					return nil, err
+				}
+				return NewSubjectRegionDocumentRequest(flags.SubjectIdentifier, region, flags.UserName), nil
+			},
+			Send: func(b *Backend, ctx context.Context, payload interface{}) error {
+				return b.sendSubjectRegionDocumentRequest(ctx, *payload.(*SubjectRegionDocumentRequest))
+			},
+		},
+		{
+			Name:       "content-request",
+			Topic:      DOCUMENT_CONTENT_REQUEST_TOPIC,
+			NewPayload: func() interface{} { return &DocumentContentRequest{} },
+			FromFlags: func(flags produceFlags) (interface{}, error) {
+				region, err := parseRegion(flags.Region)
+				if err != nil {
+					return nil, err
+				}
+				return NewDocumentContentRequest(flags.SubjectIdentifier, flags.DocumentIdentifier, region, flags.UserName), nil
+			},
+			Send: func(b *Backend, ctx context.Context, payload interface{}) error {
+				return b.sendDocumentContentRequest(ctx, *payload.(*DocumentContentRequest))
+			},
+		},
+	}
+}
+
+// findProducibleEvent returns the producible event type with the given name
+func findProducibleEvent(name string) (ProducibleEvent, bool) {
+
+	for _, producibleEvent := range producibleEvents() {
+		if producibleEvent.Name == name {
+			return producibleEvent, true
+		}
+	}
+	return ProducibleEvent{}, false
+}
+
+// produceCommand publishes an event built from flags or read from a JSON file onto its topic
+func produceCommand(args []string) int {
+
+	var flags produceFlags
+	fs := flag.NewFlagSet("produce", flag.ContinueOnError)
+	fs.StringVar(&flags.UserName, "user", "", "the user name")
+	fs.StringVar(&flags.UserPassword, "password", "", "the user password (login-attempt)")
+	fs.StringVar(&flags.SubjectIdentifier, "subject", "", "the 10 digit subject identifier (subject-access-attempt, document-request, content-request)")
+	fs.StringVar(&flags.Region, "region", "", "the region number or name (document-request, content-request)")
+	fs.StringVar(&flags.DocumentIdentifier, "document", "", "the 12 digit document identifier (content-request)")
+	fs.StringVar(&flags.SessionIdentifier, "session", "", "the session the attempt is made within (subject-access-attempt)")
+	file := fs.String("file", "", "read the event, or a JSON array of events, from this file instead of flags")
+	keyringFile := fs.String("keyring", KEYRING_FILE, "encrypt subject identifiers and document metadata with the keys in this file, if it exists")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+
+	var names []string
+	for _, producibleEvent := range producibleEvents() {
+		names = append(names, producibleEvent.Name)
+	}
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: produce <%s> [flags] | --file event.json\n", strings.Join(names, "|"))
+		return 2
+	}
+	producibleEvent, ok := findProducibleEvent(positional[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown event type '%s', expected one of: %s\n", positional[0], strings.Join(names, ", "))
+		return 2
+	}
+
+	var payloads []interface{}
+	if *file != "" {
+		payloads, err = readProducibleEvents(*file, producibleEvent)
+	} else {
+		var payload interface{}
+		payload, err = producibleEvent.FromFlags(flags)
+		payloads = []interface{}{payload}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %s: %v\n", producibleEvent.Name, err)
+		return 1
+	}
+
+	// Validate every event before producing any of them
+	for i, payload := range payloads {
+		if err := payload.(Validator).Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid %s at index %d: %v\n", producibleEvent.Name, i, err)
+			return 1
+		}
+	}
+
+	keyring, err := loadOptionalKeyring(*keyringFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load keyring: %v\n", err)
+		return 1
+	}
+
+	backend := NewBackend()
+	defer backend.Shutdown(context.Background())
+	backend.useKeyring(keyring)
+
+	for i, payload := range payloads {
+		ctx, span := backend.Tracer.Start(context.Background(), "produce", SPAN_KIND_INTERNAL)
+		span.SetAttribute("event.type", producibleEvent.Name)
+		err := producibleEvent.Send(backend, ctx, payload)
+		span.RecordError(err)
+		span.End()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to produce %s at index %d: %v\n", producibleEvent.Name, i, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// readProducibleEvents reads an event, or a JSON array of events, from a file, rejecting unknown fields
+func readProducibleEvents(filename string, producibleEvent ProducibleEvent) ([]interface{}, error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event file: %v", err)
+	}
+
+	var rawEvents []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &rawEvents); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event file: %v", err)
+		}
+	} else {
+		rawEvents = []json.RawMessage{trimmed}
+	}
+
+	var payloads []interface{}
+	for i, rawEvent := range rawEvents {
+		payload := producibleEvent.NewPayload()
+		decoder := json.NewDecoder(bytes.NewReader(rawEvent))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(payload); err != nil {
+			return nil, fmt.Errorf("event at index %d does not match %s: %v", i, producibleEvent.Topic, err)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// TestProduceCommand checks events are produced onto their topic, and the exit code when they cannot be built, are invalid
+// or cannot be saved
+func TestProduceCommand(t *testing.T) {
+
+	inTempDirectory(t)
+	eventFile := filepath.Join(t.TempDir(), "attempts.json")
+	if err := os.WriteFile(eventFile, []byte(`[{"userName": "jbloggs", "userPassword": "a"}, {"userName": "jsmith", "userPassword": "b"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		exitCode int
+		entries  int64
+	}{
+		{"flags", []string{"login-attempt", "--user", "jbloggs", "--password", "12345678"}, 0, 1},
+		{"file", []string{"login-attempt", "--file", eventFile}, 0, 3},
+		{"invalid", []string{"login-attempt", "--password", "12345678"}, 1, 3},
+		{"unknown region", []string{"document-request", "--user", "jbloggs", "--subject", "0101700008", "--region", "atlantis"}, 1, 3},
+		{"unknown event type", []string{"logout"}, 2, 3},
+		{"no event type", nil, 2, 3},
+	}
+	for _, test := range tests {
+		if exitCode := produceCommand(test.args); exitCode != test.exitCode {
+			t.Errorf("%s: exit code %d, want %d", test.name, exitCode, test.exitCode)
+		}
+		if entries := topicLength(ms.NewMessageStore(), USER_LOGIN_ATTEMPT_TOPIC); entries != test.entries {
+			t.Errorf("%s: %d entries in the topic, want %d", test.name, entries, test.entries)
+		}
+	}
+}
+
+// TestProduceCommandSaveFails checks the produce command exits with 1 when an event cannot be saved onto its topic
+func TestProduceCommandSaveFails(t *testing.T) {
+
+	inTempDirectory(t)
+	// a directory in place of the topic's data file cannot be appended to
+	if err := os.Mkdir(USER_SUBJECT_ACCESS_ATTEMPT_TOPIC+".data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"subject-access-attempt", "--user", "jbloggs", "--subject", "0101700008", "--session", "s1"}
+	if exitCode := produceCommand(args); exitCode != 1 {
+		t.Errorf("exit code %d, want 1", exitCode)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Define the structure of ReidentifiedPseudonym
+type ReidentifiedPseudonym struct {
+	Pseudonym string `json:"pseudonym"`
+	Kind      string `json:"kind,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// reidentifyCommand returns the identifiers pseudonyms were derived from, for an authorised user giving a reason. every attempt is audited
+func reidentifyCommand(args []string) int {
+
+	fs := flag.NewFlagSet("reidentify", flag.ContinueOnError)
+	userName := fs.String("user", "", "the user re-identifying the pseudonyms, who must be listed in the authorisation file")
+	reason := fs.String("reason", "", "why the pseudonyms are being re-identified, which is recorded in the audit trail")
+	authorisationFile := fs.String("authorisation-file", REIDENTIFICATION_AUTHORISATION_FILE, "the file listing the users authorised to re-identify pseudonyms")
+	keyringFile := fs.String("keyring", KEYRING_FILE, "the keyring file the pseudonym vault is encrypted with")
+	asJSON := fs.Bool("json", false, "print as JSON")
+	pseudonyms, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(pseudonyms) == 0 || *userName == "" {
+		fmt.Fprintln(os.Stderr, "usage: reidentify <pseudonym>... --user u --reason r [--authorisation-file f] [--keyring f] [--json]")
+		return 2
+	}
+	keyring, err := LoadKeyring(*keyringFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load keyring: %v\n", err)
+		return 1
+	}
+	authorisations, err := LoadReidentificationAuthorisations(*authorisationFile)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "failed to load re-identification authorisations: %v\n", err)
+		return 1
+	}
+
+	backend := NewBackend()
+	defer backend.Shutdown(context.Background())
+	backend.useKeyring(keyring)
+	// keep the audit trail's logging out of the results
+	backend.Log = io.Discard
+
+	exitCode := 0
+	var results []ReidentifiedPseudonym
+	for _, pseudonym := range pseudonyms {
+		result := ReidentifiedPseudonym{Pseudonym: pseudonym}
+		vaultEntry, err := backend.ForComponent(REIDENTIFICATION_COMPONENT).Reidentify(context.Background(), authorisations, *userName, *reason, pseudonym)
+		if err != nil {
+			result.Error = err.Error()
+			exitCode = 1
+		} else {
+			result.Kind = vaultEntry.Kind
+			result.Value = vaultEntry.Value
+		}
+		results = append(results, result)
+	}
+	backend.Log = os.Stdout
+
+	if *asJSON {
+		printJSON(results)
+		return exitCode
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Printf("%s  %s\n", result.Pseudonym, result.Error)
+			continue
+		}
+		fmt.Printf("%s  %s=%s\n", result.Pseudonym, result.Kind, result.Value)
+	}
+	return exitCode
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// replayCommand re-feeds entries from a topic into a handler, optionally into shadow topics or as a dry run
+func replayCommand(args []string) int {
+
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fromOffset := fs.Int64("from-offset", 0, "the first offset to replay")
+	toOffset := fs.Int64("to-offset", -1, "the last offset to replay, or -1 for the end of the topic")
+	fromTime := fs.String("from-time", "", "skip entries saved before this RFC 3339 time")
+	toTime := fs.String("to-time", "", "stop at the first entry saved after this RFC 3339 time")
+	handlerName := fs.String("handler", "", "the handler to replay into (default: the consumer of the topic)")
+	shadow := fs.String("shadow", "", "save emitted entries to shadow topics with this prefix instead of the live topics")
+	dryRun := fs.Bool("dry-run", false, "report what would be emitted without saving anything")
+	keyringFile := fs.String("keyring", KEYRING_FILE, "decrypt the entries replayed, and encrypt those emitted, with the keys in this file, if it exists")
+	consentFile := fs.String("consent", CONSENT_FILE, "skip the regions, and withhold the document categories, sealed by the record seals in this file, if it exists")
+	entitlementsFile := fs.String("entitlements", ENTITLEMENTS_FILE, "withhold the documents a user is not entitled to see by the entitlements in this file, if it exists")
+	asJSON := fs.Bool("json", false, "print as JSON")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: replay <topic> [--from-offset n] [--to-offset n] [--from-time t] [--to-time t] [--handler name] [--shadow prefix] [--dry-run] [--keyring file] [--consent file] [--entitlements file] [--json]")
+		return 2
+	}
+
+	options := ReplayOptions{
+		Topic:      positional[0],
+		FromOffset: *fromOffset,
+		ToOffset:   *toOffset,
+	}
+	if options.FromTime, err = parseOptionalTime(*fromTime); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --from-time: %v\n", err)
+		return 2
+	}
+	if options.ToTime, err = parseOptionalTime(*toTime); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --to-time: %v\n", err)
+		return 2
+	}
+
+	if options.Keyring, err = loadOptionalKeyring(*keyringFile); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load keyring: %v\n", err)
+		return 1
+	}
+	if options.Consent, err = loadOptionalConsentService(*consentFile); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load consent file: %v\n", err)
+		return 1
+	}
+	if options.Entitlements, err = loadOptionalEntitlementService(*entitlementsFile); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load entitlements file: %v\n", err)
+		return 1
+	}
+
+	var ok bool
+	if *handlerName == "" {
+		options.Handler, ok = findReplayHandlerForTopic(options.Topic)
+	} else {
+		options.Handler, ok = findReplayHandler(*handlerName)
+	}
+	if !ok {
+		fmt.Fprintln(os.Stderr, "no handler found, expected one of:")
+		for _, replayHandler := range replayHandlers() {
+			fmt.Fprintf(os.Stderr, "  %-45s consumes %s\n", replayHandler.Name, replayHandler.Topic)
+		}
+		return 2
+	}
+	if options.Handler.Topic != options.Topic {
+		fmt.Fprintf(os.Stderr, "handler '%s' consumes topic '%s', not '%s'\n", options.Handler.Name, options.Handler.Topic, options.Topic)
+		return 2
+	}
+
+	store := ms.NewMessageStore()
+
+	var destination MockableMessageStore = store
+	var dryRunStore *DryRunMessageStore
+	switch {
+	case *dryRun:
+		dryRunStore = NewDryRunMessageStore(store)
+		destination = dryRunStore
+	case *shadow != "":
+		destination = NewShadowMessageStore(store, *shadow)
+	}
+
+	// Create a context that cancels on a termination signal
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	report, err := replay(ctx, store, destination, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay stopped: %v\n", err)
+	}
+
+	if *asJSON {
+		printJSON(report)
+	} else {
+		fmt.Printf("replayed %d of %d entries read from %s into %s\n", report.Replayed, report.Read, report.Topic, report.Handler)
+		if report.Rejected > 0 {
+			fmt.Printf("  rejected %d entries which do not match the topic's schema\n", report.Rejected)
+		}
+		topics := make([]string, 0, len(report.Emitted))
+		for topic := range report.Emitted {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		for _, topic := range topics {
+			fmt.Printf("  emitted %d entries to %s\n", report.Emitted[topic], topic)
+		}
+		if dryRunStore != nil {
+			fmt.Println("dry run, entries which would have been emitted:")
+			for _, recorded := range dryRunStore.Recorded() {
+				printDecodedEntry(decodeEntry(recorded.Topic, recorded.Offset, recorded.Entry), false)
+			}
+		}
+	}
+
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// parseOptionalTime parses an RFC 3339 time, returning the zero time for an empty string
+func parseOptionalTime(s string) (time.Time, error) {
+
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
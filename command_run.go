@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runCommand runs the simulator until a termination signal is received
+func runCommand(args []string) int {
+
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	traceFile := fs.String("trace-file", "", "write OTLP/JSON traces to this file")
+	traceEndpoint := fs.String("trace-endpoint", "", "post OTLP/JSON traces to this collector endpoint, e.g. http://localhost:4318/v1/traces")
+	healthAddr := fs.String("health-addr", "localhost:8080", "serve /healthz and /readyz on this address, or disable when empty")
+	crashBudget := fs.Int("crash-budget", 5, "shut down once more than this many worker crashes occur within the crash window")
+	crashWindow := fs.Duration("crash-window", time.Minute, "the window over which worker crashes are counted against the crash budget")
+	offsetsFile := fs.String("offsets-file", "consumer.offsets.json", "the file consumer offsets are committed to")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 15*time.Second, "the time allowed for in-flight work to drain on shutdown")
+	scenarioFile := fs.String("scenario", "", "run the phases of this scenario file instead of generating random logins, then check its assertions and shut down")
+	codecSpec := fs.String("codec", "json", "the codec payloads are written with, then any topic=codec overrides, e.g. json,subject.region.document.response=protobuf")
+	compressionSpec := fs.String("compression", "none", "the compression of payloads, then any topic=compression overrides, each none or gzip, zstd or snappy with an optional threshold in bytes, e.g. none,subject.region.document.response=zstd:512")
+	keyringFile := fs.String("keyring", KEYRING_FILE, "encrypt and decrypt subject identifiers and document metadata with the keys in this file, if it exists")
+	consentFile := fs.String("consent", CONSENT_FILE, "skip the regions, and withhold the document categories, sealed for a subject by the record seals in this file, if it exists")
+	entitlementsFile := fs.String("entitlements", ENTITLEMENTS_FILE, "withhold the documents a user is not entitled to see by the entitlements in this file, if it exists")
+	catalogueFile := fs.String("catalogue", "", "generate documents from this document catalogue file (default: the catalogue built into the application)")
+	contentStoreDirectory := fs.String("content-store", CONTENT_STORE_DIRECTORY, "the directory the content of documents is stored in")
+	maxContentSize := fs.Int("max-content-size", DEFAULT_MAX_DOCUMENT_CONTENT_SIZE, "refuse to send the content of documents larger than this many bytes")
+	contentChunkSize := fs.Int("content-chunk-size", DEFAULT_DOCUMENT_CONTENT_CHUNK_SIZE, "send the content of documents in chunks of this many bytes")
+	sessionTTL := fs.Duration("session-ttl", DEFAULT_SESSION_TTL, "expire sessions this long after the login which started them")
+	populationFile := fs.String("population", POPULATION_FILE, "draw access attempts from the subjects in this population file, generating the default file if it does not exist, or make up a subject for each when empty")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	topicCodecs, err := ParseTopicCodecs(*codecSpec)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	topicCompression, err := ParseTopicCompression(*compressionSpec)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	keyring, err := loadOptionalKeyring(*keyringFile)
+	if err != nil {
+		fmt.Println("failed to load keyring: ", err)
+		return 1
+	}
+	consent, err := loadOptionalConsentService(*consentFile)
+	if err != nil {
+		fmt.Println("failed to load consent file: ", err)
+		return 1
+	}
+	entitlements, err := loadOptionalEntitlementService(*entitlementsFile)
+	if err != nil {
+		fmt.Println("failed to load entitlements file: ", err)
+		return 1
+	}
+	catalogue := embeddedDocumentCatalogue
+	if *catalogueFile != "" {
+		if catalogue, err = LoadDocumentCatalogue(*catalogueFile); err != nil {
+			fmt.Println("failed to load document catalogue: ", err)
+			return 1
+		}
+	}
+	if *maxContentSize <= 0 || *contentChunkSize <= 0 {
+		fmt.Println("--max-content-size and --content-chunk-size must be positive")
+		return 2
+	}
+	if *sessionTTL <= 0 {
+		fmt.Println("--session-ttl must be positive")
+		return 2
+	}
+	population, err := loadOrGeneratePopulation(*populationFile)
+	if err != nil {
+		fmt.Println("failed to load population: ", err)
+		return 1
+	}
+
+	// Create a context that cancels when the application terminates, and a child context which stops the generators first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	generatorCtx, stopGenerators := context.WithCancel(ctx)
+	defer stopGenerators()
+
+	// Create a signal channel to capture termination signals
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	backend := NewBackend()
+	backend.Codecs = topicCodecs
+	// the message store holds the backend's compression settings, so they are replaced in place
+	*backend.Compression = *topicCompression
+	backend.useKeyring(keyring)
+	backend.Consent = consent
+	backend.Entitlements = entitlements
+	backend.Catalogue = catalogue
+	backend.Population = population
+	backend.ContentStore = NewContentStore(*contentStoreDirectory)
+	backend.ContentStore.MaxSize = *maxContentSize
+	backend.ContentStore.ChunkSize = *contentChunkSize
+	backend.Sessions.TTL = *sessionTTL
+
+	// Resume each consumer from its committed offset
+	offsets, err := NewFileConsumerOffsets(*offsetsFile, backend.Log)
+	if err != nil {
+		fmt.Println("failed to load consumer offsets: ", err)
+		return 1
+	}
+	backend.Offsets = offsets
+
+	// Load the scenario, whose personas the consumers recognise
+	var scenario *Scenario
+	if *scenarioFile != "" {
+		scenario, err = LoadScenario(*scenarioFile)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		backend.Personas = NewPersonaDirectory(scenario.Personas)
+	}
+
+	// Configure the trace exporter
+	switch {
+	case *traceFile != "":
+		exporter, err := NewOTLPFileExporter(*traceFile)
+		if err != nil {
+			fmt.Println("failed to create trace exporter: ", err)
+			return 1
+		}
+		backend.Tracer = NewTracer(SERVICE_NAME, exporter)
+	case *traceEndpoint != "":
+		backend.Tracer = NewTracer(SERVICE_NAME, NewOTLPHTTPExporter(*traceEndpoint))
+	}
+
+	// Start a goroutine to serve the health endpoints
+	healthServer := &http.Server{Addr: *healthAddr, Handler: NewHealthHandler(backend)}
+	if *healthAddr != "" {
+		go func() {
+			if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Println("health endpoint stopped: ", err)
+			}
+		}()
+	}
+
+	// Create a supervisor which restarts crashed workers, and escalates to shutdown once the crash budget is exhausted
+	escalateCh := make(chan string, 1)
+	supervisor := NewSupervisor(*crashBudget, *crashWindow, backend.ForComponent(SUPERVISOR_COMPONENT).recordCrashEvent, func(reason string) {
+		select {
+		case escalateCh <- reason:
+		default:
+		}
+	})
+
+	// Start a supervised goroutine to poll SYSTEM_AUDIT_EVENT_TOPIC
+	supervisor.Go(ctx, SYSTEM_AUDIT_EVENT_CONSUMER, backend.ForComponent(SYSTEM_AUDIT_EVENT_CONSUMER).pollSystemAuditEvent)
+
+	// Start a supervised goroutine to poll USER_LOGIN_ATTEMPT_TOPIC
+	supervisor.Go(ctx, USER_LOGIN_ATTEMPT_CONSUMER, backend.ForComponent(USER_LOGIN_ATTEMPT_CONSUMER).pollUserLoginAttempt)
+	// Start a supervised goroutine to poll USER_LOGIN_ATTEMPT_OUTCOME_TOPIC
+	supervisor.Go(ctx, USER_LOGIN_ATTEMPT_OUTCOME_CONSUMER, backend.ForComponent(USER_LOGIN_ATTEMPT_OUTCOME_CONSUMER).pollUserLoginAttemptOutcome)
+
+	// Start a supervised goroutine to poll USER_SUBJECT_ACCESS_ATTEMPT_TOPIC
+	supervisor.Go(ctx, USER_SUBJECT_ACCESS_ATTEMPT_CONSUMER, backend.ForComponent(USER_SUBJECT_ACCESS_ATTEMPT_CONSUMER).pollUserSubjectAccessAttempt)
+	// Start a supervised goroutine to poll USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC
+	supervisor.Go(ctx, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_CONSUMER, backend.ForComponent(USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_CONSUMER).pollUserSubjectAccessAttemptOutcome)
+
+	// Start a supervised goroutine to poll SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC
+	supervisor.Go(ctx, SUBJECT_REGION_DOCUMENT_REQUEST_CONSUMER, backend.ForComponent(SUBJECT_REGION_DOCUMENT_REQUEST_CONSUMER).pollSubjectRegionDocumentRequest)
+	// Start a supervised goroutine to poll SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC
+	supervisor.Go(ctx, SUBJECT_REGION_DOCUMENT_RESPONSE_CONSUMER, backend.ForComponent(SUBJECT_REGION_DOCUMENT_RESPONSE_CONSUMER).pollSubjectRegionDocumentResponse)
+
+	// Start a supervised goroutine to poll DOCUMENT_CONTENT_REQUEST_TOPIC
+	supervisor.Go(ctx, DOCUMENT_CONTENT_REQUEST_CONSUMER, backend.ForComponent(DOCUMENT_CONTENT_REQUEST_CONSUMER).pollDocumentContentRequest)
+	// Start a supervised goroutine to poll DOCUMENT_CONTENT_RESPONSE_TOPIC
+	supervisor.Go(ctx, DOCUMENT_CONTENT_RESPONSE_CONSUMER, backend.ForComponent(DOCUMENT_CONTENT_RESPONSE_CONSUMER).pollDocumentContentResponse)
+
+	// Start a supervised goroutine to expire sessions on USER_SESSION_EVENT_TOPIC
+	supervisor.Go(ctx, SESSION_SWEEPER_COMPONENT, backend.ForComponent(SESSION_SWEEPER_COMPONENT).sweepUserSessions)
+
+	// Start supervised goroutines to republish SYSTEM_AUDIT_EVENT_TOPIC and USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, pseudonymised, for analytics
+	if backend.Pseudonymiser != nil {
+		supervisor.Go(ctx, SYSTEM_AUDIT_EVENT_PSEUDONYMISER, backend.ForComponent(SYSTEM_AUDIT_EVENT_PSEUDONYMISER).pseudonymiseSystemAuditEvent)
+		supervisor.Go(ctx, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER, backend.ForComponent(USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER).pseudonymiseUserSubjectAccessAttemptOutcome)
+	} else {
+		fmt.Println("pseudonymisation is disabled until a keyring is loaded")
+	}
+
+	// Start a goroutine to run the scenario, or a supervised goroutine to generate user login attempts to USER_LOGIN_ATTEMPT_TOPIC
+	var generatorsDone <-chan struct{}
+	var scenarioDone chan []ScenarioAssertionResult
+	if scenario != nil {
+		done := make(chan struct{})
+		generatorsDone = done
+		scenarioDone = make(chan []ScenarioAssertionResult, 1)
+		go func() {
+			defer close(done)
+			scenarioDone <- backend.runScenario(generatorCtx, scenario)
+		}()
+	} else {
+		generatorsDone = supervisor.Go(generatorCtx, USER_LOGIN_ATTEMPT_GENERATOR, backend.ForComponent(USER_LOGIN_ATTEMPT_GENERATOR).generateUserLoginAttempts)
+	}
+
+	// Wait for termination signal, for the supervisor to give up, or for the scenario to finish
+	exitCode := 0
+	select {
+	case results := <-scenarioDone:
+		if !printScenarioAssertionResults(results) {
+			exitCode = 1
+		}
+	case <-sigCh:
+		fmt.Println("Termination signal received, canceling polling")
+	case reason := <-escalateCh:
+		fmt.Println("Supervisor escalated to shutdown: ", reason)
+		exitCode = 1
+	}
+
+	// Drain in-flight work, bounded by the shutdown timeout
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancelShutdown()
+
+	// Stop the generators first, so no new flows start
+	stopGenerators()
+	if !waitUntil(shutdownCtx, generatorsDone) {
+		fmt.Println("shutdown timeout reached while stopping generators")
+	}
+
+	// Let each consumer finish its current entry and commit its offset
+	cancel() // Cancel the context
+	if !waitUntil(shutdownCtx, supervisor.Done()) {
+		fmt.Println("shutdown timeout reached while draining consumers")
+		exitCode = 1
+	}
+
+	// Flush buffered producers, commit offsets and close the message store
+	if err := backend.Shutdown(shutdownCtx); err != nil {
+		exitCode = 1
+	}
+	healthServer.Shutdown(shutdownCtx)
+
+	fmt.Println("Shutdown complete")
+	return exitCode
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// schemaCommand lists, shows, diffs and registers topic payload schemas
+func schemaCommand(args []string) int {
+
+	usage := "usage: schema list | show <topic> [--version n|current] | diff <topic> [--from n] [--to n|current] | register [--dir schemas] | proto"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	registry, err := LoadSchemaRegistry(schemaFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load schemas: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		return schemaListCommand(registry)
+	case "show":
+		return schemaShowCommand(registry, args[1:])
+	case "diff":
+		return schemaDiffCommand(registry, args[1:])
+	case "register":
+		return schemaRegisterCommand(registry, args[1:])
+	case "proto":
+		return schemaProtoCommand()
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// schemaListCommand prints the latest registered version of each topic's schema, and whether the code still matches it
+func schemaListCommand(registry *SchemaRegistry) int {
+
+	for _, topicDefinition := range topicDefinitions() {
+		latest := registry.LatestVersion(topicDefinition.Name)
+		status := "up to date"
+		if latest == 0 {
+			status = "not registered"
+		} else if len(diffTopicSchema(registry, topicDefinition, latest)) > 0 {
+			status = "code has changed since the latest version"
+		}
+		fmt.Printf("%-46s v%-4d %s\n", topicDefinition.Name, latest, status)
+	}
+	return 0
+}
+
+// schemaShowCommand prints a version of a topic's schema
+func schemaShowCommand(registry *SchemaRegistry, args []string) int {
+
+	fs := flag.NewFlagSet("schema show", flag.ContinueOnError)
+	version := fs.String("version", "", "the version to show, or current for the schema of the code (default: the latest version)")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: schema show <topic> [--version n|current]")
+		return 2
+	}
+
+	schema, err := resolveSchemaVersion(registry, positional[0], *version)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return printJSON(schema)
+}
+
+// schemaDiffCommand prints the changes between two versions of a topic's schema, failing if any is breaking
+func schemaDiffCommand(registry *SchemaRegistry, args []string) int {
+
+	fs := flag.NewFlagSet("schema diff", flag.ContinueOnError)
+	from := fs.String("from", "", "the version to diff from (default: the latest version)")
+	to := fs.String("to", "current", "the version to diff to, or current for the schema of the code")
+	asJSON := fs.Bool("json", false, "print as JSON")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: schema diff <topic> [--from n] [--to n|current] [--json]")
+		return 2
+	}
+	topic := positional[0]
+
+	fromSchema, err := resolveSchemaVersion(registry, topic, *from)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	toSchema, err := resolveSchemaVersion(registry, topic, *to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	changes := diffJSONSchemas("$", fromSchema, toSchema)
+	breaking := false
+	for _, change := range changes {
+		breaking = breaking || change.Breaking
+	}
+
+	if *asJSON {
+		printJSON(changes)
+	} else {
+		fmt.Printf("%s: %s -> %s\n", topic, fromSchema.ID, toSchema.ID)
+		if len(changes) == 0 {
+			fmt.Println("  no changes")
+		}
+		for _, change := range changes {
+			kind := "compatible"
+			if change.Breaking {
+				kind = "BREAKING"
+			}
+			fmt.Printf("  %-10s %s: %s\n", kind, change.Path, change.Change)
+		}
+	}
+	if breaking {
+		return 1
+	}
+	return 0
+}
+
+// schemaRegisterCommand writes a new schema version for every topic whose payload type has changed since its latest registered version
+func schemaRegisterCommand(registry *SchemaRegistry, args []string) int {
+
+	fs := flag.NewFlagSet("schema register", flag.ContinueOnError)
+	dir := fs.String("dir", SCHEMA_DIRECTORY, "the schema directory of the source tree, which is built into the application")
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return 2
+	}
+
+	written, err := registry.registerGeneratedSchemas(*dir)
+	topics := make([]string, 0, len(written))
+	for topic := range written {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		fmt.Printf("registered %s v%d\n", topic, written[topic])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(written) == 0 {
+		fmt.Println("every schema is up to date")
+	} else {
+		fmt.Println("rebuild the application to use the new versions")
+	}
+	return 0
+}
+
+// schemaProtoCommand prints the proto3 definitions of every topic's payload type, for consumers of protobuf payloads
+func schemaProtoCommand() int {
+
+	proto, err := generateProtoFile()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Print(proto)
+	return 0
+}
+
+// resolveSchemaVersion returns a registered version of a topic's schema, the latest when version is empty, or the schema of the code when version is current
+func resolveSchemaVersion(registry *SchemaRegistry, topic, version string) (*JSONSchema, error) {
+
+	topicDefinition, ok := findTopicDefinition(topic)
+	if !ok {
+		return nil, fmt.Errorf("unknown topic '%s'", topic)
+	}
+
+	latest := registry.LatestVersion(topic)
+	switch version {
+	case "current":
+		return generateTopicSchema(topicDefinition, latest+1), nil
+	case "":
+		version = strconv.Itoa(latest)
+	}
+
+	n, err := strconv.Atoi(version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version '%s'", version)
+	}
+	schema, ok := registry.Version(topic, n)
+	if !ok {
+		return nil, fmt.Errorf("topic '%s' has no schema version %d, the latest is %d", topic, n, latest)
+	}
+	return schema, nil
+}
+
+// diffTopicSchema returns the changes from a registered version of a topic's schema to the schema of the code
+func diffTopicSchema(registry *SchemaRegistry, topicDefinition TopicDefinition, version int) []SchemaChange {
+
+	schema, _ := registry.Version(topicDefinition.Name, version)
+	return diffJSONSchemas("$", schema, generateTopicSchema(topicDefinition, version))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// sessionsCommand lists the sessions which have started and have not yet ended or expired, or ends one of them
+func sessionsCommand(args []string) int {
+
+	usage := "usage: sessions [--json] | end <session> [--reason r]"
+	fs := flag.NewFlagSet("sessions", flag.ContinueOnError)
+	reason := fs.String("reason", "ended by operator", "the reason the session is ended, which is recorded in the audit trail")
+	asJSON := fs.Bool("json", false, "print as JSON")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+
+	backend := NewBackend()
+	defer backend.Shutdown(context.Background())
+
+	switch {
+	case len(positional) == 0:
+		return sessionsListCommand(backend, *asJSON)
+	case len(positional) == 2 && positional[0] == "end":
+		return sessionsEndCommand(backend, positional[1], *reason)
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// sessionsListCommand prints the sessions which have started and have not yet ended or expired, the earliest started first.
+// a session past its expiry time which the sweeper has not yet expired is listed, but no longer accepted
+func sessionsListCommand(backend *Backend, asJSON bool) int {
+
+	sessions := backend.ActiveUserSessions()
+	if asJSON {
+		return printJSON(sessions)
+	}
+
+	fmt.Printf("%d sessions\n", len(sessions))
+	fmt.Printf("%-32s %-24s %-20s %s\n", "SESSION", "USER", "STARTED", "EXPIRES")
+	now := time.Now()
+	for _, session := range sessions {
+		expires := session.ExpiresAt.Format(time.RFC3339)
+		if !now.Before(session.ExpiresAt) {
+			expires += " (expired)"
+		}
+		fmt.Printf("%-32s %-24s %-20s %s\n", session.SessionIdentifier, session.UserName, session.StartedAt.Format(time.RFC3339), expires)
+	}
+	return 0
+}
+
+// sessionsEndCommand ends a session, so the access attempts made within it are rejected from then on
+func sessionsEndCommand(backend *Backend, sessionIdentifier, reason string) int {
+
+	session, err := backend.endUserSession(context.Background(), sessionIdentifier, reason)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("ended session '%s' of user '%s'\n", session.SessionIdentifier, session.UserName)
+	return 0
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the structure of TopicSummary
+type TopicSummary struct {
+	Topic       string `json:"topic"`
+	PayloadType string `json:"payloadType,omitempty"`
+	Entries     int64  `json:"entries"`
+}
+
+// Define the structure of TopicDescription
+type TopicDescription struct {
+	TopicSummary
+	FirstTimestamp time.Time        `json:"firstTimestamp,omitempty"`
+	LastTimestamp  time.Time        `json:"lastTimestamp,omitempty"`
+	ValueBytes     int64            `json:"valueBytes"`
+	DecodeErrors   int64            `json:"decodeErrors"`
+	Keys           map[string]int64 `json:"keys"`
+	Headers        map[string]int64 `json:"headers"`
+}
+
+// topicsCommand lists topics with their payload type and entry count
+func topicsCommand(args []string) int {
+
+	fs := flag.NewFlagSet("topics", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return 2
+	}
+
+	store := ms.NewMessageStore()
+
+	var summaries []TopicSummary
+	for _, topic := range discoverTopics() {
+		summary := TopicSummary{Topic: topic, Entries: topicLength(store, topic)}
+		if topicDefinition, ok := findTopicDefinition(topic); ok {
+			summary.PayloadType = topicDefinition.PayloadType
+		}
+		summaries = append(summaries, summary)
+	}
+
+	if *asJSON {
+		return printJSON(summaries)
+	}
+	fmt.Printf("%-46s %-45s %s\n", "TOPIC", "PAYLOAD TYPE", "ENTRIES")
+	for _, summary := range summaries {
+		fmt.Printf("%-46s %-45s %d\n", summary.Topic, summary.PayloadType, summary.Entries)
+	}
+	return 0
+}
+
+// describeCommand describes a topic's entries, keys and headers
+func describeCommand(args []string) int {
+
+	fs := flag.NewFlagSet("describe", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: describe <topic> [--json]")
+		return 2
+	}
+	topic := positional[0]
+
+	store := ms.NewMessageStore()
+
+	description := TopicDescription{
+		TopicSummary: TopicSummary{Topic: topic},
+		Keys:         make(map[string]int64),
+		Headers:      make(map[string]int64),
+	}
+	if topicDefinition, ok := findTopicDefinition(topic); ok {
+		description.PayloadType = topicDefinition.PayloadType
+	}
+
+	length := topicLength(store, topic)
+	for offset := int64(0); offset < length; offset++ {
+		entry, err := store.ReadEntry(topic, offset)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ReadEntry for topic '%s', offset %d, failed: %v\n", topic, offset, err)
+			return 1
+		}
+		decodedEntry := decodeEntry(topic, offset, *entry)
+
+		description.Entries++
+		description.ValueBytes += int64(len(entry.Value))
+		if description.FirstTimestamp.IsZero() {
+			description.FirstTimestamp = entry.Timestamp
+		}
+		description.LastTimestamp = entry.Timestamp
+		if decodedEntry.DecodeError != "" {
+			description.DecodeErrors++
+		}
+		description.Keys[decodedEntry.Key]++
+		for header := range decodedEntry.Headers {
+			description.Headers[header]++
+		}
+	}
+
+	if *asJSON {
+		return printJSON(description)
+	}
+	fmt.Printf("topic:          %s\n", description.Topic)
+	fmt.Printf("payload type:   %s\n", description.PayloadType)
+	fmt.Printf("entries:        %d\n", description.Entries)
+	if description.Entries > 0 {
+		fmt.Printf("offsets:        0 - %d\n", description.Entries-1)
+		fmt.Printf("first entry:    %s\n", description.FirstTimestamp.Format(time.RFC3339))
+		fmt.Printf("last entry:     %s\n", description.LastTimestamp.Format(time.RFC3339))
+	}
+	fmt.Printf("value bytes:    %d\n", description.ValueBytes)
+	fmt.Printf("decode errors:  %d\n", description.DecodeErrors)
+	fmt.Println("headers:")
+	printCounts(description.Headers)
+	fmt.Println("keys:")
+	printCounts(description.Keys)
+	return 0
+}
+
+// readCommand prints the entry at an offset
+func readCommand(args []string) int {
+
+	fs := flag.NewFlagSet("read", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: read <topic> <offset> [--json]")
+		return 2
+	}
+	topic := positional[0]
+	offset, err := strconv.ParseInt(positional[1], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid offset '%s'\n", positional[1])
+		return 2
+	}
+
+	store := ms.NewMessageStore()
+
+	entry, err := store.ReadEntry(topic, offset)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ReadEntry for topic '%s', offset %d, failed: %v\n", topic, offset, err)
+		return 1
+	}
+	printDecodedEntry(decodeEntry(topic, offset, *entry), *asJSON)
+	return 0
+}
+
+// tailCommand prints entries from an offset, optionally following new entries
+func tailCommand(args []string) int {
+
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	from := fs.Int64("from", -1, "the offset to start from (default: the last 10 entries)")
+	limit := fs.Int64("limit", 0, "stop after printing this many entries, or 0 for no limit")
+	key := fs.String("key", "", "only print entries with this key")
+	follow := fs.Bool("follow", false, "keep polling for new entries until interrupted")
+	asJSON := fs.Bool("json", false, "print as JSON, one entry per line")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: tail <topic> [--from offset] [--limit n] [--key key] [--follow] [--json]")
+		return 2
+	}
+	topic := positional[0]
+
+	store := ms.NewMessageStore()
+
+	offset := *from
+	if offset < 0 {
+		offset = topicLength(store, topic) - 10
+		if offset < 0 {
+			offset = 0
+		}
+	}
+
+	// Create a signal channel to stop following
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	err = tailTopic(store, topic, offset, *limit, *key, *follow, sigCh, func(decodedEntry DecodedEntry) {
+		printDecodedEntry(decodedEntry, *asJSON)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// tailTopic prints the entries of a topic from an offset, skipping those whose key differs from key when it is set. it returns
+// once limit entries have been printed, or, unless following, at the end of the topic. when following it polls for new entries
+// at the end of the topic until a signal is received
+func tailTopic(store MockableMessageStore, topic string, offset, limit int64, key string, follow bool, sigCh <-chan os.Signal, printEntry func(DecodedEntry)) error {
+
+	var printed int64
+	for limit == 0 || printed < limit {
+		entry, err := store.ReadEntry(topic, offset)
+		if err != nil {
+			// the end of the topic has been reached
+			if !follow {
+				return nil
+			}
+			select {
+			case <-sigCh:
+				return nil
+			default:
+			}
+			entry, err = store.PollForNextEntry(topic, offset-1, 500*time.Millisecond)
+			if err != nil {
+				return fmt.Errorf("PollForNextEntry for topic '%s', offset %d, failed: %v", topic, offset-1, err)
+			}
+			if entry == nil {
+				continue
+			}
+		}
+
+		decodedEntry := decodeEntry(topic, offset, *entry)
+		offset++
+		if key != "" && decodedEntry.Key != key {
+			continue
+		}
+		printEntry(decodedEntry)
+		printed++
+	}
+	return nil
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(v interface{}) int {
+
+	output, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to marshal output: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(output))
+	return 0
+}
+
+// printCounts prints counts in descending order
+func printCounts(counts map[string]int64) {
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Printf("  %-38s %d\n", name, counts[name])
+	}
+}
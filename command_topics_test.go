@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// inTempDirectory changes into a new temporary directory for the rest of the test, so the message store's topic files are kept there
+func inTempDirectory(t *testing.T) {
+
+	t.Helper()
+	directory, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(directory); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// newSystemAuditEventEntry returns an entry of a system audit event about a subject, keyed by its user and encoded with a codec
+func newSystemAuditEventEntry(t *testing.T, codec Codec, userName, auditEvent string) ms.Entry {
+
+	t.Helper()
+	payload, err := codec.Marshal(NewSystemAuditEventWithSubject(userName, "0101700008", auditEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newMessageStoreEntry(context.Background(), SYSTEM_AUDIT_EVENT_TOPIC, userName, codec, payload)
+}
+
+// saveSystemAuditEvents saves a system audit event for each user in turn
+func saveSystemAuditEvents(t *testing.T, store MockableMessageStore, userNames ...string) {
+
+	t.Helper()
+	for i, userName := range userNames {
+		entry := newSystemAuditEventEntry(t, JSONCodec{}, userName, fmt.Sprintf("event %d", i))
+		if _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestTopicLength checks the length of topics either side of each power of two, and of an empty topic, in memory and on disk
+func TestTopicLength(t *testing.T) {
+
+	for _, length := range []int{0, 1, 2, 3, 4, 5, 7, 8, 9, 31, 32, 33, 100} {
+		store := newMemoryMessageStore()
+		for i := 0; i < length; i++ {
+			if _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, ms.Entry{Value: []byte(`{}`)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := topicLength(store, SYSTEM_AUDIT_EVENT_TOPIC); got != int64(length) {
+			t.Errorf("%d entries: got length %d, want %d", length, got, length)
+		}
+	}
+
+	inTempDirectory(t)
+	store := ms.NewMessageStore()
+	saveSystemAuditEvents(t, store, "jbloggs", "jsmith", "jbloggs")
+	if got := topicLength(store, SYSTEM_AUDIT_EVENT_TOPIC); got != 3 {
+		t.Errorf("on disk: got length %d, want 3", got)
+	}
+	if got := topicLength(store, USER_LOGIN_ATTEMPT_TOPIC); got != 0 {
+		t.Errorf("no topic file: got length %d, want 0", got)
+	}
+}
+
+// TestDecodeEntry checks entries are decoded into their topic's payload whatever their codec, compressed or with encrypted fields,
+// and entries which cannot be decoded keep their payload as it is with the error recorded
+func TestDecodeEntry(t *testing.T) {
+
+	// long enough to be worth compressing
+	auditEvent := strings.Repeat("login attempt ", 100)
+	want := NewSystemAuditEventWithSubject("jbloggs", "0101700008", auditEvent)
+	compressed, err := compressEntry(CompressionPolicy{Compressor: GzipCompressor{}, Threshold: 0}, newSystemAuditEventEntry(t, JSONCodec{}, "jbloggs", auditEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryption := &FieldEncryption{Keyring: newTestKeyring(t)}
+	encrypted, err := encryption.EncryptEntry(SYSTEM_AUDIT_EVENT_TOPIC, newSystemAuditEventEntry(t, ProtobufCodec{}, "jbloggs", auditEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	undecodable := newMessageStoreEntry(context.Background(), SYSTEM_AUDIT_EVENT_TOPIC, "jbloggs", ProtobufCodec{}, []byte{0xff})
+
+	tests := []struct {
+		name    string
+		topic   string
+		entry   ms.Entry
+		header  string
+		decoded bool
+	}{
+		{"json", SYSTEM_AUDIT_EVENT_TOPIC, newSystemAuditEventEntry(t, JSONCodec{}, "jbloggs", auditEvent), "", true},
+		{"protobuf", SYSTEM_AUDIT_EVENT_TOPIC, newSystemAuditEventEntry(t, ProtobufCodec{}, "jbloggs", auditEvent), CONTENT_TYPE_HEADER, true},
+		{"msgpack", SYSTEM_AUDIT_EVENT_TOPIC, newSystemAuditEventEntry(t, MessagePackCodec{}, "jbloggs", auditEvent), CONTENT_TYPE_HEADER, true},
+		{"compressed", SYSTEM_AUDIT_EVENT_TOPIC, compressed, CONTENT_ENCODING_HEADER, true},
+		{"encrypted", SYSTEM_AUDIT_EVENT_TOPIC, encrypted, ENCRYPTION_KEY_HEADER, true},
+		{"undecodable", SYSTEM_AUDIT_EVENT_TOPIC, undecodable, CONTENT_TYPE_HEADER, false},
+		{"unknown topic", "no.such.topic", newSystemAuditEventEntry(t, JSONCodec{}, "jbloggs", auditEvent), "", false},
+	}
+	for _, test := range tests {
+		decodedEntry := decodeEntry(test.topic, 7, test.entry)
+		if decodedEntry.Topic != test.topic || decodedEntry.Offset != 7 || decodedEntry.Key != "jbloggs" || !decodedEntry.Timestamp.Equal(test.entry.Timestamp) {
+			t.Errorf("%s: decoded %+v, want the entry's topic, offset, key and timestamp", test.name, decodedEntry)
+		}
+		if _, ok := decodedEntry.Headers[test.header]; test.header != "" && !ok {
+			t.Errorf("%s: headers %v, want %s", test.name, decodedEntry.Headers, test.header)
+		}
+
+		payload, ok := decodedEntry.Payload.(*SystemAuditEvent)
+		if !test.decoded {
+			if ok {
+				t.Errorf("%s: decoded %+v, want the payload left as it is", test.name, payload)
+			}
+			if test.topic == SYSTEM_AUDIT_EVENT_TOPIC && decodedEntry.DecodeError == "" {
+				t.Errorf("%s: no decode error recorded", test.name)
+			}
+			continue
+		}
+		if !ok || decodedEntry.DecodeError != "" || decodedEntry.PayloadType != "SystemAuditEvent" {
+			t.Errorf("%s: decoded %T as %q, error %q, want a SystemAuditEvent", test.name, decodedEntry.Payload, decodedEntry.PayloadType, decodedEntry.DecodeError)
+			continue
+		}
+		if test.name == "encrypted" {
+			// the entry is inspected without the keyring, so its subject is shown as it is stored
+			if !strings.HasPrefix(payload.SubjectIdentifier, ENCRYPTED_FIELD_PREFIX) || payload.AuditEvent != want.AuditEvent {
+				t.Errorf("%s: decoded %+v, want the subject encrypted and the audit event in the clear", test.name, payload)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(payload, want) {
+			t.Errorf("%s: decoded %+v, want %+v", test.name, payload, want)
+		}
+	}
+}
+
+// TestTailTopic checks tailing stops at the end of a topic, or after a limit, skipping entries with other keys,
+// and when following prints entries saved while it waits until it is signalled to stop or polling fails
+func TestTailTopic(t *testing.T) {
+
+	tail := func(store MockableMessageStore, offset, limit int64, key string, follow bool, sigCh chan os.Signal) ([]string, error) {
+		var printed []string
+		err := tailTopic(store, SYSTEM_AUDIT_EVENT_TOPIC, offset, limit, key, follow, sigCh, func(decodedEntry DecodedEntry) {
+			printed = append(printed, fmt.Sprintf("%d:%s", decodedEntry.Offset, decodedEntry.Key))
+		})
+		return printed, err
+	}
+
+	store := newMemoryMessageStore()
+	saveSystemAuditEvents(t, store, "jbloggs", "jsmith", "jbloggs", "jsmith", "jbloggs")
+	tests := []struct {
+		name   string
+		offset int64
+		limit  int64
+		key    string
+		want   []string
+	}{
+		{"to the end", 2, 0, "", []string{"2:jbloggs", "3:jsmith", "4:jbloggs"}},
+		{"limit", 0, 2, "", []string{"0:jbloggs", "1:jsmith"}},
+		{"key", 0, 0, "jsmith", []string{"1:jsmith", "3:jsmith"}},
+		{"key and limit", 1, 1, "jbloggs", []string{"2:jbloggs"}},
+		{"past the end", 5, 0, "", nil},
+	}
+	for _, test := range tests {
+		printed, err := tail(store, test.offset, test.limit, test.key, false, make(chan os.Signal, 1))
+		if err != nil || !reflect.DeepEqual(printed, test.want) {
+			t.Errorf("%s: printed %v, error %v, want %v", test.name, printed, err, test.want)
+		}
+	}
+
+	// following, entries saved after the end of the topic was reached are printed until the limit
+	done := make(chan []string)
+	go func() {
+		printed, err := tail(store, 4, 3, "", true, make(chan os.Signal, 1))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- printed
+	}()
+	time.Sleep(10 * time.Millisecond)
+	saveSystemAuditEvents(t, store, "jsmith", "jbloggs")
+	select {
+	case printed := <-done:
+		if want := []string{"4:jbloggs", "5:jsmith", "6:jbloggs"}; !reflect.DeepEqual(printed, want) {
+			t.Errorf("follow: printed %v, want %v", printed, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follow: still tailing after the limit was reached")
+	}
+
+	// following without a limit, tailing stops once signalled
+	sigCh := make(chan os.Signal, 1)
+	go func() {
+		printed, err := tail(store, 6, 0, "", true, sigCh)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- printed
+	}()
+	time.Sleep(10 * time.Millisecond)
+	sigCh <- syscall.SIGTERM
+	select {
+	case printed := <-done:
+		if want := []string{"6:jbloggs"}; !reflect.DeepEqual(printed, want) {
+			t.Errorf("signalled: printed %v, want %v", printed, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signalled: still tailing")
+	}
+
+	unreachable := &unreachableMessageStore{memoryMessageStore: store}
+	if _, err := tail(unreachable, 7, 0, "", true, make(chan os.Signal, 1)); err == nil || !strings.Contains(err.Error(), "index unreadable") {
+		t.Errorf("unreachable: error %v, want the poll's error", err)
+	}
+}
+
+// TestTopicCommands checks each topic inspection command against topic files in a temporary directory, and its exit code
+// when given invalid arguments or an offset beyond the end of the topic
+func TestTopicCommands(t *testing.T) {
+
+	inTempDirectory(t)
+	saveSystemAuditEvents(t, ms.NewMessageStore(), "jbloggs", "jsmith", "jbloggs")
+	if _, err := ms.NewMessageStore().SaveEntry("unlisted.topic", ms.Entry{Key: []byte("a"), Value: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if topics := discoverTopics(); len(topics) != len(topicDefinitions())+1 || !containsString(topics, "unlisted.topic") {
+		t.Errorf("discovered topics %v, want every topic and unlisted.topic", topics)
+	}
+
+	tests := []struct {
+		name     string
+		command  func(args []string) int
+		args     []string
+		exitCode int
+	}{
+		{"topics", topicsCommand, nil, 0},
+		{"topics json", topicsCommand, []string{"--json"}, 0},
+		{"describe", describeCommand, []string{SYSTEM_AUDIT_EVENT_TOPIC}, 0},
+		{"describe json", describeCommand, []string{SYSTEM_AUDIT_EVENT_TOPIC, "--json"}, 0},
+		{"describe empty topic", describeCommand, []string{USER_LOGIN_ATTEMPT_TOPIC}, 0},
+		{"describe without a topic", describeCommand, nil, 2},
+		{"read", readCommand, []string{SYSTEM_AUDIT_EVENT_TOPIC, "2"}, 0},
+		{"read json", readCommand, []string{"--json", SYSTEM_AUDIT_EVENT_TOPIC, "0"}, 0},
+		{"read past the end", readCommand, []string{SYSTEM_AUDIT_EVENT_TOPIC, "3"}, 1},
+		{"read invalid offset", readCommand, []string{SYSTEM_AUDIT_EVENT_TOPIC, "first"}, 2},
+		{"tail", tailCommand, []string{SYSTEM_AUDIT_EVENT_TOPIC}, 0},
+		{"tail by key", tailCommand, []string{SYSTEM_AUDIT_EVENT_TOPIC, "--from", "0", "--key", "jsmith", "--json"}, 0},
+		{"tail without a topic", tailCommand, []string{"--limit", "1"}, 2},
+	}
+	for _, test := range tests {
+		if exitCode := test.command(test.args); exitCode != test.exitCode {
+			t.Errorf("%s: exit code %d, want %d", test.name, exitCode, test.exitCode)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the payload size, in bytes, from which payloads are compressed when no threshold is given
+const DEFAULT_COMPRESSION_THRESHOLD = 1024
+
+// Compressor compresses payloads with one algorithm, which is recorded in the content-encoding header of each entry
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// compressors returns every compressor the application can write, and read
+func compressors() []Compressor {
+
+	return []Compressor{
+		GzipCompressor{},
+		ZstdCompressor{},
+		SnappyCompressor{},
+	}
+}
+
+// findCompressor returns the compressor with the given name
+func findCompressor(name string) (Compressor, bool) {
+
+	for _, compressor := range compressors() {
+		if compressor.Name() == name {
+			return compressor, true
+		}
+	}
+	return nil, false
+}
+
+// compressorNames returns the name of every compressor
+func compressorNames() []string {
+
+	var names []string
+	for _, compressor := range compressors() {
+		names = append(names, compressor.Name())
+	}
+	return names
+}
+
+// GzipCompressor compresses payloads with gzip
+type GzipCompressor struct{}
+
+// Name returns the name of the compressor
+func (GzipCompressor) Name() string {
+
+	return "gzip"
+}
+
+// Compress compresses a payload
+func (GzipCompressor) Compress(data []byte) ([]byte, error) {
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses a payload
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// zstdEncoder and zstdDecoder are shared, as both are safe for concurrent use and costly to create
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// ZstdCompressor compresses payloads with Zstandard
+type ZstdCompressor struct{}
+
+// Name returns the name of the compressor
+func (ZstdCompressor) Name() string {
+
+	return "zstd"
+}
+
+// Compress compresses a payload
+func (ZstdCompressor) Compress(data []byte) ([]byte, error) {
+
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+// Decompress decompresses a payload
+func (ZstdCompressor) Decompress(data []byte) ([]byte, error) {
+
+	return zstdDecoder.DecodeAll(data, nil)
+}
+
+// SnappyCompressor compresses payloads with the Snappy block format
+type SnappyCompressor struct{}
+
+// Name returns the name of the compressor
+func (SnappyCompressor) Name() string {
+
+	return "snappy"
+}
+
+// Compress compresses a payload
+func (SnappyCompressor) Compress(data []byte) ([]byte, error) {
+
+	return snappy.Encode(nil, data), nil
+}
+
+// Decompress decompresses a payload
+func (SnappyCompressor) Decompress(data []byte) ([]byte, error) {
+
+	return snappy.Decode(nil, data)
+}
+
+// Define the structure of CompressionPolicy, which compresses payloads of at least Threshold bytes
+type CompressionPolicy struct {
+	Compressor Compressor
+	Threshold  int
+}
+
+// String describes the policy, as ParseTopicCompression accepts it
+func (p CompressionPolicy) String() string {
+
+	if p.Compressor == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s:%d", p.Compressor.Name(), p.Threshold)
+}
+
+// TopicCompression selects the compression policy of each topic
+type TopicCompression struct {
+	Default CompressionPolicy
+	Topics  map[string]CompressionPolicy
+}
+
+// NewTopicCompression creates a new instance of TopicCompression, which compresses no topic
+func NewTopicCompression() *TopicCompression {
+
+	return &TopicCompression{
+		Topics: make(map[string]CompressionPolicy),
+	}
+}
+
+// For returns the compression policy of a topic
+func (c *TopicCompression) For(topic string) CompressionPolicy {
+
+	if c == nil {
+		return CompressionPolicy{}
+	}
+	if policy, ok := c.Topics[topic]; ok {
+		return policy
+	}
+	return c.Default
+}
+
+// String describes the selection, as ParseTopicCompression accepts it
+func (c *TopicCompression) String() string {
+
+	parts := []string{c.Default.String()}
+	topics := make([]string, 0, len(c.Topics))
+	for topic := range c.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		parts = append(parts, topic+"="+c.Topics[topic].String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseTopicCompression parses a comma separated list of a default policy and topic=policy overrides, where a policy is none,
+// or a compressor name with an optional threshold in bytes, e.g. "none,subject.region.document.response=zstd:512"
+func ParseTopicCompression(spec string) (*TopicCompression, error) {
+
+	c := NewTopicCompression()
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		topic, policySpec, isOverride := strings.Cut(part, "=")
+		if !isOverride {
+			policySpec = topic
+		}
+		policy, err := parseCompressionPolicy(policySpec)
+		if err != nil {
+			return nil, err
+		}
+		if !isOverride {
+			c.Default = policy
+			continue
+		}
+		if _, ok := findTopicDefinition(topic); !ok {
+			return nil, fmt.Errorf("unknown topic '%s'", topic)
+		}
+		c.Topics[topic] = policy
+	}
+	return c, nil
+}
+
+// parseCompressionPolicy parses none, or a compressor name with an optional threshold in bytes, e.g. zstd:512
+func parseCompressionPolicy(spec string) (CompressionPolicy, error) {
+
+	if spec == "none" {
+		return CompressionPolicy{}, nil
+	}
+	name, thresholdSpec, hasThreshold := strings.Cut(spec, ":")
+	compressor, ok := findCompressor(name)
+	if !ok {
+		return CompressionPolicy{}, fmt.Errorf("unknown compression '%s', expected none or one of %s", name, strings.Join(compressorNames(), ", "))
+	}
+	policy := CompressionPolicy{Compressor: compressor, Threshold: DEFAULT_COMPRESSION_THRESHOLD}
+	if hasThreshold {
+		threshold, err := strconv.Atoi(thresholdSpec)
+		if err != nil || threshold < 0 {
+			return CompressionPolicy{}, fmt.Errorf("invalid compression threshold '%s'", thresholdSpec)
+		}
+		policy.Threshold = threshold
+	}
+	return policy, nil
+}
+
+// CompressingMessageStore compresses the payload of each entry saved to a topic whose policy compresses it,
+// and whose payload is at least the policy's threshold. readers decompress payloads as they decode them
+type CompressingMessageStore struct {
+	MockableMessageStore
+	Compression *TopicCompression
+}
+
+// NewCompressingMessageStore creates a new instance of CompressingMessageStore
+func NewCompressingMessageStore(store MockableMessageStore, compression *TopicCompression) *CompressingMessageStore {
+
+	return &CompressingMessageStore{
+		MockableMessageStore: store,
+		Compression:          compression,
+	}
+}
+
+// SaveEntry compresses an entry's payload, then saves it
+func (s *CompressingMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	entry, err := compressEntry(s.Compression.For(topic), entry)
+	if err != nil {
+		return 0, err
+	}
+	return s.MockableMessageStore.SaveEntry(topic, entry)
+}
+
+// SaveEntries compresses every entry's payload, then saves them as one batch
+func (s *CompressingMessageStore) SaveEntries(topic string, entries []ms.Entry) (int64, error) {
+
+	policy := s.Compression.For(topic)
+	compressed := make([]ms.Entry, 0, len(entries))
+	for i, entry := range entries {
+		entry, err := compressEntry(policy, entry)
+		if err != nil {
+			return -1, fmt.Errorf("entry %d: %v", i, err)
+		}
+		compressed = append(compressed, entry)
+	}
+	return asBatchMessageStore(s.MockableMessageStore).SaveEntries(topic, compressed)
+}
+
+// ReadEntries reads up to max entries
+func (s *CompressingMessageStore) ReadEntries(topic string, offset int64, max int) ([]ms.Entry, error) {
+
+	return asBatchMessageStore(s.MockableMessageStore).ReadEntries(topic, offset, max)
+}
+
+// compressEntry rewrites an entry with its payload compressed, embedded in the envelope as a base64 string,
+// unless the payload is below the policy's threshold or compression would not make the entry smaller
+func compressEntry(policy CompressionPolicy, entry ms.Entry) (ms.Entry, error) {
+
+	if policy.Compressor == nil {
+		return entry, nil
+	}
+	envelope := decodeMessageEnvelope(entry)
+	if envelope.Headers == nil || envelope.Headers[CONTENT_ENCODING_HEADER] != "" {
+		// entries without an envelope, or already compressed
+		return entry, nil
+	}
+	payload, err := envelope.EncodedPayload()
+	if err != nil {
+		return entry, err
+	}
+	if len(payload) < policy.Threshold {
+		return entry, nil
+	}
+
+	compressed, err := policy.Compressor.Compress(payload)
+	if err != nil {
+		return entry, fmt.Errorf("failed to compress payload with %s: %v", policy.Compressor.Name(), err)
+	}
+	embedded, err := json.Marshal(compressed)
+	if err != nil {
+		return entry, fmt.Errorf("failed to marshal compressed payload: %v", err)
+	}
+	if len(embedded) >= len(envelope.Payload) {
+		return entry, nil
+	}
+
+	headers := make(map[string]string)
+	for name, value := range envelope.Headers {
+		headers[name] = value
+	}
+	headers[CONTENT_ENCODING_HEADER] = policy.Compressor.Name()
+	envelopeJSON, err := json.Marshal(NewMessageEnvelope(headers, embedded))
+	if err != nil {
+		return entry, fmt.Errorf("failed to marshal message envelope: %v", err)
+	}
+	entry.Value = envelopeJSON
+	return entry, nil
+}
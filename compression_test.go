@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// newCompressionTestEntry returns an entry of a system audit event, whose payload is encoded with a codec
+func newCompressionTestEntry(t *testing.T, codec Codec, auditEvent string) (ms.Entry, []byte) {
+
+	t.Helper()
+	payload, err := codec.Marshal(NewSystemAuditEvent("jbloggs", auditEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newMessageStoreEntry(context.Background(), SYSTEM_AUDIT_EVENT_TOPIC, "jbloggs", codec, payload), payload
+}
+
+// TestCompressorsRoundTrip checks each compressor decompresses what it compressed, including an empty payload
+func TestCompressorsRoundTrip(t *testing.T) {
+
+	payloads := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte(strings.Repeat("login attempt ", 1000)),
+		{0, 1, 2, 255, 254, 253},
+	}
+	for _, compressor := range compressors() {
+		for _, payload := range payloads {
+			compressed, err := compressor.Compress(payload)
+			if err != nil {
+				t.Fatalf("%s: compress: %v", compressor.Name(), err)
+			}
+			decompressed, err := compressor.Decompress(compressed)
+			if err != nil {
+				t.Fatalf("%s: decompress: %v", compressor.Name(), err)
+			}
+			if !bytes.Equal(decompressed, payload) {
+				t.Errorf("%s: decompressed %d bytes, want the %d compressed", compressor.Name(), len(decompressed), len(payload))
+			}
+		}
+		if _, err := compressor.Decompress([]byte("not compressed")); err == nil {
+			t.Errorf("%s: decompressed data it did not compress, want an error", compressor.Name())
+		}
+	}
+}
+
+// TestCompressEntryThreshold checks a payload is compressed from the policy's threshold, and left as is below it
+func TestCompressEntryThreshold(t *testing.T) {
+
+	for _, codec := range codecs() {
+		entry, payload := newCompressionTestEntry(t, codec, strings.Repeat("login attempt ", 100))
+		tests := []struct {
+			name       string
+			threshold  int
+			compressed bool
+		}{
+			{"below", len(payload) + 1, false},
+			{"at", len(payload), true},
+			{"above", len(payload) - 1, true},
+		}
+		for _, test := range tests {
+			for _, compressor := range compressors() {
+				compressedEntry, err := compressEntry(CompressionPolicy{Compressor: compressor, Threshold: test.threshold}, entry)
+				if err != nil {
+					t.Fatal(err)
+				}
+				envelope := decodeMessageEnvelope(compressedEntry)
+				encoding := envelope.Headers[CONTENT_ENCODING_HEADER]
+				if test.compressed && (encoding != compressor.Name() || len(compressedEntry.Value) >= len(entry.Value)) {
+					t.Errorf("%s %s %s: content encoding %q, %d bytes, want a smaller entry compressed", codec.Name(), compressor.Name(), test.name, encoding, len(compressedEntry.Value))
+				}
+				if !test.compressed && !bytes.Equal(compressedEntry.Value, entry.Value) {
+					t.Errorf("%s %s %s: entry rewritten, want it as is", codec.Name(), compressor.Name(), test.name)
+				}
+
+				// EncodedPayload decompresses transparently, so readers see the payload the codec wrote
+				encoded, err := envelope.EncodedPayload()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(encoded, payload) {
+					t.Errorf("%s %s %s: encoded payload differs from the one written", codec.Name(), compressor.Name(), test.name)
+				}
+				var systemAuditEvent SystemAuditEvent
+				if err := envelope.DecodePayload(&systemAuditEvent); err != nil || systemAuditEvent.UserName != "jbloggs" {
+					t.Errorf("%s %s %s: decoded %+v, %v", codec.Name(), compressor.Name(), test.name, systemAuditEvent, err)
+				}
+			}
+		}
+	}
+}
+
+// TestCompressEntryOnlyIfSmaller checks a payload is left as is when compressing it would not make the entry smaller
+func TestCompressEntryOnlyIfSmaller(t *testing.T) {
+
+	entry, _ := newCompressionTestEntry(t, JSONCodec{}, "a")
+	for _, compressor := range compressors() {
+		compressedEntry, err := compressEntry(CompressionPolicy{Compressor: compressor, Threshold: 0}, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(compressedEntry.Value, entry.Value) {
+			t.Errorf("%s: entry of %d bytes rewritten as %d bytes, want it as is", compressor.Name(), len(entry.Value), len(compressedEntry.Value))
+		}
+	}
+}
+
+// TestCompressEntryLeavesOtherEntries checks entries without a policy, without an envelope or already compressed are left as is
+func TestCompressEntryLeavesOtherEntries(t *testing.T) {
+
+	entry, _ := newCompressionTestEntry(t, JSONCodec{}, strings.Repeat("login attempt ", 100))
+	policy := CompressionPolicy{Compressor: ZstdCompressor{}, Threshold: 0}
+	compressedEntry, err := compressEntry(policy, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := ms.Entry{Key: []byte("jbloggs"), Value: []byte(`{"userName":"jbloggs","auditEvent":"` + strings.Repeat("login attempt ", 100) + `"}`)}
+
+	tests := []struct {
+		name   string
+		policy CompressionPolicy
+		entry  ms.Entry
+	}{
+		{"no policy", CompressionPolicy{}, entry},
+		{"no envelope", policy, legacy},
+		{"already compressed", CompressionPolicy{Compressor: GzipCompressor{}, Threshold: 0}, compressedEntry},
+	}
+	for _, test := range tests {
+		got, err := compressEntry(test.policy, test.entry)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(got.Value, test.entry.Value) {
+			t.Errorf("%s: entry rewritten, want it as is", test.name)
+		}
+	}
+}
+
+// TestEncodedPayloadRejectsUnknownEncoding checks a payload in an encoding no compressor reads is an error, rather than being decoded as is
+func TestEncodedPayloadRejectsUnknownEncoding(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		encoding string
+		payload  string
+	}{
+		{"unknown", "brotli", `"AAAA"`},
+		{"corrupt", "gzip", `"AAAA"`},
+		{"not base64", "zstd", `{"userName":"jbloggs"}`},
+	}
+	for _, test := range tests {
+		envelope := NewMessageEnvelope(map[string]string{CONTENT_TYPE_HEADER: JSON_CONTENT_TYPE, CONTENT_ENCODING_HEADER: test.encoding}, json.RawMessage(test.payload))
+		if _, err := envelope.EncodedPayload(); err == nil {
+			t.Errorf("%s: decoded, want an error", test.name)
+		}
+	}
+}
+
+// TestCompressingMessageStore checks entries saved singly and in batches are compressed by their topic's policy, and decode as written
+func TestCompressingMessageStore(t *testing.T) {
+
+	compression, err := ParseTopicCompression("none," + SYSTEM_AUDIT_EVENT_TOPIC + "=snappy:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewCompressingMessageStore(newMemoryMessageStore(), compression)
+	entry, _ := newCompressionTestEntry(t, MessagePackCodec{}, strings.Repeat("login attempt ", 100))
+	if _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SaveEntries(SYSTEM_AUDIT_EVENT_TOPIC, []ms.Entry{entry, entry}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SaveEntry(USER_LOGIN_ATTEMPT_TOPIC, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.ReadEntries(SYSTEM_AUDIT_EVENT_TOPIC, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d entries, want 3", len(entries))
+	}
+	for i, saved := range entries {
+		envelope := decodeMessageEnvelope(saved)
+		if encoding := envelope.Headers[CONTENT_ENCODING_HEADER]; encoding != "snappy" {
+			t.Errorf("entry %d: content encoding %q, want snappy", i, encoding)
+		}
+		var systemAuditEvent SystemAuditEvent
+		if err := envelope.DecodePayload(&systemAuditEvent); err != nil || systemAuditEvent.UserName != "jbloggs" {
+			t.Errorf("entry %d: decoded %+v, %v", i, systemAuditEvent, err)
+		}
+	}
+
+	saved, err := store.ReadEntry(USER_LOGIN_ATTEMPT_TOPIC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved.Value, entry.Value) {
+		t.Error("entry of a topic without a policy was compressed")
+	}
+}
+
+// TestParseTopicCompression checks policies parse with and without thresholds, describe themselves as they were given,
+// and bad names, thresholds and topics are rejected
+func TestParseTopicCompression(t *testing.T) {
+
+	tests := []struct {
+		spec string
+		want string
+		err  string
+	}{
+		{"", "none", ""},
+		{"none", "none", ""},
+		{"gzip", "gzip:1024", ""},
+		{"zstd:0", "zstd:0", ""},
+		{" snappy:512 , " + SYSTEM_AUDIT_EVENT_TOPIC + "=none", "snappy:512," + SYSTEM_AUDIT_EVENT_TOPIC + "=none", ""},
+		{"none," + SUBJECT_DOCUMENTS_TOPIC + "=zstd:64," + SYSTEM_AUDIT_EVENT_TOPIC + "=gzip", "none," + SUBJECT_DOCUMENTS_TOPIC + "=zstd:64," + SYSTEM_AUDIT_EVENT_TOPIC + "=gzip:1024", ""},
+		{"brotli", "", "unknown compression 'brotli'"},
+		{SYSTEM_AUDIT_EVENT_TOPIC + "=lz4", "", "unknown compression 'lz4'"},
+		{"gzip:-1", "", "invalid compression threshold '-1'"},
+		{"gzip:", "", "invalid compression threshold ''"},
+		{"gzip:1k", "", "invalid compression threshold '1k'"},
+		{"none,no.such.topic=gzip", "", "unknown topic 'no.such.topic'"},
+	}
+	for _, test := range tests {
+		compression, err := ParseTopicCompression(test.spec)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: error %v, want %q", test.spec, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		if got := compression.String(); got != test.want {
+			t.Errorf("%q: parsed as %q, want %q", test.spec, got, test.want)
+		}
+	}
+
+	compression, err := ParseTopicCompression("gzip:10," + SYSTEM_AUDIT_EVENT_TOPIC + "=none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy := compression.For(SYSTEM_AUDIT_EVENT_TOPIC); policy.Compressor != nil {
+		t.Errorf("overridden topic policy %s, want none", policy)
+	}
+	if policy := compression.For(USER_LOGIN_ATTEMPT_TOPIC); policy.String() != "gzip:10" {
+		t.Errorf("default topic policy %s, want gzip:10", policy)
+	}
+	if policy := (*TopicCompression)(nil).For(USER_LOGIN_ATTEMPT_TOPIC); policy.Compressor != nil {
+		t.Errorf("policy without a selection %s, want none", policy)
+	}
+}
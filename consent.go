@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Define the consent file the application loads record seals from when none is given
+const CONSENT_FILE = "consent.json"
+
+// Define the reason codes of record seals. audit events give a seal's reason code, as its free-text reason may say more
+// about the subject than the audit trail should hold
+const (
+	SEAL_REASON_SUBJECT_REQUEST = "subject-request"
+	SEAL_REASON_COURT_ORDER     = "court-order"
+	SEAL_REASON_SAFEGUARDING    = "safeguarding"
+	SEAL_REASON_OTHER           = "other"
+)
+
+// sealReasonCodes returns every reason code a seal can give
+func sealReasonCodes() []string {
+
+	return []string{SEAL_REASON_SUBJECT_REQUEST, SEAL_REASON_COURT_ORDER, SEAL_REASON_SAFEGUARDING, SEAL_REASON_OTHER}
+}
+
+// Define the structure of RecordSeal, the records of a subject which must not be shared. a seal with no category codes seals
+// the whole of each of its regions, and a seal with no regions seals its categories in every region
+type RecordSeal struct {
+	SubjectIdentifier string   `json:"subjectIdentifier"`
+	Regions           []string `json:"regions"`
+	CategoryCodes     []string `json:"categoryCodes"`
+	ReasonCode        string   `json:"reasonCode"`
+	Reason            string   `json:"reason"`
+}
+
+// Define the structure of ConsentFile, the record seals of every subject
+type ConsentFile struct {
+	Seals []RecordSeal `json:"seals"`
+}
+
+// Define the structure of regionSeal, a seal resolved to region numbers
+type regionSeal struct {
+	RecordSeal
+	regions map[int]bool // nil for every region
+}
+
+// ConsentService holds the record seals consulted before a subject's documents are requested from the regions.
+// a nil service holds no seals, so every region is requested and every document shared
+type ConsentService struct {
+	seals map[string][]regionSeal
+}
+
+// NewConsentService creates a new instance of ConsentService
+func NewConsentService(seals []RecordSeal) (*ConsentService, error) {
+
+	c := &ConsentService{
+		seals: make(map[string][]regionSeal),
+	}
+	for i, seal := range seals {
+		if err := validateSubjectIdentifier(seal.SubjectIdentifier); err != nil {
+			return nil, fmt.Errorf("seal %d: %v", i, err)
+		}
+		if len(seal.Regions) == 0 && len(seal.CategoryCodes) == 0 {
+			return nil, fmt.Errorf("seal %d: regions or categoryCodes are required", i)
+		}
+		if seal.ReasonCode == "" {
+			seal.ReasonCode = SEAL_REASON_OTHER
+		}
+		if !containsString(sealReasonCodes(), seal.ReasonCode) {
+			return nil, fmt.Errorf("seal %d: reasonCode must be one of %s", i, strings.Join(sealReasonCodes(), ", "))
+		}
+		resolved := regionSeal{RecordSeal: seal}
+		for _, name := range seal.Regions {
+			region, err := parseRegion(name)
+			if err != nil {
+				return nil, fmt.Errorf("seal %d: %v", i, err)
+			}
+			if resolved.regions == nil {
+				resolved.regions = make(map[int]bool)
+			}
+			resolved.regions[region] = true
+		}
+		c.seals[seal.SubjectIdentifier] = append(c.seals[seal.SubjectIdentifier], resolved)
+	}
+	return c, nil
+}
+
+// LoadConsentService loads the record seals of a consent file
+func LoadConsentService(filename string) (*ConsentService, error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var consentFile ConsentFile
+	if err := json.Unmarshal(data, &consentFile); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal consent file: %s, %v", filename, err)
+	}
+	return NewConsentService(consentFile.Seals)
+}
+
+// loadOptionalConsentService loads the consent file named by a command's --consent flag. no service is returned,
+// so no record is sealed, when no file is named, or when the default consent file does not exist
+func loadOptionalConsentService(filename string) (*ConsentService, error) {
+
+	if filename == "" {
+		return nil, nil
+	}
+	c, err := LoadConsentService(filename)
+	if errors.Is(err, os.ErrNotExist) && filename == CONSENT_FILE {
+		return nil, nil
+	}
+	return c, err
+}
+
+// SealedRegion returns the seal which seals the whole of a region's records of a subject, if there is one
+func (c *ConsentService) SealedRegion(subjectIdentifier string, region int) (RecordSeal, bool) {
+
+	if c == nil {
+		return RecordSeal{}, false
+	}
+	for _, seal := range c.seals[subjectIdentifier] {
+		if len(seal.CategoryCodes) == 0 && seal.regions[region] {
+			return seal.RecordSeal, true
+		}
+	}
+	return RecordSeal{}, false
+}
+
+// UnsealedRegions returns the regions whose records of a subject are not sealed as a whole, in the order given
+func (c *ConsentService) UnsealedRegions(subjectIdentifier string, regions []int) []int {
+
+	var unsealed []int
+	for _, region := range regions {
+		if _, sealed := c.SealedRegion(subjectIdentifier, region); !sealed {
+			unsealed = append(unsealed, region)
+		}
+	}
+	return unsealed
+}
+
+// FilterDocuments returns the documents of a region whose category is not sealed for the subject, and the number withheld
+func (c *ConsentService) FilterDocuments(subjectIdentifier string, region int, documents []SubjectRegionDocument) ([]SubjectRegionDocument, int) {
+
+	if c == nil || len(c.seals[subjectIdentifier]) == 0 {
+		return documents, 0
+	}
+	sealedCategoryCodes := make(map[string]bool)
+	for _, seal := range c.seals[subjectIdentifier] {
+		if seal.regions != nil && !seal.regions[region] {
+			continue
+		}
+		for _, categoryCode := range seal.CategoryCodes {
+			sealedCategoryCodes[strings.ToLower(categoryCode)] = true
+		}
+	}
+	if len(sealedCategoryCodes) == 0 {
+		return documents, 0
+	}
+
+	var shared []SubjectRegionDocument
+	for _, document := range documents {
+		if !sealedCategoryCodes[strings.ToLower(document.DocumentCategoryCode)] {
+			shared = append(shared, document)
+		}
+	}
+	return shared, len(documents) - len(shared)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newConsentTestBackend creates a backend whose store is in memory, with the given record seals
+func newConsentTestBackend(t *testing.T, seals []RecordSeal) *Backend {
+
+	t.Helper()
+	consent, err := NewConsentService(seals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBackend()
+	b.Log = io.Discard
+	b.MessageStore = newMemoryMessageStore()
+	b.Consent = consent
+	return b
+}
+
+// consentTestDocuments returns a document of each of the given category codes, in a region
+func consentTestDocuments(region int, categoryCodes ...string) []SubjectRegionDocument {
+
+	var documents []SubjectRegionDocument
+	for i, categoryCode := range categoryCodes {
+		documents = append(documents, SubjectRegionDocument{
+			DocumentIdentifier:   "D" + string(rune('0'+i)),
+			DocumentCategoryCode: categoryCode,
+			Region:               region,
+		})
+	}
+	return documents
+}
+
+// TestNewConsentServiceValidatesSeals checks seals of an invalid subject, of nothing, of an unknown region or with an unknown reason code are rejected
+func TestNewConsentServiceValidatesSeals(t *testing.T) {
+
+	tests := []struct {
+		name string
+		seal RecordSeal
+		err  string
+	}{
+		{"invalid subject", RecordSeal{SubjectIdentifier: "123", Regions: []string{"lothian"}}, "subjectIdentifier must be 10 digits"},
+		{"seals nothing", RecordSeal{SubjectIdentifier: "1234567890"}, "regions or categoryCodes are required"},
+		{"unknown region", RecordSeal{SubjectIdentifier: "1234567890", Regions: []string{"atlantis"}}, "unknown region 'atlantis'"},
+		{"unknown region number", RecordSeal{SubjectIdentifier: "1234567890", Regions: []string{"99"}}, "unknown region 99"},
+		{"unknown reason code", RecordSeal{SubjectIdentifier: "1234567890", Regions: []string{"lothian"}, ReasonCode: "because"}, "reasonCode must be one of subject-request, court-order, safeguarding, other"},
+	}
+	for _, test := range tests {
+		if _, err := NewConsentService([]RecordSeal{{SubjectIdentifier: "0987654321", CategoryCodes: []string{"MH"}}, test.seal}); err == nil ||
+			!strings.Contains(err.Error(), "seal 1: "+test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+// TestConsentSealedRegion checks only a seal of a whole region seals it, for its subject alone
+func TestConsentSealedRegion(t *testing.T) {
+
+	consent, err := NewConsentService([]RecordSeal{
+		{SubjectIdentifier: "1234567890", Regions: []string{"lothian", "fife"}, Reason: "subject request"},
+		{SubjectIdentifier: "1234567890", Regions: []string{"tayside"}, CategoryCodes: []string{"MH"}, Reason: "category only"},
+		{SubjectIdentifier: "1234567890", CategoryCodes: []string{"SH"}, Reason: "every region"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		consent *ConsentService
+		subject string
+		region  int
+		sealed  bool
+	}{
+		{"sealed region", consent, "1234567890", LOTHIAN_REGION, true},
+		{"second sealed region", consent, "1234567890", FIFE_REGION, true},
+		{"region with sealed categories", consent, "1234567890", TAYSIDE_REGION, false},
+		{"unsealed region", consent, "1234567890", HIGHLAND_REGION, false},
+		{"other subject", consent, "0987654321", LOTHIAN_REGION, false},
+		{"no consent service", nil, "1234567890", LOTHIAN_REGION, false},
+	}
+	for _, test := range tests {
+		seal, sealed := test.consent.SealedRegion(test.subject, test.region)
+		if sealed != test.sealed {
+			t.Errorf("%s: sealed %v, want %v", test.name, sealed, test.sealed)
+		}
+		// the seal gives no reason code, so is given the default
+		if sealed && (seal.Reason != "subject request" || seal.ReasonCode != SEAL_REASON_OTHER) {
+			t.Errorf("%s: seal %+v, want the seal of the whole region, with reason code %s", test.name, seal, SEAL_REASON_OTHER)
+		}
+	}
+
+	regions := []int{TAYSIDE_REGION, LOTHIAN_REGION, HIGHLAND_REGION, FIFE_REGION, BORDERS_REGION}
+	if unsealed := consent.UnsealedRegions("1234567890", regions); !reflect.DeepEqual(unsealed, []int{TAYSIDE_REGION, HIGHLAND_REGION, BORDERS_REGION}) {
+		t.Errorf("unsealed regions %v, want tayside, highland and borders in the order given", unsealed)
+	}
+	if unsealed := (*ConsentService)(nil).UnsealedRegions("1234567890", regions); !reflect.DeepEqual(unsealed, regions) {
+		t.Errorf("unsealed regions without a consent service %v, want every region", unsealed)
+	}
+}
+
+// TestConsentFilterDocuments checks documents of sealed categories are withheld in the seal's regions, or every region, and counted
+func TestConsentFilterDocuments(t *testing.T) {
+
+	consent, err := NewConsentService([]RecordSeal{
+		{SubjectIdentifier: "1234567890", Regions: []string{"tayside"}, CategoryCodes: []string{"mh"}},
+		{SubjectIdentifier: "1234567890", CategoryCodes: []string{"SH"}},
+		{SubjectIdentifier: "1234567890", Regions: []string{"lothian"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		consent  *ConsentService
+		subject  string
+		region   int
+		shared   []string
+		withheld int
+	}{
+		{"category sealed in the region", consent, "1234567890", TAYSIDE_REGION, []string{"GP", "XR"}, 2},
+		{"category sealed in every region", consent, "1234567890", HIGHLAND_REGION, []string{"GP", "MH", "XR"}, 1},
+		{"whole region sealed, no categories", consent, "1234567890", LOTHIAN_REGION, []string{"GP", "MH", "XR"}, 1},
+		{"other subject", consent, "0987654321", TAYSIDE_REGION, []string{"GP", "MH", "XR", "sh"}, 0},
+		{"no consent service", nil, "1234567890", TAYSIDE_REGION, []string{"GP", "MH", "XR", "sh"}, 0},
+	}
+	for _, test := range tests {
+		documents := consentTestDocuments(test.region, "GP", "MH", "XR", "sh")
+		shared, withheld := test.consent.FilterDocuments(test.subject, test.region, documents)
+		var categoryCodes []string
+		for _, document := range shared {
+			categoryCodes = append(categoryCodes, document.DocumentCategoryCode)
+		}
+		if !reflect.DeepEqual(categoryCodes, test.shared) || withheld != test.withheld {
+			t.Errorf("%s: shared %v, withheld %d, want %v and %d", test.name, categoryCodes, withheld, test.shared, test.withheld)
+		}
+	}
+}
+
+// TestConsentSkipsSealedRegionsAudited checks a subject access outcome requests documents only from unsealed regions,
+// recording each region skipped in the audit trail with its seal's reason code, but not its free-text reason
+func TestConsentSkipsSealedRegionsAudited(t *testing.T) {
+
+	b := newConsentTestBackend(t, []RecordSeal{
+		{SubjectIdentifier: "1234567890", Regions: []string{"lothian", "fife"}, ReasonCode: SEAL_REASON_SUBJECT_REQUEST, Reason: "asked in clinic on 3 March"},
+		{SubjectIdentifier: "1234567890", CategoryCodes: []string{"MH"}, Reason: "category only"},
+	})
+	ctx := context.Background()
+	payload, err := json.Marshal(NewUserSubjectAccessAttemptOutcome("jbloggs", "1234567890", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.processEntryFromPollUserSubjectAccessAttemptOutcome(ctx, newMessageStoreEntry(ctx, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, "jbloggs", JSONCodec{}, payload))
+
+	if requests := topicLength(b.MessageStore, SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC); requests != int64(len(allRegions())-2) {
+		t.Errorf("%d regions requested, want all but the 2 sealed", requests)
+	}
+	systemAuditEvents := readSystemAuditEvents(t, b, 0)
+	if len(systemAuditEvents) != 2 {
+		t.Fatalf("%d audit events, want one for each of the 2 regions skipped", len(systemAuditEvents))
+	}
+	for _, region := range []int{FIFE_REGION, LOTHIAN_REGION} {
+		want := "region " + regionName(region) + " skipped, records sealed, reason code: subject-request"
+		found := false
+		for _, systemAuditEvent := range systemAuditEvents {
+			found = found || (systemAuditEvent.AuditEvent == want && systemAuditEvent.SubjectIdentifier == "1234567890" && systemAuditEvent.UserName == "jbloggs")
+		}
+		if !found {
+			t.Errorf("audit events %+v, want %q", systemAuditEvents, want)
+		}
+	}
+	for _, systemAuditEvent := range systemAuditEvents {
+		if strings.Contains(systemAuditEvent.AuditEvent, "clinic") {
+			t.Errorf("audit event %q gives the seal's free-text reason", systemAuditEvent.AuditEvent)
+		}
+	}
+}
+
+// TestConsentEverySealedRegionAudited checks a subject whose every region is sealed gets no documents, after one audit event per region
+func TestConsentEverySealedRegionAudited(t *testing.T) {
+
+	var regions []string
+	for _, region := range allRegions() {
+		regions = append(regions, regionName(region))
+	}
+	b := newConsentTestBackend(t, []RecordSeal{{SubjectIdentifier: "1234567890", Regions: regions, Reason: "court order"}})
+	ctx := context.Background()
+	payload, err := json.Marshal(NewUserSubjectAccessAttemptOutcome("jbloggs", "1234567890", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.processEntryFromPollUserSubjectAccessAttemptOutcome(ctx, newMessageStoreEntry(ctx, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, "jbloggs", JSONCodec{}, payload))
+
+	if requests := topicLength(b.MessageStore, SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC); requests != 0 {
+		t.Errorf("%d regions requested, want none", requests)
+	}
+	if length := topicLength(b.MessageStore, SUBJECT_DOCUMENTS_TOPIC); length != 1 {
+		t.Errorf("%d subject documents, want 1 with no documents", length)
+	}
+	if systemAuditEvents := readSystemAuditEvents(t, b, 0); len(systemAuditEvents) != len(allRegions()) {
+		t.Errorf("%d audit events, want one for each of the %d regions", len(systemAuditEvents), len(allRegions()))
+	}
+}
+
+// TestWithholdSealedDocumentsAudited checks documents of sealed categories are withheld with one audit event giving their number,
+// and none when nothing is withheld
+func TestWithholdSealedDocumentsAudited(t *testing.T) {
+
+	b := newConsentTestBackend(t, []RecordSeal{{SubjectIdentifier: "1234567890", CategoryCodes: []string{"MH", "SH"}}})
+	ctx := context.Background()
+	request := *NewSubjectRegionDocumentRequest("1234567890", TAYSIDE_REGION, "jbloggs")
+
+	shared := b.withholdSealedDocuments(ctx, request, consentTestDocuments(TAYSIDE_REGION, "GP", "MH", "SH", "XR"))
+	if len(shared) != 2 {
+		t.Errorf("%d documents shared, want 2", len(shared))
+	}
+	systemAuditEvents := readSystemAuditEvents(t, b, 0)
+	want := "2 documents of region " + regionName(TAYSIDE_REGION) + " withheld, categories sealed"
+	if len(systemAuditEvents) != 1 || systemAuditEvents[0].AuditEvent != want || systemAuditEvents[0].SubjectIdentifier != "1234567890" {
+		t.Errorf("audit events %+v, want one %q", systemAuditEvents, want)
+	}
+
+	b.withholdSealedDocuments(ctx, request, consentTestDocuments(TAYSIDE_REGION, "GP", "XR"))
+	if length := topicLength(b.MessageStore, SYSTEM_AUDIT_EVENT_TOPIC); length != 1 {
+		t.Errorf("%d audit events, want none more when nothing is withheld", length-1)
+	}
+}
+
+// TestLoadConsentService checks seals are loaded from a consent file, and a missing default file seals nothing
+func TestLoadConsentService(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), CONSENT_FILE)
+	if err := os.WriteFile(filename, []byte(`{"seals": [{"subjectIdentifier": "1234567890", "regions": ["Lothian"], "reason": "r"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	consent, err := loadOptionalConsentService(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, sealed := consent.SealedRegion("1234567890", LOTHIAN_REGION); !sealed {
+		t.Error("seal of the consent file not loaded")
+	}
+	if consent, err := loadOptionalConsentService(""); consent != nil || err != nil {
+		t.Errorf("no consent file named: %v, %v, want neither a service nor an error", consent, err)
+	}
+	if _, err := loadOptionalConsentService(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loaded a missing consent file which was named, want an error")
+	}
+}
@@ -1,5 +1,11 @@
 package main
 
+// Define the service name reported in traces
+const SERVICE_NAME = "message-store-demo-embedded"
+
+// Define the user name recorded against audit events raised by the supervisor
+const SUPERVISOR_USER_NAME = "supervisor"
+
 // Define constants for topic names
 const (
 	SYSTEM_AUDIT_EVENT_TOPIC                  = "system.audit.event"
@@ -9,6 +15,41 @@ const (
 	USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC = "user.subject.access.attempt.outcome"
 	SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC     = "subject.region.document.request"
 	SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC    = "subject.region.document.response"
+	SUBJECT_DOCUMENTS_TOPIC                   = "subject.documents"
+	DOCUMENT_CONTENT_REQUEST_TOPIC            = "document.content.request"
+	DOCUMENT_CONTENT_RESPONSE_TOPIC           = "document.content.response"
+	USER_SESSION_EVENT_TOPIC                  = "user.session.event"
+)
+
+// Define constants for the names of topics derived for analytics, with identifiers replaced by pseudonyms, and of the pseudonym vault
+const (
+	PSEUDONYMISED_SYSTEM_AUDIT_EVENT_TOPIC                  = "analytics.system.audit.event"
+	PSEUDONYMISED_USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC = "analytics.user.subject.access.attempt.outcome"
+	PSEUDONYM_VAULT_TOPIC                                   = "pseudonym.vault"
+)
+
+// Define constants for consumer names
+const (
+	SYSTEM_AUDIT_EVENT_CONSUMER                  = "system-audit-event-consumer"
+	USER_LOGIN_ATTEMPT_CONSUMER                  = "user-login-attempt-consumer"
+	USER_LOGIN_ATTEMPT_OUTCOME_CONSUMER          = "user-login-attempt-outcome-consumer"
+	USER_SUBJECT_ACCESS_ATTEMPT_CONSUMER         = "user-subject-access-attempt-consumer"
+	USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_CONSUMER = "user-subject-access-attempt-outcome-consumer"
+	SUBJECT_REGION_DOCUMENT_REQUEST_CONSUMER     = "subject-region-document-request-consumer"
+	SUBJECT_REGION_DOCUMENT_RESPONSE_CONSUMER    = "subject-region-document-response-consumer"
+	DOCUMENT_CONTENT_REQUEST_CONSUMER            = "document-content-request-consumer"
+	DOCUMENT_CONTENT_RESPONSE_CONSUMER           = "document-content-response-consumer"
+)
+
+// Define constants for pseudonymiser names, each of which consumes a topic and republishes it to a derived topic
+const (
+	SYSTEM_AUDIT_EVENT_PSEUDONYMISER                  = "system-audit-event-pseudonymiser"
+	USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER = "user-subject-access-attempt-outcome-pseudonymiser"
+)
+
+// Define constants for generator names
+const (
+	USER_LOGIN_ATTEMPT_GENERATOR = "user-login-attempt-generator"
 )
 
 // Define constants for region names
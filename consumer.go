@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// pollTopic polls a topic and hands each entry to process, until the context is canceled
+func (b *Backend) pollTopic(ctx context.Context, consumerName, topic string, process func(context.Context, ms.Entry)) {
+
+	offset := b.Offsets.Get(consumerName)
+	batchSize := 100
+	store := asBatchMessageStore(b.MessageStore)
+
+	// Block until an entry is saved to the topic when the store can notify us, rather than sleep-polling
+	var wake <-chan struct{}
+	if notifier, ok := b.MessageStore.(EntryNotifier); ok {
+		var unsubscribe func()
+		wake, unsubscribe = notifier.Subscribe(topic)
+		defer unsubscribe()
+	}
+
+	b.Health.RegisterConsumer(consumerName, topic)
+	defer func() {
+		if r := recover(); r != nil {
+			// skip the entry which caused the panic, so the restarted consumer does not crash on it again
+			fmt.Fprintln(b.Log, "consumer '", consumerName, "' panicked at offset ", offset, ", skipping entry: ", r)
+			b.Offsets.Commit(consumerName, offset)
+			b.Health.ConsumerDead(consumerName, fmt.Sprint(r))
+			panic(r)
+		}
+		if ctx.Err() == nil {
+			b.Health.ConsumerDead(consumerName, "poll loop returned")
+			return
+		}
+		b.Health.ConsumerStopped(consumerName)
+	}()
+
+	// Loop until the context is canceled
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(b.Log, "Polling canceled")
+			return
+		default:
+			b.Health.Heartbeat(consumerName, offset)
+			entries, err := store.ReadEntries(topic, offset+1, batchSize)
+			if err != nil {
+				fmt.Fprintln(b.Log, "ReadEntries for topic '", topic, "', offset ", offset+1, ", returned error: ", err)
+				// Wait for a short duration
+				time.Sleep(500 * time.Millisecond)
+			}
+			if len(entries) == 0 {
+				// do nothing - as this just means there are no unread entries in the topic
+				waitForEntry(ctx, wake)
+				continue // Continue to the next iteration of the loop
+			}
+			fmt.Fprintln(b.Log, "ReadEntries for topic '", topic, "', offset ", offset+1, ", returned ", len(entries), " entries")
+			for _, entry := range entries {
+				if ctx.Err() != nil {
+					// leave the rest of the batch for the next run
+					break
+				}
+				offset++
+				if entry, err := b.admitEntry(topic, entry); err != nil {
+					// skip entries which break the topic's contract, rather than handing them to the handler
+					fmt.Fprintln(b.Log, "consumer '", consumerName, "' rejected entry at offset ", offset, ": ", err)
+				} else {
+					process(ctx, entry)
+				}
+				b.Offsets.Commit(consumerName, offset)
+			}
+		}
+	}
+}
+
+// admitEntry upcasts an entry written with an older version of its topic's schema, decrypts its encrypted fields,
+// then checks it against the current version
+func (b *Backend) admitEntry(topic string, entry ms.Entry) (ms.Entry, error) {
+
+	entry, err := upcastEntry(topic, entry)
+	if err != nil {
+		return entry, err
+	}
+	entry, err = b.Encryption.DecryptEntry(topic, entry)
+	if err != nil {
+		return entry, err
+	}
+	if err := b.Schemas.ValidateEntry(topic, entry); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// waitForEntry waits until wake signals that an entry has been saved, or for a short duration when there is no wake channel.
+// it also returns every few seconds, so entries saved by other processes are still found and the heartbeat keeps going
+func waitForEntry(ctx context.Context, wake <-chan struct{}) {
+
+	if wake == nil {
+		// Wait for a short duration
+		time.Sleep(500 * time.Millisecond)
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-wake:
+	case <-time.After(5 * time.Second):
+	}
+}
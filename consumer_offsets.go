@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConsumerOffsets tracks the offset of the last entry each consumer has processed, optionally persisted to a file
+type ConsumerOffsets struct {
+	mu        sync.Mutex
+	offsets   map[string]int64
+	filename  string
+	dirty     bool
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	log       io.Writer
+}
+
+// NewConsumerOffsets creates a new instance of ConsumerOffsets which is held in memory only
+func NewConsumerOffsets() *ConsumerOffsets {
+
+	return &ConsumerOffsets{
+		offsets: make(map[string]int64),
+	}
+}
+
+// NewFileConsumerOffsets creates a new instance of ConsumerOffsets, loading committed offsets from a file
+// and saving them back to it every second and when closed. failures of the periodic save are logged to log
+func NewFileConsumerOffsets(filename string, log io.Writer) (*ConsumerOffsets, error) {
+
+	o := NewConsumerOffsets()
+	o.filename = filename
+	o.log = log
+
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read consumer offsets file: %v", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &o.offsets); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal consumer offsets file: %s, %v", filename, err)
+		}
+	}
+
+	o.stop = make(chan struct{})
+	o.stopped = make(chan struct{})
+	go o.savePeriodically(time.Second)
+	return o, nil
+}
+
+// Get returns the committed offset for a consumer, or -1 when it has not processed any entries
+func (o *ConsumerOffsets) Get(consumerName string) int64 {
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	offset, ok := o.offsets[consumerName]
+	if !ok {
+		return -1
+	}
+	return offset
+}
+
+// Commit records the offset of the last entry a consumer has processed
+func (o *ConsumerOffsets) Commit(consumerName string, offset int64) {
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.offsets[consumerName] = offset
+	o.dirty = true
+}
+
+// Save writes the committed offsets to the offsets file, if there is one
+func (o *ConsumerOffsets) Save() error {
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.filename == "" || !o.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(o.offsets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal consumer offsets: %v", err)
+	}
+
+	// write to a temporary file and rename it, so a crash mid-write cannot corrupt the committed offsets
+	tempFilename := o.filename + ".tmp"
+	if err := os.WriteFile(tempFilename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write consumer offsets file: %v", err)
+	}
+	if err := os.Rename(tempFilename, o.filename); err != nil {
+		return fmt.Errorf("failed to replace consumer offsets file: %v", err)
+	}
+
+	o.dirty = false
+	return nil
+}
+
+// savePeriodically saves the committed offsets until the offsets are closed
+func (o *ConsumerOffsets) savePeriodically(interval time.Duration) {
+
+	defer close(o.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+			if err := o.Save(); err != nil {
+				fmt.Fprintln(o.log, "failed to save consumer offsets: ", err)
+			}
+		}
+	}
+}
+
+// Close stops the periodic save and saves the committed offsets one last time
+func (o *ConsumerOffsets) Close() error {
+
+	var err error
+	o.closeOnce.Do(func() {
+		if o.stop != nil {
+			close(o.stop)
+			<-o.stopped
+		}
+		err = o.Save()
+	})
+	return err
+}
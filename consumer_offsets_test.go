@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFileConsumerOffsetsRoundTrip checks committed offsets are saved on close and loaded again, and unknown consumers start at -1
+func TestFileConsumerOffsetsRoundTrip(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "consumer.offsets.json")
+	offsets, err := NewFileConsumerOffsets(filename, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets.Commit(SYSTEM_AUDIT_EVENT_CONSUMER, 41)
+	offsets.Commit(SYSTEM_AUDIT_EVENT_CONSUMER, 42)
+	offsets.Commit(USER_LOGIN_ATTEMPT_CONSUMER, 0)
+	if err := offsets.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := offsets.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	reloaded, err := NewFileConsumerOffsets(filename, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+	tests := map[string]int64{
+		SYSTEM_AUDIT_EVENT_CONSUMER:         42,
+		USER_LOGIN_ATTEMPT_CONSUMER:         0,
+		USER_LOGIN_ATTEMPT_OUTCOME_CONSUMER: -1,
+	}
+	for consumerName, want := range tests {
+		if got := reloaded.Get(consumerName); got != want {
+			t.Errorf("%s: offset %d, want %d", consumerName, got, want)
+		}
+	}
+}
+
+// TestFileConsumerOffsetsIgnoresPartialTemporaryFile checks a temporary file left by a crash mid-write neither affects loading
+// nor prevents the next save from replacing it
+func TestFileConsumerOffsetsIgnoresPartialTemporaryFile(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "consumer.offsets.json")
+	if err := os.WriteFile(filename, []byte(`{"system-audit-event-consumer": 7}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename+".tmp", []byte(`{"system-audit-event-consumer": 9`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	offsets, err := NewFileConsumerOffsets(filename, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := offsets.Get(SYSTEM_AUDIT_EVENT_CONSUMER); got != 7 {
+		t.Errorf("offset %d, want 7 from the committed file rather than the partial temporary file", got)
+	}
+	offsets.Commit(SYSTEM_AUDIT_EVENT_CONSUMER, 8)
+	if err := offsets.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewFileConsumerOffsets(filename, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+	if got := reloaded.Get(SYSTEM_AUDIT_EVENT_CONSUMER); got != 8 {
+		t.Errorf("offset %d, want 8", got)
+	}
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("partial temporary file was not replaced: %v", err)
+	}
+}
+
+// TestFileConsumerOffsetsRejectsCorruptFile checks a corrupt offsets file is an error, rather than every consumer silently starting again
+func TestFileConsumerOffsetsRejectsCorruptFile(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "consumer.offsets.json")
+	if err := os.WriteFile(filename, []byte(`{"system-audit-event-consumer": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileConsumerOffsets(filename, io.Discard); err == nil || !strings.Contains(err.Error(), filename) {
+		t.Errorf("error %v, want the corrupt file named", err)
+	}
+}
+
+// TestFileConsumerOffsetsOnlySavesCommits checks a new or empty file loads without offsets, and nothing is written until an offset is committed
+func TestFileConsumerOffsetsOnlySavesCommits(t *testing.T) {
+
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "consumer.offsets.json")
+	offsets, err := NewFileConsumerOffsets(filename, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := offsets.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("offsets file written without any commits: %v", err)
+	}
+
+	empty := filepath.Join(directory, "empty.offsets.json")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	offsets, err = NewFileConsumerOffsets(empty, io.Discard)
+	if err != nil {
+		t.Fatalf("empty file: %v", err)
+	}
+	defer offsets.Close()
+	if got := offsets.Get(SYSTEM_AUDIT_EVENT_CONSUMER); got != -1 {
+		t.Errorf("offset %d, want -1", got)
+	}
+}
+
+// TestConsumerOffsetsInMemory checks offsets without a file are held in memory, and saving them does nothing
+func TestConsumerOffsetsInMemory(t *testing.T) {
+
+	offsets := NewConsumerOffsets()
+	offsets.Commit(SYSTEM_AUDIT_EVENT_CONSUMER, 3)
+	if err := offsets.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := offsets.Get(SYSTEM_AUDIT_EVENT_CONSUMER); got != 3 {
+		t.Errorf("offset %d, want 3", got)
+	}
+}
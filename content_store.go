@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Define constants for document content. content larger than the maximum size is refused, and content is sent in chunks of the chunk size
+const (
+	CONTENT_STORE_DIRECTORY             = "content"
+	DOCUMENT_CONTENT_TYPE               = "text/plain; charset=utf-8"
+	DEFAULT_MAX_DOCUMENT_CONTENT_SIZE   = 4 << 20
+	DEFAULT_DOCUMENT_CONTENT_CHUNK_SIZE = 64 << 10
+	MIN_GENERATED_CONTENT_SIZE          = 1 << 10
+	MAX_GENERATED_CONTENT_SIZE          = 6 << 20
+)
+
+// contentSentences holds the sentences the body of generated document content is made of
+var contentSentences = []string{
+	"The patient was seen in clinic today and the history was reviewed.",
+	"Observations were within normal limits and the patient was comfortable at rest.",
+	"Current medication was reconciled and no changes were made.",
+	"Investigations were requested and the results will be reviewed at the next appointment.",
+	"The findings were discussed with the patient, who understood and agreed with the plan.",
+	"A follow-up appointment has been arranged in three months.",
+	"Please do not hesitate to contact the department should you have any concerns.",
+	"The patient was advised to seek medical attention if the symptoms worsen.",
+}
+
+// ContentStore holds the content of documents as blobs in a local directory, one per region and document identifier.
+// documents are generated rather than written, so a document's content is generated and written the first time it is read
+type ContentStore struct {
+	mu        sync.Mutex
+	directory string
+	MaxSize   int
+	ChunkSize int
+}
+
+// NewContentStore creates a new instance of ContentStore
+func NewContentStore(directory string) *ContentStore {
+
+	return &ContentStore{
+		directory: directory,
+		MaxSize:   DEFAULT_MAX_DOCUMENT_CONTENT_SIZE,
+		ChunkSize: DEFAULT_DOCUMENT_CONTENT_CHUNK_SIZE,
+	}
+}
+
+// blobFilename returns the file holding the content of a region's document
+func (s *ContentStore) blobFilename(region int, documentIdentifier string) string {
+
+	return filepath.Join(s.directory, strconv.Itoa(region), documentIdentifier)
+}
+
+// Get returns the content of a region's document, generating and writing it if the store does not hold it yet
+func (s *ContentStore) Get(subjectIdentifier string, document SubjectRegionDocument) ([]byte, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filename := s.blobFilename(document.Region, document.DocumentIdentifier)
+	content, err := os.ReadFile(filename)
+	if err == nil {
+		return content, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read document content: %v", err)
+	}
+
+	content = generateDocumentContent(subjectIdentifier, document)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create content store directory: %v", err)
+	}
+	// write to a temporary file and rename it, so a crash mid-write cannot leave partial content
+	tempFilename := filename + ".tmp"
+	if err := os.WriteFile(tempFilename, content, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write document content: %v", err)
+	}
+	if err := os.Rename(tempFilename, filename); err != nil {
+		return nil, fmt.Errorf("failed to replace document content: %v", err)
+	}
+	return content, nil
+}
+
+// Chunks splits content into chunks of the chunk size, so no entry holds more than one chunk
+func (s *ContentStore) Chunks(content []byte) [][]byte {
+
+	var chunks [][]byte
+	for len(content) > s.ChunkSize {
+		chunks = append(chunks, content[:s.ChunkSize])
+		content = content[s.ChunkSize:]
+	}
+	return append(chunks, content)
+}
+
+// generateDocumentContent generates the text of a document, a heading of its metadata then a body, whose size is drawn between
+// the minimum and maximum generated sizes, most documents being small. it is generated from a random source seeded by the document,
+// so the same document always has the same content
+func generateDocumentContent(subjectIdentifier string, document SubjectRegionDocument) []byte {
+
+	seed := fnv.New64a()
+	seed.Write([]byte(subjectIdentifier + "/" + strconv.Itoa(document.Region) + "/" + document.DocumentIdentifier))
+	random := rand.New(rand.NewSource(int64(seed.Sum64())))
+	size := int(MIN_GENERATED_CONTENT_SIZE * math.Pow(MAX_GENERATED_CONTENT_SIZE/MIN_GENERATED_CONTENT_SIZE, random.Float64()))
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "%s\n%s, %s\n\n", document.DocumentCategory, document.DocumentSpecialty, regionName(document.Region))
+	fmt.Fprintf(&content, "Date: %s\nSubject: %s\nDocument: %s\n\n", document.DocumentDate.Format("2 January 2006"), subjectIdentifier, document.DocumentIdentifier)
+	for content.Len() < size {
+		content.WriteString(contentSentences[random.Intn(len(contentSentences))])
+		if random.Intn(4) == 0 {
+			content.WriteString("\n\n")
+		} else {
+			content.WriteString(" ")
+		}
+	}
+	return []byte(content.String())
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// contentTestDocument returns the first document a region holds about a subject, or another region when it holds none
+func contentTestDocument(t *testing.T, subjectIdentifier string, region int) SubjectRegionDocument {
+
+	t.Helper()
+	for _, r := range append([]int{region}, allRegions()...) {
+		if documents := embeddedDocumentCatalogue.Documents(subjectIdentifier, r, time.Now()); len(documents) > 0 {
+			return documents[0]
+		}
+	}
+	t.Fatalf("no region holds documents about %s", subjectIdentifier)
+	return SubjectRegionDocument{}
+}
+
+// TestContentStoreChunks checks content is split into chunks of at most the chunk size, which join to the content
+func TestContentStoreChunks(t *testing.T) {
+
+	s := NewContentStore(t.TempDir())
+	s.ChunkSize = 4
+	tests := []struct {
+		content string
+		chunks  []string
+	}{
+		{"", []string{""}},
+		{"abc", []string{"abc"}},
+		{"abcd", []string{"abcd"}},
+		{"abcde", []string{"abcd", "e"}},
+		{"abcdefghijkl", []string{"abcd", "efgh", "ijkl"}},
+	}
+	for _, test := range tests {
+		chunks := s.Chunks([]byte(test.content))
+		var got []string
+		for _, chunk := range chunks {
+			got = append(got, string(chunk))
+		}
+		if strings.Join(got, "|") != strings.Join(test.chunks, "|") || len(got) != len(test.chunks) {
+			t.Errorf("%q: chunks %q, want %q", test.content, got, test.chunks)
+		}
+	}
+}
+
+// TestContentStoreGet checks a document's content is generated the same for every store, written once, and then read from the store
+func TestContentStoreGet(t *testing.T) {
+
+	directory := t.TempDir()
+	s := NewContentStore(directory)
+	document := contentTestDocument(t, "0101700008", LOTHIAN_REGION)
+
+	content, err := s.Get("0101700008", document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) < MIN_GENERATED_CONTENT_SIZE || !bytes.Contains(content, []byte(document.DocumentIdentifier)) {
+		t.Errorf("content of %d bytes, want at least %d naming the document", len(content), MIN_GENERATED_CONTENT_SIZE)
+	}
+	other, err := NewContentStore(t.TempDir()).Get("0101700008", document)
+	if err != nil || !bytes.Equal(other, content) {
+		t.Errorf("content differs between stores, %v", err)
+	}
+
+	filename := filepath.Join(directory, strconv.Itoa(document.Region), document.DocumentIdentifier)
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("blob mode %v, want 0600", info.Mode().Perm())
+	}
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	// content the store holds is returned rather than generated again
+	if err := os.WriteFile(filename, []byte("stored"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := s.Get("0101700008", document); err != nil || string(stored) != "stored" {
+		t.Errorf("content %q, %v, want the stored content", stored, err)
+	}
+}
+
+// TestReadRequestedDocumentContentMaxSize checks content larger than the store's maximum size is refused, and content within it is read
+func TestReadRequestedDocumentContentMaxSize(t *testing.T) {
+
+	b := NewBackend()
+	b.Log = io.Discard
+	b.MessageStore = newMemoryMessageStore()
+	b.ContentStore = NewContentStore(t.TempDir())
+	document := contentTestDocument(t, "0101700008", LOTHIAN_REGION)
+	documentContentRequest := *NewDocumentContentRequest("0101700008", document.DocumentIdentifier, document.Region, "jbloggs")
+
+	content, err := b.readRequestedDocumentContent(documentContentRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.ContentStore.MaxSize = len(content)
+	if _, err := b.readRequestedDocumentContent(documentContentRequest); err != nil {
+		t.Errorf("content of the maximum size refused: %v", err)
+	}
+	b.ContentStore.MaxSize = len(content) - 1
+	if _, err := b.readRequestedDocumentContent(documentContentRequest); err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("error %v, want content over the maximum size refused", err)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// documentCatalogueFile holds the document catalogue built into the application
+//
+//go:embed data/document_catalogue.json
+var documentCatalogueFile []byte
+
+// embeddedDocumentCatalogue holds the document catalogue built into the application
+var embeddedDocumentCatalogue = loadEmbeddedDocumentCatalogue()
+
+// Define constants for the dates of generated documents. records are held electronically from the start date,
+// and a subject's documents are spread over the horizon from their date of birth, or the start date if later,
+// so a subject accumulates documents as they age and those already generated never change
+const (
+	DOCUMENT_RECORDS_START_YEAR  = 1990
+	DOCUMENT_HORIZON_YEARS       = 40
+	DOCUMENT_IDENTIFIER_LENGTH   = 12
+	DOCUMENT_EARLIEST_BIRTH_YEAR = 1935
+)
+
+// Define the structure of CatalogueCategory, a document category, which is used with any specialty unless specialties are listed
+type CatalogueCategory struct {
+	Code        string   `json:"code"`
+	Name        string   `json:"name"`
+	Weight      float64  `json:"weight"`
+	Specialties []string `json:"specialties"`
+}
+
+// Define the structure of CatalogueSpecialty, a clinical specialty, which may only see subjects of a sex, or of an age in years
+// when the document is written. a zero maximum age is no maximum
+type CatalogueSpecialty struct {
+	Code   string  `json:"code"`
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+	Sex    string  `json:"sex,omitempty"`
+	MinAge int     `json:"minAge,omitempty"`
+	MaxAge int     `json:"maxAge,omitempty"`
+}
+
+// sees reports whether the specialty sees a subject of a sex and age
+func (s CatalogueSpecialty) sees(sex string, age int) bool {
+
+	return (s.Sex == "" || s.Sex == sex) && age >= s.MinAge && (s.MaxAge == 0 || age <= s.MaxAge)
+}
+
+// Define the structure of CatalogueRegion, the most documents a subject has in a region, and the multipliers of the weights of its specialties
+type CatalogueRegion struct {
+	Region           string             `json:"region"`
+	Documents        int                `json:"documents"`
+	SpecialtyWeights map[string]float64 `json:"specialtyWeights"`
+}
+
+// Define the structure of DocumentCatalogue, the categories and specialties documents are generated from, with their frequencies in each region
+type DocumentCatalogue struct {
+	Categories  []CatalogueCategory  `json:"categories"`
+	Specialties []CatalogueSpecialty `json:"specialties"`
+	Regions     []CatalogueRegion    `json:"regions"`
+	regions     map[int]CatalogueRegion
+}
+
+// ParseDocumentCatalogue parses and validates a document catalogue
+func ParseDocumentCatalogue(data []byte) (*DocumentCatalogue, error) {
+
+	var c DocumentCatalogue
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal document catalogue: %v", err)
+	}
+	if len(c.Categories) == 0 || len(c.Specialties) == 0 {
+		return nil, fmt.Errorf("document catalogue needs categories and specialties")
+	}
+
+	specialties := make(map[string]bool)
+	for _, specialty := range c.Specialties {
+		if specialty.Code == "" || specialty.Weight <= 0 {
+			return nil, fmt.Errorf("specialty '%s' needs a code and a positive weight", specialty.Name)
+		}
+		if specialty.Sex != "" && specialty.Sex != SEX_FEMALE && specialty.Sex != SEX_MALE {
+			return nil, fmt.Errorf("specialty '%s' has an unknown sex '%s'", specialty.Code, specialty.Sex)
+		}
+		specialties[specialty.Code] = true
+	}
+	for _, category := range c.Categories {
+		if category.Code == "" || category.Weight <= 0 {
+			return nil, fmt.Errorf("category '%s' needs a code and a positive weight", category.Name)
+		}
+		for _, code := range category.Specialties {
+			if !specialties[code] {
+				return nil, fmt.Errorf("category '%s' lists unknown specialty '%s'", category.Code, code)
+			}
+		}
+	}
+
+	c.regions = make(map[int]CatalogueRegion)
+	for _, catalogueRegion := range c.Regions {
+		region, err := parseRegion(catalogueRegion.Region)
+		if err != nil {
+			return nil, err
+		}
+		for code, multiplier := range catalogueRegion.SpecialtyWeights {
+			if !specialties[code] || multiplier < 0 {
+				return nil, fmt.Errorf("region '%s' has an invalid weight for specialty '%s'", catalogueRegion.Region, code)
+			}
+		}
+		c.regions[region] = catalogueRegion
+	}
+	for _, region := range allRegions() {
+		if _, ok := c.regions[region]; !ok {
+			return nil, fmt.Errorf("document catalogue has no region '%s'", regionName(region))
+		}
+	}
+	return &c, nil
+}
+
+// LoadDocumentCatalogue loads a document catalogue file
+func LoadDocumentCatalogue(filename string) (*DocumentCatalogue, error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseDocumentCatalogue(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return c, nil
+}
+
+// loadEmbeddedDocumentCatalogue loads the document catalogue built into the application, which documents cannot be generated without
+func loadEmbeddedDocumentCatalogue() *DocumentCatalogue {
+
+	c, err := ParseDocumentCatalogue(documentCatalogueFile)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Documents returns the documents a region holds about a subject as of a reference date. they are generated from a random source seeded
+// by the subject and region, so every lookup as of the same date returns the same documents, dated within the subject's lifetime and
+// not after the reference date
+func (c *DocumentCatalogue) Documents(subjectIdentifier string, region int, referenceDate time.Time) []SubjectRegionDocument {
+
+	seed := fnv.New64a()
+	seed.Write([]byte(subjectIdentifier + "/" + strconv.Itoa(region)))
+	random := rand.New(rand.NewSource(int64(seed.Sum64())))
+
+	dateOfBirth := subjectDateOfBirth(subjectIdentifier, referenceDate)
+	sex := subjectSex(subjectIdentifier)
+	start := dateOfBirth
+	if recordsStart := time.Date(DOCUMENT_RECORDS_START_YEAR, 1, 1, 0, 0, 0, 0, time.UTC); start.Before(recordsStart) {
+		start = recordsStart
+	}
+	horizon := start.AddDate(DOCUMENT_HORIZON_YEARS, 0, 0).Sub(start)
+
+	catalogueRegion := c.regions[region]
+	var documents []SubjectRegionDocument
+	for i, n := 0, random.Intn(catalogueRegion.Documents+1); i < n; i++ {
+		// draw every document, including those dated in the future, so each keeps its place in the random sequence
+		documentIdentifier := generateDocumentIdentifier(random)
+		documentDate := start.Add(time.Duration(random.Int63n(int64(horizon)))).Truncate(time.Second)
+		specialty := c.pickSpecialty(random, catalogueRegion, sex, ageOn(dateOfBirth, documentDate))
+		category := c.pickCategory(random, specialty.Code)
+		if documentDate.After(referenceDate) {
+			continue
+		}
+		documents = append(documents, SubjectRegionDocument{
+			DocumentIdentifier:    documentIdentifier,
+			DocumentDate:          documentDate,
+			DocumentCategoryCode:  category.Code,
+			DocumentCategory:      category.Name,
+			DocumentSpecialtyCode: specialty.Code,
+			DocumentSpecialty:     specialty.Name,
+			Region:                region,
+		})
+	}
+	return documents
+}
+
+// pickSpecialty picks a specialty which sees the subject in proportion to its weight, multiplied by its weight in the region
+func (c *DocumentCatalogue) pickSpecialty(random *rand.Rand, catalogueRegion CatalogueRegion, sex string, age int) CatalogueSpecialty {
+
+	weights := make([]float64, len(c.Specialties))
+	for i, specialty := range c.Specialties {
+		if !specialty.sees(sex, age) {
+			continue
+		}
+		weights[i] = specialty.Weight
+		if multiplier, ok := catalogueRegion.SpecialtyWeights[specialty.Code]; ok {
+			weights[i] *= multiplier
+		}
+	}
+	return c.Specialties[pickWeighted(random, weights)]
+}
+
+// pickCategory picks a category used with a specialty in proportion to its weight, or any category if none is used with it
+func (c *DocumentCatalogue) pickCategory(random *rand.Rand, specialtyCode string) CatalogueCategory {
+
+	weights := make([]float64, len(c.Categories))
+	for i, category := range c.Categories {
+		if len(category.Specialties) == 0 || containsString(category.Specialties, specialtyCode) {
+			weights[i] = category.Weight
+		}
+	}
+	return c.Categories[pickWeighted(random, weights)]
+}
+
+// pickWeighted returns the index of a weight picked in proportion to the weights, or any index when every weight is zero
+func pickWeighted(random *rand.Rand, weights []float64) int {
+
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	if total <= 0 {
+		return random.Intn(len(weights))
+	}
+	n := random.Float64() * total
+	for i, weight := range weights {
+		n -= weight
+		if n < 0 {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
+
+// generateDocumentIdentifier generates a document identifier of digits 0 to 9 from a random source
+func generateDocumentIdentifier(random *rand.Rand) string {
+
+	documentIdentifier := make([]byte, DOCUMENT_IDENTIFIER_LENGTH)
+	for i := range documentIdentifier {
+		documentIdentifier[i] = byte('0' + random.Intn(10))
+	}
+	return string(documentIdentifier)
+}
+
+// subjectDateOfBirth returns a subject's date of birth, from the DDMMYY of a CHI number, in the latest century which is not after the
+// reference date. a subject identifier which does not start with a valid date is given a date of birth derived from it, so it is still stable
+func subjectDateOfBirth(subjectIdentifier string, referenceDate time.Time) time.Time {
+
+	if len(subjectIdentifier) >= 6 {
+		if dateOfBirth, err := time.Parse("020106", subjectIdentifier[:6]); err == nil {
+			// time.Parse places two digit years from 69 in the 1900s, and earlier years in the 2000s
+			if dateOfBirth.Year() < 2000 && !dateOfBirth.AddDate(100, 0, 0).After(referenceDate) {
+				dateOfBirth = dateOfBirth.AddDate(100, 0, 0)
+			}
+			if dateOfBirth.After(referenceDate) {
+				dateOfBirth = dateOfBirth.AddDate(-100, 0, 0)
+			}
+			return dateOfBirth
+		}
+	}
+
+	seed := fnv.New64a()
+	seed.Write([]byte(subjectIdentifier))
+	return time.Date(DOCUMENT_EARLIEST_BIRTH_YEAR, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(seed.Sum64()%(90*365)))
+}
+
+// subjectSex returns a subject's sex from the ninth digit of their CHI number, which is odd for males and even for females
+func subjectSex(subjectIdentifier string) string {
+
+	if len(subjectIdentifier) >= 9 && (subjectIdentifier[8]-'0')%2 == 1 {
+		return SEX_MALE
+	}
+	return SEX_FEMALE
+}
+
+// ageOn returns the age in whole years on a date of someone born on a date of birth. the month and day are compared rather than
+// the day of the year, which moves by one after February in leap years
+func ageOn(dateOfBirth, date time.Time) int {
+
+	age := date.Year() - dateOfBirth.Year()
+	if date.Month() < dateOfBirth.Month() || (date.Month() == dateOfBirth.Month() && date.Day() < dateOfBirth.Day()) {
+		age--
+	}
+	return age
+}
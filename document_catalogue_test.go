@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestAgeOn checks ages are in whole years, counting a birthday from its month and day, across leap years
+func TestAgeOn(t *testing.T) {
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name        string
+		dateOfBirth time.Time
+		date        time.Time
+		age         int
+	}{
+		{"day of birth", date(2000, time.March, 1), date(2000, time.March, 1), 0},
+		{"first birthday, born in a leap year", date(2000, time.March, 1), date(2001, time.March, 1), 1},
+		{"day before first birthday, born in a leap year", date(2000, time.March, 1), date(2001, time.February, 28), 0},
+		{"birthday in a leap year", date(1999, time.March, 1), date(2000, time.March, 1), 1},
+		{"day before birthday in a leap year", date(1999, time.March, 1), date(2000, time.February, 29), 0},
+		{"born on a leap day, on the 28th", date(2000, time.February, 29), date(2001, time.February, 28), 0},
+		{"born on a leap day, on the 1st of March", date(2000, time.February, 29), date(2001, time.March, 1), 1},
+		{"born on a leap day, on a leap day", date(2000, time.February, 29), date(2004, time.February, 29), 4},
+		{"end of the year", date(1970, time.December, 31), date(2020, time.December, 30), 49},
+		{"new year", date(1970, time.January, 1), date(2020, time.January, 1), 50},
+	}
+	for _, test := range tests {
+		if age := ageOn(test.dateOfBirth, test.date); age != test.age {
+			t.Errorf("%s: age %d, want %d", test.name, age, test.age)
+		}
+	}
+}
+
+// TestDocumentCatalogueDocumentsStable checks every lookup of a subject's documents in a region as of a date returns the same documents,
+// dated within the subject's lifetime and not after that date, which differ between regions
+func TestDocumentCatalogueDocumentsStable(t *testing.T) {
+
+	reloaded, err := ParseDocumentCatalogue(documentCatalogueFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referenceDate := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, subjectIdentifier := range []string{"0101700000", "2902801234", "1507955678", "3112459999", "not a chi number"} {
+		byRegion := make(map[string]int)
+		for _, region := range allRegions() {
+			documents := embeddedDocumentCatalogue.Documents(subjectIdentifier, region, referenceDate)
+			if again := embeddedDocumentCatalogue.Documents(subjectIdentifier, region, referenceDate); !reflect.DeepEqual(documents, again) {
+				t.Errorf("%s in %s: documents differ between lookups", subjectIdentifier, regionName(region))
+			}
+			if again := reloaded.Documents(subjectIdentifier, region, referenceDate); !reflect.DeepEqual(documents, again) {
+				t.Errorf("%s in %s: documents differ between catalogues", subjectIdentifier, regionName(region))
+			}
+
+			dateOfBirth := subjectDateOfBirth(subjectIdentifier, referenceDate)
+			for _, document := range documents {
+				if document.Region != region || document.DocumentDate.Before(dateOfBirth) || document.DocumentDate.After(referenceDate) {
+					t.Errorf("%s in %s: document %+v, want it of the region, dated from %v until %v",
+						subjectIdentifier, regionName(region), document, dateOfBirth, referenceDate)
+				}
+			}
+			if len(documents) > 0 {
+				byRegion[documents[0].DocumentIdentifier]++
+			}
+		}
+		for documentIdentifier, count := range byRegion {
+			if count > 1 {
+				t.Errorf("%s: document %s in %d regions, want each region's documents to differ", subjectIdentifier, documentIdentifier, count)
+			}
+		}
+	}
+}
+
+// TestDocumentCatalogueReferenceDate checks a subject's documents as of an earlier date are those as of a later date which are
+// dated by the earlier date, so documents accumulate as the reference date moves on and those already generated never change
+func TestDocumentCatalogueReferenceDate(t *testing.T) {
+
+	earlier := time.Date(2010, time.June, 1, 0, 0, 0, 0, time.UTC)
+	later := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	accumulated := false
+	for _, region := range allRegions() {
+		var want []SubjectRegionDocument
+		laterDocuments := embeddedDocumentCatalogue.Documents("0101700000", region, later)
+		for _, document := range laterDocuments {
+			if !document.DocumentDate.After(earlier) {
+				want = append(want, document)
+			}
+		}
+		if documents := embeddedDocumentCatalogue.Documents("0101700000", region, earlier); !reflect.DeepEqual(documents, want) {
+			t.Errorf("%s: %d documents as of %v, want the %d of those as of %v dated by then", regionName(region), len(documents), earlier, len(want), later)
+		}
+		accumulated = accumulated || len(laterDocuments) > len(want)
+	}
+	if !accumulated {
+		t.Error("no documents dated between the two reference dates, want some to accumulate")
+	}
+}
+
+// TestSubjectDateOfBirth checks a CHI number's date of birth is placed in the latest century which is not after the reference date,
+// and a subject identifier which is not a CHI number is given the same date of birth whatever the reference date
+func TestSubjectDateOfBirth(t *testing.T) {
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		subjectIdentifier string
+		referenceDate     time.Time
+		dateOfBirth       time.Time
+	}{
+		{"0101700000", date(2025, time.January, 1), date(1970, time.January, 1)},
+		{"0101200000", date(2025, time.January, 1), date(2020, time.January, 1)},
+		{"0101300000", date(2025, time.January, 1), date(1930, time.January, 1)},
+		{"0101300000", date(2031, time.January, 1), date(2030, time.January, 1)},
+		{"0101680000", date(2025, time.January, 1), date(1968, time.January, 1)},
+		{"0101690000", date(2070, time.January, 1), date(2069, time.January, 1)},
+		{"0101250000", date(2025, time.January, 1), date(2025, time.January, 1)},
+		{"0201250000", date(2025, time.January, 1), date(1925, time.January, 2)},
+	}
+	for _, test := range tests {
+		if dateOfBirth := subjectDateOfBirth(test.subjectIdentifier, test.referenceDate); !dateOfBirth.Equal(test.dateOfBirth) {
+			t.Errorf("%s as of %v: born %v, want %v", test.subjectIdentifier, test.referenceDate, dateOfBirth, test.dateOfBirth)
+		}
+	}
+
+	dateOfBirth := subjectDateOfBirth("not a chi number", date(2025, time.January, 1))
+	if again := subjectDateOfBirth("not a chi number", date(2070, time.January, 1)); !again.Equal(dateOfBirth) || dateOfBirth.Year() < DOCUMENT_EARLIEST_BIRTH_YEAR {
+		t.Errorf("born %v, then %v, want the same date of birth from %d", dateOfBirth, again, DOCUMENT_EARLIEST_BIRTH_YEAR)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the percentage of region responses with documents after which the user goes on to open one of them
+const DOCUMENT_CONTENT_REQUEST_PERCENTAGE = 20
+
+// Define the structure of DocumentContentRequest, a request to the region holding a document for its content
+type DocumentContentRequest struct {
+	SubjectIdentifier  string `json:"subjectIdentifier" protobuf:"1" encrypt:"true"`
+	DocumentIdentifier string `json:"documentIdentifier" protobuf:"2" encrypt:"true"`
+	Region             int    `json:"region" protobuf:"3"`
+	UserName           string `json:"userName" protobuf:"4"`
+}
+
+// NewDocumentContentRequest creates a new instance of DocumentContentRequest
+func NewDocumentContentRequest(subjectIdentifier, documentIdentifier string, region int, userName string) *DocumentContentRequest {
+
+	return &DocumentContentRequest{
+		SubjectIdentifier:  subjectIdentifier,
+		DocumentIdentifier: documentIdentifier,
+		Region:             region,
+		UserName:           userName,
+	}
+}
+
+// Validate checks that a DocumentContentRequest has every required field
+func (d DocumentContentRequest) Validate() error {
+
+	if d.UserName == "" {
+		return fmt.Errorf("userName is required")
+	}
+	if !isValidRegion(d.Region) {
+		return fmt.Errorf("unknown region %d", d.Region)
+	}
+	if err := validateDocumentIdentifier(d.DocumentIdentifier); err != nil {
+		return err
+	}
+	return validateSubjectIdentifier(d.SubjectIdentifier)
+}
+
+// validateDocumentIdentifier checks that a document identifier is made of digits, of the length documents are generated with
+func validateDocumentIdentifier(documentIdentifier string) error {
+
+	if len(documentIdentifier) != DOCUMENT_IDENTIFIER_LENGTH {
+		return fmt.Errorf("documentIdentifier must be %d digits", DOCUMENT_IDENTIFIER_LENGTH)
+	}
+	for _, c := range documentIdentifier {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("documentIdentifier must be %d digits", DOCUMENT_IDENTIFIER_LENGTH)
+		}
+	}
+	return nil
+}
+
+// requestDocumentContent sometimes has the user open one of the documents of a region response they are entitled to see,
+// by requesting its content from the region, which is recorded as a system audit event
+func (b *Backend) requestDocumentContent(ctx context.Context, subjectRegionDocumentResponse SubjectRegionDocumentResponse) {
+
+	if subjectRegionDocumentResponse.Error != "" || rand.Intn(100) >= DOCUMENT_CONTENT_REQUEST_PERCENTAGE {
+		return
+	}
+	documents, _ := b.Entitlements.FilterDocuments(subjectRegionDocumentResponse.UserName, subjectRegionDocumentResponse.Documents)
+	if len(documents) == 0 {
+		return
+	}
+	document := documents[rand.Intn(len(documents))]
+
+	documentContentRequest := NewDocumentContentRequest(subjectRegionDocumentResponse.SubjectIdentifier,
+		document.DocumentIdentifier,
+		document.Region,
+		subjectRegionDocumentResponse.UserName)
+	b.auditDocumentContent(ctx, *documentContentRequest, fmt.Sprintf("document content requested from region %s", regionName(document.Region)))
+	if err := b.sendDocumentContentRequest(ctx, *documentContentRequest); err != nil {
+		fmt.Fprintln(b.Log, err)
+	}
+}
+
+// sendDocumentContentRequest sends a document content request to a topic
+func (b *Backend) sendDocumentContentRequest(ctx context.Context, documentContentRequest DocumentContentRequest) error {
+
+	topic := DOCUMENT_CONTENT_REQUEST_TOPIC
+
+	ctx, span := b.Tracer.Start(ctx, "sendDocumentContentRequest", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(documentContentRequest)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to marshal documentContentRequest: %v", err)
+	}
+
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, documentContentRequest.UserName, codec, payload)
+
+	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("SaveEntry for topic '%s' failed: %v", topic, err)
+	}
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved documentContentRequest to topic ", topic, " at offset ", offset)
+	return nil
+}
+
+// pollDocumentContentRequest polls the topic for document content requests
+func (b *Backend) pollDocumentContentRequest(ctx context.Context) {
+
+	b.pollTopic(ctx, DOCUMENT_CONTENT_REQUEST_CONSUMER, DOCUMENT_CONTENT_REQUEST_TOPIC, b.processEntryFromPollDocumentContentRequest)
+}
+
+// processEntryFromPollDocumentContentRequest processes an entry from polling a topic
+func (b *Backend) processEntryFromPollDocumentContentRequest(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	ctx, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPollDocumentContentRequest", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", DOCUMENT_CONTENT_REQUEST_TOPIC)
+
+	var documentContentRequest DocumentContentRequest
+	if err := envelope.DecodePayload(&documentContentRequest); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal documentContentRequest: %v", err)
+	}
+	fmt.Fprintf(b.Log, "Received documentContentRequest: %v\n", documentContentRequest)
+
+	b.processDocumentContentRequest(ctx, documentContentRequest)
+}
+
+// processDocumentContentRequest has the region holding a document send its content in chunks, or an error, recording either as a system audit event
+func (b *Backend) processDocumentContentRequest(ctx context.Context, documentContentRequest DocumentContentRequest) {
+
+	ctx, span := b.Tracer.Start(ctx, "processDocumentContentRequest", SPAN_KIND_INTERNAL)
+	defer span.End()
+	span.SetAttribute("region", documentContentRequest.Region)
+
+	content, err := b.readRequestedDocumentContent(documentContentRequest)
+	if err != nil {
+		span.RecordError(err)
+		b.sendDocumentContentResponses(ctx, []DocumentContentResponse{*NewDocumentContentErrorResponse(documentContentRequest, err)})
+		b.auditDocumentContent(ctx, documentContentRequest, fmt.Sprintf("document content of region %s not sent, %v", regionName(documentContentRequest.Region), err))
+		return
+	}
+	chunks := b.ContentStore.Chunks(content)
+	span.SetAttribute("document.content.chunks", len(chunks))
+	b.sendDocumentContentResponses(ctx, NewDocumentContentResponses(documentContentRequest, content, chunks))
+	b.auditDocumentContent(ctx, documentContentRequest, fmt.Sprintf("document content of region %s sent, %d bytes in %d chunks",
+		regionName(documentContentRequest.Region), len(content), len(chunks)))
+}
+
+// readRequestedDocumentContent returns the content of the requested document, or an error when the region is unavailable,
+// the document may not be shared with the user, or its content is larger than the content store's limit
+func (b *Backend) readRequestedDocumentContent(documentContentRequest DocumentContentRequest) ([]byte, error) {
+
+	if b.RegionOutages.IsDown(documentContentRequest.Region) {
+		return nil, fmt.Errorf("system unavailable")
+	}
+	document, err := b.findRequestedDocument(documentContentRequest)
+	if err != nil {
+		return nil, err
+	}
+	content, err := b.ContentStore.Get(documentContentRequest.SubjectIdentifier, document)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > b.ContentStore.MaxSize {
+		return nil, fmt.Errorf("content of %d bytes exceeds the limit of %d bytes", len(content), b.ContentStore.MaxSize)
+	}
+	return content, nil
+}
+
+// findRequestedDocument returns the requested document, if the region holds it about the subject and may share it with the user.
+// the record seals and entitlements which decide which documents are listed also decide which may be opened
+func (b *Backend) findRequestedDocument(documentContentRequest DocumentContentRequest) (SubjectRegionDocument, error) {
+
+	if seal, ok := b.Consent.SealedRegion(documentContentRequest.SubjectIdentifier, documentContentRequest.Region); ok {
+		return SubjectRegionDocument{}, fmt.Errorf("records sealed, reason code: %s", seal.ReasonCode)
+	}
+	documents := b.lookupSubjectRegionDocuments(documentContentRequest.SubjectIdentifier, documentContentRequest.Region)
+	for _, document := range documents {
+		if document.DocumentIdentifier != documentContentRequest.DocumentIdentifier {
+			continue
+		}
+		if shared, _ := b.Consent.FilterDocuments(documentContentRequest.SubjectIdentifier, documentContentRequest.Region, []SubjectRegionDocument{document}); len(shared) == 0 {
+			return SubjectRegionDocument{}, fmt.Errorf("category sealed")
+		}
+		if !b.Entitlements.IsEntitled(documentContentRequest.UserName, document) {
+			return SubjectRegionDocument{}, fmt.Errorf("user not entitled to its category or specialty")
+		}
+		return document, nil
+	}
+	return SubjectRegionDocument{}, fmt.Errorf("document not found")
+}
+
+// auditDocumentContent records a system audit event about the content of a requested document
+func (b *Backend) auditDocumentContent(ctx context.Context, documentContentRequest DocumentContentRequest, auditEvent string) {
+
+	b.sendSystemAuditEvent(ctx, *NewSystemAuditEventWithDocument(documentContentRequest.UserName,
+		documentContentRequest.SubjectIdentifier,
+		documentContentRequest.DocumentIdentifier,
+		auditEvent))
+}
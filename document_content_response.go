@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the structure of DocumentContentResponse, one chunk of a document's content, or the error which prevented it being sent.
+// size and checksum describe the whole content, so it can be checked once every chunk has been received
+type DocumentContentResponse struct {
+	SubjectIdentifier  string `json:"subjectIdentifier" protobuf:"1" encrypt:"true"`
+	DocumentIdentifier string `json:"documentIdentifier" protobuf:"2" encrypt:"true"`
+	Region             int    `json:"region" protobuf:"3"`
+	UserName           string `json:"userName" protobuf:"4"`
+	ContentType        string `json:"contentType,omitempty" protobuf:"5"`
+	Size               int    `json:"size" protobuf:"6"`
+	Checksum           string `json:"checksum,omitempty" protobuf:"7"`
+	ChunkIndex         int    `json:"chunkIndex" protobuf:"8"`
+	ChunkCount         int    `json:"chunkCount" protobuf:"9"`
+	Content            string `json:"content,omitempty" protobuf:"10" encrypt:"true"`
+	Error              string `json:"error,omitempty" protobuf:"11"`
+}
+
+// NewDocumentContentResponses creates an instance of DocumentContentResponse for each chunk of a document's content, its chunk base64 encoded
+func NewDocumentContentResponses(documentContentRequest DocumentContentRequest, content []byte, chunks [][]byte) []DocumentContentResponse {
+
+	checksum := contentChecksum(content)
+	responses := make([]DocumentContentResponse, 0, len(chunks))
+	for i, chunk := range chunks {
+		responses = append(responses, DocumentContentResponse{
+			SubjectIdentifier:  documentContentRequest.SubjectIdentifier,
+			DocumentIdentifier: documentContentRequest.DocumentIdentifier,
+			Region:             documentContentRequest.Region,
+			UserName:           documentContentRequest.UserName,
+			ContentType:        DOCUMENT_CONTENT_TYPE,
+			Size:               len(content),
+			Checksum:           checksum,
+			ChunkIndex:         i,
+			ChunkCount:         len(chunks),
+			Content:            base64.StdEncoding.EncodeToString(chunk),
+		})
+	}
+	return responses
+}
+
+// NewDocumentContentErrorResponse creates a new instance of DocumentContentResponse, with no chunks, for an error
+func NewDocumentContentErrorResponse(documentContentRequest DocumentContentRequest, err error) *DocumentContentResponse {
+
+	return &DocumentContentResponse{
+		SubjectIdentifier:  documentContentRequest.SubjectIdentifier,
+		DocumentIdentifier: documentContentRequest.DocumentIdentifier,
+		Region:             documentContentRequest.Region,
+		UserName:           documentContentRequest.UserName,
+		Error:              errorMessage(err),
+	}
+}
+
+// contentChecksum returns the SHA-256 checksum of content, prefixed with its algorithm
+func contentChecksum(content []byte) string {
+
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// sendDocumentContentResponses sends the chunks of a document's content to a topic as one batch, so no other entries are saved between them
+func (b *Backend) sendDocumentContentResponses(ctx context.Context, documentContentResponses []DocumentContentResponse) {
+
+	topic := DOCUMENT_CONTENT_RESPONSE_TOPIC
+
+	ctx, span := b.Tracer.Start(ctx, "sendDocumentContentResponses", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+	span.SetAttribute("messaging.batch.message_count", len(documentContentResponses))
+
+	codec := b.Codecs.For(topic)
+	var messageStoreEntries []ms.Entry
+	for _, documentContentResponse := range documentContentResponses {
+		payload, err := codec.Marshal(documentContentResponse)
+		if err != nil {
+			span.RecordError(err)
+			fmt.Fprintf(b.Log, "failed to marshal event: %v", err)
+		}
+		messageStoreEntries = append(messageStoreEntries, newMessageStoreEntry(ctx, topic, documentContentResponse.UserName, codec, payload))
+	}
+
+	offset, err := asBatchMessageStore(b.MessageStore).SaveEntries(topic, messageStoreEntries)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "SaveEntries for topic '%s' failed: %v", topic, err)
+	}
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved ", len(messageStoreEntries), " documentContentResponses to topic ", topic, " from offset ", offset)
+}
+
+// Define the structure of pendingDocumentContent, the chunks received so far of one document's content
+type pendingDocumentContent struct {
+	chunks  map[int][]byte
+	started time.Time
+}
+
+// DocumentContentAssembler collects the chunks of each document's content until every chunk has been received.
+// pending content is held in memory, so any which is incomplete when the application stops is lost
+type DocumentContentAssembler struct {
+	mu      sync.Mutex
+	pending map[string]*pendingDocumentContent
+	timeout time.Duration
+}
+
+// NewDocumentContentAssembler creates a new instance of DocumentContentAssembler
+func NewDocumentContentAssembler(timeout time.Duration) *DocumentContentAssembler {
+
+	return &DocumentContentAssembler{
+		pending: make(map[string]*pendingDocumentContent),
+		timeout: timeout,
+	}
+}
+
+// Add adds a chunk to the content it belongs to, which is identified by its trace and document, and returns the content once
+// every chunk has been received, or an error when a chunk is out of range or cannot be decoded, or the content does not match
+// its size and checksum. a chunk received again replaces the first, so only distinct chunks count towards the content being complete
+func (a *DocumentContentAssembler) Add(traceID string, documentContentResponse DocumentContentResponse) ([]byte, bool, error) {
+
+	if documentContentResponse.ChunkIndex < 0 || documentContentResponse.ChunkIndex >= documentContentResponse.ChunkCount {
+		return nil, false, fmt.Errorf("chunk %d is out of range of %d chunks", documentContentResponse.ChunkIndex, documentContentResponse.ChunkCount)
+	}
+	chunk, err := base64.StdEncoding.DecodeString(documentContentResponse.Content)
+	if err != nil {
+		return nil, false, fmt.Errorf("chunk %d could not be decoded: %v", documentContentResponse.ChunkIndex, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	key := traceID + "/" + documentContentResponse.UserName + "/" + documentContentResponse.SubjectIdentifier + "/" +
+		strconv.Itoa(documentContentResponse.Region) + "/" + documentContentResponse.DocumentIdentifier
+	pending, ok := a.pending[key]
+	if !ok {
+		pending = &pendingDocumentContent{
+			chunks:  make(map[int][]byte),
+			started: now,
+		}
+		a.pending[key] = pending
+	}
+	pending.chunks[documentContentResponse.ChunkIndex] = chunk
+
+	if len(pending.chunks) < documentContentResponse.ChunkCount {
+		return nil, false, nil
+	}
+	delete(a.pending, key)
+	var content bytes.Buffer
+	for i := 0; i < documentContentResponse.ChunkCount; i++ {
+		content.Write(pending.chunks[i])
+	}
+	if content.Len() != documentContentResponse.Size || contentChecksum(content.Bytes()) != documentContentResponse.Checksum {
+		return nil, false, fmt.Errorf("content of %d bytes does not match its size of %d bytes and checksum", content.Len(), documentContentResponse.Size)
+	}
+	return content.Bytes(), true, nil
+}
+
+// Expire discards content which some chunk has not been received for within the timeout, and returns a description of each
+func (a *DocumentContentAssembler) Expire(now time.Time) []string {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var discarded []string
+	for key, pending := range a.pending {
+		if now.Sub(pending.started) > a.timeout {
+			discarded = append(discarded, fmt.Sprintf("discarding document content '%s', only %d chunks received", key, len(pending.chunks)))
+			delete(a.pending, key)
+		}
+	}
+	return discarded
+}
+
+// pollDocumentContentResponse polls the topic for document content responses
+func (b *Backend) pollDocumentContentResponse(ctx context.Context) {
+
+	b.pollTopic(ctx, DOCUMENT_CONTENT_RESPONSE_CONSUMER, DOCUMENT_CONTENT_RESPONSE_TOPIC, b.processEntryFromPollDocumentContentResponse)
+}
+
+// processEntryFromPollDocumentContentResponse processes an entry from polling a topic
+func (b *Backend) processEntryFromPollDocumentContentResponse(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	ctx, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPollDocumentContentResponse", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", DOCUMENT_CONTENT_RESPONSE_TOPIC)
+
+	var documentContentResponse DocumentContentResponse
+	if err := envelope.DecodePayload(&documentContentResponse); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal documentContentResponse: %v", err)
+	}
+	fmt.Fprintf(b.Log, "received documentContentResponse: chunk %d of %d, region %d, error '%s'\n", documentContentResponse.ChunkIndex+1,
+		documentContentResponse.ChunkCount, documentContentResponse.Region, documentContentResponse.Error)
+
+	b.assembleDocumentContentResponse(ctx, documentContentResponse)
+}
+
+// assembleDocumentContentResponse adds a chunk to its document's content, and records the content's delivery as a system audit event
+// once every chunk has been received, or its failure when the content is corrupt. errors were recorded by the region which sent them
+func (b *Backend) assembleDocumentContentResponse(ctx context.Context, documentContentResponse DocumentContentResponse) {
+
+	ctx, span := b.Tracer.Start(ctx, "assembleDocumentContentResponse", SPAN_KIND_INTERNAL)
+	defer span.End()
+	span.SetAttribute("region", documentContentResponse.Region)
+
+	if documentContentResponse.Error != "" {
+		return
+	}
+	for _, discarded := range b.ContentAssembler.Expire(time.Now()) {
+		fmt.Fprintln(b.Log, discarded)
+	}
+	spanContext, _ := spanContextFromContext(ctx)
+	content, ok, err := b.ContentAssembler.Add(spanContext.TraceIDString(), documentContentResponse)
+	auditEvent := ""
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		auditEvent = fmt.Sprintf("document content of region %s not delivered, %v", regionName(documentContentResponse.Region), err)
+	case ok:
+		auditEvent = fmt.Sprintf("document content of region %s delivered, %d bytes", regionName(documentContentResponse.Region), len(content))
+	default:
+		return
+	}
+	b.sendSystemAuditEvent(ctx, *NewSystemAuditEventWithDocument(documentContentResponse.UserName,
+		documentContentResponse.SubjectIdentifier,
+		documentContentResponse.DocumentIdentifier,
+		auditEvent))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// documentContentTestResponses returns the chunks of content of a document, three bytes to a chunk
+func documentContentTestResponses(content string) []DocumentContentResponse {
+
+	s := &ContentStore{ChunkSize: 3}
+	documentContentRequest := *NewDocumentContentRequest("0101700008", "A1B2C3D4E5F6", LOTHIAN_REGION, "jbloggs")
+	return NewDocumentContentResponses(documentContentRequest, []byte(content), s.Chunks([]byte(content)))
+}
+
+// TestDocumentContentAssemblerAdd checks content is returned once every distinct chunk has been received, in any order,
+// and chunks out of range or content not matching its size and checksum are errors
+func TestDocumentContentAssemblerAdd(t *testing.T) {
+
+	responses := documentContentTestResponses("abcdefgh")
+	outOfRange := responses[0]
+	outOfRange.ChunkIndex = outOfRange.ChunkCount
+	negative := responses[0]
+	negative.ChunkIndex = -1
+	wrongSize := responses[2]
+	wrongSize.Size++
+	wrongChecksum := responses[2]
+	wrongChecksum.Checksum = contentChecksum([]byte("abcdefgi"))
+	undecodable := responses[2]
+	undecodable.Content = "not base64!"
+
+	tests := []struct {
+		name      string
+		responses []DocumentContentResponse
+		content   string
+		err       string
+	}{
+		{"in order", responses, "abcdefgh", ""},
+		{"out of order", []DocumentContentResponse{responses[2], responses[0], responses[1]}, "abcdefgh", ""},
+		{"duplicate chunks", []DocumentContentResponse{responses[0], responses[0], responses[1], responses[1], responses[2]}, "abcdefgh", ""},
+		{"duplicate chunks are not complete", []DocumentContentResponse{responses[0], responses[1], responses[1]}, "", ""},
+		{"chunk index of the chunk count", []DocumentContentResponse{responses[0], responses[1], outOfRange}, "", "chunk 3 is out of range of 3 chunks"},
+		{"negative chunk index", []DocumentContentResponse{negative}, "", "chunk -1 is out of range of 3 chunks"},
+		{"size mismatch", []DocumentContentResponse{responses[0], responses[1], wrongSize}, "", "does not match its size of 9 bytes"},
+		{"checksum mismatch", []DocumentContentResponse{responses[0], responses[1], wrongChecksum}, "", "does not match its size of 8 bytes and checksum"},
+		{"undecodable chunk", []DocumentContentResponse{undecodable}, "", "chunk 2 could not be decoded"},
+	}
+	for _, test := range tests {
+		a := NewDocumentContentAssembler(time.Minute)
+		var content []byte
+		var complete bool
+		var err error
+		for i, response := range test.responses {
+			var ok bool
+			if content, ok, err = a.Add("trace", response); ok && i != len(test.responses)-1 {
+				t.Errorf("%s: complete after %d of %d chunks", test.name, i+1, len(test.responses))
+			}
+			complete = complete || ok
+		}
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || complete != (test.content != "") || string(content) != test.content {
+			t.Errorf("%s: content %q, complete %v, %v, want %q", test.name, content, complete, err, test.content)
+		}
+	}
+}
+
+// TestDocumentContentAssemblerSeparatesContent checks chunks of the same document assemble separately for each trace
+func TestDocumentContentAssemblerSeparatesContent(t *testing.T) {
+
+	a := NewDocumentContentAssembler(time.Minute)
+	responses := documentContentTestResponses("abcdefgh")
+	for _, traceID := range []string{"trace-1", "trace-2"} {
+		for _, response := range responses[:2] {
+			if _, ok, err := a.Add(traceID, response); ok || err != nil {
+				t.Fatalf("%s: complete %v, %v, before the last chunk", traceID, ok, err)
+			}
+		}
+	}
+	for _, traceID := range []string{"trace-1", "trace-2"} {
+		if content, ok, err := a.Add(traceID, responses[2]); !ok || err != nil || !bytes.Equal(content, []byte("abcdefgh")) {
+			t.Errorf("%s: content %q, complete %v, %v, want it assembled", traceID, content, ok, err)
+		}
+	}
+}
+
+// TestDocumentContentAssemblerExpires checks content is discarded when some chunk is not received within the timeout,
+// so chunks received afterwards start the content again
+func TestDocumentContentAssemblerExpires(t *testing.T) {
+
+	a := NewDocumentContentAssembler(time.Minute)
+	responses := documentContentTestResponses("abcdefgh")
+	for _, response := range responses[:2] {
+		if _, _, err := a.Add("trace", response); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if discarded := a.Expire(time.Now().Add(30 * time.Second)); len(discarded) != 0 || len(a.pending) != 1 {
+		t.Fatalf("discarded %v, %d pending, want the content kept within the timeout", discarded, len(a.pending))
+	}
+	discarded := a.Expire(time.Now().Add(2 * time.Minute))
+	if len(discarded) != 1 || !strings.Contains(discarded[0], "only 2 chunks received") || len(a.pending) != 0 {
+		t.Fatalf("discarded %v, %d pending, want the content discarded and described after the timeout", discarded, len(a.pending))
+	}
+
+	if content, ok, err := a.Add("trace", responses[2]); ok || err != nil {
+		t.Errorf("content %q, complete %v, %v, want the last chunk alone incomplete", content, ok, err)
+	}
+	if len(a.pending) != 1 {
+		t.Errorf("%d pending, want the last chunk to start the content again", len(a.pending))
+	}
+}
+
+// TestAssembleDocumentContentResponseLogsExpired checks content discarded after the timeout is logged to the backend's log writer
+func TestAssembleDocumentContentResponseLogsExpired(t *testing.T) {
+
+	b := NewBackend()
+	b.MessageStore = newMemoryMessageStore()
+	var log bytes.Buffer
+	b.Log = &log
+	// every pending content has outlived a negative timeout by the next chunk
+	b.ContentAssembler = NewDocumentContentAssembler(-time.Second)
+	responses := documentContentTestResponses("abcdefgh")
+
+	b.assembleDocumentContentResponse(context.Background(), responses[0])
+	if strings.Contains(log.String(), "discarding") {
+		t.Fatalf("logged %q before any content expired", log.String())
+	}
+	b.assembleDocumentContentResponse(context.Background(), responses[1])
+	if !strings.Contains(log.String(), "discarding document content '") || !strings.Contains(log.String(), "only 1 chunks received\n") {
+		t.Errorf("logged %q, want the expired content", log.String())
+	}
+}
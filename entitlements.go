@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Define the entitlements file the application loads users' entitlements from when none is given
+const ENTITLEMENTS_FILE = "entitlements.json"
+
+// Define constants for entitlements. the wildcard entitles a user to every category or specialty,
+// and the redacted value replaces the metadata of a document a user is not entitled to see
+const (
+	ENTITLEMENT_WILDCARD = "*"
+	REDACTED_VALUE       = "redacted"
+)
+
+// Define the structure of UserEntitlement, the document categories and specialties a user may see
+type UserEntitlement struct {
+	UserName       string   `json:"userName"`
+	CategoryCodes  []string `json:"categoryCodes"`
+	SpecialtyCodes []string `json:"specialtyCodes"`
+}
+
+// Define the structure of EntitlementsFile, the entitlements of every user, those of users who are not listed,
+// and whether the documents a user is not entitled to see are redacted rather than stripped
+type EntitlementsFile struct {
+	Users   []UserEntitlement `json:"users"`
+	Default *UserEntitlement  `json:"default"`
+	Redact  bool              `json:"redact"`
+}
+
+// EntitlementService applies users' entitlements to the documents delivered to them. a document is visible to a user entitled to both
+// its category and its specialty. a user who is not listed has the default entitlement, or none. a nil service entitles everyone to everything
+type EntitlementService struct {
+	users       map[string]UserEntitlement
+	defaultUser *UserEntitlement
+	redact      bool
+}
+
+// NewEntitlementService creates a new instance of EntitlementService
+func NewEntitlementService(entitlementsFile EntitlementsFile) (*EntitlementService, error) {
+
+	e := &EntitlementService{
+		users:       make(map[string]UserEntitlement),
+		defaultUser: entitlementsFile.Default,
+		redact:      entitlementsFile.Redact,
+	}
+	for i, user := range entitlementsFile.Users {
+		if user.UserName == "" {
+			return nil, fmt.Errorf("user %d: userName is required", i)
+		}
+		if _, ok := e.users[user.UserName]; ok {
+			return nil, fmt.Errorf("user '%s' is listed more than once", user.UserName)
+		}
+		e.users[user.UserName] = user
+	}
+	return e, nil
+}
+
+// LoadEntitlementService loads the entitlements of an entitlements file
+func LoadEntitlementService(filename string) (*EntitlementService, error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var entitlementsFile EntitlementsFile
+	if err := json.Unmarshal(data, &entitlementsFile); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal entitlements file: %s, %v", filename, err)
+	}
+	return NewEntitlementService(entitlementsFile)
+}
+
+// loadOptionalEntitlementService loads the entitlements file named by a command's --entitlements flag. no service is returned,
+// so every document is delivered, when no file is named, or when the default entitlements file does not exist
+func loadOptionalEntitlementService(filename string) (*EntitlementService, error) {
+
+	if filename == "" {
+		return nil, nil
+	}
+	e, err := LoadEntitlementService(filename)
+	if errors.Is(err, os.ErrNotExist) && filename == ENTITLEMENTS_FILE {
+		return nil, nil
+	}
+	return e, err
+}
+
+// IsEntitled reports whether a user may see a document
+func (e *EntitlementService) IsEntitled(userName string, document SubjectRegionDocument) bool {
+
+	if e == nil {
+		return true
+	}
+	user, ok := e.users[userName]
+	if !ok {
+		if e.defaultUser == nil {
+			return false
+		}
+		user = *e.defaultUser
+	}
+	return containsEntitlement(user.CategoryCodes, document.DocumentCategoryCode) &&
+		containsEntitlement(user.SpecialtyCodes, document.DocumentSpecialtyCode)
+}
+
+// containsEntitlement reports whether a list of entitled codes includes a code, or the wildcard
+func containsEntitlement(entitled []string, code string) bool {
+
+	for _, entitledCode := range entitled {
+		if entitledCode == ENTITLEMENT_WILDCARD || strings.EqualFold(entitledCode, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterDocuments returns the documents a user may see, with those they may not stripped, or redacted to their date and region,
+// and the number withheld
+func (e *EntitlementService) FilterDocuments(userName string, documents []SubjectRegionDocument) ([]SubjectRegionDocument, int) {
+
+	if e == nil {
+		return documents, 0
+	}
+	var delivered []SubjectRegionDocument
+	withheld := 0
+	for _, document := range documents {
+		if e.IsEntitled(userName, document) {
+			delivered = append(delivered, document)
+			continue
+		}
+		withheld++
+		if e.redact {
+			delivered = append(delivered, SubjectRegionDocument{
+				DocumentIdentifier:    REDACTED_VALUE,
+				DocumentDate:          document.DocumentDate,
+				DocumentCategoryCode:  REDACTED_VALUE,
+				DocumentCategory:      REDACTED_VALUE,
+				DocumentSpecialtyCode: REDACTED_VALUE,
+				DocumentSpecialty:     REDACTED_VALUE,
+				Region:                document.Region,
+			})
+		}
+	}
+	return delivered, withheld
+}
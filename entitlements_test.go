@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// entitlementTestDocument returns a document of a category and specialty
+func entitlementTestDocument(documentIdentifier, categoryCode, specialtyCode string) SubjectRegionDocument {
+
+	return SubjectRegionDocument{
+		DocumentIdentifier:    documentIdentifier,
+		DocumentDate:          time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		DocumentCategoryCode:  categoryCode,
+		DocumentCategory:      "category " + categoryCode,
+		DocumentSpecialtyCode: specialtyCode,
+		DocumentSpecialty:     "specialty " + specialtyCode,
+		Region:                LOTHIAN_REGION,
+	}
+}
+
+// newTestEntitlementService creates an entitlement service, failing the test if it cannot
+func newTestEntitlementService(t *testing.T, redact bool, defaultUser *UserEntitlement) *EntitlementService {
+
+	t.Helper()
+	entitlements, err := NewEntitlementService(EntitlementsFile{
+		Users: []UserEntitlement{
+			{UserName: "gp", CategoryCodes: []string{"GP", "xr"}, SpecialtyCodes: []string{ENTITLEMENT_WILDCARD}},
+			{UserName: "cardiologist", CategoryCodes: []string{ENTITLEMENT_WILDCARD}, SpecialtyCodes: []string{"CARD"}},
+			{UserName: "nobody"},
+		},
+		Default: defaultUser,
+		Redact:  redact,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entitlements
+}
+
+// TestIsEntitled checks a user must be entitled to both a document's category and its specialty, by code or wildcard, ignoring case,
+// and an unlisted user has the default entitlement
+func TestIsEntitled(t *testing.T) {
+
+	entitlements := newTestEntitlementService(t, false, nil)
+	withDefault := newTestEntitlementService(t, false, &UserEntitlement{CategoryCodes: []string{"GP"}, SpecialtyCodes: []string{"GEN"}})
+
+	tests := []struct {
+		name         string
+		entitlements *EntitlementService
+		userName     string
+		document     SubjectRegionDocument
+		entitled     bool
+	}{
+		{"category and any specialty", entitlements, "gp", entitlementTestDocument("1", "GP", "CARD"), true},
+		{"category of another case", entitlements, "gp", entitlementTestDocument("1", "XR", "GEN"), true},
+		{"category not entitled", entitlements, "gp", entitlementTestDocument("1", "MH", "GEN"), false},
+		{"any category and specialty", entitlements, "cardiologist", entitlementTestDocument("1", "MH", "card"), true},
+		{"specialty not entitled", entitlements, "cardiologist", entitlementTestDocument("1", "GP", "GEN"), false},
+		{"no entitlements", entitlements, "nobody", entitlementTestDocument("1", "GP", "GEN"), false},
+		{"unlisted without a default", entitlements, "jbloggs", entitlementTestDocument("1", "GP", "GEN"), false},
+		{"unlisted with a default", withDefault, "jbloggs", entitlementTestDocument("1", "GP", "GEN"), true},
+		{"unlisted outside the default", withDefault, "jbloggs", entitlementTestDocument("1", "GP", "CARD"), false},
+		{"listed ignores the default", withDefault, "nobody", entitlementTestDocument("1", "GP", "GEN"), false},
+		{"no entitlement service", nil, "jbloggs", entitlementTestDocument("1", "MH", "PSY"), true},
+	}
+	for _, test := range tests {
+		if entitled := test.entitlements.IsEntitled(test.userName, test.document); entitled != test.entitled {
+			t.Errorf("%s: entitled %v, want %v", test.name, entitled, test.entitled)
+		}
+	}
+}
+
+// TestEntitlementsFilterDocuments checks documents a user may not see are stripped, or redacted to their date and region,
+// and counted as withheld either way
+func TestEntitlementsFilterDocuments(t *testing.T) {
+
+	documents := []SubjectRegionDocument{
+		entitlementTestDocument("1", "GP", "GEN"),
+		entitlementTestDocument("2", "MH", "PSY"),
+		entitlementTestDocument("3", "XR", "RAD"),
+		entitlementTestDocument("4", "SH", "GEN"),
+	}
+
+	tests := []struct {
+		name         string
+		entitlements *EntitlementService
+		userName     string
+		delivered    []string
+		withheld     int
+	}{
+		{"stripped", newTestEntitlementService(t, false, nil), "gp", []string{"1", "3"}, 2},
+		{"redacted", newTestEntitlementService(t, true, nil), "gp", []string{"1", REDACTED_VALUE, "3", REDACTED_VALUE}, 2},
+		{"all stripped", newTestEntitlementService(t, false, nil), "nobody", nil, 4},
+		{"none withheld", newTestEntitlementService(t, false, &UserEntitlement{CategoryCodes: []string{"*"}, SpecialtyCodes: []string{"*"}}), "jbloggs", []string{"1", "2", "3", "4"}, 0},
+		{"no entitlement service", nil, "nobody", []string{"1", "2", "3", "4"}, 0},
+	}
+	for _, test := range tests {
+		delivered, withheld := test.entitlements.FilterDocuments(test.userName, documents)
+		var documentIdentifiers []string
+		for _, document := range delivered {
+			documentIdentifiers = append(documentIdentifiers, document.DocumentIdentifier)
+		}
+		if strings.Join(documentIdentifiers, ",") != strings.Join(test.delivered, ",") || withheld != test.withheld {
+			t.Errorf("%s: delivered %v, withheld %d, want %v and %d", test.name, documentIdentifiers, withheld, test.delivered, test.withheld)
+		}
+	}
+
+	redacted, _ := newTestEntitlementService(t, true, nil).FilterDocuments("gp", documents[1:2])
+	want := SubjectRegionDocument{
+		DocumentIdentifier:    REDACTED_VALUE,
+		DocumentDate:          documents[1].DocumentDate,
+		DocumentCategoryCode:  REDACTED_VALUE,
+		DocumentCategory:      REDACTED_VALUE,
+		DocumentSpecialtyCode: REDACTED_VALUE,
+		DocumentSpecialty:     REDACTED_VALUE,
+		Region:                LOTHIAN_REGION,
+	}
+	if len(redacted) != 1 || redacted[0] != want {
+		t.Errorf("redacted %+v, want only the date and region kept", redacted)
+	}
+}
+
+// TestApplyEntitlementsAudited checks the subject's documents record the number withheld, with one audit event when any are withheld
+func TestApplyEntitlementsAudited(t *testing.T) {
+
+	b := NewBackend()
+	b.Log = io.Discard
+	b.MessageStore = newMemoryMessageStore()
+	b.Entitlements = newTestEntitlementService(t, false, nil)
+	ctx := context.Background()
+
+	subjectDocuments := NewSubjectDocuments("1234567890", []SubjectRegionDocument{
+		entitlementTestDocument("1", "GP", "GEN"),
+		entitlementTestDocument("2", "MH", "PSY"),
+		entitlementTestDocument("3", "SH", "GEN"),
+	}, "gp", 1)
+	b.applyEntitlements(ctx, subjectDocuments)
+	if len(subjectDocuments.Documents) != 1 || subjectDocuments.Withheld != 2 {
+		t.Errorf("%d documents, %d withheld, want 1 and 2", len(subjectDocuments.Documents), subjectDocuments.Withheld)
+	}
+	systemAuditEvents := readSystemAuditEvents(t, b, 0)
+	if len(systemAuditEvents) != 1 || !strings.HasPrefix(systemAuditEvents[0].AuditEvent, "2 documents withheld") ||
+		systemAuditEvents[0].UserName != "gp" || systemAuditEvents[0].SubjectIdentifier != "1234567890" {
+		t.Errorf("audit events %+v, want one recording 2 documents withheld from gp", systemAuditEvents)
+	}
+
+	subjectDocuments = NewSubjectDocuments("1234567890", []SubjectRegionDocument{entitlementTestDocument("1", "GP", "GEN")}, "gp", 1)
+	b.applyEntitlements(ctx, subjectDocuments)
+	if subjectDocuments.Withheld != 0 {
+		t.Errorf("%d withheld, want 0", subjectDocuments.Withheld)
+	}
+	if length := topicLength(b.MessageStore, SYSTEM_AUDIT_EVENT_TOPIC); length != 1 {
+		t.Errorf("%d audit events, want none more when nothing is withheld", length-1)
+	}
+}
+
+// TestLoadEntitlementService checks entitlements are loaded from a file, and users without a name or listed twice are rejected
+func TestLoadEntitlementService(t *testing.T) {
+
+	directory := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"valid", `{"users": [{"userName": "gp", "categoryCodes": ["GP"], "specialtyCodes": ["*"]}], "redact": true}`, ""},
+		{"no user name", `{"users": [{"categoryCodes": ["GP"]}]}`, "user 0: userName is required"},
+		{"listed twice", `{"users": [{"userName": "gp"}, {"userName": "gp"}]}`, "user 'gp' is listed more than once"},
+		{"corrupt", `{"users": [`, "failed to unmarshal entitlements file"},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(directory, strings.ReplaceAll(test.name, " ", "-")+".json")
+		if err := os.WriteFile(filename, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		entitlements, err := loadOptionalEntitlementService(filename)
+		if test.err == "" {
+			if err != nil || !entitlements.IsEntitled("gp", entitlementTestDocument("1", "GP", "GEN")) {
+				t.Errorf("%s: %v, want gp entitled", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+	if entitlements, err := loadOptionalEntitlementService(""); entitlements != nil || err != nil {
+		t.Errorf("no entitlements file named: %v, %v, want neither a service nor an error", entitlements, err)
+	}
+}
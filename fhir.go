@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Define constants for FHIR R4. the code and identifier systems other than HL7's are the application's own
+const (
+	FHIR_BASE_SYSTEM                = "https://github.com/mmcnicol/message-store-demo-embedded/fhir"
+	FHIR_CHI_NUMBER_SYSTEM          = FHIR_BASE_SYSTEM + "/Id/chi-number"
+	FHIR_DOCUMENT_IDENTIFIER_SYSTEM = FHIR_BASE_SYSTEM + "/Id/document-identifier"
+	FHIR_REGION_SYSTEM              = FHIR_BASE_SYSTEM + "/Id/region"
+	FHIR_DOCUMENT_CATEGORY_SYSTEM   = FHIR_BASE_SYSTEM + "/CodeSystem/document-category"
+	FHIR_SPECIALTY_SYSTEM           = FHIR_BASE_SYSTEM + "/CodeSystem/specialty"
+	FHIR_SECURITY_LABEL_SYSTEM      = "http://terminology.hl7.org/CodeSystem/v3-ObservationValue"
+	FHIR_REDACTED_SECURITY_LABEL    = "REDACTED"
+)
+
+// Define the structure of FHIRBundle, a FHIR R4 Bundle
+type FHIRBundle struct {
+	ResourceType string            `json:"resourceType"`
+	ID           string            `json:"id"`
+	Type         string            `json:"type"`
+	Timestamp    string            `json:"timestamp"`
+	Entry        []FHIRBundleEntry `json:"entry,omitempty"`
+}
+
+// Define the structure of FHIRBundleEntry, a resource in a Bundle
+type FHIRBundleEntry struct {
+	FullURL  string      `json:"fullUrl"`
+	Resource interface{} `json:"resource"`
+}
+
+// Define the structure of FHIRMeta, the metadata of a resource
+type FHIRMeta struct {
+	Security []FHIRCoding `json:"security,omitempty"`
+}
+
+// Define the structure of FHIRIdentifier
+type FHIRIdentifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+// Define the structure of FHIRCoding
+type FHIRCoding struct {
+	System  string `json:"system"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+// Define the structure of FHIRCodeableConcept
+type FHIRCodeableConcept struct {
+	Coding []FHIRCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+// Define the structure of FHIRReference, a reference to a resource by its URL, or by its identifier
+type FHIRReference struct {
+	Reference  string          `json:"reference,omitempty"`
+	Identifier *FHIRIdentifier `json:"identifier,omitempty"`
+	Display    string          `json:"display,omitempty"`
+}
+
+// Define the structure of FHIROrganization, a FHIR R4 Organization
+type FHIROrganization struct {
+	ResourceType string           `json:"resourceType"`
+	ID           string           `json:"id"`
+	Identifier   []FHIRIdentifier `json:"identifier"`
+	Name         string           `json:"name"`
+}
+
+// Define the structure of FHIRDocumentReference, a FHIR R4 DocumentReference
+type FHIRDocumentReference struct {
+	ResourceType     string                         `json:"resourceType"`
+	ID               string                         `json:"id"`
+	Meta             *FHIRMeta                      `json:"meta,omitempty"`
+	MasterIdentifier *FHIRIdentifier                `json:"masterIdentifier,omitempty"`
+	Status           string                         `json:"status"`
+	Type             *FHIRCodeableConcept           `json:"type,omitempty"`
+	Subject          FHIRReference                  `json:"subject"`
+	Date             string                         `json:"date"`
+	Custodian        FHIRReference                  `json:"custodian"`
+	Content          []FHIRDocumentReferenceContent `json:"content"`
+	Context          *FHIRDocumentReferenceContext  `json:"context,omitempty"`
+}
+
+// Define the structure of FHIRDocumentReferenceContent, the document a DocumentReference refers to
+type FHIRDocumentReferenceContent struct {
+	Attachment FHIRAttachment `json:"attachment"`
+}
+
+// Define the structure of FHIRAttachment
+type FHIRAttachment struct {
+	Title    string `json:"title,omitempty"`
+	Creation string `json:"creation,omitempty"`
+}
+
+// Define the structure of FHIRDocumentReferenceContext, the clinical context of a document
+type FHIRDocumentReferenceContext struct {
+	PracticeSetting *FHIRCodeableConcept `json:"practiceSetting,omitempty"`
+}
+
+// NewFHIRDocumentBundle renders a subject's consolidated documents as a FHIR R4 collection Bundle, with a DocumentReference per document
+// and an Organization per region holding documents, which is the custodian of its documents. documents redacted by entitlements keep
+// only their date and custodian, and are labelled REDACTED
+func NewFHIRDocumentBundle(id string, subjectDocuments SubjectDocuments, timestamp time.Time) FHIRBundle {
+
+	bundle := FHIRBundle{
+		ResourceType: "Bundle",
+		ID:           id,
+		Type:         "collection",
+		Timestamp:    timestamp.UTC().Format(time.RFC3339),
+	}
+	subject := FHIRReference{Identifier: &FHIRIdentifier{System: FHIR_CHI_NUMBER_SYSTEM, Value: subjectDocuments.SubjectIdentifier}}
+
+	custodians := make(map[int]string)
+	for _, document := range subjectDocuments.Documents {
+		if _, ok := custodians[document.Region]; !ok {
+			organization := FHIROrganization{
+				ResourceType: "Organization",
+				ID:           "region-" + strconv.Itoa(document.Region),
+				Identifier:   []FHIRIdentifier{{System: FHIR_REGION_SYSTEM, Value: strconv.Itoa(document.Region)}},
+				Name:         regionName(document.Region),
+			}
+			custodians[document.Region] = fhirFullURL("Organization", organization.ID)
+			bundle.Entry = append(bundle.Entry, FHIRBundleEntry{FullURL: custodians[document.Region], Resource: organization})
+		}
+	}
+
+	for i, document := range subjectDocuments.Documents {
+		documentReference := newFHIRDocumentReference(document, subject, FHIRReference{
+			Reference: custodians[document.Region],
+			Display:   regionName(document.Region),
+		})
+		if document.DocumentIdentifier == REDACTED_VALUE {
+			// redacted documents have no identifier, so are numbered by their position
+			documentReference.ID = fmt.Sprintf("%s-redacted-%d", id, i)
+		}
+		bundle.Entry = append(bundle.Entry, FHIRBundleEntry{FullURL: fhirFullURL("DocumentReference", documentReference.ID), Resource: documentReference})
+	}
+	return bundle
+}
+
+// newFHIRDocumentReference renders a document as a DocumentReference, its category as its type and its specialty as its practice setting
+func newFHIRDocumentReference(document SubjectRegionDocument, subject, custodian FHIRReference) FHIRDocumentReference {
+
+	documentDate := document.DocumentDate.UTC().Format(time.RFC3339)
+	documentReference := FHIRDocumentReference{
+		ResourceType: "DocumentReference",
+		Status:       "current",
+		Subject:      subject,
+		Date:         documentDate,
+		Custodian:    custodian,
+	}
+	if document.DocumentIdentifier == REDACTED_VALUE {
+		documentReference.Meta = &FHIRMeta{Security: []FHIRCoding{{System: FHIR_SECURITY_LABEL_SYSTEM, Code: FHIR_REDACTED_SECURITY_LABEL, Display: "redacted"}}}
+		documentReference.Content = []FHIRDocumentReferenceContent{{Attachment: FHIRAttachment{Creation: documentDate}}}
+		return documentReference
+	}
+
+	documentReference.ID = fmt.Sprintf("region-%d-%s", document.Region, document.DocumentIdentifier)
+	documentReference.MasterIdentifier = &FHIRIdentifier{System: FHIR_DOCUMENT_IDENTIFIER_SYSTEM, Value: document.DocumentIdentifier}
+	documentReference.Type = &FHIRCodeableConcept{
+		Coding: []FHIRCoding{{System: FHIR_DOCUMENT_CATEGORY_SYSTEM, Code: document.DocumentCategoryCode, Display: document.DocumentCategory}},
+		Text:   document.DocumentCategory,
+	}
+	documentReference.Context = &FHIRDocumentReferenceContext{PracticeSetting: &FHIRCodeableConcept{
+		Coding: []FHIRCoding{{System: FHIR_SPECIALTY_SYSTEM, Code: document.DocumentSpecialtyCode, Display: document.DocumentSpecialty}},
+		Text:   document.DocumentSpecialty,
+	}}
+	documentReference.Content = []FHIRDocumentReferenceContent{{Attachment: FHIRAttachment{
+		Title:    document.DocumentCategory + ", " + document.DocumentSpecialty,
+		Creation: documentDate,
+	}}}
+	return documentReference
+}
+
+// fhirFullURL returns the full URL of a resource in a Bundle, a urn:uuid derived from its type and ID, so it is the same in every export
+func fhirFullURL(resourceType, id string) string {
+
+	sum := sha1.Sum([]byte(resourceType + "/" + id))
+	sum[6] = sum[6]&0x0f | 0x50 // version 5, name based
+	sum[8] = sum[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// Define the golden export of fhirTestSubjectDocuments, which the rendered Bundle must match byte for byte
+const FHIR_GOLDEN_BUNDLE_FILE = "testdata/fhir/document_bundle.json"
+
+// fhirTestSubjectDocuments returns a subject's documents held by two regions, one of them redacted, with a date outside UTC
+func fhirTestSubjectDocuments() SubjectDocuments {
+
+	bst := time.FixedZone("BST", 3600)
+	documents := []SubjectRegionDocument{
+		{
+			DocumentIdentifier:    "A1B2C3D4E5F6",
+			DocumentDate:          time.Date(2021, 6, 15, 10, 30, 0, 0, bst),
+			DocumentCategoryCode:  "GP",
+			DocumentCategory:      "General practice letter",
+			DocumentSpecialtyCode: "GEN",
+			DocumentSpecialty:     "General practice",
+			Region:                LOTHIAN_REGION,
+		},
+		{
+			DocumentIdentifier:    REDACTED_VALUE,
+			DocumentDate:          time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC),
+			DocumentCategoryCode:  REDACTED_VALUE,
+			DocumentCategory:      REDACTED_VALUE,
+			DocumentSpecialtyCode: REDACTED_VALUE,
+			DocumentSpecialty:     REDACTED_VALUE,
+			Region:                FIFE_REGION,
+		},
+		{
+			DocumentIdentifier:    "F6E5D4C3B2A1",
+			DocumentDate:          time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			DocumentCategoryCode:  "XR",
+			DocumentCategory:      "Radiology report",
+			DocumentSpecialtyCode: "RAD",
+			DocumentSpecialty:     "Radiology",
+			Region:                LOTHIAN_REGION,
+		},
+	}
+	return *NewSubjectDocuments("0101700008", documents, "jbloggs", 2)
+}
+
+// TestFHIRDocumentBundleGolden checks the Bundle of a subject's documents matches the golden export: an Organization per region
+// before the DocumentReferences, their custodian references, name based urn:uuid full URLs, the subject's CHI number and UTC dates
+func TestFHIRDocumentBundleGolden(t *testing.T) {
+
+	bundle := NewFHIRDocumentBundle("export-1", fhirTestSubjectDocuments(), time.Date(2025, 1, 2, 3, 4, 5, 0, time.FixedZone("BST", 3600)))
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(FHIR_GOLDEN_BUNDLE_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytes.TrimSpace(golden)) {
+		t.Errorf("bundle differs from %s:\n%s", FHIR_GOLDEN_BUNDLE_FILE, data)
+	}
+}
+
+// TestFHIRDocumentBundleReferences checks every full URL is a unique version 5 urn:uuid, and every custodian refers to
+// the Organization of its document's region within the Bundle
+func TestFHIRDocumentBundleReferences(t *testing.T) {
+
+	subjectDocuments := fhirTestSubjectDocuments()
+	bundle := NewFHIRDocumentBundle("export-1", subjectDocuments, time.Now())
+	fullURLPattern := regexp.MustCompile(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	organizations := make(map[string]FHIROrganization)
+	var documentReferences []FHIRDocumentReference
+	for _, entry := range bundle.Entry {
+		if !fullURLPattern.MatchString(entry.FullURL) {
+			t.Errorf("full URL %s, want a version 5 urn:uuid", entry.FullURL)
+		}
+		switch resource := entry.Resource.(type) {
+		case FHIROrganization:
+			if _, ok := organizations[entry.FullURL]; ok {
+				t.Errorf("full URL %s of more than one Organization", entry.FullURL)
+			}
+			organizations[entry.FullURL] = resource
+		case FHIRDocumentReference:
+			if entry.FullURL != fhirFullURL("DocumentReference", resource.ID) {
+				t.Errorf("DocumentReference %s has full URL %s, want it derived from its ID", resource.ID, entry.FullURL)
+			}
+			documentReferences = append(documentReferences, resource)
+		default:
+			t.Errorf("unexpected resource %T", resource)
+		}
+	}
+
+	if len(organizations) != 2 || len(documentReferences) != len(subjectDocuments.Documents) {
+		t.Fatalf("%d Organizations and %d DocumentReferences, want 2 and %d", len(organizations), len(documentReferences), len(subjectDocuments.Documents))
+	}
+	for i, documentReference := range documentReferences {
+		document := subjectDocuments.Documents[i]
+		organization, ok := organizations[documentReference.Custodian.Reference]
+		if !ok || organization.Name != regionName(document.Region) || documentReference.Custodian.Display != organization.Name {
+			t.Errorf("%s: custodian %+v, want the Organization of %s", documentReference.ID, documentReference.Custodian, regionName(document.Region))
+		}
+		if documentReference.Subject.Identifier == nil || *documentReference.Subject.Identifier != (FHIRIdentifier{System: FHIR_CHI_NUMBER_SYSTEM, Value: "0101700008"}) {
+			t.Errorf("%s: subject %+v, want the subject's CHI number", documentReference.ID, documentReference.Subject)
+		}
+		if documentReference.Date != document.DocumentDate.UTC().Format(time.RFC3339) {
+			t.Errorf("%s: date %s, want the document date in UTC", documentReference.ID, documentReference.Date)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define constants for field encryption. the encryption-key header holds the entry's data key, wrapped by a keyring key
+// and prefixed by that key's ID, and each encrypted field holds its ciphertext as a base64 string with the enc: prefix
+const (
+	ENCRYPTION_KEY_HEADER  = "encryption-key"
+	ENCRYPTED_FIELD_PREFIX = "enc:"
+)
+
+// FieldEncryption encrypts the payload fields tagged encrypt:"true", e.g. subject identifiers, with a data key generated for
+// each entry, which is wrapped by the keyring's primary key. fields are left in the clear while no keyring is loaded
+type FieldEncryption struct {
+	Keyring *Keyring
+}
+
+// NewFieldEncryption creates a new instance of FieldEncryption, with no keyring
+func NewFieldEncryption() *FieldEncryption {
+
+	return &FieldEncryption{}
+}
+
+// Enabled reports whether a keyring is loaded
+func (e *FieldEncryption) Enabled() bool {
+
+	return e != nil && e.Keyring != nil
+}
+
+// EncryptEntry rewrites an entry with the encrypted fields of its payload encrypted, unless no keyring is loaded,
+// the topic's payload has no encrypted fields, or the entry is already encrypted
+func (e *FieldEncryption) EncryptEntry(topic string, entry ms.Entry) (ms.Entry, error) {
+
+	if !e.Enabled() {
+		return entry, nil
+	}
+	topicDefinition, ok := findTopicDefinition(topic)
+	if !ok || !hasEncryptedFields(reflect.TypeOf(topicDefinition.NewPayload())) {
+		return entry, nil
+	}
+	envelope := decodeMessageEnvelope(entry)
+	if envelope.Headers == nil || envelope.Headers[ENCRYPTION_KEY_HEADER] != "" {
+		// entries without an envelope, or already encrypted
+		return entry, nil
+	}
+
+	payload := topicDefinition.NewPayload()
+	if err := envelope.DecodePayload(payload); err != nil {
+		return entry, fmt.Errorf("failed to decode payload to encrypt: %v", err)
+	}
+	dataKey, err := newDataKey()
+	if err != nil {
+		return entry, err
+	}
+	err = transformEncryptedFields(reflect.ValueOf(payload), func(name, value string) (string, error) {
+		ciphertext, err := sealAESGCM(dataKey, []byte(value), []byte(name))
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt field '%s': %v", name, err)
+		}
+		return ENCRYPTED_FIELD_PREFIX + base64.StdEncoding.EncodeToString(ciphertext), nil
+	})
+	if err != nil {
+		return entry, err
+	}
+	wrappedDataKey, err := e.Keyring.WrapDataKey(dataKey)
+	if err != nil {
+		return entry, err
+	}
+
+	headers := copyHeaders(envelope.Headers)
+	headers[ENCRYPTION_KEY_HEADER] = wrappedDataKey
+	return rewriteEntryPayload(entry, envelope, headers, payload)
+}
+
+// DecryptEntry rewrites an encrypted entry with the encrypted fields of its payload in the clear, and without its data key
+func (e *FieldEncryption) DecryptEntry(topic string, entry ms.Entry) (ms.Entry, error) {
+
+	envelope := decodeMessageEnvelope(entry)
+	wrappedDataKey := envelope.Headers[ENCRYPTION_KEY_HEADER]
+	if wrappedDataKey == "" {
+		return entry, nil
+	}
+	if !e.Enabled() {
+		return entry, fmt.Errorf("entry is encrypted with key '%s', but no keyring is loaded", wrappedDataKeyID(wrappedDataKey))
+	}
+	topicDefinition, ok := findTopicDefinition(topic)
+	if !ok {
+		return entry, fmt.Errorf("cannot decrypt entry of unknown topic '%s'", topic)
+	}
+
+	dataKey, err := e.Keyring.UnwrapDataKey(wrappedDataKey)
+	if err != nil {
+		return entry, err
+	}
+	payload := topicDefinition.NewPayload()
+	if err := envelope.DecodePayload(payload); err != nil {
+		return entry, fmt.Errorf("failed to decode payload to decrypt: %v", err)
+	}
+	err = transformEncryptedFields(reflect.ValueOf(payload), func(name, value string) (string, error) {
+		if !strings.HasPrefix(value, ENCRYPTED_FIELD_PREFIX) {
+			return value, nil
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, ENCRYPTED_FIELD_PREFIX))
+		if err != nil {
+			return "", fmt.Errorf("failed to decode encrypted field '%s': %v", name, err)
+		}
+		plaintext, err := openAESGCM(dataKey, ciphertext, []byte(name))
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt field '%s': %v", name, err)
+		}
+		return string(plaintext), nil
+	})
+	if err != nil {
+		return entry, err
+	}
+
+	headers := copyHeaders(envelope.Headers)
+	delete(headers, ENCRYPTION_KEY_HEADER)
+	return rewriteEntryPayload(entry, envelope, headers, payload)
+}
+
+// RewrapEntry rewraps an encrypted entry's data key with the keyring's primary key. the payload is left as it is
+func (e *FieldEncryption) RewrapEntry(entry ms.Entry) (ms.Entry, error) {
+
+	envelope := decodeMessageEnvelope(entry)
+	wrappedDataKey := envelope.Headers[ENCRYPTION_KEY_HEADER]
+	if wrappedDataKey == "" || wrappedDataKeyID(wrappedDataKey) == e.Keyring.Primary {
+		return entry, nil
+	}
+	dataKey, err := e.Keyring.UnwrapDataKey(wrappedDataKey)
+	if err != nil {
+		return entry, err
+	}
+	wrappedDataKey, err = e.Keyring.WrapDataKey(dataKey)
+	if err != nil {
+		return entry, err
+	}
+
+	envelope.Headers = copyHeaders(envelope.Headers)
+	envelope.Headers[ENCRYPTION_KEY_HEADER] = wrappedDataKey
+	envelopeJSON, err := json.Marshal(envelope)
+	if err != nil {
+		return entry, fmt.Errorf("failed to marshal message envelope: %v", err)
+	}
+	entry.Value = envelopeJSON
+	return entry, nil
+}
+
+// hasEncryptedFields reports whether a payload type has a field tagged encrypt:"true", at any depth
+func hasEncryptedFields(t reflect.Type) bool {
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return hasEncryptedFields(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if field.Tag.Get("encrypt") == "true" || hasEncryptedFields(field.Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transformEncryptedFields replaces the value of every non-empty string field tagged encrypt:"true", at any depth,
+// with the result of transform, which is given the field's JSON name so a ciphertext cannot be moved to another field
+func transformEncryptedFields(v reflect.Value, transform func(name, value string) (string, error)) error {
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			return transformEncryptedFields(v.Elem(), transform)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := transformEncryptedFields(v.Index(i), transform); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, ok := jsonFieldName(field)
+			if !ok {
+				continue
+			}
+			if field.Tag.Get("encrypt") != "true" {
+				if err := transformEncryptedFields(v.Field(i), transform); err != nil {
+					return err
+				}
+				continue
+			}
+			if field.Type.Kind() != reflect.String {
+				return fmt.Errorf("field '%s' is tagged encrypt, but is not a string", name)
+			}
+			if v.Field(i).String() == "" {
+				continue
+			}
+			value, err := transform(name, v.Field(i).String())
+			if err != nil {
+				return err
+			}
+			v.Field(i).SetString(value)
+		}
+	}
+	return nil
+}
+
+// copyHeaders returns a copy of an envelope's headers, so they can be changed without changing the envelope
+func copyHeaders(headers map[string]string) map[string]string {
+
+	copied := make(map[string]string)
+	for name, value := range headers {
+		copied[name] = value
+	}
+	return copied
+}
+
+// rewriteEntryPayload rewrites an entry with a payload encoded with the envelope's codec, uncompressed, and the given headers
+func rewriteEntryPayload(entry ms.Entry, envelope MessageEnvelope, headers map[string]string, payload interface{}) (ms.Entry, error) {
+
+	codec, err := envelope.Codec()
+	if err != nil {
+		return entry, err
+	}
+	data, err := codec.Marshal(payload)
+	if err != nil {
+		return entry, fmt.Errorf("failed to marshal %s payload: %v", codec.ContentType(), err)
+	}
+	if _, ok := codec.(JSONCodec); !ok {
+		// Embed the payload as a base64 string
+		data, _ = json.Marshal(data)
+	}
+	delete(headers, CONTENT_ENCODING_HEADER)
+	envelopeJSON, err := json.Marshal(NewMessageEnvelope(headers, data))
+	if err != nil {
+		return entry, fmt.Errorf("failed to marshal message envelope: %v", err)
+	}
+	entry.Value = envelopeJSON
+	return entry, nil
+}
+
+// EncryptingMessageStore encrypts the encrypted fields of each entry saved. consumers decrypt entries as they admit them
+type EncryptingMessageStore struct {
+	MockableMessageStore
+	Encryption *FieldEncryption
+}
+
+// NewEncryptingMessageStore creates a new instance of EncryptingMessageStore
+func NewEncryptingMessageStore(store MockableMessageStore, encryption *FieldEncryption) *EncryptingMessageStore {
+
+	return &EncryptingMessageStore{
+		MockableMessageStore: store,
+		Encryption:           encryption,
+	}
+}
+
+// SaveEntry encrypts an entry's fields, then saves it
+func (s *EncryptingMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	entry, err := s.Encryption.EncryptEntry(topic, entry)
+	if err != nil {
+		return 0, err
+	}
+	return s.MockableMessageStore.SaveEntry(topic, entry)
+}
+
+// SaveEntries encrypts every entry's fields, then saves them as one batch
+func (s *EncryptingMessageStore) SaveEntries(topic string, entries []ms.Entry) (int64, error) {
+
+	encrypted := make([]ms.Entry, 0, len(entries))
+	for i, entry := range entries {
+		entry, err := s.Encryption.EncryptEntry(topic, entry)
+		if err != nil {
+			return -1, fmt.Errorf("entry %d: %v", i, err)
+		}
+		encrypted = append(encrypted, entry)
+	}
+	return asBatchMessageStore(s.MockableMessageStore).SaveEntries(topic, encrypted)
+}
+
+// ReadEntries reads up to max entries
+func (s *EncryptingMessageStore) ReadEntries(topic string, offset int64, max int) ([]ms.Entry, error) {
+
+	return asBatchMessageStore(s.MockableMessageStore).ReadEntries(topic, offset, max)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// newEncryptionTestEntry returns an entry of a topic's sample payload, encoded with a codec
+func newEncryptionTestEntry(t *testing.T, topicDefinition TopicDefinition, codec Codec) (ms.Entry, interface{}) {
+
+	t.Helper()
+	sample := topicDefinition.NewPayload()
+	sampleCodecPayload(t, reflect.ValueOf(sample).Elem())
+	payload, err := codec.Marshal(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newMessageStoreEntry(context.Background(), topicDefinition.Name, "jbloggs", codec, payload), sample
+}
+
+// encryptedFieldValues returns the value of every non-empty encrypted field of a payload, keyed by the field's JSON name
+// and the field's position among the fields of that name
+func encryptedFieldValues(t *testing.T, payload interface{}) map[string]string {
+
+	t.Helper()
+	values := make(map[string]string)
+	err := transformEncryptedFields(reflect.ValueOf(payload), func(name, value string) (string, error) {
+		for i := 0; ; i++ {
+			key := name + "#" + string(rune('0'+i))
+			if _, ok := values[key]; !ok {
+				values[key] = value
+				return value, nil
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+// decodeTestEntry decodes an entry's payload into a new payload of its topic
+func decodeTestEntry(t *testing.T, topicDefinition TopicDefinition, entry ms.Entry) interface{} {
+
+	t.Helper()
+	payload := topicDefinition.NewPayload()
+	if err := decodeMessageEnvelope(entry).DecodePayload(payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// TestFieldEncryptionRoundTrip checks every encrypted field of every topic's payload is encrypted, in every codec,
+// and decrypts to its value in the clear, while other fields are left as they are
+func TestFieldEncryptionRoundTrip(t *testing.T) {
+
+	encryption := &FieldEncryption{Keyring: newTestKeyring(t)}
+	for _, topicDefinition := range topicDefinitions() {
+		for _, codec := range codecs() {
+			entry, sample := newEncryptionTestEntry(t, topicDefinition, codec)
+			encrypted, err := encryption.EncryptEntry(topicDefinition.Name, entry)
+			if err != nil {
+				t.Fatalf("%s %s: %v", topicDefinition.Name, codec.Name(), err)
+			}
+
+			inClear := encryptedFieldValues(t, sample)
+			if len(inClear) == 0 {
+				if string(encrypted.Value) != string(entry.Value) {
+					t.Errorf("%s %s: entry without encrypted fields was rewritten", topicDefinition.Name, codec.Name())
+				}
+				continue
+			}
+			if wrappedDataKeyID(decodeMessageEnvelope(encrypted).Headers[ENCRYPTION_KEY_HEADER]) != "k1" {
+				t.Errorf("%s %s: data key not wrapped by the primary key", topicDefinition.Name, codec.Name())
+			}
+			encryptedPayload := decodeTestEntry(t, topicDefinition, encrypted)
+			for key, value := range encryptedFieldValues(t, encryptedPayload) {
+				if !strings.HasPrefix(value, ENCRYPTED_FIELD_PREFIX) || value == inClear[key] {
+					t.Errorf("%s %s: field %s holds %q, want it encrypted", topicDefinition.Name, codec.Name(), key, value)
+				}
+			}
+			// blank the encrypted fields, so the rest of the payload can be compared with the sample
+			blank := func(name, value string) (string, error) { return "", nil }
+			blankedSample := decodeTestEntry(t, topicDefinition, entry)
+			if err := transformEncryptedFields(reflect.ValueOf(encryptedPayload), blank); err != nil {
+				t.Fatal(err)
+			}
+			if err := transformEncryptedFields(reflect.ValueOf(blankedSample), blank); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(encryptedPayload, blankedSample) {
+				t.Errorf("%s %s: fields which are not encrypted changed, %+v, want %+v", topicDefinition.Name, codec.Name(), encryptedPayload, blankedSample)
+			}
+
+			// encrypting an encrypted entry leaves it as it is
+			again, err := encryption.EncryptEntry(topicDefinition.Name, encrypted)
+			if err != nil || string(again.Value) != string(encrypted.Value) {
+				t.Errorf("%s %s: encrypted entry encrypted again, %v", topicDefinition.Name, codec.Name(), err)
+			}
+
+			decrypted, err := encryption.DecryptEntry(topicDefinition.Name, encrypted)
+			if err != nil {
+				t.Fatalf("%s %s: %v", topicDefinition.Name, codec.Name(), err)
+			}
+			if header, ok := decodeMessageEnvelope(decrypted).Headers[ENCRYPTION_KEY_HEADER]; ok {
+				t.Errorf("%s %s: decrypted entry still has its data key %q", topicDefinition.Name, codec.Name(), header)
+			}
+			if payload := decodeTestEntry(t, topicDefinition, decrypted); !reflect.DeepEqual(payload, sample) {
+				t.Errorf("%s %s: decrypted %+v, want %+v", topicDefinition.Name, codec.Name(), payload, sample)
+			}
+		}
+	}
+}
+
+// TestFieldEncryptionWithoutKeyring checks fields are left in the clear without a keyring, and an encrypted entry cannot then be read
+func TestFieldEncryptionWithoutKeyring(t *testing.T) {
+
+	topicDefinition, _ := findTopicDefinition(SYSTEM_AUDIT_EVENT_TOPIC)
+	entry, _ := newEncryptionTestEntry(t, topicDefinition, JSONCodec{})
+	encrypted, err := (&FieldEncryption{Keyring: newTestKeyring(t)}).EncryptEntry(SYSTEM_AUDIT_EVENT_TOPIC, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encryption := range []*FieldEncryption{nil, NewFieldEncryption()} {
+		cleartext, err := encryption.EncryptEntry(SYSTEM_AUDIT_EVENT_TOPIC, entry)
+		if err != nil || string(cleartext.Value) != string(entry.Value) {
+			t.Errorf("entry encrypted without a keyring, %v", err)
+		}
+		if _, err := encryption.DecryptEntry(SYSTEM_AUDIT_EVENT_TOPIC, encrypted); err == nil || !strings.Contains(err.Error(), "no keyring is loaded") {
+			t.Errorf("error %v, want no keyring is loaded", err)
+		}
+	}
+}
+
+// TestFieldEncryptionRejectsTampering checks an entry whose ciphertext was changed, moved to another field, or whose wrapped data key
+// was changed, is rejected rather than decrypted
+func TestFieldEncryptionRejectsTampering(t *testing.T) {
+
+	encryption := &FieldEncryption{Keyring: newTestKeyring(t)}
+	topicDefinition, _ := findTopicDefinition(SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC)
+	entry, _ := newEncryptionTestEntry(t, topicDefinition, JSONCodec{})
+	encrypted, err := encryption.EncryptEntry(SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := decodeMessageEnvelope(encrypted)
+
+	// tamper rewrites the encrypted entry with its payload changed
+	tamper := func(change func(response *SubjectRegionDocumentResponse)) ms.Entry {
+		response := decodeTestEntry(t, topicDefinition, encrypted).(*SubjectRegionDocumentResponse)
+		change(response)
+		tampered, err := rewriteEntryPayload(encrypted, envelope, copyHeaders(envelope.Headers), response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tampered
+	}
+	flipped := tamper(func(response *SubjectRegionDocumentResponse) {
+		ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(response.SubjectIdentifier, ENCRYPTED_FIELD_PREFIX))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext[len(ciphertext)-1] ^= 1
+		response.SubjectIdentifier = ENCRYPTED_FIELD_PREFIX + base64.StdEncoding.EncodeToString(ciphertext)
+	})
+	swapped := tamper(func(response *SubjectRegionDocumentResponse) {
+		response.SubjectIdentifier, response.Documents[0].DocumentIdentifier = response.Documents[0].DocumentIdentifier, response.SubjectIdentifier
+	})
+	garbled := tamper(func(response *SubjectRegionDocumentResponse) {
+		response.SubjectIdentifier = ENCRYPTED_FIELD_PREFIX + "!"
+	})
+
+	wrappedDataKey := envelope.Headers[ENCRYPTION_KEY_HEADER]
+	wrapped, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(wrappedDataKey, "k1:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped[0] ^= 1
+	headers := copyHeaders(envelope.Headers)
+	headers[ENCRYPTION_KEY_HEADER] = "k1:" + base64.StdEncoding.EncodeToString(wrapped)
+	tamperedKey, err := rewriteEntryPayload(encrypted, envelope, headers, decodeTestEntry(t, topicDefinition, encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		entry ms.Entry
+		err   string
+	}{
+		{"tampered ciphertext", flipped, "failed to decrypt field 'subjectIdentifier'"},
+		{"swapped ciphertexts", swapped, "failed to decrypt field"},
+		{"ciphertext not base64", garbled, "failed to decode encrypted field 'subjectIdentifier'"},
+		{"tampered wrapped key", tamperedKey, "failed to unwrap data key with key 'k1'"},
+	}
+	for _, test := range tests {
+		if _, err := encryption.DecryptEntry(SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC, test.entry); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+// TestFieldEncryptionRewrap checks an entry rewrapped after rotation is wrapped by the new primary key and decrypts once the old key is
+// removed, while an entry which was not rewrapped fails with the removed key named
+func TestFieldEncryptionRewrap(t *testing.T) {
+
+	encryption := &FieldEncryption{Keyring: newTestKeyring(t)}
+	topicDefinition, _ := findTopicDefinition(SUBJECT_DOCUMENTS_TOPIC)
+	entry, sample := newEncryptionTestEntry(t, topicDefinition, ProtobufCodec{})
+	encrypted, err := encryption.EncryptEntry(SUBJECT_DOCUMENTS_TOPIC, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unchanged, err := encryption.RewrapEntry(encrypted)
+	if err != nil || string(unchanged.Value) != string(encrypted.Value) {
+		t.Errorf("entry already wrapped by the primary key was rewrapped, %v", err)
+	}
+
+	if _, err := encryption.Keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, err := encryption.RewrapEntry(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := wrappedDataKeyID(decodeMessageEnvelope(rewrapped).Headers[ENCRYPTION_KEY_HEADER]); id != "k2" {
+		t.Errorf("rewrapped with key %q, want the new primary key k2", id)
+	}
+	if err := encryption.Keyring.Remove("k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := encryption.DecryptEntry(SUBJECT_DOCUMENTS_TOPIC, rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload := decodeTestEntry(t, topicDefinition, decrypted); !reflect.DeepEqual(payload, sample) {
+		t.Errorf("decrypted %+v, want %+v", payload, sample)
+	}
+
+	if _, err := encryption.DecryptEntry(SUBJECT_DOCUMENTS_TOPIC, encrypted); err == nil || !strings.Contains(err.Error(), "keyring has no key 'k1'") {
+		t.Errorf("error %v, want the removed key named", err)
+	}
+	if _, err := encryption.RewrapEntry(encrypted); err == nil || !strings.Contains(err.Error(), "keyring has no key 'k1'") {
+		t.Errorf("rewrap error %v, want the removed key named", err)
+	}
+}
+
+// TestEncryptingMessageStore checks entries saved singly and in batches are encrypted
+func TestEncryptingMessageStore(t *testing.T) {
+
+	store := NewEncryptingMessageStore(newMemoryMessageStore(), &FieldEncryption{Keyring: newTestKeyring(t)})
+	topicDefinition, _ := findTopicDefinition(SYSTEM_AUDIT_EVENT_TOPIC)
+	entry, _ := newEncryptionTestEntry(t, topicDefinition, MessagePackCodec{})
+	if _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SaveEntries(SYSTEM_AUDIT_EVENT_TOPIC, []ms.Entry{entry}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := store.ReadEntries(SYSTEM_AUDIT_EVENT_TOPIC, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(entries))
+	}
+	for i, saved := range entries {
+		if decodeMessageEnvelope(saved).Headers[ENCRYPTION_KEY_HEADER] == "" {
+			t.Errorf("entry %d saved without a data key", i)
+		}
+		systemAuditEvent := decodeTestEntry(t, topicDefinition, saved).(*SystemAuditEvent)
+		if !strings.HasPrefix(systemAuditEvent.SubjectIdentifier, ENCRYPTED_FIELD_PREFIX) {
+			t.Errorf("entry %d: subject identifier %q saved in the clear", i, systemAuditEvent.SubjectIdentifier)
+		}
+	}
+}
@@ -2,4 +2,7 @@ module github.com/mmcnicol/message-store-demo-embedded
 
 go 1.20
 
-require github.com/mmcnicol/message-store v0.0.3 // indirect
+require (
+	github.com/klauspost/compress v1.16.7
+	github.com/mmcnicol/message-store v0.0.3
+)
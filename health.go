@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Define constants for consumer states
+const (
+	CONSUMER_STATE_RUNNING = "running"
+	CONSUMER_STATE_STALLED = "stalled"
+	CONSUMER_STATE_STOPPED = "stopped"
+	CONSUMER_STATE_DEAD    = "dead"
+)
+
+// Define the structure of ConsumerHealth
+type ConsumerHealth struct {
+	Name          string    `json:"name"`
+	Topic         string    `json:"topic"`
+	State         string    `json:"state"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	Offset        int64     `json:"offset"`
+	Crashes       int       `json:"crashes"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Define the structure of StoreHealth
+type StoreHealth struct {
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Define the structure of HealthReport
+type HealthReport struct {
+	Status    string           `json:"status"`
+	Consumers []ConsumerHealth `json:"consumers"`
+	Store     *StoreHealth     `json:"store,omitempty"`
+}
+
+// HealthMonitor tracks consumer heartbeats and checks the message store is reachable
+type HealthMonitor struct {
+	mu             sync.Mutex
+	consumers      map[string]*ConsumerHealth
+	StallThreshold time.Duration
+	CheckTimeout   time.Duration
+}
+
+// NewHealthMonitor creates a new instance of HealthMonitor
+func NewHealthMonitor(stallThreshold time.Duration) *HealthMonitor {
+
+	return &HealthMonitor{
+		consumers:      make(map[string]*ConsumerHealth),
+		StallThreshold: stallThreshold,
+		CheckTimeout:   2 * time.Second,
+	}
+}
+
+// RegisterConsumer records that a consumer has started polling a topic
+func (h *HealthMonitor) RegisterConsumer(name, topic string) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var crashes int
+	if consumer, ok := h.consumers[name]; ok {
+		crashes = consumer.Crashes
+	}
+	h.consumers[name] = &ConsumerHealth{
+		Name:          name,
+		Topic:         topic,
+		State:         CONSUMER_STATE_RUNNING,
+		LastHeartbeat: time.Now(),
+		Offset:        -1,
+		Crashes:       crashes,
+	}
+}
+
+// Heartbeat records that a consumer is still making progress
+func (h *HealthMonitor) Heartbeat(name string, offset int64) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if consumer, ok := h.consumers[name]; ok {
+		consumer.LastHeartbeat = time.Now()
+		consumer.Offset = offset
+	}
+}
+
+// ConsumerStopped records that a consumer returned because it was asked to
+func (h *HealthMonitor) ConsumerStopped(name string) {
+
+	h.setConsumerState(name, CONSUMER_STATE_STOPPED, "")
+}
+
+// ConsumerDead records that a consumer returned unexpectedly
+func (h *HealthMonitor) ConsumerDead(name, reason string) {
+
+	h.setConsumerState(name, CONSUMER_STATE_DEAD, reason)
+}
+
+// RecordCrash counts a crash of a consumer
+func (h *HealthMonitor) RecordCrash(name string) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if consumer, ok := h.consumers[name]; ok {
+		consumer.Crashes++
+	}
+}
+
+// setConsumerState sets the state of a consumer
+func (h *HealthMonitor) setConsumerState(name, state, reason string) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if consumer, ok := h.consumers[name]; ok {
+		consumer.State = state
+		consumer.Error = reason
+	}
+}
+
+// Consumers returns the health of every registered consumer, flagging running consumers whose heartbeat is overdue as stalled
+func (h *HealthMonitor) Consumers() []ConsumerHealth {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	consumers := make([]ConsumerHealth, 0, len(h.consumers))
+	for _, consumer := range h.consumers {
+		c := *consumer
+		if c.State == CONSUMER_STATE_RUNNING && time.Since(c.LastHeartbeat) > h.StallThreshold {
+			c.State = CONSUMER_STATE_STALLED
+		}
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool { return consumers[i].Name < consumers[j].Name })
+	return consumers
+}
+
+// CheckStore checks that the index of every consumed topic can be read
+func (h *HealthMonitor) CheckStore(store MockableMessageStore) StoreHealth {
+
+	var topics []string
+	for _, consumer := range h.Consumers() {
+		topics = append(topics, consumer.Topic)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		for _, topic := range topics {
+			// polling beyond the end of the topic reads its index without reading an entry
+			if _, err := store.PollForNextEntry(topic, math.MaxInt64, 0); err != nil {
+				result <- fmt.Errorf("topic '%s': %v", topic, err)
+				return
+			}
+		}
+		result <- nil
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return StoreHealth{Reachable: false, Error: err.Error()}
+		}
+		return StoreHealth{Reachable: true}
+	case <-time.After(h.CheckTimeout):
+		return StoreHealth{Reachable: false, Error: "timed out"}
+	}
+}
+
+// Liveness reports unhealthy when any consumer is dead or stalled
+func (h *HealthMonitor) Liveness() HealthReport {
+
+	report := HealthReport{Status: "ok", Consumers: h.Consumers()}
+	for _, consumer := range report.Consumers {
+		if consumer.State == CONSUMER_STATE_DEAD || consumer.State == CONSUMER_STATE_STALLED {
+			report.Status = "unhealthy"
+		}
+	}
+	return report
+}
+
+// Readiness reports unready unless every consumer is running and the message store is reachable
+func (h *HealthMonitor) Readiness(store MockableMessageStore) HealthReport {
+
+	storeHealth := h.CheckStore(store)
+	report := HealthReport{Status: "ok", Consumers: h.Consumers(), Store: &storeHealth}
+	if len(report.Consumers) == 0 || !storeHealth.Reachable {
+		report.Status = "unready"
+	}
+	for _, consumer := range report.Consumers {
+		if consumer.State != CONSUMER_STATE_RUNNING {
+			report.Status = "unready"
+		}
+	}
+	return report
+}
+
+// NewHealthHandler creates an http handler serving /healthz and /readyz for the backend
+func NewHealthHandler(b *Backend) http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, b.Health.Liveness(), b.Log)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, b.Health.Readiness(b.MessageStore), b.Log)
+	})
+	return mux
+}
+
+// writeHealthReport writes a health report as JSON, with status 503 unless it is ok, logging a failure to write it
+func writeHealthReport(w http.ResponseWriter, report HealthReport, log io.Writer) {
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		fmt.Fprintf(log, "failed to write health report: %v\n", err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// unreachableMessageStore fails every poll, or blocks until released, as a store whose disk has gone away might
+type unreachableMessageStore struct {
+	*memoryMessageStore
+	block chan struct{}
+}
+
+// PollForNextEntry fails, or blocks until the store is released
+func (s *unreachableMessageStore) PollForNextEntry(topic string, offset int64, pollDuration time.Duration) (*ms.Entry, error) {
+
+	if s.block != nil {
+		<-s.block
+	}
+	return nil, fmt.Errorf("index unreadable")
+}
+
+// findConsumerHealth returns the health of a consumer, failing the test when it is not registered
+func findConsumerHealth(t *testing.T, h *HealthMonitor, name string) ConsumerHealth {
+
+	t.Helper()
+	for _, consumer := range h.Consumers() {
+		if consumer.Name == name {
+			return consumer
+		}
+	}
+	t.Fatalf("consumer '%s' is not registered", name)
+	return ConsumerHealth{}
+}
+
+// TestHealthMonitorStall checks a running consumer is flagged stalled once its heartbeat is overdue, and running again after a heartbeat
+func TestHealthMonitorStall(t *testing.T) {
+
+	h := NewHealthMonitor(20 * time.Millisecond)
+	h.RegisterConsumer(SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC)
+	if consumer := findConsumerHealth(t, h, SYSTEM_AUDIT_EVENT_CONSUMER); consumer.State != CONSUMER_STATE_RUNNING || consumer.Offset != -1 {
+		t.Errorf("state %q, offset %d, want running at offset -1", consumer.State, consumer.Offset)
+	}
+	if report := h.Liveness(); report.Status != "ok" {
+		t.Errorf("liveness %q, want ok", report.Status)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if consumer := findConsumerHealth(t, h, SYSTEM_AUDIT_EVENT_CONSUMER); consumer.State != CONSUMER_STATE_STALLED {
+		t.Errorf("state %q, want stalled", consumer.State)
+	}
+	if report := h.Liveness(); report.Status != "unhealthy" {
+		t.Errorf("liveness %q, want unhealthy", report.Status)
+	}
+
+	h.Heartbeat(SYSTEM_AUDIT_EVENT_CONSUMER, 7)
+	if consumer := findConsumerHealth(t, h, SYSTEM_AUDIT_EVENT_CONSUMER); consumer.State != CONSUMER_STATE_RUNNING || consumer.Offset != 7 {
+		t.Errorf("state %q, offset %d, want running at offset 7", consumer.State, consumer.Offset)
+	}
+}
+
+// TestHealthMonitorDeadAndStopped checks a dead consumer fails liveness and readiness, and a stopped one only readiness
+func TestHealthMonitorDeadAndStopped(t *testing.T) {
+
+	store := newMemoryMessageStore()
+	tests := []struct {
+		name      string
+		stop      func(h *HealthMonitor)
+		state     string
+		liveness  string
+		readiness string
+	}{
+		{"running", func(h *HealthMonitor) {}, CONSUMER_STATE_RUNNING, "ok", "ok"},
+		{"dead", func(h *HealthMonitor) { h.ConsumerDead(SYSTEM_AUDIT_EVENT_CONSUMER, "poll loop returned") }, CONSUMER_STATE_DEAD, "unhealthy", "unready"},
+		{"stopped", func(h *HealthMonitor) { h.ConsumerStopped(SYSTEM_AUDIT_EVENT_CONSUMER) }, CONSUMER_STATE_STOPPED, "ok", "unready"},
+	}
+	for _, test := range tests {
+		h := NewHealthMonitor(time.Minute)
+		h.RegisterConsumer(SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC)
+		test.stop(h)
+		if consumer := findConsumerHealth(t, h, SYSTEM_AUDIT_EVENT_CONSUMER); consumer.State != test.state {
+			t.Errorf("%s: state %q, want %q", test.name, consumer.State, test.state)
+		}
+		if report := h.Liveness(); report.Status != test.liveness {
+			t.Errorf("%s: liveness %q, want %q", test.name, report.Status, test.liveness)
+		}
+		if report := h.Readiness(store); report.Status != test.readiness {
+			t.Errorf("%s: readiness %q, want %q", test.name, report.Status, test.readiness)
+		}
+	}
+
+	h := NewHealthMonitor(time.Minute)
+	h.RegisterConsumer(SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC)
+	h.ConsumerDead(SYSTEM_AUDIT_EVENT_CONSUMER, "panic: boom")
+	if consumer := findConsumerHealth(t, h, SYSTEM_AUDIT_EVENT_CONSUMER); consumer.Error != "panic: boom" {
+		t.Errorf("error %q, want the reason it died", consumer.Error)
+	}
+}
+
+// TestHealthMonitorCrashesSurviveRestart checks a consumer's crashes are still counted once it registers again on restart
+func TestHealthMonitorCrashesSurviveRestart(t *testing.T) {
+
+	h := NewHealthMonitor(time.Minute)
+	h.RegisterConsumer(SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC)
+	h.RecordCrash(SYSTEM_AUDIT_EVENT_CONSUMER)
+	h.ConsumerDead(SYSTEM_AUDIT_EVENT_CONSUMER, "panic: boom")
+	h.RecordCrash(SYSTEM_AUDIT_EVENT_CONSUMER)
+	h.RegisterConsumer(SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC)
+
+	consumer := findConsumerHealth(t, h, SYSTEM_AUDIT_EVENT_CONSUMER)
+	if consumer.Crashes != 2 || consumer.State != CONSUMER_STATE_RUNNING || consumer.Error != "" {
+		t.Errorf("crashes %d, state %q, error %q, want 2 crashes, running without error", consumer.Crashes, consumer.State, consumer.Error)
+	}
+}
+
+// TestHealthMonitorReadinessStore checks readiness needs a consumer and a store whose topics can be read within the check timeout
+func TestHealthMonitorReadinessStore(t *testing.T) {
+
+	h := NewHealthMonitor(time.Minute)
+	if report := h.Readiness(newMemoryMessageStore()); report.Status != "unready" {
+		t.Errorf("readiness without consumers %q, want unready", report.Status)
+	}
+	h.RegisterConsumer(SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC)
+
+	report := h.Readiness(&unreachableMessageStore{memoryMessageStore: newMemoryMessageStore()})
+	if report.Status != "unready" || report.Store.Reachable || report.Store.Error == "" {
+		t.Errorf("readiness %q, store %+v, want unready with the store unreachable", report.Status, *report.Store)
+	}
+
+	h.CheckTimeout = 20 * time.Millisecond
+	blocked := &unreachableMessageStore{memoryMessageStore: newMemoryMessageStore(), block: make(chan struct{})}
+	defer close(blocked.block)
+	if storeHealth := h.CheckStore(blocked); storeHealth.Reachable || storeHealth.Error != "timed out" {
+		t.Errorf("store %+v, want timed out", storeHealth)
+	}
+}
+
+// TestHealthHandler checks the endpoints answer 200 when ok and 503 otherwise
+func TestHealthHandler(t *testing.T) {
+
+	b := NewBackend()
+	b.MessageStore = newMemoryMessageStore()
+	b.Health = NewHealthMonitor(time.Minute)
+	b.Health.RegisterConsumer(SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC)
+	handler := NewHealthHandler(b)
+
+	get := func(path string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz %d, want 200", code)
+	}
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz %d, want 200", code)
+	}
+
+	b.Health.ConsumerDead(SYSTEM_AUDIT_EVENT_CONSUMER, "poll loop returned")
+	if code := get("/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz %d, want 503", code)
+	}
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz %d, want 503", code)
+	}
+}
+
+// TestPollTopicPanicMarksConsumerDead checks a consumer whose handler panics is reported dead, with the offending entry committed so a restart skips it
+func TestPollTopicPanicMarksConsumerDead(t *testing.T) {
+
+	b := NewBackend()
+	store := newMemoryMessageStore()
+	b.MessageStore = store
+	b.sendSystemAuditEvent(context.Background(), *NewSystemAuditEvent("jbloggs", "login attempt"))
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v, want the handler's panic to be re-raised", r)
+			}
+		}()
+		b.pollTopic(context.Background(), SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC, func(ctx context.Context, entry ms.Entry) {
+			panic("boom")
+		})
+	}()
+
+	consumer := findConsumerHealth(t, b.Health, SYSTEM_AUDIT_EVENT_CONSUMER)
+	if consumer.State != CONSUMER_STATE_DEAD || consumer.Error != "boom" {
+		t.Errorf("state %q, error %q, want dead with the panic as its error", consumer.State, consumer.Error)
+	}
+	if offset := b.Offsets.Get(SYSTEM_AUDIT_EVENT_CONSUMER); offset != 0 {
+		t.Errorf("committed offset %d, want 0, so the entry which panicked is skipped", offset)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Define the JSON Schema dialect generated schemas declare
+const JSON_SCHEMA_DIALECT = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchemaType is a JSON Schema type keyword, which is written as a string when it holds one type and as an array otherwise
+type JSONSchemaType []string
+
+// MarshalJSON writes a single type as a string
+func (t JSONSchemaType) MarshalJSON() ([]byte, error) {
+
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// UnmarshalJSON reads a type written as a string or as an array
+func (t *JSONSchemaType) UnmarshalJSON(data []byte) error {
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = JSONSchemaType{single}
+		return nil
+	}
+	var types []string
+	if err := json.Unmarshal(data, &types); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings: %v", err)
+	}
+	*t = types
+	return nil
+}
+
+// has reports whether the type keyword allows a type
+func (t JSONSchemaType) has(name string) bool {
+
+	for _, typeName := range t {
+		if typeName == name || (typeName == "number" && name == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// Define the structure of JSONSchema, the subset of JSON Schema needed to describe topic payloads
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 JSONSchemaType         `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+}
+
+// timeType is the reflected type of time.Time, which is marshalled as an RFC 3339 string
+var timeType = reflect.TypeOf(time.Time{})
+
+// generateJSONSchema generates the schema of the JSON encoding/json produces for a Go type
+func generateJSONSchema(t reflect.Type) *JSONSchema {
+
+	switch {
+	case t == timeType:
+		return &JSONSchema{Type: JSONSchemaType{"string"}, Format: "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := generateJSONSchema(t.Elem())
+		if len(schema.Type) > 0 {
+			schema.Type = append(schema.Type, "null")
+		}
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		// an interface, e.g. error, can hold anything
+		return &JSONSchema{}
+	case reflect.Struct:
+		additionalProperties := false
+		schema := &JSONSchema{
+			Type:                 JSONSchemaType{"object"},
+			Properties:           make(map[string]*JSONSchema),
+			AdditionalProperties: &additionalProperties,
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, omitEmpty, ok := jsonFieldName(field)
+			if !ok {
+				continue
+			}
+			schema.Properties[name] = generateJSONSchema(field.Type)
+			if !omitEmpty {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		return schema
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// byte slices are marshalled as base64 strings
+			return &JSONSchema{Type: JSONSchemaType{"string", "null"}}
+		}
+		return &JSONSchema{Type: JSONSchemaType{"array", "null"}, Items: generateJSONSchema(t.Elem())}
+	case reflect.Array:
+		return &JSONSchema{Type: JSONSchemaType{"array"}, Items: generateJSONSchema(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: JSONSchemaType{"object", "null"}}
+	case reflect.String:
+		return &JSONSchema{Type: JSONSchemaType{"string"}}
+	case reflect.Bool:
+		return &JSONSchema{Type: JSONSchemaType{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: JSONSchemaType{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: JSONSchemaType{"number"}}
+	}
+	return &JSONSchema{}
+}
+
+// jsonFieldName returns the name encoding/json gives a struct field, and whether it is omitted when empty
+func jsonFieldName(field reflect.StructField) (string, bool, bool) {
+
+	if field.PkgPath != "" {
+		// unexported fields are not marshalled
+		return "", false, false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	omitEmpty := false
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, true
+}
+
+// ValidateJSON checks a JSON document against the schema, returning every violation
+func (s *JSONSchema) ValidateJSON(data []byte) error {
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	violations := s.validate("$", value)
+	if len(violations) > 0 {
+		return fmt.Errorf("%s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// validate checks a decoded JSON value against the schema
+func (s *JSONSchema) validate(path string, value interface{}) []string {
+
+	if len(s.Type) > 0 && !s.Type.has(jsonTypeOf(value)) {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(s.Type, " or "), jsonTypeOf(value))}
+	}
+
+	var violations []string
+	switch v := value.(type) {
+	case string:
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				violations = append(violations, fmt.Sprintf("%s: not an RFC 3339 date-time", path))
+			}
+		}
+		if len(s.Enum) > 0 && !containsString(s.Enum, v) {
+			violations = append(violations, fmt.Sprintf("%s: '%s' is not one of %s", path, v, strings.Join(s.Enum, ", ")))
+		}
+		if s.Pattern != "" {
+			matched, err := regexp.MatchString(s.Pattern, v)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("%s: invalid pattern '%s': %v", path, s.Pattern, err))
+			} else if !matched {
+				violations = append(violations, fmt.Sprintf("%s: does not match pattern '%s'", path, s.Pattern))
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				violations = append(violations, fmt.Sprintf("%s: missing required property '%s'", path, name))
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					violations = append(violations, fmt.Sprintf("%s: unknown property '%s'", path, name))
+				}
+				continue
+			}
+			violations = append(violations, property.validate(path+"."+name, v[name])...)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				violations = append(violations, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	}
+	return violations
+}
+
+// jsonTypeOf returns the JSON Schema type of a value decoded with UseNumber
+func jsonTypeOf(value interface{}) string {
+
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "unknown"
+}
+
+// Define the structure of SchemaChange
+type SchemaChange struct {
+	Path     string `json:"path"`
+	Change   string `json:"change"`
+	Breaking bool   `json:"breaking"`
+}
+
+// diffJSONSchemas lists the changes from one schema to another. a change is breaking when a document
+// valid against the old schema can be invalid against the new one, so consumers of the new version cannot read old entries
+func diffJSONSchemas(path string, from, to *JSONSchema) []SchemaChange {
+
+	var changes []SchemaChange
+
+	for _, typeName := range from.Type {
+		if !to.Type.has(typeName) && len(to.Type) > 0 {
+			changes = append(changes, SchemaChange{path, fmt.Sprintf("type '%s' no longer allowed", typeName), true})
+		}
+	}
+	if len(from.Type) == 0 && len(to.Type) > 0 {
+		changes = append(changes, SchemaChange{path, fmt.Sprintf("type restricted to %s", strings.Join(to.Type, " or ")), true})
+	}
+	for _, typeName := range to.Type {
+		if !from.Type.has(typeName) && len(from.Type) > 0 {
+			changes = append(changes, SchemaChange{path, fmt.Sprintf("type '%s' now allowed", typeName), false})
+		}
+	}
+	if from.Format != to.Format {
+		changes = append(changes, SchemaChange{path, fmt.Sprintf("format changed from '%s' to '%s'", from.Format, to.Format), to.Format != ""})
+	}
+	for _, value := range from.Enum {
+		if !containsString(to.Enum, value) && len(to.Enum) > 0 {
+			changes = append(changes, SchemaChange{path, fmt.Sprintf("value '%s' no longer allowed", value), true})
+		}
+	}
+	if len(from.Enum) == 0 && len(to.Enum) > 0 {
+		changes = append(changes, SchemaChange{path, fmt.Sprintf("values restricted to %s", strings.Join(to.Enum, ", ")), true})
+	}
+	for _, value := range to.Enum {
+		if !containsString(from.Enum, value) && len(from.Enum) > 0 {
+			changes = append(changes, SchemaChange{path, fmt.Sprintf("value '%s' now allowed", value), false})
+		}
+	}
+	if from.Pattern != to.Pattern {
+		changes = append(changes, SchemaChange{path, fmt.Sprintf("pattern changed from '%s' to '%s'", from.Pattern, to.Pattern), to.Pattern != ""})
+	}
+
+	names := make(map[string]bool)
+	for name := range from.Properties {
+		names[name] = true
+	}
+	for name := range to.Properties {
+		names[name] = true
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	toClosed := to.AdditionalProperties != nil && !*to.AdditionalProperties
+	for _, name := range sortedNames {
+		propertyPath := path + "." + name
+		fromProperty, inFrom := from.Properties[name]
+		toProperty, inTo := to.Properties[name]
+		switch {
+		case inFrom && !inTo:
+			changes = append(changes, SchemaChange{propertyPath, "property removed", toClosed})
+		case !inFrom && inTo:
+			if containsString(to.Required, name) {
+				changes = append(changes, SchemaChange{propertyPath, "required property added", true})
+			} else {
+				changes = append(changes, SchemaChange{propertyPath, "optional property added", false})
+			}
+		default:
+			fromRequired, toRequired := containsString(from.Required, name), containsString(to.Required, name)
+			if !fromRequired && toRequired {
+				changes = append(changes, SchemaChange{propertyPath, "property became required", true})
+			}
+			if fromRequired && !toRequired {
+				changes = append(changes, SchemaChange{propertyPath, "property became optional", false})
+			}
+			changes = append(changes, diffJSONSchemas(propertyPath, fromProperty, toProperty)...)
+		}
+	}
+
+	fromClosed := from.AdditionalProperties != nil && !*from.AdditionalProperties
+	if !fromClosed && toClosed {
+		changes = append(changes, SchemaChange{path, "unknown properties no longer allowed", true})
+	}
+	if fromClosed && !toClosed {
+		changes = append(changes, SchemaChange{path, "unknown properties now allowed", false})
+	}
+
+	switch {
+	case from.Items != nil && to.Items != nil:
+		changes = append(changes, diffJSONSchemas(path+"[]", from.Items, to.Items)...)
+	case from.Items == nil && to.Items != nil:
+		changes = append(changes, SchemaChange{path + "[]", "items restricted", true})
+	}
+	return changes
+}
+
+// containsString reports whether a slice contains a string
+func containsString(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// parseTestJSONSchema parses a schema written as JSON, failing the test when it cannot be
+func parseTestJSONSchema(t *testing.T, data string) *JSONSchema {
+
+	t.Helper()
+	var schema JSONSchema
+	if err := json.Unmarshal([]byte(data), &schema); err != nil {
+		t.Fatal(err)
+	}
+	return &schema
+}
+
+// TestValidateJSON checks documents are validated against their types, required and unknown properties, enums, patterns and
+// formats, down through nested arrays and objects, with every violation reported by its path
+func TestValidateJSON(t *testing.T) {
+
+	schema := parseTestJSONSchema(t, `{
+		"type": "object",
+		"properties": {
+			"userName": {"type": "string", "pattern": "^[a-z]+$"},
+			"region": {"type": "integer"},
+			"score": {"type": "number"},
+			"outcome": {"type": "boolean"},
+			"status": {"type": "string", "enum": ["open", "closed"]},
+			"at": {"type": "string", "format": "date-time"},
+			"note": {"type": ["string", "null"]},
+			"documents": {
+				"type": "array",
+				"items": {
+					"type": "object",
+					"properties": {"id": {"type": "string"}, "tags": {"type": "array", "items": {"type": "string"}}},
+					"required": ["id"],
+					"additionalProperties": false
+				}
+			}
+		},
+		"required": ["userName", "region"],
+		"additionalProperties": false
+	}`)
+
+	tests := []struct {
+		name     string
+		document string
+		err      string
+	}{
+		{"required only", `{"userName": "jbloggs", "region": 1}`, ""},
+		{"every property", `{"userName": "jbloggs", "region": 1, "score": 0.5, "outcome": true, "status": "open", "at": "2026-03-01T09:00:00Z",
+			"note": "first", "documents": [{"id": "d1", "tags": ["a"]}, {"id": "d2"}]}`, ""},
+		{"null allowed", `{"userName": "jbloggs", "region": 1, "note": null}`, ""},
+		{"integer is a number", `{"userName": "jbloggs", "region": 1, "score": 2}`, ""},
+		{"invalid JSON", `{"userName": `, "invalid JSON"},
+		{"not an object", `["jbloggs"]`, "$: expected object, got array"},
+		{"wrong type", `{"userName": 5, "region": 1}`, "$.userName: expected string, got integer"},
+		{"number not an integer", `{"userName": "jbloggs", "region": 1.5}`, "$.region: expected integer, got number"},
+		{"null not allowed", `{"userName": "jbloggs", "region": 1, "outcome": null}`, "$.outcome: expected boolean, got null"},
+		{"missing required", `{"userName": "jbloggs"}`, "$: missing required property 'region'"},
+		{"unknown property", `{"userName": "jbloggs", "region": 1, "password": "secret"}`, "$: unknown property 'password'"},
+		{"not in enum", `{"userName": "jbloggs", "region": 1, "status": "pending"}`, "$.status: 'pending' is not one of open, closed"},
+		{"not matching pattern", `{"userName": "JBloggs", "region": 1}`, "$.userName: does not match pattern '^[a-z]+$'"},
+		{"not a date-time", `{"userName": "jbloggs", "region": 1, "at": "1 March 2026"}`, "$.at: not an RFC 3339 date-time"},
+		{"nested missing required", `{"userName": "jbloggs", "region": 1, "documents": [{"id": "d1"}, {}]}`, "$.documents[1]: missing required property 'id'"},
+		{"nested unknown property", `{"userName": "jbloggs", "region": 1, "documents": [{"id": "d1", "size": 3}]}`, "$.documents[0]: unknown property 'size'"},
+		{"nested wrong type", `{"userName": "jbloggs", "region": 1, "documents": [{"id": "d1", "tags": ["a", 2]}]}`, "$.documents[0].tags[1]: expected string, got integer"},
+		{"every violation", `{"userName": 5, "status": "pending"}`, "$: missing required property 'region'; $.status: 'pending' is not one of open, closed; $.userName: expected string, got integer"},
+	}
+	for _, test := range tests {
+		err := schema.ValidateJSON([]byte(test.document))
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	invalidPattern := parseTestJSONSchema(t, `{"type": "string", "pattern": "("}`)
+	if err := invalidPattern.ValidateJSON([]byte(`"a"`)); err == nil || !strings.Contains(err.Error(), "invalid pattern") {
+		t.Errorf("error %v, want the invalid pattern reported", err)
+	}
+}
+
+// TestDiffJSONSchemas checks each change between schema versions is found at its path, and classified as breaking when documents
+// valid against the old version can be invalid against the new one
+func TestDiffJSONSchemas(t *testing.T) {
+
+	const base = `{"type": "object", "properties": {"userName": {"type": "string"}, "note": {"type": ["string", "null"]}},
+		"required": ["userName"], "additionalProperties": false}`
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		changes []SchemaChange
+	}{
+		{"unchanged", base, base, nil},
+		{"optional field added", base, `{"type": "object", "properties": {"userName": {"type": "string"}, "note": {"type": ["string", "null"]},
+			"region": {"type": "integer"}}, "required": ["userName"], "additionalProperties": false}`,
+			[]SchemaChange{{"$.region", "optional property added", false}}},
+		{"required field added", base, `{"type": "object", "properties": {"userName": {"type": "string"}, "note": {"type": ["string", "null"]},
+			"region": {"type": "integer"}}, "required": ["userName", "region"], "additionalProperties": false}`,
+			[]SchemaChange{{"$.region", "required property added", true}}},
+		{"field removed", base, `{"type": "object", "properties": {"userName": {"type": "string"}}, "required": ["userName"], "additionalProperties": false}`,
+			[]SchemaChange{{"$.note", "property removed", true}}},
+		{"field removed, unknown properties allowed", base, `{"type": "object", "properties": {"userName": {"type": "string"}}, "required": ["userName"]}`,
+			[]SchemaChange{{"$.note", "property removed", false}, {"$", "unknown properties now allowed", false}}},
+		{"type narrowed", base, `{"type": "object", "properties": {"userName": {"type": "string"}, "note": {"type": "string"}},
+			"required": ["userName"], "additionalProperties": false}`,
+			[]SchemaChange{{"$.note", "type 'null' no longer allowed", true}}},
+		{"type widened", base, `{"type": "object", "properties": {"userName": {"type": ["string", "null"]}, "note": {"type": ["string", "null"]}},
+			"required": ["userName"], "additionalProperties": false}`,
+			[]SchemaChange{{"$.userName", "type 'null' now allowed", false}}},
+		{"required added", base, `{"type": "object", "properties": {"userName": {"type": "string"}, "note": {"type": ["string", "null"]}},
+			"required": ["userName", "note"], "additionalProperties": false}`,
+			[]SchemaChange{{"$.note", "property became required", true}}},
+		{"required removed", base, `{"type": "object", "properties": {"userName": {"type": "string"}, "note": {"type": ["string", "null"]}},
+			"additionalProperties": false}`,
+			[]SchemaChange{{"$.userName", "property became optional", false}}},
+		{"enum added", `{"type": "string"}`, `{"type": "string", "enum": ["open", "closed"]}`,
+			[]SchemaChange{{"$", "values restricted to open, closed", true}}},
+		{"enum narrowed", `{"type": "string", "enum": ["open", "closed"]}`, `{"type": "string", "enum": ["open"]}`,
+			[]SchemaChange{{"$", "value 'closed' no longer allowed", true}}},
+		{"enum widened", `{"type": "string", "enum": ["open"]}`, `{"type": "string", "enum": ["open", "closed"]}`,
+			[]SchemaChange{{"$", "value 'closed' now allowed", false}}},
+		{"pattern added", `{"type": "string"}`, `{"type": "string", "pattern": "^[a-z]+$"}`,
+			[]SchemaChange{{"$", "pattern changed from '' to '^[a-z]+$'", true}}},
+		{"pattern removed", `{"type": "string", "pattern": "^[a-z]+$"}`, `{"type": "string"}`,
+			[]SchemaChange{{"$", "pattern changed from '^[a-z]+$' to ''", false}}},
+		{"nested item narrowed", `{"type": "array", "items": {"type": "object", "properties": {"id": {"type": ["string", "integer"]}}}}`,
+			`{"type": "array", "items": {"type": "object", "properties": {"id": {"type": "string"}}}}`,
+			[]SchemaChange{{"$[].id", "type 'integer' no longer allowed", true}}},
+		{"items restricted", `{"type": "array"}`, `{"type": "array", "items": {"type": "string"}}`,
+			[]SchemaChange{{"$[]", "items restricted", true}}},
+	}
+	for _, test := range tests {
+		changes := diffJSONSchemas("$", parseTestJSONSchema(t, test.from), parseTestJSONSchema(t, test.to))
+		if !reflect.DeepEqual(changes, test.changes) {
+			t.Errorf("%s: got %+v, want %+v", test.name, changes, test.changes)
+		}
+	}
+}
+
+// TestLoadEmbeddedSchemaRegistry checks schemas which cannot be loaded leave the registry empty, and why is logged to the writer
+func TestLoadEmbeddedSchemaRegistry(t *testing.T) {
+
+	var log bytes.Buffer
+	r := loadEmbeddedSchemaRegistry(schemaFiles, &log)
+	if r.LatestVersion(SYSTEM_AUDIT_EVENT_TOPIC) == 0 || log.Len() != 0 {
+		t.Errorf("latest version %d, logged %q, want the embedded schemas loaded", r.LatestVersion(SYSTEM_AUDIT_EVENT_TOPIC), log.String())
+	}
+
+	gap := fstest.MapFS{SCHEMA_DIRECTORY + "/" + schemaFilename(SYSTEM_AUDIT_EVENT_TOPIC, 2): {Data: []byte(`{"type": "object"}`)}}
+	r = loadEmbeddedSchemaRegistry(gap, &log)
+	if len(r.Topics()) != 0 || !strings.HasPrefix(log.String(), "failed to load schemas: ") || !strings.Contains(log.String(), "no version 1") {
+		t.Errorf("topics %v, logged %q, want no schemas and the missing version logged", r.Topics(), log.String())
+	}
+}
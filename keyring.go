@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Define the keyring file commands use when none is given, and the size in bytes of every key
+const (
+	KEYRING_FILE = "keyring.json"
+	KEY_SIZE     = 32
+)
+
+// Define the structure of KeyringKey, a key encryption key
+type KeyringKey struct {
+	ID      string    `json:"id"`
+	Key     []byte    `json:"key"`
+	Created time.Time `json:"created"`
+}
+
+// Keyring holds the key encryption keys which wrap the data key of each encrypted entry. new data keys are wrapped
+// with the primary key, and older keys are kept until no entry is wrapped with them, so those entries can still be read.
+// it also holds the key pseudonyms are derived with, which is never rotated, so an identifier keeps its pseudonym
+type Keyring struct {
+	Primary             string       `json:"primary"`
+	Keys                []KeyringKey `json:"keys"`
+	PseudonymisationKey []byte       `json:"pseudonymisationKey,omitempty"`
+}
+
+// NewKeyring creates a new instance of Keyring, with one key
+func NewKeyring() (*Keyring, error) {
+
+	k := &Keyring{}
+	if _, err := k.Rotate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// LoadKeyring loads a keyring file
+func LoadKeyring(filename string) (*Keyring, error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var k Keyring
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal keyring file: %s, %v", filename, err)
+	}
+	for _, key := range k.Keys {
+		if len(key.Key) != KEY_SIZE {
+			return nil, fmt.Errorf("key '%s' in keyring file %s is %d bytes, not %d", key.ID, filename, len(key.Key), KEY_SIZE)
+		}
+	}
+	if _, ok := k.Key(k.Primary); !ok {
+		return nil, fmt.Errorf("keyring file %s has no primary key '%s'", filename, k.Primary)
+	}
+	if k.PseudonymisationKey != nil && len(k.PseudonymisationKey) != KEY_SIZE {
+		return nil, fmt.Errorf("pseudonymisation key in keyring file %s is %d bytes, not %d", filename, len(k.PseudonymisationKey), KEY_SIZE)
+	}
+	return &k, nil
+}
+
+// loadOptionalKeyring loads the keyring file named by a command's --keyring flag. fields are left in the clear,
+// and no keyring is returned, when no file is named, or when the default keyring file does not exist
+func loadOptionalKeyring(filename string) (*Keyring, error) {
+
+	if filename == "" {
+		return nil, nil
+	}
+	k, err := LoadKeyring(filename)
+	if errors.Is(err, os.ErrNotExist) && filename == KEYRING_FILE {
+		return nil, nil
+	}
+	return k, err
+}
+
+// useKeyring encrypts fields, and pseudonymises identifiers, with the keys of a keyring, or does neither when the keyring is nil
+func (b *Backend) useKeyring(keyring *Keyring) {
+
+	b.Encryption.Keyring = keyring
+	b.Pseudonymiser = nil
+	if keyring != nil && keyring.PseudonymisationKey != nil {
+		b.Pseudonymiser = NewPseudonymiser(keyring.PseudonymisationKey)
+	}
+}
+
+// Save writes the keyring to a file which only its owner can read
+func (k *Keyring) Save(filename string) error {
+
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal keyring: %v", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write keyring file: %v", err)
+	}
+	return nil
+}
+
+// Key returns the key with the given ID
+func (k *Keyring) Key(id string) (KeyringKey, bool) {
+
+	for _, key := range k.Keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return KeyringKey{}, false
+}
+
+// Rotate adds a new key and makes it the primary key. a keyring created before pseudonymisation also gets its pseudonymisation key
+func (k *Keyring) Rotate() (KeyringKey, error) {
+
+	if k.PseudonymisationKey == nil {
+		pseudonymisationKey := make([]byte, KEY_SIZE)
+		if _, err := rand.Read(pseudonymisationKey); err != nil {
+			return KeyringKey{}, fmt.Errorf("failed to generate pseudonymisation key: %v", err)
+		}
+		k.PseudonymisationKey = pseudonymisationKey
+	}
+
+	key := KeyringKey{
+		ID:      fmt.Sprintf("k%d", len(k.Keys)+1),
+		Key:     make([]byte, KEY_SIZE),
+		Created: time.Now().UTC(),
+	}
+	for {
+		if _, ok := k.Key(key.ID); !ok {
+			break
+		}
+		key.ID += "a"
+	}
+	if _, err := rand.Read(key.Key); err != nil {
+		return KeyringKey{}, fmt.Errorf("failed to generate key: %v", err)
+	}
+	k.Keys = append(k.Keys, key)
+	k.Primary = key.ID
+	return key, nil
+}
+
+// Remove removes a key other than the primary key
+func (k *Keyring) Remove(id string) error {
+
+	if id == k.Primary {
+		return fmt.Errorf("key '%s' is the primary key", id)
+	}
+	for i, key := range k.Keys {
+		if key.ID == id {
+			k.Keys = append(k.Keys[:i], k.Keys[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("keyring has no key '%s'", id)
+}
+
+// WrapDataKey encrypts a data key with the primary key, returning the wrapped key, prefixed by the primary key's ID
+func (k *Keyring) WrapDataKey(dataKey []byte) (string, error) {
+
+	key, _ := k.Key(k.Primary)
+	wrapped, err := sealAESGCM(key.Key, dataKey, []byte(key.ID))
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key: %v", err)
+	}
+	return key.ID + ":" + base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// UnwrapDataKey decrypts a data key wrapped by WrapDataKey, with whichever key wrapped it
+func (k *Keyring) UnwrapDataKey(wrappedDataKey string) ([]byte, error) {
+
+	id, encoded, ok := strings.Cut(wrappedDataKey, ":")
+	if !ok {
+		return nil, fmt.Errorf("wrapped data key is not <key id>:<base64>")
+	}
+	key, ok := k.Key(id)
+	if !ok {
+		return nil, fmt.Errorf("keyring has no key '%s'", id)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode wrapped data key: %v", err)
+	}
+	dataKey, err := openAESGCM(key.Key, wrapped, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key '%s': %v", id, err)
+	}
+	return dataKey, nil
+}
+
+// wrappedDataKeyID returns the ID of the key which wrapped a data key
+func wrappedDataKeyID(wrappedDataKey string) string {
+
+	id, _, _ := strings.Cut(wrappedDataKey, ":")
+	return id
+}
+
+// newDataKey generates a random data key
+func newDataKey() ([]byte, error) {
+
+	dataKey := make([]byte, KEY_SIZE)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	return dataKey, nil
+}
+
+// sealAESGCM encrypts and authenticates plaintext and additional data with AES-GCM, returning a random nonce followed by the ciphertext
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAESGCM decrypts and authenticates the output of sealAESGCM
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// newAESGCM creates an AES-GCM cipher
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestKeyring creates a keyring, failing the test if it cannot
+func newTestKeyring(t *testing.T) *Keyring {
+
+	t.Helper()
+	keyring, err := NewKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// TestKeyringRotateAndRemove checks each rotation adds a primary key with a new ID, and only keys other than the primary can be removed
+func TestKeyringRotateAndRemove(t *testing.T) {
+
+	keyring := newTestKeyring(t)
+	if keyring.Primary != "k1" || len(keyring.PseudonymisationKey) != KEY_SIZE {
+		t.Fatalf("primary %q, pseudonymisation key of %d bytes, want k1 and a %d byte key", keyring.Primary, len(keyring.PseudonymisationKey), KEY_SIZE)
+	}
+	pseudonymisationKey := keyring.PseudonymisationKey
+
+	key, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != "k2" || keyring.Primary != "k2" || len(key.Key) != KEY_SIZE {
+		t.Errorf("rotated to %q, primary %q, want k2 with a %d byte key", key.ID, keyring.Primary, KEY_SIZE)
+	}
+	if !bytes.Equal(keyring.PseudonymisationKey, pseudonymisationKey) {
+		t.Error("rotation changed the pseudonymisation key, which would change every pseudonym")
+	}
+
+	if err := keyring.Remove("k2"); err == nil || !strings.Contains(err.Error(), "primary key") {
+		t.Errorf("removed the primary key, error %v", err)
+	}
+	if err := keyring.Remove("k9"); err == nil || !strings.Contains(err.Error(), "no key 'k9'") {
+		t.Errorf("removed an unknown key, error %v", err)
+	}
+	if err := keyring.Remove("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keyring.Key("k1"); ok {
+		t.Error("removed key k1 is still in the keyring")
+	}
+
+	// the ID the next key would be numbered with is still in use
+	key, err = keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != "k2a" {
+		t.Errorf("rotated to %q, want k2a as k2 is in use", key.ID)
+	}
+}
+
+// TestKeyringWrapDataKey checks a data key wrapped by the primary key is unwrapped after rotation, and a tampered,
+// relabelled or malformed wrapped key is rejected
+func TestKeyringWrapDataKey(t *testing.T) {
+
+	keyring := newTestKeyring(t)
+	dataKey, err := newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrappedDataKey, err := keyring.WrapDataKey(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrappedDataKeyID(wrappedDataKey) != "k1" {
+		t.Errorf("wrapped data key %q, want it prefixed by k1", wrappedDataKey)
+	}
+	if _, err := keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := keyring.UnwrapDataKey(wrappedDataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Error("unwrapped data key differs from the one wrapped")
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(wrappedDataKey, "k1:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped[len(wrapped)-1] ^= 1
+	tests := []struct {
+		name           string
+		wrappedDataKey string
+		err            string
+	}{
+		{"tampered", "k1:" + base64.StdEncoding.EncodeToString(wrapped), "failed to unwrap data key with key 'k1'"},
+		{"relabelled", "k2" + strings.TrimPrefix(wrappedDataKey, "k1"), "failed to unwrap data key with key 'k2'"},
+		{"unknown key", "k9" + strings.TrimPrefix(wrappedDataKey, "k1"), "keyring has no key 'k9'"},
+		{"no key id", strings.TrimPrefix(wrappedDataKey, "k1:"), "not <key id>:<base64>"},
+		{"not base64", "k1:!", "failed to decode wrapped data key"},
+		{"too short", "k1:AAAA", "ciphertext is too short"},
+	}
+	for _, test := range tests {
+		if _, err := keyring.UnwrapDataKey(test.wrappedDataKey); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	if err := keyring.Remove("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.UnwrapDataKey(wrappedDataKey); err == nil || !strings.Contains(err.Error(), "keyring has no key 'k1'") {
+		t.Errorf("unwrapped with a removed key, error %v", err)
+	}
+}
+
+// TestKeyringSaveAndLoad checks a keyring is saved readable only by its owner and loads as saved, and invalid keyring files are rejected
+func TestKeyringSaveAndLoad(t *testing.T) {
+
+	directory := t.TempDir()
+	keyring := newTestKeyring(t)
+	if _, err := keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(directory, KEYRING_FILE)
+	if err := keyring.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("keyring file mode %v, want 0600", mode)
+	}
+	loaded, err := LoadKeyring(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Primary != "k2" || len(loaded.Keys) != 2 || !bytes.Equal(loaded.PseudonymisationKey, keyring.PseudonymisationKey) {
+		t.Errorf("loaded %+v, want the keyring saved", loaded)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"corrupt", `{"primary": `, "failed to unmarshal keyring file"},
+		{"short key", `{"primary": "k1", "keys": [{"id": "k1", "key": "AAAA"}]}`, "key 'k1' in keyring file"},
+		{"no primary", `{"primary": "k2", "keys": []}`, "has no primary key 'k2'"},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(directory, strings.ReplaceAll(test.name, " ", "-")+".json")
+		if err := os.WriteFile(filename, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadKeyring(filename); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	if keyring, err := loadOptionalKeyring(""); keyring != nil || err != nil {
+		t.Errorf("no keyring file named: %v, %v, want neither a keyring nor an error", keyring, err)
+	}
+	if _, err := loadOptionalKeyring(filepath.Join(directory, "missing.json")); err == nil {
+		t.Error("loaded a missing keyring file which was named, want an error")
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the user name and subject the load test logs in and accesses as
+const (
+	LOAD_TEST_USER_NAME          = "load.test"
+	LOAD_TEST_SUBJECT_IDENTIFIER = "0101700000"
+)
+
+// PrefixedMessageStore keeps every topic under a prefix, so a whole pipeline can run against topics of its own
+type PrefixedMessageStore struct {
+	MockableMessageStore
+	Prefix string
+}
+
+// NewPrefixedMessageStore creates a new instance of PrefixedMessageStore
+func NewPrefixedMessageStore(store MockableMessageStore, prefix string) *PrefixedMessageStore {
+
+	return &PrefixedMessageStore{
+		MockableMessageStore: store,
+		Prefix:               prefix,
+	}
+}
+
+// PrefixedTopic returns the topic entries for topic are kept in
+func (s *PrefixedMessageStore) PrefixedTopic(topic string) string {
+
+	return s.Prefix + "." + topic
+}
+
+// SaveEntry saves an entry to the prefixed topic
+func (s *PrefixedMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	return s.MockableMessageStore.SaveEntry(s.PrefixedTopic(topic), entry)
+}
+
+// ReadEntry reads an entry from the prefixed topic
+func (s *PrefixedMessageStore) ReadEntry(topic string, offset int64) (*ms.Entry, error) {
+
+	return s.MockableMessageStore.ReadEntry(s.PrefixedTopic(topic), offset)
+}
+
+// PollForNextEntry polls the prefixed topic
+func (s *PrefixedMessageStore) PollForNextEntry(topic string, offset int64, pollDuration time.Duration) (*ms.Entry, error) {
+
+	return s.MockableMessageStore.PollForNextEntry(s.PrefixedTopic(topic), offset, pollDuration)
+}
+
+// SaveEntries saves entries to the prefixed topic as one batch
+func (s *PrefixedMessageStore) SaveEntries(topic string, entries []ms.Entry) (int64, error) {
+
+	return asBatchMessageStore(s.MockableMessageStore).SaveEntries(s.PrefixedTopic(topic), entries)
+}
+
+// ReadEntries reads up to max entries from the prefixed topic
+func (s *PrefixedMessageStore) ReadEntries(topic string, offset int64, max int) ([]ms.Entry, error) {
+
+	return asBatchMessageStore(s.MockableMessageStore).ReadEntries(s.PrefixedTopic(topic), offset, max)
+}
+
+// Define the structure of LoadTestOptions
+type LoadTestOptions struct {
+	Rate        float64 // logins per second, or 0 for as fast as possible
+	Duration    time.Duration
+	Drain       time.Duration // the time allowed for consumers to catch up once logins stop
+	Prefix      string
+	Codecs      *TopicCodecs      // the codec each topic is written with, or nil for JSON
+	Compression *TopicCompression // the compression of each topic, or nil for none
+	Keyring     *Keyring          // encrypts subject identifiers and document metadata, or nil to leave them in the clear
+	Log         io.Writer         // where the pipeline's progress is logged, or nil for standard output
+}
+
+// Define the structure of LatencyReport, in milliseconds
+type LatencyReport struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50Ms"`
+	P90   float64 `json:"p90Ms"`
+	P99   float64 `json:"p99Ms"`
+	Max   float64 `json:"maxMs"`
+}
+
+// Define the structure of TopicThroughput
+type TopicThroughput struct {
+	Topic     string  `json:"topic"`
+	Entries   int64   `json:"entries"`
+	PerSecond float64 `json:"perSecond"`
+}
+
+// Define the structure of ConsumerLag
+type ConsumerLag struct {
+	Name     string `json:"name"`
+	Topic    string `json:"topic"`
+	MaxLag   int64  `json:"maxLag"`
+	FinalLag int64  `json:"finalLag"`
+}
+
+// Define the structure of LoadTestReport
+type LoadTestReport struct {
+	TargetRate  float64           `json:"targetRate"`
+	Codecs      string            `json:"codecs"`
+	Compression string            `json:"compression"`
+	Encryption  string            `json:"encryption"`
+	LoginsSent  int64             `json:"loginsSent"`
+	SendRate    float64           `json:"sendRate"`
+	Elapsed     float64           `json:"elapsedSeconds"`
+	Drained     bool              `json:"drained"`
+	Latency     LatencyReport     `json:"latency"`
+	Topics      []TopicThroughput `json:"topics"`
+	Consumers   []ConsumerLag     `json:"consumers"`
+}
+
+// runLoadTest drives the whole pipeline, against topics under the options' prefix, with logins at the target rate for the options' duration,
+// waits for the consumers to catch up, and reports end-to-end latency from login attempt to consolidated documents, throughput and consumer lag
+func runLoadTest(ctx context.Context, store MockableMessageStore, options LoadTestOptions) (LoadTestReport, error) {
+
+	prefixed := NewPrefixedMessageStore(NewLockingMessageStore(store), options.Prefix)
+	b := NewBackend()
+	if options.Codecs != nil {
+		b.Codecs = options.Codecs
+	}
+	if options.Compression != nil {
+		b.Compression = options.Compression
+	}
+	b.useKeyring(options.Keyring)
+	if options.Log != nil {
+		b.Log = options.Log
+	}
+	b.MessageStore = newPipelineMessageStore(prefixed, b.Schemas, b.Encryption, b.Compression)
+	accessGrantRate := 1.0
+	b.Personas = NewPersonaDirectory([]Persona{{
+		UserName:         LOAD_TEST_USER_NAME,
+		Password:         LOAD_TEST_USER_NAME,
+		AccessesPerLogin: 1,
+		AccessGrantRate:  &accessGrantRate,
+		Subjects:         []string{LOAD_TEST_SUBJECT_IDENTIFIER},
+	}})
+
+	report := LoadTestReport{TargetRate: options.Rate, Codecs: b.Codecs.String(), Compression: b.Compression.String(), Encryption: "none"}
+	if b.Encryption.Enabled() {
+		report.Encryption = "key " + b.Encryption.Keyring.Primary
+	}
+
+	// Start each consumer at the end of its topic, so entries left by earlier runs are not counted
+	startOffsets := make(map[string]int64)
+	for _, topicDefinition := range topicDefinitions() {
+		startOffsets[topicDefinition.Name] = topicLength(prefixed, topicDefinition.Name)
+	}
+	for _, replayHandler := range replayHandlers() {
+		b.Offsets.Commit(replayHandler.Name, startOffsets[replayHandler.Topic]-1)
+	}
+
+	// Start a supervised goroutine per consumer
+	consumerCtx, stopConsumers := context.WithCancel(context.Background())
+	defer stopConsumers()
+	generatorCtx, stopGenerator := context.WithCancel(ctx)
+	defer stopGenerator()
+	supervisor := NewSupervisor(5, time.Minute, b.ForComponent(SUPERVISOR_COMPONENT).recordCrashEvent, func(reason string) {
+		fmt.Fprintln(b.Log, "Supervisor escalated to shutdown: ", reason)
+		stopGenerator()
+	})
+	supervisor.Log = b.Log
+	for _, replayHandler := range replayHandlers() {
+		replayHandler := replayHandler
+		component := b.ForComponent(replayHandler.Name)
+		supervisor.Go(consumerCtx, replayHandler.Name, func(ctx context.Context) {
+			component.pollTopic(ctx, replayHandler.Name, replayHandler.Topic, func(ctx context.Context, entry ms.Entry) {
+				replayHandler.Process(component, ctx, entry)
+			})
+		})
+	}
+
+	// Start a goroutine to sample consumer lag every second
+	lags := newConsumerLagSampler(b)
+	samplerDone := make(chan struct{})
+	stopSampler := make(chan struct{})
+	go func() {
+		defer close(samplerDone)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopSampler:
+				return
+			case <-ticker.C:
+				lags.Sample()
+			}
+		}
+	}()
+
+	// Generate logins at the target rate, or as fast as possible
+	start := time.Now()
+	report.LoginsSent = b.ForComponent(USER_LOGIN_ATTEMPT_GENERATOR).generateLoadTestLogins(generatorCtx, options.Rate, options.Duration)
+	report.SendRate = float64(report.LoginsSent) / time.Since(start).Seconds()
+
+	// Wait for the consumers to catch up
+	drainCtx, cancelDrain := context.WithTimeout(ctx, options.Drain)
+	defer cancelDrain()
+	for !report.Drained && drainCtx.Err() == nil {
+		report.Drained = lags.Sample() == 0
+		if !report.Drained {
+			select {
+			case <-drainCtx.Done():
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+	elapsed := time.Since(start)
+	report.Elapsed = elapsed.Seconds()
+
+	close(stopSampler)
+	<-samplerDone
+	stopConsumers()
+	<-supervisor.Done()
+
+	report.Consumers = lags.Report()
+	for _, topicDefinition := range topicDefinitions() {
+		entries := topicLength(prefixed, topicDefinition.Name) - startOffsets[topicDefinition.Name]
+		report.Topics = append(report.Topics, TopicThroughput{
+			Topic:     topicDefinition.Name,
+			Entries:   entries,
+			PerSecond: float64(entries) / elapsed.Seconds(),
+		})
+	}
+	report.Latency = measureEndToEndLatency(prefixed, startOffsets)
+	return report, ctx.Err()
+}
+
+// generateLoadTestLogins sends load test logins at rate per second, or as fast as possible when rate is 0, until duration has elapsed
+func (b *Backend) generateLoadTestLogins(ctx context.Context, rate float64, duration time.Duration) int64 {
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var ticks <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var sent int64
+	for {
+		if ticks != nil {
+			select {
+			case <-ctx.Done():
+				return sent
+			case <-ticks:
+			}
+		} else if ctx.Err() != nil {
+			return sent
+		}
+		b.startUserLoginAttempt(ctx, *NewUserLoginAttempt(LOAD_TEST_USER_NAME, LOAD_TEST_USER_NAME))
+		sent++
+	}
+}
+
+// consumerLagSampler records how far each consumer is behind the end of its topic
+type consumerLagSampler struct {
+	mu     sync.Mutex
+	b      *Backend
+	maxLag map[string]int64
+	lag    map[string]int64
+}
+
+// newConsumerLagSampler creates a new instance of consumerLagSampler
+func newConsumerLagSampler(b *Backend) *consumerLagSampler {
+
+	return &consumerLagSampler{
+		b:      b,
+		maxLag: make(map[string]int64),
+		lag:    make(map[string]int64),
+	}
+}
+
+// Sample records the current lag of every consumer, and returns the total
+func (s *consumerLagSampler) Sample() int64 {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total int64
+	for _, replayHandler := range replayHandlers() {
+		lag := topicLength(s.b.MessageStore, replayHandler.Topic) - 1 - s.b.Offsets.Get(replayHandler.Name)
+		s.lag[replayHandler.Name] = lag
+		if lag > s.maxLag[replayHandler.Name] {
+			s.maxLag[replayHandler.Name] = lag
+		}
+		total += lag
+	}
+	return total
+}
+
+// Report returns the maximum and latest lag of every consumer
+func (s *consumerLagSampler) Report() []ConsumerLag {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var consumerLags []ConsumerLag
+	for _, replayHandler := range replayHandlers() {
+		consumerLags = append(consumerLags, ConsumerLag{
+			Name:     replayHandler.Name,
+			Topic:    replayHandler.Topic,
+			MaxLag:   s.maxLag[replayHandler.Name],
+			FinalLag: s.lag[replayHandler.Name],
+		})
+	}
+	return consumerLags
+}
+
+// measureEndToEndLatency matches each consolidated documents entry to the login attempt which started its trace,
+// and returns the percentiles of the time between them
+func measureEndToEndLatency(store MockableMessageStore, startOffsets map[string]int64) LatencyReport {
+
+	loginTimes := make(map[string]time.Time)
+	forEachEntry(store, USER_LOGIN_ATTEMPT_TOPIC, startOffsets[USER_LOGIN_ATTEMPT_TOPIC], func(entry ms.Entry, spanContext SpanContext) {
+		loginTimes[spanContext.TraceIDString()] = entry.Timestamp
+	})
+
+	var latencies []time.Duration
+	forEachEntry(store, SUBJECT_DOCUMENTS_TOPIC, startOffsets[SUBJECT_DOCUMENTS_TOPIC], func(entry ms.Entry, spanContext SpanContext) {
+		if loginTime, ok := loginTimes[spanContext.TraceIDString()]; ok {
+			latencies = append(latencies, entry.Timestamp.Sub(loginTime))
+		}
+	})
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return LatencyReport{
+		Count: int64(len(latencies)),
+		P50:   percentileMilliseconds(latencies, 0.50),
+		P90:   percentileMilliseconds(latencies, 0.90),
+		P99:   percentileMilliseconds(latencies, 0.99),
+		Max:   percentileMilliseconds(latencies, 1),
+	}
+}
+
+// forEachEntry calls fn with each entry of a topic from an offset, and the trace context it carries
+func forEachEntry(store MockableMessageStore, topic string, from int64, fn func(entry ms.Entry, spanContext SpanContext)) {
+
+	end := topicLength(store, topic)
+	for offset := from; offset < end; offset++ {
+		entry, err := store.ReadEntry(topic, offset)
+		if err != nil {
+			fmt.Printf("ReadEntry for topic '%s', offset %d, failed: %v\n", topic, offset, err)
+			continue
+		}
+		spanContext, err := parseTraceparent(decodeMessageEnvelope(*entry).Headers[TRACEPARENT_HEADER])
+		if err != nil {
+			continue
+		}
+		fn(*entry, spanContext)
+	}
+}
+
+// percentileMilliseconds returns the nearest-rank percentile of sorted durations in milliseconds
+func percentileMilliseconds(sorted []time.Duration, percentile float64) float64 {
+
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(percentile*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return float64(sorted[rank]) / float64(time.Millisecond)
+}
@@ -1,11 +1,8 @@
 package main
 
 import (
-	"context"
-	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
 	"time"
 
 	ms "github.com/mmcnicol/message-store"
@@ -23,39 +20,17 @@ type MessageStoreClient struct {
 
 func main() {
 
-	// Create a context that cancels when the application terminates
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	args := os.Args[1:]
 
-	// Create a signal channel to capture termination signals
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	// Run the simulator when no command is given, e.g. "msdemo -trace-file traces.json"
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		os.Exit(runCommand(args))
+	}
 
-	backend := NewBackend()
-
-	// Start a goroutine to poll SYSTEM_AUDIT_EVENT_TOPIC
-	go backend.pollSystemAuditEvent(ctx)
-
-	// Start a goroutine to poll USER_LOGIN_ATTEMPT_TOPIC
-	go backend.pollUserLoginAttempt(ctx)
-	// Start a goroutine to poll USER_LOGIN_ATTEMPT_OUTCOME_TOPIC
-	go backend.pollUserLoginAttemptOutcome(ctx)
-
-	// Start a goroutine to poll USER_SUBJECT_ACCESS_ATTEMPT_TOPIC
-	go backend.pollUserSubjectAccessAttempt(ctx)
-	// Start a goroutine to poll USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC
-	go backend.pollUserSubjectAccessAttemptOutcome(ctx)
-
-	// Start a goroutine to poll SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC
-	go backend.pollSubjectRegionDocumentRequest(ctx)
-	// Start a goroutine to poll SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC
-	go backend.pollSubjectRegionDocumentResponse(ctx)
-
-	// Start a goroutine to generate user login attempts to USER_LOGIN_ATTEMPT_TOPIC
-	go backend.generateUserLoginAttempts(ctx)
-
-	// Wait for termination signal
-	<-sigCh
-	fmt.Println("Termination signal received, canceling polling")
-	cancel() // Cancel the context
+	command, ok := findCommand(args[0])
+	if !ok {
+		printUsage()
+		os.Exit(2)
+	}
+	os.Exit(command.Run(args[1:]))
 }
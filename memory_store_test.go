@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// memoryMessageStore keeps topics in memory, so tests and benchmarks exercise the handlers rather than the disk
+type memoryMessageStore struct {
+	mu     sync.Mutex
+	topics map[string][]ms.Entry
+}
+
+// newMemoryMessageStore creates a new instance of memoryMessageStore
+func newMemoryMessageStore() *memoryMessageStore {
+
+	return &memoryMessageStore{
+		topics: make(map[string][]ms.Entry),
+	}
+}
+
+// SaveEntry appends an entry to a topic
+func (s *memoryMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.Timestamp == (time.Time{}) {
+		entry.Timestamp = time.Now()
+	}
+	s.topics[topic] = append(s.topics[topic], entry)
+	return int64(len(s.topics[topic]) - 1), nil
+}
+
+// ReadEntry reads the entry at an offset
+func (s *memoryMessageStore) ReadEntry(topic string, offset int64) (*ms.Entry, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.topics[topic]
+	if offset < 0 || offset >= int64(len(entries)) {
+		return nil, fmt.Errorf("offset %d not found in topic '%s'", offset, topic)
+	}
+	entry := entries[offset]
+	return &entry, nil
+}
+
+// PollForNextEntry reads the entry after an offset, without waiting
+func (s *memoryMessageStore) PollForNextEntry(topic string, offset int64, pollDuration time.Duration) (*ms.Entry, error) {
+
+	entry, err := s.ReadEntry(topic, offset+1)
+	if err != nil {
+		return nil, nil
+	}
+	return entry, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define constants for message envelope header names
+const (
+	TRACEPARENT_HEADER      = "traceparent"
+	SCHEMA_VERSION_HEADER   = "schema-version"
+	CONTENT_TYPE_HEADER     = "content-type"
+	CONTENT_ENCODING_HEADER = "content-encoding"
+)
+
+// Define the structure of MessageEnvelope. a JSON payload is embedded as is, and a payload in any other format, or compressed, as a base64 string
+type MessageEnvelope struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	Payload json.RawMessage   `json:"payload"`
+}
+
+// NewMessageEnvelope creates a new instance of MessageEnvelope
+func NewMessageEnvelope(headers map[string]string, payload []byte) *MessageEnvelope {
+
+	return &MessageEnvelope{
+		Headers: headers,
+		Payload: payload,
+	}
+}
+
+// SchemaVersion returns the version of its topic's schema the payload was written with.
+// entries written before versions were recorded are version 1
+func (e MessageEnvelope) SchemaVersion() int {
+
+	version, err := strconv.Atoi(e.Headers[SCHEMA_VERSION_HEADER])
+	if err != nil || version < 1 {
+		return 1
+	}
+	return version
+}
+
+// Codec returns the codec of the payload's content type
+func (e MessageEnvelope) Codec() (Codec, error) {
+
+	return codecForContentType(e.Headers[CONTENT_TYPE_HEADER])
+}
+
+// EncodedPayload returns the payload as its codec wrote it, decompressing it if it was compressed
+func (e MessageEnvelope) EncodedPayload() ([]byte, error) {
+
+	codec, err := e.Codec()
+	if err != nil {
+		return nil, err
+	}
+	encoding := e.Headers[CONTENT_ENCODING_HEADER]
+	if _, ok := codec.(JSONCodec); ok && encoding == "" {
+		return e.Payload, nil
+	}
+
+	var data []byte
+	if err := json.Unmarshal(e.Payload, &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s payload: %v", codec.ContentType(), err)
+	}
+	if encoding == "" {
+		return data, nil
+	}
+	compressor, ok := findCompressor(encoding)
+	if !ok {
+		return nil, fmt.Errorf("unsupported content encoding '%s'", encoding)
+	}
+	data, err = compressor.Decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s payload: %v", encoding, err)
+	}
+	return data, nil
+}
+
+// DecodePayload decodes the payload, in whichever format it was written, into the value v points to
+func (e MessageEnvelope) DecodePayload(v interface{}) error {
+
+	codec, err := e.Codec()
+	if err != nil {
+		return err
+	}
+	data, err := e.EncodedPayload()
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, v)
+}
+
+// JSONPayload returns the payload as JSON, so it can be upcast, validated and inspected whichever format it was written in.
+// MessagePack has the same shape as JSON, while protobuf is decoded into the topic's current payload type
+func (e MessageEnvelope) JSONPayload(topic string) (json.RawMessage, error) {
+
+	codec, err := e.Codec()
+	if err != nil {
+		return nil, err
+	}
+	switch codec.(type) {
+	case JSONCodec:
+		return e.EncodedPayload()
+	case MessagePackCodec:
+		var payload interface{}
+		if err := e.DecodePayload(&payload); err != nil {
+			return nil, err
+		}
+		return json.Marshal(payload)
+	}
+	topicDefinition, ok := findTopicDefinition(topic)
+	if !ok {
+		return nil, fmt.Errorf("cannot decode %s payload of unknown topic '%s'", codec.ContentType(), topic)
+	}
+	payload := topicDefinition.NewPayload()
+	if err := e.DecodePayload(payload); err != nil {
+		return nil, err
+	}
+	return json.Marshal(payload)
+}
+
+// newMessageStoreEntry wraps a payload for a topic in a message envelope, carrying the trace context from ctx,
+// the version of the topic's schema the payload is written with and the content type of the codec it was encoded with
+func newMessageStoreEntry(ctx context.Context, topic, key string, codec Codec, payload []byte) ms.Entry {
+
+	headers := make(map[string]string)
+	injectTraceContext(ctx, headers)
+	if version := currentPayloadVersion(topic); version > 0 {
+		headers[SCHEMA_VERSION_HEADER] = strconv.Itoa(version)
+	}
+	headers[CONTENT_TYPE_HEADER] = codec.ContentType()
+
+	if _, ok := codec.(JSONCodec); !ok {
+		// Embed the payload as a base64 string
+		payload, _ = json.Marshal(payload)
+	}
+
+	envelopeJSON, err := json.Marshal(NewMessageEnvelope(headers, payload))
+	if err != nil {
+		fmt.Printf("failed to marshal message envelope: %v", err)
+	}
+
+	messageStoreEntry := ms.Entry{}
+	messageStoreEntry.Key = []byte(key)
+	messageStoreEntry.Value = envelopeJSON
+	return messageStoreEntry
+}
+
+// decodeMessageEnvelope extracts the message envelope from a message store entry
+func decodeMessageEnvelope(entry ms.Entry) MessageEnvelope {
+
+	var envelope MessageEnvelope
+	if err := json.Unmarshal(entry.Value, &envelope); err != nil || len(envelope.Payload) == 0 {
+		// entries written before envelopes were introduced hold the bare payload
+		return MessageEnvelope{Payload: entry.Value}
+	}
+	return envelope
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Define the MessagePack extension type of timestamps
+const MESSAGE_PACK_TIMESTAMP_EXTENSION = -1
+
+// MessagePackCodec encodes payloads as MessagePack, writing structs as maps keyed by their JSON field names
+// so the payload has the same shape as its JSON encoding, and times as timestamp extensions
+type MessagePackCodec struct{}
+
+// Name returns the name of the codec
+func (MessagePackCodec) Name() string {
+
+	return "msgpack"
+}
+
+// ContentType returns the content type of the codec
+func (MessagePackCodec) ContentType() string {
+
+	return MESSAGE_PACK_CONTENT_TYPE
+}
+
+// Marshal encodes a payload
+func (MessagePackCodec) Marshal(v interface{}) ([]byte, error) {
+
+	var buf bytes.Buffer
+	if err := encodeMessagePack(&buf, reflect.ValueOf(v)); err != nil {
+		return nil, fmt.Errorf("failed to encode MessagePack: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes a payload into the value v points to. map keys which match no struct field are ignored
+func (MessagePackCodec) Unmarshal(data []byte, v interface{}) error {
+
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("failed to decode MessagePack: expected a non-nil pointer, got %T", v)
+	}
+	d := &messagePackDecoder{data: data}
+	value, err := d.decode()
+	if err != nil {
+		return fmt.Errorf("failed to decode MessagePack: %v", err)
+	}
+	if d.pos != len(d.data) {
+		return fmt.Errorf("failed to decode MessagePack: %d bytes after the value", len(d.data)-d.pos)
+	}
+	if err := assignMessagePackValue(target.Elem(), value); err != nil {
+		return fmt.Errorf("failed to decode MessagePack: %v", err)
+	}
+	return nil
+}
+
+// encodeMessagePack appends the MessagePack encoding of a value
+func encodeMessagePack(buf *bytes.Buffer, v reflect.Value) error {
+
+	if !v.IsValid() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+	if v.Type() == timeType {
+		writeMessagePackTimestamp(buf, v.Interface().(time.Time))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		return encodeMessagePack(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeMessagePackInt(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		writeMessagePackUint(buf, v.Uint())
+	case reflect.Float32:
+		buf.WriteByte(0xca)
+		binary.Write(buf, binary.BigEndian, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v.Float()))
+	case reflect.String:
+		writeMessagePackString(buf, v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeMessagePackBinary(buf, v.Bytes())
+			return nil
+		}
+		return encodeMessagePackArray(buf, v)
+	case reflect.Array:
+		return encodeMessagePackArray(buf, v)
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", v.Type().Key())
+		}
+		// Write keys in order, so equal maps encode identically
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		writeMessagePackLength(buf, len(keys), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			writeMessagePackString(buf, key.String())
+			if err := encodeMessagePack(buf, v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return encodeMessagePackStruct(buf, v)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// encodeMessagePackArray appends the MessagePack encoding of a slice or array
+func encodeMessagePackArray(buf *bytes.Buffer, v reflect.Value) error {
+
+	writeMessagePackLength(buf, v.Len(), 0x90, 0xdc, 0xdd)
+	for i := 0; i < v.Len(); i++ {
+		if err := encodeMessagePack(buf, v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeMessagePackStruct appends a struct as a map of the fields encoding/json would marshal
+func encodeMessagePackStruct(buf *bytes.Buffer, v reflect.Value) error {
+
+	type namedField struct {
+		name  string
+		value reflect.Value
+	}
+	var fields []namedField
+	for i := 0; i < v.NumField(); i++ {
+		name, omitEmpty, ok := jsonFieldName(v.Type().Field(i))
+		if !ok || (omitEmpty && v.Field(i).IsZero()) {
+			continue
+		}
+		fields = append(fields, namedField{name, v.Field(i)})
+	}
+
+	writeMessagePackLength(buf, len(fields), 0x80, 0xde, 0xdf)
+	for _, field := range fields {
+		writeMessagePackString(buf, field.name)
+		if err := encodeMessagePack(buf, field.value); err != nil {
+			return fmt.Errorf("%s: %v", field.name, err)
+		}
+	}
+	return nil
+}
+
+// writeMessagePackInt appends an integer in its smallest encoding
+func writeMessagePackInt(buf *bytes.Buffer, n int64) {
+
+	switch {
+	case n >= 0:
+		writeMessagePackUint(buf, uint64(n))
+	case n >= -32:
+		buf.WriteByte(byte(n))
+	case n >= math.MinInt8:
+		buf.Write([]byte{0xd0, byte(n)})
+	case n >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(n))
+	case n >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(n))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// writeMessagePackUint appends an unsigned integer in its smallest encoding
+func writeMessagePackUint(buf *bytes.Buffer, n uint64) {
+
+	switch {
+	case n <= 0x7f:
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// writeMessagePackString appends a string
+func writeMessagePackString(buf *bytes.Buffer, s string) {
+
+	if len(s) < 32 {
+		buf.WriteByte(0xa0 | byte(len(s)))
+	} else if len(s) <= math.MaxUint8 {
+		buf.Write([]byte{0xd9, byte(len(s))})
+	} else {
+		writeMessagePackLength(buf, len(s), 0, 0xda, 0xdb)
+	}
+	buf.WriteString(s)
+}
+
+// writeMessagePackBinary appends a byte slice
+func writeMessagePackBinary(buf *bytes.Buffer, b []byte) {
+
+	if len(b) <= math.MaxUint8 {
+		buf.Write([]byte{0xc4, byte(len(b))})
+	} else {
+		writeMessagePackLength(buf, len(b), 0, 0xc5, 0xc6)
+	}
+	buf.Write(b)
+}
+
+// writeMessagePackLength appends the header of an array, map or string, using the fixed format
+// for lengths below 16 when it has one, then the 16 bit and 32 bit formats
+func writeMessagePackLength(buf *bytes.Buffer, n int, fixed, format16, format32 byte) {
+
+	switch {
+	case fixed != 0 && n < 16:
+		buf.WriteByte(fixed | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(format16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(format32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// writeMessagePackTimestamp appends a time as a 96 bit timestamp extension, which holds any time with nanosecond precision
+func writeMessagePackTimestamp(buf *bytes.Buffer, t time.Time) {
+
+	buf.Write([]byte{0xc7, 12, 0xff}) // ext 8, length 12, type -1
+	binary.Write(buf, binary.BigEndian, uint32(t.Nanosecond()))
+	binary.Write(buf, binary.BigEndian, t.Unix())
+}
+
+// messagePackDecoder decodes MessagePack into nil, bool, int64, uint64, float64, string, []byte, time.Time,
+// []interface{} and map[string]interface{} values
+type messagePackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// read returns the next n bytes
+func (d *messagePackDecoder) read(n int) ([]byte, error) {
+
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, fmt.Errorf("unexpected end of data at byte %d", d.pos)
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// readUint reads a big endian unsigned integer of n bytes
+func (d *messagePackDecoder) readUint(n int) (uint64, error) {
+
+	b, err := d.read(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+// decode decodes the next value
+func (d *messagePackDecoder) decode() (interface{}, error) {
+
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	format := b[0]
+
+	switch {
+	case format <= 0x7f:
+		return int64(format), nil
+	case format >= 0xe0:
+		return int64(int8(format)), nil
+	case format&0xf0 == 0x80:
+		return d.decodeMap(int(format & 0x0f))
+	case format&0xf0 == 0x90:
+		return d.decodeArray(int(format & 0x0f))
+	case format&0xe0 == 0xa0:
+		return d.decodeString(int(format & 0x1f))
+	}
+
+	switch format {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readUint(1 << (format - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.read(int(n))
+		return append([]byte(nil), data...), err
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readUint(1 << (format - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExtension(int(n))
+	case 0xca:
+		bits, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := d.readUint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.readUint(1 << (format - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (format - 0xd0)
+		u, err := d.readUint(size)
+		// sign extend from the size of the integer
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExtension(1 << (format - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readUint(1 << (format - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.readUint(2 << (format - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(n))
+	case 0xde, 0xdf:
+		n, err := d.readUint(2 << (format - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(n))
+	}
+	return nil, fmt.Errorf("unsupported format 0x%02x at byte %d", format, d.pos-1)
+}
+
+// decodeString decodes a string of n bytes
+func (d *messagePackDecoder) decodeString(n int) (interface{}, error) {
+
+	b, err := d.read(n)
+	return string(b), err
+}
+
+// decodeArray decodes an array of n values
+func (d *messagePackDecoder) decodeArray(n int) (interface{}, error) {
+
+	values := make([]interface{}, 0, minInt(n, len(d.data)-d.pos))
+	for i := 0; i < n; i++ {
+		value, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// decodeMap decodes a map of n string keys and their values
+func (d *messagePackDecoder) decodeMap(n int) (interface{}, error) {
+
+	values := make(map[string]interface{}, minInt(n, len(d.data)-d.pos))
+	for i := 0; i < n; i++ {
+		key, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("unsupported map key %v", key)
+		}
+		if values[name], err = d.decode(); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// decodeExtension decodes an extension of n bytes, of which only timestamps are supported
+func (d *messagePackDecoder) decodeExtension(n int) (interface{}, error) {
+
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	if int8(b[0]) != MESSAGE_PACK_TIMESTAMP_EXTENSION {
+		return nil, fmt.Errorf("unsupported extension type %d", int8(b[0]))
+	}
+	data, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		u := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(u&(1<<34-1)), int64(u>>34)).UTC(), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))).UTC(), nil
+	}
+	return nil, fmt.Errorf("invalid timestamp length %d", n)
+}
+
+// assignMessagePackValue stores a decoded value in a Go value, converting it to the Go value's type
+func assignMessagePackValue(target reflect.Value, value interface{}) error {
+
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+	if target.Type() == timeType {
+		t, ok := value.(time.Time)
+		if !ok {
+			return fmt.Errorf("cannot decode %T into %s", value, target.Type())
+		}
+		target.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.Ptr:
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return assignMessagePackValue(target.Elem(), value)
+	case reflect.Interface:
+		if target.NumMethod() == 0 {
+			target.Set(reflect.ValueOf(value))
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := value.(bool); ok {
+			target.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := messagePackInt(value); ok && !target.OverflowInt(n) {
+			target.SetInt(n)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u, ok := value.(uint64); ok && !target.OverflowUint(u) {
+			target.SetUint(u)
+			return nil
+		}
+		if n, ok := value.(int64); ok && n >= 0 && !target.OverflowUint(uint64(n)) {
+			target.SetUint(uint64(n))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := value.(type) {
+		case float64:
+			target.SetFloat(n)
+			return nil
+		case int64:
+			target.SetFloat(float64(n))
+			return nil
+		case uint64:
+			target.SetFloat(float64(n))
+			return nil
+		}
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			target.SetString(s)
+			return nil
+		}
+	case reflect.Slice:
+		if b, ok := value.([]byte); ok && target.Type().Elem().Kind() == reflect.Uint8 {
+			target.SetBytes(b)
+			return nil
+		}
+		if values, ok := value.([]interface{}); ok {
+			slice := reflect.MakeSlice(target.Type(), len(values), len(values))
+			for i, v := range values {
+				if err := assignMessagePackValue(slice.Index(i), v); err != nil {
+					return fmt.Errorf("[%d]: %v", i, err)
+				}
+			}
+			target.Set(slice)
+			return nil
+		}
+	case reflect.Array:
+		if values, ok := value.([]interface{}); ok && len(values) == target.Len() {
+			for i, v := range values {
+				if err := assignMessagePackValue(target.Index(i), v); err != nil {
+					return fmt.Errorf("[%d]: %v", i, err)
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if values, ok := value.(map[string]interface{}); ok && target.Type().Key().Kind() == reflect.String {
+			m := reflect.MakeMapWithSize(target.Type(), len(values))
+			for name, v := range values {
+				element := reflect.New(target.Type().Elem()).Elem()
+				if err := assignMessagePackValue(element, v); err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+				m.SetMapIndex(reflect.ValueOf(name).Convert(target.Type().Key()), element)
+			}
+			target.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if values, ok := value.(map[string]interface{}); ok {
+			return assignMessagePackStruct(target, values)
+		}
+	}
+	return fmt.Errorf("cannot decode %T into %s", value, target.Type())
+}
+
+// assignMessagePackStruct stores a decoded map in a struct, matching keys to JSON field names
+func assignMessagePackStruct(target reflect.Value, values map[string]interface{}) error {
+
+	for i := 0; i < target.NumField(); i++ {
+		name, _, ok := jsonFieldName(target.Type().Field(i))
+		if !ok {
+			continue
+		}
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		if err := assignMessagePackValue(target.Field(i), value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// messagePackInt returns a decoded integer as an int64
+func messagePackInt(value interface{}) (int64, bool) {
+
+	switch n := value.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), n <= math.MaxInt64
+	}
+	return 0, false
+}
+
+// minInt returns the smaller of two ints
+func minInt(a, b int) int {
+
+	if a < b {
+		return a
+	}
+	return b
+}
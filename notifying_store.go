@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// EntryNotifier is implemented by message stores which can wake consumers when an entry is saved to a topic
+type EntryNotifier interface {
+	Subscribe(topic string) (wake <-chan struct{}, unsubscribe func())
+}
+
+// NotifyingMessageStore wakes the subscribers to a topic whenever an entry is saved to it through this store.
+// entries saved by other processes are not seen, so subscribers should still poll occasionally
+type NotifyingMessageStore struct {
+	MockableMessageStore
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]bool
+}
+
+// NewNotifyingMessageStore creates a new instance of NotifyingMessageStore
+func NewNotifyingMessageStore(store MockableMessageStore) *NotifyingMessageStore {
+
+	return &NotifyingMessageStore{
+		MockableMessageStore: store,
+		subscribers:          make(map[string]map[chan struct{}]bool),
+	}
+}
+
+// Subscribe returns a channel which receives a value after each save to topic.
+// the channel holds at most one pending wake, so a subscriber which is busy when several entries are saved is woken once
+func (s *NotifyingMessageStore) Subscribe(topic string) (<-chan struct{}, func()) {
+
+	wake := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers[topic] == nil {
+		s.subscribers[topic] = make(map[chan struct{}]bool)
+	}
+	s.subscribers[topic][wake] = true
+
+	return wake, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subscribers[topic], wake)
+	}
+}
+
+// SaveEntry saves an entry, then wakes the topic's subscribers
+func (s *NotifyingMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	offset, err := s.MockableMessageStore.SaveEntry(topic, entry)
+	if err != nil {
+		return offset, err
+	}
+	s.notify(topic)
+	return offset, nil
+}
+
+// SaveEntries saves entries as one batch, then wakes the topic's subscribers once
+func (s *NotifyingMessageStore) SaveEntries(topic string, entries []ms.Entry) (int64, error) {
+
+	offset, err := asBatchMessageStore(s.MockableMessageStore).SaveEntries(topic, entries)
+	if offset >= 0 {
+		// some entries may have been saved even if the batch failed part way
+		s.notify(topic)
+	}
+	return offset, err
+}
+
+// ReadEntries reads up to max entries
+func (s *NotifyingMessageStore) ReadEntries(topic string, offset int64, max int) ([]ms.Entry, error) {
+
+	return asBatchMessageStore(s.MockableMessageStore).ReadEntries(topic, offset, max)
+}
+
+// notify wakes the topic's subscribers
+func (s *NotifyingMessageStore) notify(topic string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for wake := range s.subscribers[topic] {
+		select {
+		case wake <- struct{}{}:
+		default:
+			// a wake is already pending
+		}
+	}
+}
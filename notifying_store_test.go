@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// TestNotifyingMessageStoreWakes checks a consumer blocked waiting for an entry is woken as soon as one is saved to its topic,
+// and not by saves to other topics
+func TestNotifyingMessageStoreWakes(t *testing.T) {
+
+	store := NewNotifyingMessageStore(newMemoryMessageStore())
+	wake, unsubscribe := store.Subscribe(SYSTEM_AUDIT_EVENT_TOPIC)
+	defer unsubscribe()
+
+	woken := make(chan struct{})
+	go func() {
+		waitForEntry(context.Background(), wake)
+		close(woken)
+	}()
+
+	if _, err := store.SaveEntry(USER_LOGIN_ATTEMPT_TOPIC, ms.Entry{Value: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-woken:
+		t.Fatal("woken by a save to another topic")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, ms.Entry{Value: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	// well within the five seconds after which a waiting consumer polls anyway
+	select {
+	case <-woken:
+	case <-time.After(time.Second):
+		t.Fatal("not woken by a save to the topic")
+	}
+}
+
+// TestNotifyingMessageStoreWakesOnce checks several saves while a subscriber is busy leave one wake pending, and an unsubscribed
+// subscriber is not woken
+func TestNotifyingMessageStoreWakesOnce(t *testing.T) {
+
+	store := NewNotifyingMessageStore(newMemoryMessageStore())
+	wake, unsubscribe := store.Subscribe(SYSTEM_AUDIT_EVENT_TOPIC)
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, ms.Entry{Value: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.SaveEntries(SYSTEM_AUDIT_EVENT_TOPIC, []ms.Entry{{Value: []byte("{}")}, {Value: []byte("{}")}}); err != nil {
+		t.Fatal(err)
+	}
+	if pending := len(wake); pending != 1 {
+		t.Errorf("%d wakes pending, want 1", pending)
+	}
+	<-wake
+
+	unsubscribe()
+	if _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, ms.Entry{Value: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	if pending := len(wake); pending != 0 {
+		t.Errorf("%d wakes pending after unsubscribing, want none", pending)
+	}
+}
+
+// TestNotifyingMessageStoreSaveFails checks a save which fails is returned and does not wake the topic's subscribers
+func TestNotifyingMessageStoreSaveFails(t *testing.T) {
+
+	store := NewNotifyingMessageStore(&failingMessageStore{newMemoryMessageStore()})
+	wake, unsubscribe := store.Subscribe(SYSTEM_AUDIT_EVENT_TOPIC)
+	defer unsubscribe()
+
+	if _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, ms.Entry{Value: []byte("{}")}); err == nil {
+		t.Error("save succeeded, want the store's error")
+	}
+	if pending := len(wake); pending != 0 {
+		t.Errorf("%d wakes pending after a failed save, want none", pending)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Define the structure of Persona, a simulated user with their own login and access behaviour
+type Persona struct {
+	UserName         string   `json:"userName"`
+	Password         string   `json:"password"`
+	Weight           int      `json:"weight"`
+	LoginFailureRate float64  `json:"loginFailureRate"`
+	AccessesPerLogin int      `json:"accessesPerLogin"`
+	AccessGrantRate  *float64 `json:"accessGrantRate"`
+	Subjects         []string `json:"subjects"`
+}
+
+// PersonaDirectory holds the personas the backend recognises. a nil directory recognises no one
+type PersonaDirectory struct {
+	mu       sync.RWMutex
+	personas map[string]Persona
+	order    []string
+}
+
+// NewPersonaDirectory creates a new instance of PersonaDirectory
+func NewPersonaDirectory(personas []Persona) *PersonaDirectory {
+
+	d := &PersonaDirectory{
+		personas: make(map[string]Persona),
+	}
+	for _, persona := range personas {
+		d.personas[persona.UserName] = persona
+		d.order = append(d.order, persona.UserName)
+	}
+	return d
+}
+
+// Find returns the persona with the given user name
+func (d *PersonaDirectory) Find(userName string) (Persona, bool) {
+
+	if d == nil {
+		return Persona{}, false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	persona, ok := d.personas[userName]
+	return persona, ok
+}
+
+// Len returns the number of personas
+func (d *PersonaDirectory) Len() int {
+
+	if d == nil {
+		return 0
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.order)
+}
+
+// Pick returns a persona chosen at random in proportion to the personas' weights
+func (d *PersonaDirectory) Pick() (Persona, bool) {
+
+	if d.Len() == 0 {
+		return Persona{}, false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	totalWeight := 0
+	for _, userName := range d.order {
+		totalWeight += personaWeight(d.personas[userName])
+	}
+	n := rand.Intn(totalWeight)
+	for _, userName := range d.order {
+		n -= personaWeight(d.personas[userName])
+		if n < 0 {
+			return d.personas[userName], true
+		}
+	}
+	return d.personas[d.order[len(d.order)-1]], true
+}
+
+// personaWeight returns the weight of a persona, defaulting to 1
+func personaWeight(persona Persona) int {
+
+	if persona.Weight <= 0 {
+		return 1
+	}
+	return persona.Weight
+}
+
+// RegionOutages holds the regions which are currently unavailable. a nil set holds no regions
+type RegionOutages struct {
+	mu      sync.RWMutex
+	regions map[int]bool
+}
+
+// NewRegionOutages creates a new instance of RegionOutages
+func NewRegionOutages() *RegionOutages {
+
+	return &RegionOutages{
+		regions: make(map[int]bool),
+	}
+}
+
+// Set replaces the regions which are unavailable
+func (o *RegionOutages) Set(regions []int) {
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.regions = make(map[int]bool)
+	for _, region := range regions {
+		o.regions[region] = true
+	}
+}
+
+// IsDown reports whether a region is unavailable
+func (o *RegionOutages) IsDown(region int) bool {
+
+	if o == nil {
+		return false
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.regions[region]
+}
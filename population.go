@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Define constants for the synthetic population. subjects are aged under 90 on the reference date, so the two digit year of their
+// CHI number is not ambiguous
+const (
+	POPULATION_FILE                   = "population.json"
+	DEFAULT_POPULATION_SIZE           = 1000
+	DEFAULT_POPULATION_SEED           = 1
+	DEFAULT_POPULATION_REFERENCE_DATE = "2025-01-01"
+	REFERENCE_DATE_FORMAT             = "2006-01-02"
+	MAX_SUBJECT_AGE                   = 90
+	SEX_FEMALE                        = "female"
+	SEX_MALE                          = "male"
+)
+
+// regionPopulations maps each region to its approximate population in thousands, which home regions are drawn in proportion to
+var regionPopulations = map[int]float64{
+	AYRSHIRE_AND_ARRAN_REGION:        370,
+	BORDERS_REGION:                   116,
+	DUMFRIES_AND_GALLOWAY_REGION:     148,
+	FIFE_REGION:                      374,
+	FORTH_VALLEY_REGION:              306,
+	GRAMPIAN_REGION:                  586,
+	GREATER_GLASGOW_AND_CYLDE_REGION: 1185,
+	HIGHLAND_REGION:                  322,
+	LOTHIAN_REGION:                   916,
+	LANARKSHIRE_REGION:               661,
+	ORKNEY_REGION:                    22,
+	SHETLAND_REGION:                  23,
+	TAYSIDE_REGION:                   417,
+	WESTERN_ISLES_REGION:             27,
+}
+
+// Define the structure of Subject, a member of the synthetic population. regions holds the regions which hold documents about the subject,
+// their home region first
+type Subject struct {
+	SubjectIdentifier string    `json:"subjectIdentifier"`
+	DateOfBirth       time.Time `json:"dateOfBirth"`
+	Sex               string    `json:"sex"`
+	HomeRegion        int       `json:"homeRegion"`
+	Regions           []int     `json:"regions"`
+}
+
+// HoldsDocumentsIn reports whether a region holds documents about the subject
+func (s Subject) HoldsDocumentsIn(region int) bool {
+
+	for _, r := range s.Regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// Define the structure of PopulationFile, a generated population and how it was generated. subjects' dates of birth are drawn
+// relative to the reference date rather than the day it was generated
+type PopulationFile struct {
+	Generated     time.Time `json:"generated"`
+	Seed          int64     `json:"seed"`
+	ReferenceDate time.Time `json:"referenceDate"`
+	Subjects      []Subject `json:"subjects"`
+}
+
+// Population holds the synthetic subjects access attempts and document lookups draw from. a nil population holds no subjects
+type Population struct {
+	file     PopulationFile
+	subjects map[string]Subject
+}
+
+// NewPopulation creates a new instance of Population
+func NewPopulation(populationFile PopulationFile) (*Population, error) {
+
+	p := &Population{
+		file:     populationFile,
+		subjects: make(map[string]Subject),
+	}
+	for i, subject := range populationFile.Subjects {
+		if !isValidCHINumber(subject.SubjectIdentifier) {
+			return nil, fmt.Errorf("subject %d: '%s' is not a valid CHI number", i, subject.SubjectIdentifier)
+		}
+		if _, ok := p.subjects[subject.SubjectIdentifier]; ok {
+			return nil, fmt.Errorf("subject '%s' is listed more than once", subject.SubjectIdentifier)
+		}
+		if !isValidRegion(subject.HomeRegion) || !subject.HoldsDocumentsIn(subject.HomeRegion) {
+			return nil, fmt.Errorf("subject '%s' needs a home region which holds their documents", subject.SubjectIdentifier)
+		}
+		p.subjects[subject.SubjectIdentifier] = subject
+	}
+	return p, nil
+}
+
+// GeneratePopulation generates a population of subjects from a seed, born in the 90 years before a reference date,
+// so the same seed and reference date generate the same population
+func GeneratePopulation(size int, seed int64, referenceDate time.Time) *Population {
+
+	random := rand.New(rand.NewSource(seed))
+	referenceDate = referenceDate.UTC().Truncate(24 * time.Hour)
+	populationFile := PopulationFile{Generated: time.Now().UTC(), Seed: seed, ReferenceDate: referenceDate}
+	generated := make(map[string]bool)
+	for len(populationFile.Subjects) < size {
+		subject := generateSubject(random, referenceDate)
+		if generated[subject.SubjectIdentifier] {
+			continue
+		}
+		generated[subject.SubjectIdentifier] = true
+		populationFile.Subjects = append(populationFile.Subjects, subject)
+	}
+	p, _ := NewPopulation(populationFile)
+	return p
+}
+
+// generateSubject generates a subject, with a home region drawn in proportion to the regions' populations,
+// and documents held by up to three other regions, e.g. where they were treated by a specialist centre or lived before
+func generateSubject(random *rand.Rand, referenceDate time.Time) Subject {
+
+	earliest := referenceDate.AddDate(-MAX_SUBJECT_AGE, 0, 1)
+	dateOfBirth := earliest.Add(time.Duration(random.Int63n(int64(referenceDate.Sub(earliest))))).Truncate(24 * time.Hour)
+	sex := SEX_FEMALE
+	if random.Intn(2) == 0 {
+		sex = SEX_MALE
+	}
+
+	regions := allRegions()
+	weights := make([]float64, len(regions))
+	for i, region := range regions {
+		weights[i] = regionPopulations[region]
+	}
+	homeRegion := regions[pickWeighted(random, weights)]
+	subject := Subject{
+		SubjectIdentifier: generateCHINumber(random, dateOfBirth, sex),
+		DateOfBirth:       dateOfBirth,
+		Sex:               sex,
+		HomeRegion:        homeRegion,
+		Regions:           []int{homeRegion},
+	}
+	for otherRegions := pickWeighted(random, []float64{50, 30, 15, 5}); otherRegions > 0; {
+		region := regions[pickWeighted(random, weights)]
+		if !subject.HoldsDocumentsIn(region) {
+			subject.Regions = append(subject.Regions, region)
+			otherRegions--
+		}
+	}
+	return subject
+}
+
+// generateCHINumber generates a CHI number: the date of birth as DDMMYY, two random digits, a digit which is odd for males
+// and even for females, and a modulus 11 check digit. serial numbers whose check digit would be 10 are not issued, so are drawn again
+func generateCHINumber(random *rand.Rand, dateOfBirth time.Time, sex string) string {
+
+	for {
+		digits := dateOfBirth.Format("020106") + fmt.Sprintf("%02d", random.Intn(100))
+		sexDigit := 2 * random.Intn(5)
+		if sex == SEX_MALE {
+			sexDigit++
+		}
+		digits += strconv.Itoa(sexDigit)
+		if checkDigit, ok := chiCheckDigit(digits); ok {
+			return digits + strconv.Itoa(checkDigit)
+		}
+	}
+}
+
+// chiCheckDigit returns the modulus 11 check digit of the first nine digits of a CHI number, which are weighted 10 down to 2,
+// or false when the check digit would be 10, which is not valid
+func chiCheckDigit(digits string) (int, bool) {
+
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(digits[i]-'0') * (10 - i)
+	}
+	checkDigit := 11 - sum%11
+	switch checkDigit {
+	case 11:
+		return 0, true
+	case 10:
+		return 0, false
+	}
+	return checkDigit, true
+}
+
+// isValidCHINumber reports whether a subject identifier is 10 digits starting with a valid date, with a valid check digit
+func isValidCHINumber(subjectIdentifier string) bool {
+
+	if validateSubjectIdentifier(subjectIdentifier) != nil {
+		return false
+	}
+	if _, err := time.Parse("020106", subjectIdentifier[:6]); err != nil {
+		return false
+	}
+	checkDigit, ok := chiCheckDigit(subjectIdentifier)
+	return ok && int(subjectIdentifier[9]-'0') == checkDigit
+}
+
+// LoadPopulation loads a population file
+func LoadPopulation(filename string) (*Population, error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var populationFile PopulationFile
+	if err := json.Unmarshal(data, &populationFile); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal population file: %s, %v", filename, err)
+	}
+	return NewPopulation(populationFile)
+}
+
+// loadOrGeneratePopulation loads the population file named by a command's --population flag. no population is returned when
+// no file is named, and when the default population file does not exist, the default population is generated and saved to it
+func loadOrGeneratePopulation(filename string) (*Population, error) {
+
+	if filename == "" {
+		return nil, nil
+	}
+	p, err := LoadPopulation(filename)
+	if errors.Is(err, os.ErrNotExist) && filename == POPULATION_FILE {
+		referenceDate, err := time.Parse(REFERENCE_DATE_FORMAT, DEFAULT_POPULATION_REFERENCE_DATE)
+		if err != nil {
+			return nil, err
+		}
+		p = GeneratePopulation(DEFAULT_POPULATION_SIZE, DEFAULT_POPULATION_SEED, referenceDate)
+		if err := p.Save(filename); err != nil {
+			return nil, err
+		}
+		fmt.Printf("generated a population of %d subjects to %s\n", p.Len(), filename)
+		return p, nil
+	}
+	return p, err
+}
+
+// Save writes the population to a file
+func (p *Population) Save(filename string) error {
+
+	data, err := json.MarshalIndent(p.file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal population: %v", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write population file: %v", err)
+	}
+	return nil
+}
+
+// Len returns the number of subjects
+func (p *Population) Len() int {
+
+	if p == nil {
+		return 0
+	}
+	return len(p.file.Subjects)
+}
+
+// Subjects returns every subject, in the order they were generated
+func (p *Population) Subjects() []Subject {
+
+	if p == nil {
+		return nil
+	}
+	return p.file.Subjects
+}
+
+// Find returns the subject with the given identifier
+func (p *Population) Find(subjectIdentifier string) (Subject, bool) {
+
+	if p == nil {
+		return Subject{}, false
+	}
+	subject, ok := p.subjects[subjectIdentifier]
+	return subject, ok
+}
+
+// ReferenceDate returns the date the population was generated as of, which its subjects' documents are looked up as of too
+func (p *Population) ReferenceDate() time.Time {
+
+	if p == nil {
+		return time.Time{}
+	}
+	return p.file.ReferenceDate
+}
+
+// Pick returns a subject chosen at random
+func (p *Population) Pick() (Subject, bool) {
+
+	if p.Len() == 0 {
+		return Subject{}, false
+	}
+	return p.file.Subjects[rand.Intn(len(p.file.Subjects))], true
+}
+
+// CountByRegion returns the number of subjects whose home is each region
+func (p *Population) CountByRegion() map[int]int {
+
+	counts := make(map[int]int)
+	for _, subject := range p.Subjects() {
+		counts[subject.HomeRegion]++
+	}
+	return counts
+}
+
+// lookupSubjectRegionDocuments returns the documents a region holds about a subject. a subject of the population only has documents
+// in the regions of their document history, dated by the population's reference date so they never change, and any other subject
+// may have documents in every region, dated up to today
+func (b *Backend) lookupSubjectRegionDocuments(subjectIdentifier string, region int) []SubjectRegionDocument {
+
+	subject, ok := b.Population.Find(subjectIdentifier)
+	if !ok {
+		return b.Catalogue.Documents(subjectIdentifier, region, time.Now())
+	}
+	if !subject.HoldsDocumentsIn(region) {
+		return nil
+	}
+	return b.Catalogue.Documents(subjectIdentifier, region, b.Population.ReferenceDate())
+}
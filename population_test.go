@@ -0,0 +1,150 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestCHICheckDigit checks the modulus 11 check digit of the first nine digits, where a remainder of 0 is check digit 0
+// and a check digit of 10 is not valid
+func TestCHICheckDigit(t *testing.T) {
+
+	tests := []struct {
+		digits     string
+		checkDigit int
+		ok         bool
+	}{
+		{"010170000", 8, true},
+		{"181113540", 4, true},
+		{"010170005", 9, true},
+		{"010170004", 0, true},
+		{"010170013", 0, false},
+		{"010170027", 0, false},
+	}
+	for _, test := range tests {
+		if checkDigit, ok := chiCheckDigit(test.digits); checkDigit != test.checkDigit || ok != test.ok {
+			t.Errorf("%s: check digit %d, %v, want %d, %v", test.digits, checkDigit, ok, test.checkDigit, test.ok)
+		}
+	}
+}
+
+// TestIsValidCHINumber checks a CHI number is 10 digits starting with a valid date, ending in its check digit
+func TestIsValidCHINumber(t *testing.T) {
+
+	tests := []struct {
+		name              string
+		subjectIdentifier string
+		valid             bool
+	}{
+		{"valid", "0101700008", true},
+		{"valid, check digit 0", "0101700040", true},
+		{"valid, leap day", "2902800002", true},
+		{"wrong check digit", "0101700009", false},
+		{"not a date", "3201700002", false},
+		{"leap day of a year which is not a leap year", "2902810008", false},
+		{"too short", "010170000", false},
+		{"too long", "01017000080", false},
+		{"not digits", "01017000a8", false},
+		{"empty", "", false},
+	}
+	for _, test := range tests {
+		if valid := isValidCHINumber(test.subjectIdentifier); valid != test.valid {
+			t.Errorf("%s: %s valid %v, want %v", test.name, test.subjectIdentifier, valid, test.valid)
+		}
+	}
+
+	// no check digit is valid for a serial number whose check digit would be 10
+	for checkDigit := 0; checkDigit <= 9; checkDigit++ {
+		if subjectIdentifier := "010170013" + strconv.Itoa(checkDigit); isValidCHINumber(subjectIdentifier) {
+			t.Errorf("%s valid, want no check digit valid when it would be 10", subjectIdentifier)
+		}
+	}
+}
+
+// TestGeneratePopulationDeterministic checks the same size, seed and reference date generate the same valid subjects, born in the 90 years
+// before the reference date, which is kept when the population is saved and loaded
+func TestGeneratePopulationDeterministic(t *testing.T) {
+
+	referenceDate := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := GeneratePopulation(200, 7, referenceDate)
+	if p.Len() != 200 {
+		t.Fatalf("%d subjects, want 200", p.Len())
+	}
+	if again := GeneratePopulation(200, 7, referenceDate); !reflect.DeepEqual(p.Subjects(), again.Subjects()) {
+		t.Error("subjects differ between populations of the same seed and reference date")
+	}
+	if later := GeneratePopulation(200, 7, referenceDate.AddDate(1, 0, 0)); reflect.DeepEqual(p.Subjects(), later.Subjects()) {
+		t.Error("subjects the same for another reference date")
+	}
+
+	earliest := referenceDate.AddDate(-MAX_SUBJECT_AGE, 0, 0)
+	for _, subject := range p.Subjects() {
+		if !isValidCHINumber(subject.SubjectIdentifier) || subject.SubjectIdentifier[:6] != subject.DateOfBirth.Format("020106") {
+			t.Errorf("%s: not a valid CHI number of date of birth %v", subject.SubjectIdentifier, subject.DateOfBirth)
+		}
+		if !subject.DateOfBirth.After(earliest) || subject.DateOfBirth.After(referenceDate) {
+			t.Errorf("%s: born %v, want in the %d years before %v", subject.SubjectIdentifier, subject.DateOfBirth, MAX_SUBJECT_AGE, referenceDate)
+		}
+		if subjectSex(subject.SubjectIdentifier) != subject.Sex {
+			t.Errorf("%s: sex %s, want it of the ninth digit", subject.SubjectIdentifier, subject.Sex)
+		}
+		if len(subject.Regions) == 0 || subject.Regions[0] != subject.HomeRegion {
+			t.Errorf("%s: regions %v, want the home region %d first", subject.SubjectIdentifier, subject.Regions, subject.HomeRegion)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), POPULATION_FILE)
+	if err := p.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadPopulation(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.file.ReferenceDate.Equal(referenceDate) || !reflect.DeepEqual(loaded.Subjects(), p.Subjects()) {
+		t.Errorf("loaded reference date %v and %d subjects, want %v and the subjects saved", loaded.file.ReferenceDate, loaded.Len(), referenceDate)
+	}
+}
+
+// TestLookupSubjectRegionDocuments checks a subject of the population has documents only in the regions of their history, dated by
+// the population's reference date, while any other subject may have documents in every region
+func TestLookupSubjectRegionDocuments(t *testing.T) {
+
+	referenceDate := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBackend()
+	b.Population = GeneratePopulation(20, 7, referenceDate)
+	if !b.Population.ReferenceDate().Equal(referenceDate) {
+		t.Fatalf("reference date %v, want %v", b.Population.ReferenceDate(), referenceDate)
+	}
+
+	for _, subject := range b.Population.Subjects() {
+		if dateOfBirth := subjectDateOfBirth(subject.SubjectIdentifier, referenceDate); !dateOfBirth.Equal(subject.DateOfBirth) {
+			t.Errorf("%s: catalogue date of birth %v, want %v", subject.SubjectIdentifier, dateOfBirth, subject.DateOfBirth)
+		}
+		for _, region := range allRegions() {
+			documents := b.lookupSubjectRegionDocuments(subject.SubjectIdentifier, region)
+			if !subject.HoldsDocumentsIn(region) {
+				if len(documents) != 0 {
+					t.Errorf("%s: %d documents in %s, outside their history", subject.SubjectIdentifier, len(documents), regionName(region))
+				}
+				continue
+			}
+			if want := b.Catalogue.Documents(subject.SubjectIdentifier, region, referenceDate); !reflect.DeepEqual(documents, want) {
+				t.Errorf("%s in %s: documents differ from those as of the population's reference date", subject.SubjectIdentifier, regionName(region))
+			}
+		}
+	}
+
+	documentRegions := 0
+	for _, region := range allRegions() {
+		if len(b.lookupSubjectRegionDocuments("0101700000", region)) > 0 {
+			documentRegions++
+		}
+	}
+	if documentRegions < 2 {
+		t.Errorf("documents in %d regions for a subject outside the population, want them in any region", documentRegions)
+	}
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Define constants for protobuf wire types
+const (
+	PROTOBUF_VARINT  = 0
+	PROTOBUF_FIXED64 = 1
+	PROTOBUF_BYTES   = 2
+	PROTOBUF_FIXED32 = 5
+)
+
+// ProtobufCodec encodes payloads as Protocol Buffers, taking each field's number from its protobuf struct tag,
+// e.g. `protobuf:"1"`. ints are int64, times are google.protobuf.Timestamp messages and, as in proto3,
+// fields holding their zero value are not written. schema proto prints the matching .proto definitions
+type ProtobufCodec struct{}
+
+// Name returns the name of the codec
+func (ProtobufCodec) Name() string {
+
+	return "protobuf"
+}
+
+// ContentType returns the content type of the codec
+func (ProtobufCodec) ContentType() string {
+
+	return PROTOBUF_CONTENT_TYPE
+}
+
+// Marshal encodes a payload, which must be a struct or a pointer to one
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("failed to encode protobuf: expected a struct, got %T", v)
+	}
+	data, err := appendProtobufMessage(nil, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode protobuf: %v", err)
+	}
+	return data, nil
+}
+
+// Unmarshal decodes a payload into the struct v points to. fields whose number matches no struct field are skipped
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() || target.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("failed to decode protobuf: expected a pointer to a struct, got %T", v)
+	}
+	target.Elem().Set(reflect.Zero(target.Elem().Type()))
+	if err := decodeProtobufMessage(data, target.Elem()); err != nil {
+		return fmt.Errorf("failed to decode protobuf: %v", err)
+	}
+	return nil
+}
+
+// protobufFieldNumber returns the field number in a struct field's protobuf tag
+func protobufFieldNumber(field reflect.StructField) (int, bool) {
+
+	number, err := strconv.Atoi(field.Tag.Get("protobuf"))
+	return number, err == nil && number > 0
+}
+
+// protobufFields returns the fields of a struct type by field number, failing if an exported field has no number
+func protobufFields(t reflect.Type) (map[int]int, error) {
+
+	fields := make(map[int]int)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, _, ok := jsonFieldName(field); !ok {
+			continue
+		}
+		number, ok := protobufFieldNumber(field)
+		if !ok {
+			return nil, fmt.Errorf("%s.%s has no protobuf field number", t.Name(), field.Name)
+		}
+		if _, duplicate := fields[number]; duplicate {
+			return nil, fmt.Errorf("%s.%s reuses protobuf field number %d", t.Name(), field.Name, number)
+		}
+		fields[number] = i
+	}
+	return fields, nil
+}
+
+// isProtobufPackable reports whether repeated values of a type are written packed, as one length-delimited field
+func isProtobufPackable(t reflect.Type) bool {
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// appendProtobufTag appends a field's number and wire type
+func appendProtobufTag(b []byte, number int, wireType int) []byte {
+
+	return binary.AppendUvarint(b, uint64(number)<<3|uint64(wireType))
+}
+
+// appendProtobufBytes appends a length-delimited field
+func appendProtobufBytes(b []byte, number int, data []byte) []byte {
+
+	b = appendProtobufTag(b, number, PROTOBUF_BYTES)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// appendProtobufMessage appends the fields of a struct
+func appendProtobufMessage(b []byte, v reflect.Value) ([]byte, error) {
+
+	if v.Type() == timeType {
+		// google.protobuf.Timestamp
+		t := v.Interface().(time.Time)
+		if seconds := t.Unix(); seconds != 0 {
+			b = appendProtobufTag(b, 1, PROTOBUF_VARINT)
+			b = binary.AppendUvarint(b, uint64(seconds))
+		}
+		if nanos := t.Nanosecond(); nanos != 0 {
+			b = appendProtobufTag(b, 2, PROTOBUF_VARINT)
+			b = binary.AppendUvarint(b, uint64(nanos))
+		}
+		return b, nil
+	}
+
+	fields, err := protobufFields(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]int, 0, len(fields))
+	for number := range fields {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	for _, number := range numbers {
+		if b, err = appendProtobufField(b, number, v.Field(fields[number]), false); err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", v.Type().Name(), v.Type().Field(fields[number]).Name, err)
+		}
+	}
+	return b, nil
+}
+
+// appendProtobufField appends a field, omitting it when it holds its zero value unless it is always written,
+// as elements of repeated fields and values pointed to by optional fields are
+func appendProtobufField(b []byte, number int, v reflect.Value, always bool) ([]byte, error) {
+
+	if v.Type() == timeType {
+		if v.Interface().(time.Time).IsZero() && !always {
+			return b, nil
+		}
+		message, err := appendProtobufMessage(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		return appendProtobufBytes(b, number, message), nil
+	}
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Struct && v.IsZero() && !always {
+		return b, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return b, nil
+		}
+		return appendProtobufField(b, number, v.Elem(), true)
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		b = appendProtobufTag(b, number, PROTOBUF_VARINT)
+		return appendProtobufScalar(b, v), nil
+	case reflect.Float32:
+		b = appendProtobufTag(b, number, PROTOBUF_FIXED32)
+		return appendProtobufScalar(b, v), nil
+	case reflect.Float64:
+		b = appendProtobufTag(b, number, PROTOBUF_FIXED64)
+		return appendProtobufScalar(b, v), nil
+	case reflect.String:
+		return appendProtobufBytes(b, number, []byte(v.String())), nil
+	case reflect.Struct:
+		message, err := appendProtobufMessage(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		return appendProtobufBytes(b, number, message), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendProtobufBytes(b, number, v.Bytes()), nil
+		}
+		if isProtobufPackable(v.Type().Elem()) {
+			var packed []byte
+			for i := 0; i < v.Len(); i++ {
+				packed = appendProtobufScalar(packed, v.Index(i))
+			}
+			return appendProtobufBytes(b, number, packed), nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			var err error
+			if b, err = appendProtobufField(b, number, v.Index(i), true); err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// appendProtobufScalar appends the value of a varint, fixed32 or fixed64 field, without its tag
+func appendProtobufScalar(b []byte, v reflect.Value) []byte {
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// negative ints are written as ten byte two's complement varints, as protobuf int64 fields are
+		return binary.AppendUvarint(b, uint64(v.Int()))
+	case reflect.Float32:
+		return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float()))
+	}
+	return binary.AppendUvarint(b, v.Uint())
+}
+
+// protobufReader reads the fields of a message
+type protobufReader struct {
+	data []byte
+	pos  int
+}
+
+// varint reads a varint
+func (r *protobufReader) varint() (uint64, error) {
+
+	u, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid varint at byte %d", r.pos)
+	}
+	r.pos += n
+	return u, nil
+}
+
+// bytes reads n bytes
+func (r *protobufReader) bytes(n uint64) ([]byte, error) {
+
+	if uint64(len(r.data)-r.pos) < n {
+		return nil, fmt.Errorf("unexpected end of data at byte %d", r.pos)
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// field reads the next field's number, wire type and value, which is held in either u or data depending on the wire type
+func (r *protobufReader) field() (int, int, uint64, []byte, error) {
+
+	tag, err := r.varint()
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	number, wireType := int(tag>>3), int(tag&7)
+
+	var u uint64
+	var data []byte
+	switch wireType {
+	case PROTOBUF_VARINT:
+		u, err = r.varint()
+	case PROTOBUF_FIXED64:
+		data, err = r.bytes(8)
+		if err == nil {
+			u = binary.LittleEndian.Uint64(data)
+		}
+	case PROTOBUF_BYTES:
+		var n uint64
+		if n, err = r.varint(); err == nil {
+			data, err = r.bytes(n)
+		}
+	case PROTOBUF_FIXED32:
+		data, err = r.bytes(4)
+		if err == nil {
+			u = uint64(binary.LittleEndian.Uint32(data))
+		}
+	default:
+		err = fmt.Errorf("unsupported wire type %d of field %d", wireType, number)
+	}
+	return number, wireType, u, data, err
+}
+
+// decodeProtobufMessage decodes the fields of a message into a struct
+func decodeProtobufMessage(data []byte, target reflect.Value) error {
+
+	r := &protobufReader{data: data}
+
+	if target.Type() == timeType {
+		var seconds, nanos int64
+		for r.pos < len(r.data) {
+			number, _, u, _, err := r.field()
+			if err != nil {
+				return err
+			}
+			switch number {
+			case 1:
+				seconds = int64(u)
+			case 2:
+				nanos = int64(u)
+			}
+		}
+		target.Set(reflect.ValueOf(time.Unix(seconds, nanos).UTC()))
+		return nil
+	}
+
+	fields, err := protobufFields(target.Type())
+	if err != nil {
+		return err
+	}
+	for r.pos < len(r.data) {
+		number, wireType, u, fieldData, err := r.field()
+		if err != nil {
+			return err
+		}
+		i, ok := fields[number]
+		if !ok {
+			// a field added by a newer version of the payload
+			continue
+		}
+		if err := decodeProtobufField(target.Field(i), wireType, u, fieldData); err != nil {
+			return fmt.Errorf("%s.%s: %v", target.Type().Name(), target.Type().Field(i).Name, err)
+		}
+	}
+	return nil
+}
+
+// decodeProtobufField stores a field's value in a Go value, appending to it when it is repeated
+func decodeProtobufField(target reflect.Value, wireType int, u uint64, data []byte) error {
+
+	if target.Type() == timeType || target.Kind() == reflect.Struct {
+		if wireType != PROTOBUF_BYTES {
+			return fmt.Errorf("wire type %d cannot hold a message", wireType)
+		}
+		return decodeProtobufMessage(data, target)
+	}
+
+	switch target.Kind() {
+	case reflect.Ptr:
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return decodeProtobufField(target.Elem(), wireType, u, data)
+	case reflect.String:
+		if wireType == PROTOBUF_BYTES {
+			target.SetString(string(data))
+			return nil
+		}
+	case reflect.Slice:
+		element := target.Type().Elem()
+		if element.Kind() == reflect.Uint8 {
+			if wireType == PROTOBUF_BYTES {
+				target.SetBytes(append([]byte(nil), data...))
+				return nil
+			}
+			break
+		}
+		if isProtobufPackable(element) && wireType == PROTOBUF_BYTES {
+			// packed scalars
+			r := &protobufReader{data: data}
+			for r.pos < len(r.data) {
+				value := reflect.New(element).Elem()
+				if err := decodeProtobufPackedScalar(r, value); err != nil {
+					return err
+				}
+				target.Set(reflect.Append(target, value))
+			}
+			return nil
+		}
+		value := reflect.New(element).Elem()
+		if err := decodeProtobufField(value, wireType, u, data); err != nil {
+			return err
+		}
+		target.Set(reflect.Append(target, value))
+		return nil
+	default:
+		if isProtobufPackable(target.Type()) && wireType == protobufScalarWireType(target.Type()) {
+			return setProtobufScalar(target, u)
+		}
+	}
+	return fmt.Errorf("wire type %d cannot be decoded into %s", wireType, target.Type())
+}
+
+// protobufScalarWireType returns the wire type of a scalar type
+func protobufScalarWireType(t reflect.Type) int {
+
+	switch t.Kind() {
+	case reflect.Float32:
+		return PROTOBUF_FIXED32
+	case reflect.Float64:
+		return PROTOBUF_FIXED64
+	}
+	return PROTOBUF_VARINT
+}
+
+// decodeProtobufPackedScalar reads one value of a packed repeated field
+func decodeProtobufPackedScalar(r *protobufReader, target reflect.Value) error {
+
+	switch protobufScalarWireType(target.Type()) {
+	case PROTOBUF_FIXED32:
+		data, err := r.bytes(4)
+		if err != nil {
+			return err
+		}
+		return setProtobufScalar(target, uint64(binary.LittleEndian.Uint32(data)))
+	case PROTOBUF_FIXED64:
+		data, err := r.bytes(8)
+		if err != nil {
+			return err
+		}
+		return setProtobufScalar(target, binary.LittleEndian.Uint64(data))
+	}
+	u, err := r.varint()
+	if err != nil {
+		return err
+	}
+	return setProtobufScalar(target, u)
+}
+
+// setProtobufScalar stores the raw value of a varint, fixed32 or fixed64 field in a Go value
+func setProtobufScalar(target reflect.Value, u uint64) error {
+
+	switch target.Kind() {
+	case reflect.Bool:
+		target.SetBool(u != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if target.OverflowInt(int64(u)) {
+			return fmt.Errorf("%d overflows %s", int64(u), target.Type())
+		}
+		target.SetInt(int64(u))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if target.OverflowUint(u) {
+			return fmt.Errorf("%d overflows %s", u, target.Type())
+		}
+		target.SetUint(u)
+	case reflect.Float32:
+		target.SetFloat(float64(math.Float32frombits(uint32(u))))
+	case reflect.Float64:
+		target.SetFloat(math.Float64frombits(u))
+	}
+	return nil
+}
+
+// generateProtoFile generates the proto3 definitions of every topic's payload type, which ProtobufCodec writes
+func generateProtoFile() (string, error) {
+
+	var messages []string
+	defined := make(map[reflect.Type]bool)
+	usesTimestamp := false
+
+	var define func(t reflect.Type) error
+	define = func(t reflect.Type) error {
+
+		if defined[t] {
+			return nil
+		}
+		defined[t] = true
+		fields, err := protobufFields(t)
+		if err != nil {
+			return err
+		}
+		numbers := make([]int, 0, len(fields))
+		for number := range fields {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "message %s {\n", t.Name())
+		for _, number := range numbers {
+			field := t.Field(fields[number])
+			name, _, _ := jsonFieldName(field)
+			fieldType, nested, err := protoFieldType(field.Type)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %v", t.Name(), field.Name, err)
+			}
+			if nested == timeType {
+				usesTimestamp = true
+			} else if nested != nil {
+				if err := define(nested); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(&sb, "  %s %s = %d;\n", fieldType, protoFieldName(name), number)
+		}
+		sb.WriteString("}\n")
+		messages = append(messages, sb.String())
+		return nil
+	}
+
+	for _, topicDefinition := range topicDefinitions() {
+		if err := define(reflect.TypeOf(topicDefinition.NewPayload()).Elem()); err != nil {
+			return "", err
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("syntax = \"proto3\";\n\npackage msdemo;\n\n")
+	if usesTimestamp {
+		sb.WriteString("import \"google/protobuf/timestamp.proto\";\n\n")
+	}
+	sb.WriteString(strings.Join(messages, "\n"))
+	return sb.String(), nil
+}
+
+// protoFieldType returns the proto3 type of a Go type, and the struct type of a nested message
+func protoFieldType(t reflect.Type) (string, reflect.Type, error) {
+
+	switch {
+	case t == timeType:
+		return "google.protobuf.Timestamp", timeType, nil
+	case t.Kind() == reflect.Ptr:
+		fieldType, nested, err := protoFieldType(t.Elem())
+		return "optional " + fieldType, nested, err
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return "bytes", nil, nil
+	case t.Kind() == reflect.Slice:
+		fieldType, nested, err := protoFieldType(t.Elem())
+		return "repeated " + fieldType, nested, err
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool", nil, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int64", nil, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "uint64", nil, nil
+	case reflect.Float32:
+		return "float", nil, nil
+	case reflect.Float64:
+		return "double", nil, nil
+	case reflect.String:
+		return "string", nil, nil
+	case reflect.Struct:
+		return t.Name(), t, nil
+	}
+	return "", nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// protoFieldName converts a JSON field name to snake case, from which protobuf derives the same JSON name
+func protoFieldName(name string) string {
+
+	var sb strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
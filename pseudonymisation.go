@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Define constants for pseudonyms, which are the prefix followed by a truncated keyed HMAC of the kind of identifier and its value
+const (
+	PSEUDONYM_PREFIX                  = "psn_"
+	PSEUDONYM_SIZE                    = 16
+	USER_NAME_PSEUDONYM_KIND          = "userName"
+	SUBJECT_IDENTIFIER_PSEUDONYM_KIND = "subjectIdentifier"
+)
+
+// Define the file listing the users authorised to re-identify pseudonyms when none is given
+const REIDENTIFICATION_AUTHORISATION_FILE = "reidentification.json"
+
+// Define the structure of PseudonymVaultEntry, which records the identifier a pseudonym was derived from, so an authorised user can re-identify it
+type PseudonymVaultEntry struct {
+	Pseudonym string `json:"pseudonym" protobuf:"1"`
+	Kind      string `json:"kind" protobuf:"2"`
+	Value     string `json:"value" protobuf:"3" encrypt:"true"`
+}
+
+// Pseudonymiser derives stable pseudonyms from identifiers with a keyed HMAC, recording each new pseudonym in the vault topic
+type Pseudonymiser struct {
+	key     []byte
+	mu      sync.Mutex
+	vaulted map[string]bool // the pseudonyms in the vault topic, or nil until it has been read
+}
+
+// NewPseudonymiser creates a new instance of Pseudonymiser
+func NewPseudonymiser(key []byte) *Pseudonymiser {
+
+	return &Pseudonymiser{
+		key: key,
+	}
+}
+
+// Pseudonym returns the pseudonym of an identifier of a kind, or an empty string for an empty identifier.
+// the kind is part of the HMAC, so a user name and a subject identifier with the same value have different pseudonyms
+func (p *Pseudonymiser) Pseudonym(kind, value string) string {
+
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return PSEUDONYM_PREFIX + hex.EncodeToString(mac.Sum(nil)[:PSEUDONYM_SIZE])
+}
+
+// pseudonymise returns the pseudonym of an identifier, first recording it in the vault topic if it is not there yet
+func (b *Backend) pseudonymise(ctx context.Context, kind, value string) (string, error) {
+
+	pseudonym := b.Pseudonymiser.Pseudonym(kind, value)
+	if pseudonym == "" {
+		return "", nil
+	}
+
+	p := b.Pseudonymiser
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.vaulted == nil {
+		vaulted, err := b.readPseudonymVault()
+		if err != nil {
+			return "", err
+		}
+		p.vaulted = make(map[string]bool)
+		for _, vaultEntry := range vaulted {
+			p.vaulted[vaultEntry.Pseudonym] = true
+		}
+	}
+	if p.vaulted[pseudonym] {
+		return pseudonym, nil
+	}
+	if err := b.sendPseudonymVaultEntry(ctx, PseudonymVaultEntry{Pseudonym: pseudonym, Kind: kind, Value: value}); err != nil {
+		return "", err
+	}
+	p.vaulted[pseudonym] = true
+	return pseudonym, nil
+}
+
+// sendPseudonymVaultEntry sends a pseudonym vault entry to the vault topic
+func (b *Backend) sendPseudonymVaultEntry(ctx context.Context, pseudonymVaultEntry PseudonymVaultEntry) error {
+
+	topic := PSEUDONYM_VAULT_TOPIC
+
+	ctx, span := b.Tracer.Start(ctx, "sendPseudonymVaultEntry", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(pseudonymVaultEntry)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to marshal pseudonym vault entry: %v", err)
+	}
+
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, pseudonymVaultEntry.Pseudonym, codec, payload)
+
+	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("SaveEntry for topic '%s' failed: %v", topic, err)
+	}
+	span.SetAttribute("messaging.message.offset", offset)
+	return nil
+}
+
+// readPseudonymVault reads every entry of the vault topic, with the identifiers still encrypted
+func (b *Backend) readPseudonymVault() ([]PseudonymVaultEntry, error) {
+
+	var vaulted []PseudonymVaultEntry
+	store := asBatchMessageStore(b.MessageStore)
+	for offset := int64(0); ; {
+		entries, err := store.ReadEntries(PSEUDONYM_VAULT_TOPIC, offset, 100)
+		if err != nil || len(entries) == 0 {
+			// the end of the topic has been reached
+			return vaulted, nil
+		}
+		for _, entry := range entries {
+			var vaultEntry PseudonymVaultEntry
+			if err := decodeMessageEnvelope(entry).DecodePayload(&vaultEntry); err != nil {
+				return nil, fmt.Errorf("failed to decode entry at offset %d of topic '%s': %v", offset, PSEUDONYM_VAULT_TOPIC, err)
+			}
+			vaulted = append(vaulted, vaultEntry)
+			offset++
+		}
+	}
+}
+
+// Define the structure of ReidentificationAuthorisations, the users who may re-identify pseudonyms
+type ReidentificationAuthorisations struct {
+	AuthorisedUsers []string `json:"authorisedUsers"`
+}
+
+// LoadReidentificationAuthorisations loads a re-identification authorisation file
+func LoadReidentificationAuthorisations(filename string) (*ReidentificationAuthorisations, error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var a ReidentificationAuthorisations
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal re-identification authorisation file: %s, %v", filename, err)
+	}
+	return &a, nil
+}
+
+// IsAuthorised reports whether a user may re-identify pseudonyms. nil authorisations authorise no one
+func (a *ReidentificationAuthorisations) IsAuthorised(userName string) bool {
+
+	if a == nil {
+		return false
+	}
+	for _, authorisedUser := range a.AuthorisedUsers {
+		if authorisedUser == userName {
+			return true
+		}
+	}
+	return false
+}
+
+// Reidentify returns the vault entry a pseudonym was derived from, with its identifier in the clear, provided the user is authorised and gives
+// a reason. every attempt, whether granted, refused or for an unknown pseudonym, is recorded as a system audit event
+func (b *Backend) Reidentify(ctx context.Context, authorisations *ReidentificationAuthorisations, userName, reason, pseudonym string) (PseudonymVaultEntry, error) {
+
+	ctx, span := b.Tracer.Start(ctx, "reidentify", SPAN_KIND_INTERNAL)
+	defer span.End()
+
+	audit := func(subjectIdentifier, outcome string) {
+		auditEvent := fmt.Sprintf("pseudonym %s re-identification %s, reason: %s", pseudonym, outcome, reason)
+		b.sendSystemAuditEvent(ctx, *NewSystemAuditEventWithSubject(userName, subjectIdentifier, auditEvent))
+	}
+
+	if !authorisations.IsAuthorised(userName) {
+		audit("", "refused")
+		return PseudonymVaultEntry{}, fmt.Errorf("user '%s' is not authorised to re-identify pseudonyms", userName)
+	}
+	if strings.TrimSpace(reason) == "" {
+		audit("", "refused")
+		return PseudonymVaultEntry{}, fmt.Errorf("a reason is required to re-identify a pseudonym")
+	}
+
+	vaultEntry, err := b.findPseudonymVaultEntry(pseudonym)
+	if err != nil {
+		span.RecordError(err)
+		audit("", "failed")
+		return PseudonymVaultEntry{}, err
+	}
+	subjectIdentifier := ""
+	if vaultEntry.Kind == SUBJECT_IDENTIFIER_PSEUDONYM_KIND {
+		subjectIdentifier = vaultEntry.Value
+	}
+	audit(subjectIdentifier, "granted")
+	return vaultEntry, nil
+}
+
+// findPseudonymVaultEntry reads the vault topic for the entry of a pseudonym, decrypting its identifier
+func (b *Backend) findPseudonymVaultEntry(pseudonym string) (PseudonymVaultEntry, error) {
+
+	end := topicLength(b.MessageStore, PSEUDONYM_VAULT_TOPIC)
+	for offset := int64(0); offset < end; offset++ {
+		entry, err := b.MessageStore.ReadEntry(PSEUDONYM_VAULT_TOPIC, offset)
+		if err != nil || entry == nil {
+			continue
+		}
+		if string(entry.Key) != pseudonym {
+			continue
+		}
+		decrypted, err := b.Encryption.DecryptEntry(PSEUDONYM_VAULT_TOPIC, *entry)
+		if err != nil {
+			return PseudonymVaultEntry{}, err
+		}
+		var vaultEntry PseudonymVaultEntry
+		if err := decodeMessageEnvelope(decrypted).DecodePayload(&vaultEntry); err != nil {
+			return PseudonymVaultEntry{}, fmt.Errorf("failed to decode entry at offset %d of topic '%s': %v", offset, PSEUDONYM_VAULT_TOPIC, err)
+		}
+		if vaultEntry.Pseudonym == pseudonym {
+			return vaultEntry, nil
+		}
+	}
+	return PseudonymVaultEntry{}, fmt.Errorf("unknown pseudonym '%s'", pseudonym)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newPseudonymisingTestBackend creates a backend whose store is in memory and encrypts fields, with a keyring loaded
+func newPseudonymisingTestBackend(t *testing.T) *Backend {
+
+	t.Helper()
+	b := NewBackend()
+	b.Log = io.Discard
+	b.MessageStore = NewEncryptingMessageStore(newMemoryMessageStore(), b.Encryption)
+	b.useKeyring(newTestKeyring(t))
+	return b
+}
+
+// readSystemAuditEvents returns the system audit events saved from an offset, decrypted
+func readSystemAuditEvents(t *testing.T, b *Backend, from int64) []SystemAuditEvent {
+
+	t.Helper()
+	var systemAuditEvents []SystemAuditEvent
+	for offset := from; offset < topicLength(b.MessageStore, SYSTEM_AUDIT_EVENT_TOPIC); offset++ {
+		entry, err := b.MessageStore.ReadEntry(SYSTEM_AUDIT_EVENT_TOPIC, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := b.Encryption.DecryptEntry(SYSTEM_AUDIT_EVENT_TOPIC, *entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var systemAuditEvent SystemAuditEvent
+		if err := decodeMessageEnvelope(decrypted).DecodePayload(&systemAuditEvent); err != nil {
+			t.Fatal(err)
+		}
+		systemAuditEvents = append(systemAuditEvents, systemAuditEvent)
+	}
+	return systemAuditEvents
+}
+
+// TestPseudonym checks pseudonyms are stable for a key, differ between keys and between kinds of identifier, and are not derived from nothing
+func TestPseudonym(t *testing.T) {
+
+	key := []byte(strings.Repeat("k", KEY_SIZE))
+	p := NewPseudonymiser(key)
+	pseudonym := p.Pseudonym(SUBJECT_IDENTIFIER_PSEUDONYM_KIND, "1234567890")
+	if !strings.HasPrefix(pseudonym, PSEUDONYM_PREFIX) || len(pseudonym) != len(PSEUDONYM_PREFIX)+2*PSEUDONYM_SIZE {
+		t.Errorf("pseudonym %q, want %s followed by %d hex digits", pseudonym, PSEUDONYM_PREFIX, 2*PSEUDONYM_SIZE)
+	}
+
+	tests := []struct {
+		name  string
+		p     *Pseudonymiser
+		kind  string
+		value string
+		same  bool
+	}{
+		{"same identifier", p, SUBJECT_IDENTIFIER_PSEUDONYM_KIND, "1234567890", true},
+		{"same key, new pseudonymiser", NewPseudonymiser(append([]byte(nil), key...)), SUBJECT_IDENTIFIER_PSEUDONYM_KIND, "1234567890", true},
+		{"other identifier", p, SUBJECT_IDENTIFIER_PSEUDONYM_KIND, "1234567891", false},
+		{"other kind", p, USER_NAME_PSEUDONYM_KIND, "1234567890", false},
+		{"other key", NewPseudonymiser([]byte(strings.Repeat("j", KEY_SIZE))), SUBJECT_IDENTIFIER_PSEUDONYM_KIND, "1234567890", false},
+		// the kind and value are separated, so moving characters between them changes the pseudonym
+		{"shifted boundary", p, SUBJECT_IDENTIFIER_PSEUDONYM_KIND + "1", "234567890", false},
+	}
+	for _, test := range tests {
+		if got := test.p.Pseudonym(test.kind, test.value); (got == pseudonym) != test.same {
+			t.Errorf("%s: pseudonym %q, same as %q is %v, want %v", test.name, got, pseudonym, got == pseudonym, test.same)
+		}
+	}
+	if got := p.Pseudonym(USER_NAME_PSEUDONYM_KIND, ""); got != "" {
+		t.Errorf("pseudonym of an empty identifier %q, want it empty", got)
+	}
+}
+
+// TestPseudonymiseVaultsEachPseudonymOnce checks each new pseudonym is recorded in the vault once, with its identifier encrypted,
+// including by a backend which starts with the vault already written
+func TestPseudonymiseVaultsEachPseudonymOnce(t *testing.T) {
+
+	b := newPseudonymisingTestBackend(t)
+	ctx := context.Background()
+	for _, value := range []string{"1234567890", "1234567890", "", "0987654321"} {
+		if _, err := b.pseudonymise(ctx, SUBJECT_IDENTIFIER_PSEUDONYM_KIND, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if length := topicLength(b.MessageStore, PSEUDONYM_VAULT_TOPIC); length != 2 {
+		t.Errorf("%d vault entries, want 2", length)
+	}
+	vaulted, err := b.readPseudonymVault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, vaultEntry := range vaulted {
+		if !strings.HasPrefix(vaultEntry.Value, ENCRYPTED_FIELD_PREFIX) {
+			t.Errorf("vault entry %+v holds its identifier in the clear", vaultEntry)
+		}
+	}
+
+	// a restarted backend reads the vault rather than recording the pseudonym again
+	b.Pseudonymiser = NewPseudonymiser(b.Encryption.Keyring.PseudonymisationKey)
+	if _, err := b.pseudonymise(ctx, SUBJECT_IDENTIFIER_PSEUDONYM_KIND, "1234567890"); err != nil {
+		t.Fatal(err)
+	}
+	if length := topicLength(b.MessageStore, PSEUDONYM_VAULT_TOPIC); length != 2 {
+		t.Errorf("%d vault entries after restart, want 2", length)
+	}
+}
+
+// TestReidentify checks only an authorised user giving a reason can re-identify a pseudonym, and every attempt is audited once,
+// with the subject identifier recorded only when a subject identifier is granted
+func TestReidentify(t *testing.T) {
+
+	b := newPseudonymisingTestBackend(t)
+	ctx := context.Background()
+	subjectPseudonym, err := b.pseudonymise(ctx, SUBJECT_IDENTIFIER_PSEUDONYM_KIND, "1234567890")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPseudonym, err := b.pseudonymise(ctx, USER_NAME_PSEUDONYM_KIND, "jbloggs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorisations := &ReidentificationAuthorisations{AuthorisedUsers: []string{"auditor"}}
+
+	tests := []struct {
+		name           string
+		authorisations *ReidentificationAuthorisations
+		userName       string
+		reason         string
+		pseudonym      string
+		err            string
+		value          string
+		outcome        string
+		subject        string
+	}{
+		{"unauthorised", authorisations, "jbloggs", "incident 42", subjectPseudonym, "not authorised", "", "refused", ""},
+		{"no authorisations", nil, "auditor", "incident 42", subjectPseudonym, "not authorised", "", "refused", ""},
+		{"no reason", authorisations, "auditor", "", subjectPseudonym, "a reason is required", "", "refused", ""},
+		{"blank reason", authorisations, "auditor", "  ", subjectPseudonym, "a reason is required", "", "refused", ""},
+		{"unknown pseudonym", authorisations, "auditor", "incident 42", PSEUDONYM_PREFIX + "unknown", "unknown pseudonym", "", "failed", ""},
+		{"subject", authorisations, "auditor", "incident 42", subjectPseudonym, "", "1234567890", "granted", "1234567890"},
+		{"user", authorisations, "auditor", "incident 42", userPseudonym, "", "jbloggs", "granted", ""},
+	}
+	for _, test := range tests {
+		from := topicLength(b.MessageStore, SYSTEM_AUDIT_EVENT_TOPIC)
+		vaultEntry, err := b.Reidentify(ctx, test.authorisations, test.userName, test.reason, test.pseudonym)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			if vaultEntry != (PseudonymVaultEntry{}) {
+				t.Errorf("%s: refused re-identification returned %+v", test.name, vaultEntry)
+			}
+		} else if err != nil || vaultEntry.Value != test.value || vaultEntry.Pseudonym != test.pseudonym {
+			t.Errorf("%s: re-identified %+v, %v, want %q", test.name, vaultEntry, err, test.value)
+		}
+
+		systemAuditEvents := readSystemAuditEvents(t, b, from)
+		if len(systemAuditEvents) != 1 {
+			t.Errorf("%s: %d audit events, want 1", test.name, len(systemAuditEvents))
+			continue
+		}
+		systemAuditEvent := systemAuditEvents[0]
+		if systemAuditEvent.UserName != test.userName || systemAuditEvent.SubjectIdentifier != test.subject ||
+			!strings.Contains(systemAuditEvent.AuditEvent, "re-identification "+test.outcome) ||
+			!strings.Contains(systemAuditEvent.AuditEvent, test.pseudonym) ||
+			!strings.HasSuffix(systemAuditEvent.AuditEvent, "reason: "+test.reason) {
+			t.Errorf("%s: audit event %+v, want the attempt %s by %s", test.name, systemAuditEvent, test.outcome, test.userName)
+		}
+	}
+}
+
+// TestLoadReidentificationAuthorisations checks the authorised users are loaded, and a corrupt file is rejected
+func TestLoadReidentificationAuthorisations(t *testing.T) {
+
+	directory := t.TempDir()
+	filename := filepath.Join(directory, REIDENTIFICATION_AUTHORISATION_FILE)
+	if err := os.WriteFile(filename, []byte(`{"authorisedUsers": ["auditor"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	authorisations, err := LoadReidentificationAuthorisations(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !authorisations.IsAuthorised("auditor") || authorisations.IsAuthorised("jbloggs") || authorisations.IsAuthorised("") {
+		t.Errorf("authorisations %+v, want only auditor authorised", authorisations)
+	}
+
+	corrupt := filepath.Join(directory, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte(`{"authorisedUsers": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadReidentificationAuthorisations(corrupt); err == nil || !strings.Contains(err.Error(), corrupt) {
+		t.Errorf("error %v, want the corrupt file named", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the structure of PseudonymisedSystemAuditEvent, a system audit event for analytics, with pseudonyms in place of identifiers
+type PseudonymisedSystemAuditEvent struct {
+	UserPseudonym    string `json:"userPseudonym" protobuf:"1"`
+	SubjectPseudonym string `json:"subjectPseudonym" protobuf:"2"`
+	AuditEvent       string `json:"auditEvent" protobuf:"3"`
+}
+
+// sendPseudonymisedSystemAuditEvent sends a pseudonymised system audit event to a topic
+func (b *Backend) sendPseudonymisedSystemAuditEvent(ctx context.Context, pseudonymisedSystemAuditEvent PseudonymisedSystemAuditEvent) {
+
+	topic := PSEUDONYMISED_SYSTEM_AUDIT_EVENT_TOPIC
+
+	ctx, span := b.Tracer.Start(ctx, "sendPseudonymisedSystemAuditEvent", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(pseudonymisedSystemAuditEvent)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to marshal event: %v", err)
+	}
+
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, pseudonymisedSystemAuditEvent.UserPseudonym, codec, payload)
+
+	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "SaveEntry for topic '%s' failed: %v", topic, err)
+	}
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved PseudonymisedSystemAuditEvent to topic ", topic, " at offset ", offset)
+}
+
+// pseudonymiseSystemAuditEvent polls the system audit event topic, republishing each event with pseudonyms in place of identifiers
+func (b *Backend) pseudonymiseSystemAuditEvent(ctx context.Context) {
+
+	b.pollTopic(ctx, SYSTEM_AUDIT_EVENT_PSEUDONYMISER, SYSTEM_AUDIT_EVENT_TOPIC, b.processEntryFromPseudonymiseSystemAuditEvent)
+}
+
+// processEntryFromPseudonymiseSystemAuditEvent processes an entry from polling a topic
+func (b *Backend) processEntryFromPseudonymiseSystemAuditEvent(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	ctx, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPseudonymiseSystemAuditEvent", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", SYSTEM_AUDIT_EVENT_TOPIC)
+
+	if b.Pseudonymiser == nil {
+		fmt.Fprintln(b.Log, "pseudonymisation is disabled until a keyring is loaded, skipping systemAuditEvent")
+		return
+	}
+
+	var systemAuditEvent SystemAuditEvent
+	if err := envelope.DecodePayload(&systemAuditEvent); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal systemAuditEvent: %v", err)
+		return
+	}
+
+	var pseudonymisedSystemAuditEvent PseudonymisedSystemAuditEvent
+	var err error
+	if pseudonymisedSystemAuditEvent.UserPseudonym, err = b.pseudonymise(ctx, USER_NAME_PSEUDONYM_KIND, systemAuditEvent.UserName); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to pseudonymise systemAuditEvent: %v\n", err)
+		return
+	}
+	if pseudonymisedSystemAuditEvent.SubjectPseudonym, err = b.pseudonymise(ctx, SUBJECT_IDENTIFIER_PSEUDONYM_KIND, systemAuditEvent.SubjectIdentifier); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to pseudonymise systemAuditEvent: %v\n", err)
+		return
+	}
+	pseudonymisedSystemAuditEvent.AuditEvent = systemAuditEvent.AuditEvent
+	b.sendPseudonymisedSystemAuditEvent(ctx, pseudonymisedSystemAuditEvent)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the structure of PseudonymisedUserSubjectAccessAttemptOutcome, a user subject access attempt outcome for analytics,
+// with pseudonyms in place of identifiers
+type PseudonymisedUserSubjectAccessAttemptOutcome struct {
+	UserPseudonym    string `json:"userPseudonym" protobuf:"1"`
+	SubjectPseudonym string `json:"subjectPseudonym" protobuf:"2"`
+	Outcome          bool   `json:"outcome" protobuf:"3"`
+}
+
+// sendPseudonymisedUserSubjectAccessAttemptOutcome sends a pseudonymised user subject access attempt outcome to a topic
+func (b *Backend) sendPseudonymisedUserSubjectAccessAttemptOutcome(ctx context.Context, pseudonymisedUserSubjectAccessAttemptOutcome PseudonymisedUserSubjectAccessAttemptOutcome) {
+
+	topic := PSEUDONYMISED_USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC
+
+	ctx, span := b.Tracer.Start(ctx, "sendPseudonymisedUserSubjectAccessAttemptOutcome", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(pseudonymisedUserSubjectAccessAttemptOutcome)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to marshal event: %v", err)
+	}
+
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, pseudonymisedUserSubjectAccessAttemptOutcome.UserPseudonym, codec, payload)
+
+	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "SaveEntry for topic '%s' failed: %v", topic, err)
+	}
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved PseudonymisedUserSubjectAccessAttemptOutcome to topic ", topic, " at offset ", offset)
+}
+
+// pseudonymiseUserSubjectAccessAttemptOutcome polls the user subject access attempt outcome topic, republishing each outcome with pseudonyms in place of identifiers
+func (b *Backend) pseudonymiseUserSubjectAccessAttemptOutcome(ctx context.Context) {
+
+	b.pollTopic(ctx, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, b.processEntryFromPseudonymiseUserSubjectAccessAttemptOutcome)
+}
+
+// processEntryFromPseudonymiseUserSubjectAccessAttemptOutcome processes an entry from polling a topic
+func (b *Backend) processEntryFromPseudonymiseUserSubjectAccessAttemptOutcome(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	ctx, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPseudonymiseUserSubjectAccessAttemptOutcome", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC)
+
+	if b.Pseudonymiser == nil {
+		fmt.Fprintln(b.Log, "pseudonymisation is disabled until a keyring is loaded, skipping userSubjectAccessAttemptOutcome")
+		return
+	}
+
+	var userSubjectAccessAttemptOutcome UserSubjectAccessAttemptOutcome
+	if err := envelope.DecodePayload(&userSubjectAccessAttemptOutcome); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal userSubjectAccessAttemptOutcome: %v", err)
+		return
+	}
+
+	var pseudonymisedUserSubjectAccessAttemptOutcome PseudonymisedUserSubjectAccessAttemptOutcome
+	var err error
+	if pseudonymisedUserSubjectAccessAttemptOutcome.UserPseudonym, err = b.pseudonymise(ctx, USER_NAME_PSEUDONYM_KIND, userSubjectAccessAttemptOutcome.UserName); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to pseudonymise userSubjectAccessAttemptOutcome: %v\n", err)
+		return
+	}
+	if pseudonymisedUserSubjectAccessAttemptOutcome.SubjectPseudonym, err = b.pseudonymise(ctx, SUBJECT_IDENTIFIER_PSEUDONYM_KIND, userSubjectAccessAttemptOutcome.SubjectIdentifier); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to pseudonymise userSubjectAccessAttemptOutcome: %v\n", err)
+		return
+	}
+	pseudonymisedUserSubjectAccessAttemptOutcome.Outcome = userSubjectAccessAttemptOutcome.Outcome
+	b.sendPseudonymisedUserSubjectAccessAttemptOutcome(ctx, pseudonymisedUserSubjectAccessAttemptOutcome)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// regionNames maps each region to its name
+var regionNames = map[int]string{
+	AYRSHIRE_AND_ARRAN_REGION:        "Ayrshire and Arran",
+	BORDERS_REGION:                   "Borders",
+	DUMFRIES_AND_GALLOWAY_REGION:     "Dumfries and Galloway",
+	FIFE_REGION:                      "Fife",
+	FORTH_VALLEY_REGION:              "Forth Valley",
+	GRAMPIAN_REGION:                  "Grampian",
+	GREATER_GLASGOW_AND_CYLDE_REGION: "Greater Glasgow and Clyde",
+	HIGHLAND_REGION:                  "Highland",
+	LOTHIAN_REGION:                   "Lothian",
+	LANARKSHIRE_REGION:               "Lanarkshire",
+	ORKNEY_REGION:                    "Orkney",
+	SHETLAND_REGION:                  "Shetland",
+	TAYSIDE_REGION:                   "Tayside",
+	WESTERN_ISLES_REGION:             "Western Isles",
+}
+
+// allRegions returns every region, in the order of the region constants
+func allRegions() []int {
+
+	return []int{
+		AYRSHIRE_AND_ARRAN_REGION,
+		BORDERS_REGION,
+		DUMFRIES_AND_GALLOWAY_REGION,
+		FIFE_REGION,
+		FORTH_VALLEY_REGION,
+		GRAMPIAN_REGION,
+		GREATER_GLASGOW_AND_CYLDE_REGION,
+		HIGHLAND_REGION,
+		LOTHIAN_REGION,
+		LANARKSHIRE_REGION,
+		ORKNEY_REGION,
+		SHETLAND_REGION,
+		TAYSIDE_REGION,
+		WESTERN_ISLES_REGION,
+	}
+}
+
+// regionName returns the name of a region
+func regionName(region int) string {
+
+	name, ok := regionNames[region]
+	if !ok {
+		return "Region" + strconv.Itoa(region)
+	}
+	return name
+}
+
+// isValidRegion reports whether region is one of the region constants
+func isValidRegion(region int) bool {
+
+	_, ok := regionNames[region]
+	return ok
+}
+
+// parseRegion parses a region given either as its number or as its name, e.g. "8" or "lothian"
+func parseRegion(s string) (int, error) {
+
+	if region, err := strconv.Atoi(s); err == nil {
+		if !isValidRegion(region) {
+			return 0, fmt.Errorf("unknown region %d", region)
+		}
+		return region, nil
+	}
+	for region, name := range regionNames {
+		if strings.EqualFold(name, s) || strings.EqualFold(strings.ReplaceAll(name, " ", "-"), s) {
+			return region, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown region '%s'", s)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the structure of ReplayHandler
+type ReplayHandler struct {
+	Name    string
+	Topic   string
+	Process func(b *Backend, ctx context.Context, entry ms.Entry)
+}
+
+// replayHandlers returns every handler entries can be replayed into, named after the consumer which runs it
+func replayHandlers() []ReplayHandler {
+
+	return []ReplayHandler{
+		{SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC, (*Backend).processEntryFromPollSystemAuditEvent},
+		{USER_LOGIN_ATTEMPT_CONSUMER, USER_LOGIN_ATTEMPT_TOPIC, (*Backend).processEntryFromPollUserLoginAttempt},
+		{USER_LOGIN_ATTEMPT_OUTCOME_CONSUMER, USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, (*Backend).processEntryFromPollUserLoginAttemptOutcome},
+		{USER_SUBJECT_ACCESS_ATTEMPT_CONSUMER, USER_SUBJECT_ACCESS_ATTEMPT_TOPIC, (*Backend).processEntryFromPollUserSubjectAccessAttempt},
+		{USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_CONSUMER, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, (*Backend).processEntryFromPollUserSubjectAccessAttemptOutcome},
+		{SUBJECT_REGION_DOCUMENT_REQUEST_CONSUMER, SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC, (*Backend).processEntryFromPollSubjectRegionDocumentRequest},
+		{SUBJECT_REGION_DOCUMENT_RESPONSE_CONSUMER, SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC, (*Backend).processEntryFromPollSubjectRegionDocumentResponse},
+		{DOCUMENT_CONTENT_REQUEST_CONSUMER, DOCUMENT_CONTENT_REQUEST_TOPIC, (*Backend).processEntryFromPollDocumentContentRequest},
+		{DOCUMENT_CONTENT_RESPONSE_CONSUMER, DOCUMENT_CONTENT_RESPONSE_TOPIC, (*Backend).processEntryFromPollDocumentContentResponse},
+		{SYSTEM_AUDIT_EVENT_PSEUDONYMISER, SYSTEM_AUDIT_EVENT_TOPIC, (*Backend).processEntryFromPseudonymiseSystemAuditEvent},
+		{USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, (*Backend).processEntryFromPseudonymiseUserSubjectAccessAttemptOutcome},
+	}
+}
+
+// findReplayHandler returns the handler with the given name
+func findReplayHandler(name string) (ReplayHandler, bool) {
+
+	for _, replayHandler := range replayHandlers() {
+		if replayHandler.Name == name {
+			return replayHandler, true
+		}
+	}
+	return ReplayHandler{}, false
+}
+
+// findReplayHandlerForTopic returns the handler which consumes a topic
+func findReplayHandlerForTopic(topic string) (ReplayHandler, bool) {
+
+	for _, replayHandler := range replayHandlers() {
+		if replayHandler.Topic == topic {
+			return replayHandler, true
+		}
+	}
+	return ReplayHandler{}, false
+}
+
+// Define the structure of ReplayOptions
+type ReplayOptions struct {
+	Topic        string
+	FromOffset   int64
+	ToOffset     int64 // inclusive, or -1 for the end of the topic
+	FromTime     time.Time
+	ToTime       time.Time
+	Handler      ReplayHandler
+	Keyring      *Keyring            // decrypts the entries replayed, and encrypts those emitted, or nil to leave them as they are
+	Consent      *ConsentService     // the record seals the handler consults, or nil for none
+	Entitlements *EntitlementService // the entitlements the handler applies, or nil for none
+	Log          io.Writer           // where the handler's progress and skipped entries are logged, or nil for standard output
+}
+
+// Define the structure of ReplayReport
+type ReplayReport struct {
+	Topic    string           `json:"topic"`
+	Handler  string           `json:"handler"`
+	Read     int64            `json:"read"`
+	Replayed int64            `json:"replayed"`
+	Rejected int64            `json:"rejected"`
+	Emitted  map[string]int64 `json:"emitted"`
+}
+
+// replay re-feeds the entries of a topic within an offset and time range into a handler,
+// saving the entries the handler emits to destination, which can be a shadow or dry-run store
+func replay(ctx context.Context, source, destination MockableMessageStore, options ReplayOptions) (ReplayReport, error) {
+
+	counting := newCountingMessageStore(destination)
+	b := NewBackend()
+	b.useKeyring(options.Keyring)
+	b.Consent = options.Consent
+	b.Entitlements = options.Entitlements
+	if options.Log != nil {
+		b.Log = options.Log
+	}
+	// emitted entries pass through the same layers as when the handler runs in the application
+	b.MessageStore = newPipelineMessageStore(NewLockingMessageStore(counting), b.Schemas, b.Encryption, b.Compression)
+
+	// the handler emits entries as the component it is, so it is held to the same topic ACLs as when it runs in the application
+	handler := b.ForComponent(options.Handler.Name)
+
+	report := ReplayReport{
+		Topic:   options.Topic,
+		Handler: options.Handler.Name,
+	}
+
+	for offset := options.FromOffset; options.ToOffset < 0 || offset <= options.ToOffset; offset++ {
+		if ctx.Err() != nil {
+			report.Emitted = counting.Counts()
+			return report, ctx.Err()
+		}
+
+		entry, err := source.ReadEntry(options.Topic, offset)
+		if err != nil {
+			if options.ToOffset >= 0 {
+				report.Emitted = counting.Counts()
+				return report, fmt.Errorf("ReadEntry for topic '%s', offset %d, failed: %v", options.Topic, offset, err)
+			}
+			// the end of the topic has been reached
+			break
+		}
+		report.Read++
+
+		if !options.FromTime.IsZero() && entry.Timestamp.Before(options.FromTime) {
+			continue
+		}
+		if !options.ToTime.IsZero() && entry.Timestamp.After(options.ToTime) {
+			// entries are appended in time order, so no later entry can be in range
+			break
+		}
+
+		admitted, err := b.admitEntry(options.Topic, *entry)
+		if err != nil {
+			fmt.Fprintf(b.Log, "skipping entry at offset %d: %v\n", offset, err)
+			report.Rejected++
+			continue
+		}
+
+		spanCtx, span := b.Tracer.Start(ctx, "replay", SPAN_KIND_INTERNAL)
+		span.SetAttribute("messaging.destination.name", options.Topic)
+		span.SetAttribute("messaging.message.offset", offset)
+		options.Handler.Process(handler, spanCtx, admitted)
+		span.End()
+		report.Replayed++
+	}
+
+	report.Emitted = counting.Counts()
+	return report, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// ShadowMessageStore redirects every saved entry to a shadow topic, so handlers can be re-run without touching live topics
+type ShadowMessageStore struct {
+	MockableMessageStore
+	Prefix string
+}
+
+// NewShadowMessageStore creates a new instance of ShadowMessageStore
+func NewShadowMessageStore(store MockableMessageStore, prefix string) *ShadowMessageStore {
+
+	return &ShadowMessageStore{
+		MockableMessageStore: store,
+		Prefix:               prefix,
+	}
+}
+
+// ShadowTopic returns the shadow topic entries for topic are saved to
+func (s *ShadowMessageStore) ShadowTopic(topic string) string {
+
+	return s.Prefix + "." + topic
+}
+
+// SaveEntry saves an entry to the shadow topic
+func (s *ShadowMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	return s.MockableMessageStore.SaveEntry(s.ShadowTopic(topic), entry)
+}
+
+// Define the structure of RecordedEntry
+type RecordedEntry struct {
+	Topic  string
+	Offset int64
+	Entry  ms.Entry
+}
+
+// DryRunMessageStore records every saved entry instead of saving it
+type DryRunMessageStore struct {
+	MockableMessageStore
+	mu       sync.Mutex
+	recorded []RecordedEntry
+	offsets  map[string]int64
+}
+
+// NewDryRunMessageStore creates a new instance of DryRunMessageStore
+func NewDryRunMessageStore(store MockableMessageStore) *DryRunMessageStore {
+
+	return &DryRunMessageStore{
+		MockableMessageStore: store,
+		offsets:              make(map[string]int64),
+	}
+}
+
+// SaveEntry records an entry, returning the offset it would have within the entries recorded for its topic
+func (s *DryRunMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry.Timestamp == (time.Time{}) {
+		entry.Timestamp = time.Now()
+	}
+	offset := s.offsets[topic]
+	s.offsets[topic] = offset + 1
+	s.recorded = append(s.recorded, RecordedEntry{Topic: topic, Offset: offset, Entry: entry})
+	return offset, nil
+}
+
+// Recorded returns every recorded entry, in the order it was saved
+func (s *DryRunMessageStore) Recorded() []RecordedEntry {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RecordedEntry(nil), s.recorded...)
+}
+
+// countingMessageStore counts the entries saved to each topic
+type countingMessageStore struct {
+	MockableMessageStore
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// newCountingMessageStore creates a new instance of countingMessageStore
+func newCountingMessageStore(store MockableMessageStore) *countingMessageStore {
+
+	return &countingMessageStore{
+		MockableMessageStore: store,
+		counts:               make(map[string]int64),
+	}
+}
+
+// SaveEntry saves an entry and counts it against its topic
+func (s *countingMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	offset, err := s.MockableMessageStore.SaveEntry(topic, entry)
+	if err == nil {
+		s.mu.Lock()
+		s.counts[topic]++
+		s.mu.Unlock()
+	}
+	return offset, err
+}
+
+// Counts returns the number of entries saved to each topic
+func (s *countingMessageStore) Counts() map[string]int64 {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int64, len(s.counts))
+	for topic, count := range s.counts {
+		counts[topic] = count
+	}
+	return counts
+}
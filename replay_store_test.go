@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// failingMessageStore fails every save, as a store whose disk is full might, while reads still succeed
+type failingMessageStore struct {
+	*memoryMessageStore
+}
+
+// SaveEntry fails
+func (s *failingMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	return 0, fmt.Errorf("disk full")
+}
+
+// TestShadowMessageStore checks entries are saved to the shadow topic of their topic, while reads are of the topic asked for
+func TestShadowMessageStore(t *testing.T) {
+
+	memory := newMemoryMessageStore()
+	shadow := NewShadowMessageStore(memory, "replay")
+	if topic := shadow.ShadowTopic(SYSTEM_AUDIT_EVENT_TOPIC); topic != "replay."+SYSTEM_AUDIT_EVENT_TOPIC {
+		t.Errorf("shadow topic %s, want replay.%s", topic, SYSTEM_AUDIT_EVENT_TOPIC)
+	}
+	for i := int64(0); i < 2; i++ {
+		if offset, err := shadow.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, ms.Entry{Key: []byte("jbloggs")}); err != nil || offset != i {
+			t.Errorf("saved at offset %d, error %v, want offset %d", offset, err, i)
+		}
+	}
+	if length := topicLength(memory, SYSTEM_AUDIT_EVENT_TOPIC); length != 0 {
+		t.Errorf("%d entries in the live topic, want none", length)
+	}
+	if _, err := shadow.ReadEntry(shadow.ShadowTopic(SYSTEM_AUDIT_EVENT_TOPIC), 1); err != nil {
+		t.Errorf("shadow topic entry not readable: %v", err)
+	}
+}
+
+// TestDryRunMessageStore checks saved entries are recorded in order, with offsets counted per topic and a timestamp, and nothing is saved
+func TestDryRunMessageStore(t *testing.T) {
+
+	memory := newMemoryMessageStore()
+	dryRun := NewDryRunMessageStore(memory)
+	saves := []struct {
+		topic  string
+		key    string
+		offset int64
+	}{
+		{SYSTEM_AUDIT_EVENT_TOPIC, "a", 0},
+		{USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, "b", 0},
+		{SYSTEM_AUDIT_EVENT_TOPIC, "c", 1},
+	}
+	for _, save := range saves {
+		if offset, err := dryRun.SaveEntry(save.topic, ms.Entry{Key: []byte(save.key)}); err != nil || offset != save.offset {
+			t.Errorf("%s %s: offset %d, error %v, want offset %d", save.topic, save.key, offset, err, save.offset)
+		}
+	}
+
+	recorded := dryRun.Recorded()
+	if len(recorded) != len(saves) {
+		t.Fatalf("%d entries recorded, want %d", len(recorded), len(saves))
+	}
+	for i, save := range saves {
+		if recorded[i].Topic != save.topic || recorded[i].Offset != save.offset || string(recorded[i].Entry.Key) != save.key || recorded[i].Entry.Timestamp.IsZero() {
+			t.Errorf("recorded %+v, want %s %s at offset %d with a timestamp", recorded[i], save.topic, save.key, save.offset)
+		}
+	}
+	if length := topicLength(memory, SYSTEM_AUDIT_EVENT_TOPIC); length != 0 {
+		t.Errorf("%d entries saved, want none", length)
+	}
+}
+
+// TestCountingMessageStore checks saved entries are counted by topic, saves which fail are not, and the counts returned are a copy
+func TestCountingMessageStore(t *testing.T) {
+
+	counting := newCountingMessageStore(newMemoryMessageStore())
+	for _, topic := range []string{SYSTEM_AUDIT_EVENT_TOPIC, USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, SYSTEM_AUDIT_EVENT_TOPIC} {
+		if _, err := counting.SaveEntry(topic, ms.Entry{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts := counting.Counts()
+	want := map[string]int64{SYSTEM_AUDIT_EVENT_TOPIC: 2, USER_LOGIN_ATTEMPT_OUTCOME_TOPIC: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts %v, want %v", counts, want)
+	}
+	counts[SYSTEM_AUDIT_EVENT_TOPIC] = 10
+	if again := counting.Counts(); !reflect.DeepEqual(again, want) {
+		t.Errorf("counts %v after changing a copy, want %v", again, want)
+	}
+
+	failing := newCountingMessageStore(&failingMessageStore{newMemoryMessageStore()})
+	if _, err := failing.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, ms.Entry{}); err == nil {
+		t.Error("save succeeded, want the store's error")
+	}
+	if counts := failing.Counts(); len(counts) != 0 {
+		t.Errorf("counts %v of a failed save, want none", counts)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// saveUserLoginAttempts saves a user login attempt for each user in turn, timestamped a minute apart from start
+func saveUserLoginAttempts(t *testing.T, store MockableMessageStore, start time.Time, userNames ...string) {
+
+	t.Helper()
+	for i, userName := range userNames {
+		payload, err := JSONCodec{}.Marshal(NewUserLoginAttempt(userName, "12345678"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := newMessageStoreEntry(context.Background(), USER_LOGIN_ATTEMPT_TOPIC, userName, JSONCodec{}, payload)
+		entry.Timestamp = start.Add(time.Duration(i) * time.Minute)
+		if _, err := store.SaveEntry(USER_LOGIN_ATTEMPT_TOPIC, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestReplayHandlers checks every handler consumes a known topic under a unique name, and is found by its name and its topic
+func TestReplayHandlers(t *testing.T) {
+
+	seen := make(map[string]bool)
+	for _, replayHandler := range replayHandlers() {
+		if seen[replayHandler.Name] {
+			t.Errorf("%s: more than one handler of that name", replayHandler.Name)
+		}
+		seen[replayHandler.Name] = true
+		if _, ok := findTopicDefinition(replayHandler.Topic); !ok {
+			t.Errorf("%s: consumes unknown topic '%s'", replayHandler.Name, replayHandler.Topic)
+		}
+		if found, ok := findReplayHandler(replayHandler.Name); !ok || found.Topic != replayHandler.Topic {
+			t.Errorf("%s: not found by its name", replayHandler.Name)
+		}
+	}
+	if replayHandler, ok := findReplayHandlerForTopic(USER_LOGIN_ATTEMPT_TOPIC); !ok || replayHandler.Name != USER_LOGIN_ATTEMPT_CONSUMER {
+		t.Errorf("handler for %s: %+v, want %s", USER_LOGIN_ATTEMPT_TOPIC, replayHandler, USER_LOGIN_ATTEMPT_CONSUMER)
+	}
+	if _, ok := findReplayHandler("no-such-consumer"); ok {
+		t.Error("found a handler which does not exist")
+	}
+}
+
+// TestReplayRanges checks only the entries within the offset and time range are replayed, and the entries the handler
+// emits are counted by topic
+func TestReplayRanges(t *testing.T) {
+
+	start := time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC)
+	source := newMemoryMessageStore()
+	saveUserLoginAttempts(t, source, start, "jbloggs", "jsmith", "jdoe")
+	handler, _ := findReplayHandler(USER_LOGIN_ATTEMPT_CONSUMER)
+
+	tests := []struct {
+		name       string
+		fromOffset int64
+		toOffset   int64
+		fromTime   time.Time
+		toTime     time.Time
+		read       int64
+		replayed   int64
+		err        string
+	}{
+		{"whole topic", 0, -1, time.Time{}, time.Time{}, 3, 3, ""},
+		{"from an offset", 1, -1, time.Time{}, time.Time{}, 2, 2, ""},
+		{"one offset", 1, 1, time.Time{}, time.Time{}, 1, 1, ""},
+		{"from a time", 0, -1, start.Add(time.Minute), time.Time{}, 3, 2, ""},
+		// the entry after the time range is read, and ends the replay
+		{"to a time", 0, -1, time.Time{}, start.Add(time.Minute), 3, 2, ""},
+		{"within a time", 0, -1, start.Add(30 * time.Second), start.Add(90 * time.Second), 3, 1, ""},
+		{"past the end", 2, 5, time.Time{}, time.Time{}, 1, 1, "offset 3"},
+	}
+	for _, test := range tests {
+		destination := NewDryRunMessageStore(source)
+		var log bytes.Buffer
+		report, err := replay(context.Background(), source, destination, ReplayOptions{
+			Topic:      USER_LOGIN_ATTEMPT_TOPIC,
+			FromOffset: test.fromOffset,
+			ToOffset:   test.toOffset,
+			FromTime:   test.fromTime,
+			ToTime:     test.toTime,
+			Handler:    handler,
+			Log:        &log,
+		})
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+		if report.Read != test.read || report.Replayed != test.replayed || report.Rejected != 0 {
+			t.Errorf("%s: read %d, replayed %d, rejected %d, want %d, %d and 0", test.name, report.Read, report.Replayed, report.Rejected, test.read, test.replayed)
+		}
+		// each login attempt replayed emits its outcome and an audit event
+		want := map[string]int64{USER_LOGIN_ATTEMPT_OUTCOME_TOPIC: test.replayed, SYSTEM_AUDIT_EVENT_TOPIC: test.replayed}
+		if test.replayed == 0 {
+			want = map[string]int64{}
+		}
+		if !reflect.DeepEqual(report.Emitted, want) || int64(len(destination.Recorded())) != 2*test.replayed {
+			t.Errorf("%s: emitted %v, recorded %d, want %v", test.name, report.Emitted, len(destination.Recorded()), want)
+		}
+		if log.Len() == 0 && test.replayed > 0 {
+			t.Errorf("%s: nothing logged to the replay's log writer", test.name)
+		}
+	}
+	if length := topicLength(source, USER_LOGIN_ATTEMPT_OUTCOME_TOPIC); length != 0 {
+		t.Errorf("%d entries saved to the source's live topic, want none", length)
+	}
+}
+
+// TestReplayRejects checks entries which do not match the topic's schema are skipped and logged, and a cancelled replay stops
+func TestReplayRejects(t *testing.T) {
+
+	source := newMemoryMessageStore()
+	saveUserLoginAttempts(t, source, time.Now(), "jbloggs")
+	invalid := newMessageStoreEntry(context.Background(), USER_LOGIN_ATTEMPT_TOPIC, "jsmith", JSONCodec{}, []byte(`{"userName": 5}`))
+	if _, err := source.SaveEntry(USER_LOGIN_ATTEMPT_TOPIC, invalid); err != nil {
+		t.Fatal(err)
+	}
+	saveUserLoginAttempts(t, source, time.Now(), "jdoe")
+	handler, _ := findReplayHandler(USER_LOGIN_ATTEMPT_CONSUMER)
+
+	var log bytes.Buffer
+	options := ReplayOptions{Topic: USER_LOGIN_ATTEMPT_TOPIC, ToOffset: -1, Handler: handler, Log: &log}
+	report, err := replay(context.Background(), source, NewDryRunMessageStore(source), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Read != 3 || report.Replayed != 2 || report.Rejected != 1 {
+		t.Errorf("read %d, replayed %d, rejected %d, want 3, 2 and 1", report.Read, report.Replayed, report.Rejected)
+	}
+	if !strings.Contains(log.String(), "skipping entry at offset 1: ") {
+		t.Errorf("logged %q, want the rejected entry", log.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if report, err := replay(ctx, source, NewDryRunMessageStore(source), options); err != context.Canceled || report.Read != 0 {
+		t.Errorf("cancelled: read %d, error %v, want nothing read and %v", report.Read, err, context.Canceled)
+	}
+}
+
+// TestReplayPipeline checks emitted entries pass through the same layers as in the application, so their fields are encrypted,
+// and are saved to shadow topics rather than the live topics when replaying into a shadow store
+func TestReplayPipeline(t *testing.T) {
+
+	source := newMemoryMessageStore()
+	payload, err := JSONCodec{}.Marshal(NewUserSubjectAccessAttempt("jbloggs", "0101700008", "no-such-session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := newMessageStoreEntry(context.Background(), USER_SUBJECT_ACCESS_ATTEMPT_TOPIC, "jbloggs", JSONCodec{}, payload)
+	if _, err := source.SaveEntry(USER_SUBJECT_ACCESS_ATTEMPT_TOPIC, entry); err != nil {
+		t.Fatal(err)
+	}
+	handler, _ := findReplayHandler(USER_SUBJECT_ACCESS_ATTEMPT_CONSUMER)
+
+	shadow := NewShadowMessageStore(source, "shadow")
+	var log bytes.Buffer
+	report, err := replay(context.Background(), source, shadow, ReplayOptions{
+		Topic:    USER_SUBJECT_ACCESS_ATTEMPT_TOPIC,
+		ToOffset: -1,
+		Handler:  handler,
+		Keyring:  newTestKeyring(t),
+		Log:      &log,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Replayed != 1 || report.Emitted[SYSTEM_AUDIT_EVENT_TOPIC] != 1 {
+		t.Fatalf("replayed %d, emitted %v, want the attempt's audit event", report.Replayed, report.Emitted)
+	}
+	if length := topicLength(source, SYSTEM_AUDIT_EVENT_TOPIC); length != 0 {
+		t.Errorf("%d entries saved to the live topic, want none", length)
+	}
+	emitted, err := source.ReadEntry(shadow.ShadowTopic(SYSTEM_AUDIT_EVENT_TOPIC), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decodeMessageEnvelope(*emitted).Headers[ENCRYPTION_KEY_HEADER]; !ok {
+		t.Errorf("emitted entry %s is not encrypted", emitted.Value)
+	}
+	decodedEntry := decodeEntry(SYSTEM_AUDIT_EVENT_TOPIC, 0, *emitted)
+	if systemAuditEvent, ok := decodedEntry.Payload.(*SystemAuditEvent); !ok || !strings.HasPrefix(systemAuditEvent.SubjectIdentifier, ENCRYPTED_FIELD_PREFIX) {
+		t.Errorf("emitted %+v, want the subject encrypted", decodedEntry.Payload)
+	}
+}
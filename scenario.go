@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Define constants for scenario phase types
+const (
+	SCENARIO_PHASE_STEADY      = "steady"
+	SCENARIO_PHASE_BRUTE_FORCE = "brute-force"
+	SCENARIO_PHASE_IDLE        = "idle"
+)
+
+// ScenarioDuration is a time.Duration written in JSON as a string, e.g. "30s"
+type ScenarioDuration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *ScenarioDuration) UnmarshalJSON(data []byte) error {
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"30s\": %v", err)
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = ScenarioDuration(duration)
+	return nil
+}
+
+// MarshalJSON formats a duration string
+func (d ScenarioDuration) MarshalJSON() ([]byte, error) {
+
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Define the structure of Scenario
+type Scenario struct {
+	Name       string              `json:"name"`
+	Personas   []Persona           `json:"personas"`
+	Phases     []ScenarioPhase     `json:"phases"`
+	Settle     ScenarioDuration    `json:"settle"`
+	Assertions []ScenarioAssertion `json:"assertions"`
+}
+
+// Define the structure of ScenarioPhase
+type ScenarioPhase struct {
+	Name            string           `json:"name"`
+	Type            string           `json:"type"`
+	Duration        ScenarioDuration `json:"duration"`
+	LoginsPerSecond float64          `json:"loginsPerSecond"`
+	TargetUser      string           `json:"targetUser"`
+	RegionOutages   []string         `json:"regionOutages"`
+}
+
+// LoadScenario reads and validates a scenario file
+func LoadScenario(filename string) (*Scenario, error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %v", err)
+	}
+
+	var scenario Scenario
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal scenario file: %s, %v", filename, err)
+	}
+	if err := scenario.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario file: %s, %v", filename, err)
+	}
+	return &scenario, nil
+}
+
+// Validate checks that a scenario can be run
+func (s Scenario) Validate() error {
+
+	personas := NewPersonaDirectory(s.Personas)
+	for _, persona := range s.Personas {
+		if persona.UserName == "" || persona.Password == "" {
+			return fmt.Errorf("every persona needs a userName and a password")
+		}
+		for _, subjectIdentifier := range persona.Subjects {
+			if err := validateSubjectIdentifier(subjectIdentifier); err != nil {
+				return fmt.Errorf("persona '%s': %v", persona.UserName, err)
+			}
+		}
+	}
+
+	if len(s.Phases) == 0 {
+		return fmt.Errorf("at least one phase is required")
+	}
+	for i, phase := range s.Phases {
+		if phase.Duration <= 0 {
+			return fmt.Errorf("phase %d: duration is required", i)
+		}
+		if _, err := phase.regions(); err != nil {
+			return fmt.Errorf("phase %d: %v", i, err)
+		}
+		switch phase.Type {
+		case SCENARIO_PHASE_IDLE:
+		case SCENARIO_PHASE_STEADY, "":
+			if phase.LoginsPerSecond <= 0 {
+				return fmt.Errorf("phase %d: loginsPerSecond is required", i)
+			}
+		case SCENARIO_PHASE_BRUTE_FORCE:
+			if phase.LoginsPerSecond <= 0 {
+				return fmt.Errorf("phase %d: loginsPerSecond is required", i)
+			}
+			if _, ok := personas.Find(phase.TargetUser); !ok {
+				return fmt.Errorf("phase %d: targetUser must be one of the personas", i)
+			}
+		default:
+			return fmt.Errorf("phase %d: unknown type '%s'", i, phase.Type)
+		}
+	}
+
+	for i, assertion := range s.Assertions {
+		if assertion.Topic == "" {
+			return fmt.Errorf("assertion %d: topic is required", i)
+		}
+		if assertion.Min == nil && assertion.Max == nil {
+			return fmt.Errorf("assertion %d: min or max is required", i)
+		}
+	}
+	return nil
+}
+
+// regions parses the regions which are down during a phase
+func (p ScenarioPhase) regions() ([]int, error) {
+
+	var regions []int
+	for _, name := range p.RegionOutages {
+		region, err := parseRegion(name)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// runScenario runs each phase of a scenario in turn, then waits for the pipeline to settle and checks the assertions.
+// the backend's personas should be set from the scenario before its consumers are started
+func (b *Backend) runScenario(ctx context.Context, scenario *Scenario) []ScenarioAssertionResult {
+
+	fmt.Fprintln(b.Log, "scenario '", scenario.Name, "' started")
+
+	// Remember where each asserted topic ends, so only entries produced by the scenario are counted
+	startOffsets := make(map[string]int64)
+	for _, assertion := range scenario.Assertions {
+		startOffsets[assertion.Topic] = topicLength(b.MessageStore, assertion.Topic)
+	}
+
+	// the phases generate logins as the login generator, while the assertions read every topic as the operator
+	generator := b.ForComponent(USER_LOGIN_ATTEMPT_GENERATOR)
+	for _, phase := range scenario.Phases {
+		if ctx.Err() != nil {
+			return nil
+		}
+		generator.runScenarioPhase(ctx, phase)
+	}
+	b.RegionOutages.Set(nil)
+
+	// Wait for the pipeline to finish processing the last logins
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(time.Duration(scenario.Settle)):
+	}
+
+	results := b.checkScenarioAssertions(scenario.Assertions, startOffsets)
+	fmt.Fprintln(b.Log, "scenario '", scenario.Name, "' finished")
+	return results
+}
+
+// runScenarioPhase generates logins at the phase's rate until the phase's duration has elapsed
+func (b *Backend) runScenarioPhase(ctx context.Context, phase ScenarioPhase) {
+
+	fmt.Fprintln(b.Log, "scenario phase '", phase.Name, "' started, type ", phase.Type, ", for ", time.Duration(phase.Duration))
+
+	regions, _ := phase.regions()
+	b.RegionOutages.Set(regions)
+
+	end := time.After(time.Duration(phase.Duration))
+	if phase.Type == SCENARIO_PHASE_IDLE {
+		select {
+		case <-ctx.Done():
+		case <-end:
+		}
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / phase.LoginsPerSecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-end:
+			return
+		case <-ticker.C:
+			if phase.Type == SCENARIO_PHASE_BRUTE_FORCE {
+				b.generateBruteForceLoginAttempt(ctx, phase.TargetUser)
+			} else {
+				b.generatePersonaLoginAttempt(ctx)
+			}
+		}
+	}
+}
+
+// generatePersonaLoginAttempt generates a login attempt by a persona, who mistypes their password at their login failure rate,
+// or a random user when there are no personas
+func (b *Backend) generatePersonaLoginAttempt(ctx context.Context) {
+
+	persona, ok := b.Personas.Pick()
+	if !ok {
+		b.generateUserLoginAttempt(ctx)
+		return
+	}
+
+	password := persona.Password
+	if rand.Float64() < persona.LoginFailureRate {
+		password = b.generateWrongPassword(persona)
+	}
+	b.startUserLoginAttempt(ctx, *NewUserLoginAttempt(persona.UserName, password))
+}
+
+// generateBruteForceLoginAttempt generates a login attempt against a persona with a guessed password
+func (b *Backend) generateBruteForceLoginAttempt(ctx context.Context, userName string) {
+
+	persona, _ := b.Personas.Find(userName)
+	b.startUserLoginAttempt(ctx, *NewUserLoginAttempt(userName, b.generateWrongPassword(persona)))
+}
+
+// generateWrongPassword generates a random password which is not the persona's password
+func (b *Backend) generateWrongPassword(persona Persona) string {
+
+	for {
+		password := b.generateRandomPassword(8)
+		if password != persona.Password {
+			return password
+		}
+	}
+}
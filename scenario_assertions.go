@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Define the structure of ScenarioAssertion, a bound on the number of entries a scenario produces on a topic
+type ScenarioAssertion struct {
+	Description string                 `json:"description"`
+	Topic       string                 `json:"topic"`
+	Where       map[string]interface{} `json:"where"`
+	Min         *int64                 `json:"min"`
+	Max         *int64                 `json:"max"`
+}
+
+// Define the structure of ScenarioAssertionResult
+type ScenarioAssertionResult struct {
+	Assertion ScenarioAssertion
+	Count     int64
+	Passed    bool
+}
+
+// checkScenarioAssertions counts the entries produced on each asserted topic since its start offset which match the assertion's fields
+func (b *Backend) checkScenarioAssertions(assertions []ScenarioAssertion, startOffsets map[string]int64) []ScenarioAssertionResult {
+
+	var results []ScenarioAssertionResult
+	for _, assertion := range assertions {
+		result := ScenarioAssertionResult{Assertion: assertion}
+		end := topicLength(b.MessageStore, assertion.Topic)
+		for offset := startOffsets[assertion.Topic]; offset < end; offset++ {
+			entry, err := b.MessageStore.ReadEntry(assertion.Topic, offset)
+			if err != nil {
+				fmt.Fprintf(b.Log, "ReadEntry for topic '%s', offset %d, failed: %v\n", assertion.Topic, offset, err)
+				continue
+			}
+			decrypted, err := b.Encryption.DecryptEntry(assertion.Topic, *entry)
+			if err != nil {
+				fmt.Fprintf(b.Log, "failed to decrypt entry at offset %d of topic '%s': %v\n", offset, assertion.Topic, err)
+				continue
+			}
+			payload, err := decodeMessageEnvelope(decrypted).JSONPayload(assertion.Topic)
+			if err == nil && matchesScenarioAssertion(payload, assertion.Where) {
+				result.Count++
+			}
+		}
+		result.Passed = (assertion.Min == nil || result.Count >= *assertion.Min) &&
+			(assertion.Max == nil || result.Count <= *assertion.Max)
+		results = append(results, result)
+	}
+	return results
+}
+
+// matchesScenarioAssertion reports whether every field in where has the same value in the payload
+func matchesScenarioAssertion(payload json.RawMessage, where map[string]interface{}) bool {
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return false
+	}
+	for name, want := range where {
+		wantJSON, _ := json.Marshal(want)
+		gotJSON, _ := json.Marshal(fields[name])
+		if string(wantJSON) != string(gotJSON) {
+			return false
+		}
+	}
+	return true
+}
+
+// printScenarioAssertionResults prints a line per assertion, and reports whether they all passed
+func printScenarioAssertionResults(results []ScenarioAssertionResult) bool {
+
+	passed := true
+	for _, result := range results {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+			passed = false
+		}
+		description := result.Assertion.Description
+		if description == "" {
+			description = result.Assertion.Topic
+		}
+		fmt.Printf("%s  %s: %d entries (%s)\n", status, description, result.Count, formatScenarioBounds(result.Assertion))
+	}
+	return passed
+}
+
+// formatScenarioBounds formats the bounds of an assertion, e.g. "min 1, max 5"
+func formatScenarioBounds(assertion ScenarioAssertion) string {
+
+	switch {
+	case assertion.Min != nil && assertion.Max != nil:
+		return fmt.Sprintf("min %d, max %d", *assertion.Min, *assertion.Max)
+	case assertion.Min != nil:
+		return fmt.Sprintf("min %d", *assertion.Min)
+	default:
+		return fmt.Sprintf("max %d", *assertion.Max)
+	}
+}
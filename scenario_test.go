@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoadScenario checks a scenario file is parsed with its durations, and unreadable, corrupt and invalid files are rejected naming the problem
+func TestLoadScenario(t *testing.T) {
+
+	directory := t.TempDir()
+	write := func(name, content string) string {
+		filename := filepath.Join(directory, name)
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	filename := write("scenario.json", `{
+		"name": "brute force",
+		"personas": [{"userName": "jbloggs", "password": "secret", "weight": 3, "subjects": ["0101700008"]}],
+		"phases": [
+			{"name": "warm up", "type": "steady", "duration": "1m30s", "loginsPerSecond": 2},
+			{"name": "attack", "type": "brute-force", "duration": "10s", "loginsPerSecond": 20, "targetUser": "jbloggs", "regionOutages": ["lothian"]}
+		],
+		"settle": "5s",
+		"assertions": [{"topic": "user.login.attempt.outcome", "where": {"outcome": false}, "min": 100}]
+	}`)
+	scenario, err := LoadScenario(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scenario.Name != "brute force" || len(scenario.Personas) != 1 || scenario.Personas[0].Weight != 3 || len(scenario.Phases) != 2 ||
+		time.Duration(scenario.Phases[0].Duration) != 90*time.Second || time.Duration(scenario.Settle) != 5*time.Second ||
+		len(scenario.Assertions) != 1 || *scenario.Assertions[0].Min != 100 || scenario.Assertions[0].Max != nil {
+		t.Errorf("loaded %+v, want the scenario in the file", scenario)
+	}
+	if regions, err := scenario.Phases[1].regions(); err != nil || len(regions) != 1 || regions[0] != LOTHIAN_REGION {
+		t.Errorf("region outages %v, error %v, want lothian", regions, err)
+	}
+	if data, err := json.Marshal(scenario.Settle); err != nil || string(data) != `"5s"` {
+		t.Errorf("settle marshalled as %s, error %v, want \"5s\"", data, err)
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		err      string
+	}{
+		{"missing", filepath.Join(directory, "missing.json"), "failed to read scenario file"},
+		{"corrupt", write("corrupt.json", `{"name": `), "failed to unmarshal scenario file"},
+		{"duration not a string", write("number.json", `{"phases": [{"duration": 30}]}`), "duration must be a string"},
+		{"invalid duration", write("duration.json", `{"phases": [{"duration": "30 seconds"}]}`), "failed to unmarshal scenario file"},
+		{"invalid", write("invalid.json", `{"phases": []}`), "at least one phase is required"},
+	}
+	for _, test := range tests {
+		_, err := LoadScenario(test.filename)
+		if err == nil || !strings.Contains(err.Error(), test.err) || !strings.Contains(err.Error(), test.filename) {
+			t.Errorf("%s: error %v, want %q naming the file", test.name, err, test.err)
+		}
+	}
+}
+
+// TestScenarioValidate checks scenarios which can be run are valid, and each way of writing one which cannot is rejected
+func TestScenarioValidate(t *testing.T) {
+
+	one := int64(1)
+	persona := Persona{UserName: "jbloggs", Password: "secret"}
+	steady := ScenarioPhase{Type: SCENARIO_PHASE_STEADY, Duration: ScenarioDuration(time.Second), LoginsPerSecond: 1}
+	withPhase := func(phase ScenarioPhase) Scenario {
+		return Scenario{Personas: []Persona{persona}, Phases: []ScenarioPhase{phase}}
+	}
+	withAssertion := func(assertion ScenarioAssertion) Scenario {
+		scenario := withPhase(steady)
+		scenario.Assertions = []ScenarioAssertion{assertion}
+		return scenario
+	}
+
+	tests := []struct {
+		name     string
+		scenario Scenario
+		err      string
+	}{
+		{"steady", withPhase(steady), ""},
+		{"steady by default", withPhase(ScenarioPhase{Duration: ScenarioDuration(time.Second), LoginsPerSecond: 1}), ""},
+		{"idle", withPhase(ScenarioPhase{Type: SCENARIO_PHASE_IDLE, Duration: ScenarioDuration(time.Second)}), ""},
+		{"brute force", withPhase(ScenarioPhase{Type: SCENARIO_PHASE_BRUTE_FORCE, Duration: ScenarioDuration(time.Second), LoginsPerSecond: 1, TargetUser: "jbloggs"}), ""},
+		{"region outage", withPhase(ScenarioPhase{Type: SCENARIO_PHASE_IDLE, Duration: ScenarioDuration(time.Second), RegionOutages: []string{"lothian", "2"}}), ""},
+		{"assertion", withAssertion(ScenarioAssertion{Topic: SYSTEM_AUDIT_EVENT_TOPIC, Max: &one}), ""},
+		{"persona without a password", Scenario{Personas: []Persona{{UserName: "jbloggs"}}, Phases: []ScenarioPhase{steady}}, "every persona needs a userName and a password"},
+		{"persona without a user name", Scenario{Personas: []Persona{{Password: "secret"}}, Phases: []ScenarioPhase{steady}}, "every persona needs a userName and a password"},
+		{"persona subject", Scenario{Personas: []Persona{{UserName: "jbloggs", Password: "secret", Subjects: []string{"123"}}}, Phases: []ScenarioPhase{steady}}, "persona 'jbloggs'"},
+		{"no phases", Scenario{Personas: []Persona{persona}}, "at least one phase is required"},
+		{"no duration", withPhase(ScenarioPhase{Type: SCENARIO_PHASE_IDLE}), "phase 0: duration is required"},
+		{"unknown region", withPhase(ScenarioPhase{Type: SCENARIO_PHASE_IDLE, Duration: ScenarioDuration(time.Second), RegionOutages: []string{"atlantis"}}), "phase 0: "},
+		{"steady without a rate", withPhase(ScenarioPhase{Type: SCENARIO_PHASE_STEADY, Duration: ScenarioDuration(time.Second)}), "phase 0: loginsPerSecond is required"},
+		{"brute force without a rate", withPhase(ScenarioPhase{Type: SCENARIO_PHASE_BRUTE_FORCE, Duration: ScenarioDuration(time.Second), TargetUser: "jbloggs"}), "phase 0: loginsPerSecond is required"},
+		{"brute force of a stranger", withPhase(ScenarioPhase{Type: SCENARIO_PHASE_BRUTE_FORCE, Duration: ScenarioDuration(time.Second), LoginsPerSecond: 1, TargetUser: "jsmith"}), "phase 0: targetUser must be one of the personas"},
+		{"unknown type", withPhase(ScenarioPhase{Type: "surge", Duration: ScenarioDuration(time.Second), LoginsPerSecond: 1}), "phase 0: unknown type 'surge'"},
+		{"assertion without a topic", withAssertion(ScenarioAssertion{Min: &one}), "assertion 0: topic is required"},
+		{"assertion without bounds", withAssertion(ScenarioAssertion{Topic: SYSTEM_AUDIT_EVENT_TOPIC}), "assertion 0: min or max is required"},
+	}
+	for _, test := range tests {
+		err := test.scenario.Validate()
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+// TestCheckScenarioAssertions checks each kind of assertion passes when the entries produced since the start offset are within
+// its bounds and fails when they are not, counting only the entries which match its fields, including encrypted ones
+func TestCheckScenarioAssertions(t *testing.T) {
+
+	b := NewBackend()
+	b.MessageStore = newMemoryMessageStore()
+	b.Log = io.Discard
+	b.useKeyring(newTestKeyring(t))
+	ctx := context.Background()
+
+	// produced before the scenario started, so not counted
+	b.sendUserLoginAttemptOutcome(ctx, *NewUserLoginAttemptOutcome("jbloggs", false))
+	startOffsets := map[string]int64{
+		USER_LOGIN_ATTEMPT_OUTCOME_TOPIC:          topicLength(b.MessageStore, USER_LOGIN_ATTEMPT_OUTCOME_TOPIC),
+		USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC: topicLength(b.MessageStore, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC),
+	}
+	b.sendUserLoginAttemptOutcome(ctx, *NewUserLoginAttemptOutcome("jbloggs", true))
+	b.sendUserLoginAttemptOutcome(ctx, *NewUserLoginAttemptOutcome("jbloggs", false))
+	b.sendUserLoginAttemptOutcome(ctx, *NewUserLoginAttemptOutcome("jsmith", false))
+	b.sendUserSubjectAccessAttemptOutcome(ctx, *NewUserSubjectAccessAttemptOutcome("jbloggs", "0101700008", true))
+	b.sendUserSubjectAccessAttemptOutcome(ctx, *NewUserSubjectAccessAttemptOutcome("jbloggs", "0202800002", true))
+
+	bound := func(n int64) *int64 { return &n }
+	tests := []struct {
+		name   string
+		topic  string
+		where  map[string]interface{}
+		min    *int64
+		max    *int64
+		count  int64
+		passed bool
+	}{
+		{"min met", USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, nil, bound(3), nil, 3, true},
+		{"min not met", USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, nil, bound(4), nil, 3, false},
+		{"max met", USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, nil, nil, bound(3), 3, true},
+		{"max exceeded", USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, nil, nil, bound(2), 3, false},
+		{"within range", USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, nil, bound(1), bound(5), 3, true},
+		{"below range", USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, nil, bound(4), bound(5), 3, false},
+		{"above range", USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, nil, bound(1), bound(2), 3, false},
+		{"where matched", USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, map[string]interface{}{"outcome": false}, bound(2), bound(2), 2, true},
+		{"where not matched", USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, map[string]interface{}{"userName": "jsmith", "outcome": true}, bound(1), nil, 0, false},
+		{"where encrypted field matched", USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, map[string]interface{}{"subjectIdentifier": "0101700008"}, bound(1), bound(1), 1, true},
+		{"where encrypted field not matched", USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, map[string]interface{}{"subjectIdentifier": "0303900003"}, bound(1), nil, 0, false},
+		{"empty topic", SYSTEM_AUDIT_EVENT_TOPIC, nil, nil, bound(0), 0, true},
+	}
+	var assertions []ScenarioAssertion
+	for _, test := range tests {
+		assertions = append(assertions, ScenarioAssertion{Description: test.name, Topic: test.topic, Where: test.where, Min: test.min, Max: test.max})
+	}
+	results := b.checkScenarioAssertions(assertions, startOffsets)
+	if len(results) != len(tests) {
+		t.Fatalf("%d results, want %d", len(results), len(tests))
+	}
+	for i, test := range tests {
+		if results[i].Assertion.Description != test.name || results[i].Count != test.count || results[i].Passed != test.passed {
+			t.Errorf("%s: counted %d, passed %v, want %d and %v", test.name, results[i].Count, results[i].Passed, test.count, test.passed)
+		}
+	}
+	if printScenarioAssertionResults(results[:1]) != true || printScenarioAssertionResults(results[:2]) != false {
+		t.Error("printed results passed, want them passed only when every assertion passed")
+	}
+}
+
+// TestFormatScenarioBounds checks bounds are formatted with whichever of min and max are set
+func TestFormatScenarioBounds(t *testing.T) {
+
+	one, five := int64(1), int64(5)
+	tests := []struct {
+		assertion ScenarioAssertion
+		want      string
+	}{
+		{ScenarioAssertion{Min: &one, Max: &five}, "min 1, max 5"},
+		{ScenarioAssertion{Min: &one}, "min 1"},
+		{ScenarioAssertion{Max: &five}, "max 5"},
+	}
+	for _, test := range tests {
+		if got := formatScenarioBounds(test.assertion); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+// TestPersonaDirectory checks personas are found by user name and picked in proportion to their weight, and a nil directory holds no one
+func TestPersonaDirectory(t *testing.T) {
+
+	personas := NewPersonaDirectory([]Persona{
+		{UserName: "jbloggs", Password: "a", Weight: 99},
+		{UserName: "jsmith", Password: "b"},
+	})
+	if persona, ok := personas.Find("jsmith"); !ok || persona.Password != "b" {
+		t.Errorf("found %+v, %v, want jsmith", persona, ok)
+	}
+	if _, ok := personas.Find("jdoe"); ok {
+		t.Error("found jdoe, who is not a persona")
+	}
+	if personas.Len() != 2 {
+		t.Errorf("%d personas, want 2", personas.Len())
+	}
+	picked := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		persona, ok := personas.Pick()
+		if !ok {
+			t.Fatal("no persona picked")
+		}
+		picked[persona.UserName]++
+	}
+	// jsmith has the default weight of 1, so is picked about 1 time in 100
+	if picked["jbloggs"] < 900 || picked["jsmith"] == 0 {
+		t.Errorf("picked %v, want jbloggs about 99 times as often as jsmith", picked)
+	}
+
+	var none *PersonaDirectory
+	if _, ok := none.Find("jbloggs"); ok || none.Len() != 0 {
+		t.Error("nil directory holds a persona")
+	}
+	if _, ok := none.Pick(); ok {
+		t.Error("picked a persona from a nil directory")
+	}
+}
+
+// TestRegionOutages checks the regions set are down until replaced, and a nil set holds no regions
+func TestRegionOutages(t *testing.T) {
+
+	outages := NewRegionOutages()
+	outages.Set([]int{LOTHIAN_REGION})
+	if !outages.IsDown(LOTHIAN_REGION) {
+		t.Error("lothian is up, want it down")
+	}
+	outages.Set(nil)
+	if outages.IsDown(LOTHIAN_REGION) {
+		t.Error("lothian is still down after the outages were replaced")
+	}
+	var none *RegionOutages
+	if none.IsDown(LOTHIAN_REGION) {
+		t.Error("nil set holds a region")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// schemaFiles holds the registered schema of every topic payload version, named <topic>.v<version>.json
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// embeddedSchemas holds the schemas built into the application
+var embeddedSchemas = loadEmbeddedSchemaRegistry(schemaFiles, os.Stderr)
+
+// Define the directory registered schemas are kept in
+const SCHEMA_DIRECTORY = "schemas"
+
+// SchemaRegistry holds every registered version of each topic's payload schema
+type SchemaRegistry struct {
+	versions map[string][]*JSONSchema
+}
+
+// NewSchemaRegistry creates a new instance of SchemaRegistry, with no schemas
+func NewSchemaRegistry() *SchemaRegistry {
+
+	return &SchemaRegistry{
+		versions: make(map[string][]*JSONSchema),
+	}
+}
+
+// LoadSchemaRegistry loads every schema in the schema directory of fsys
+func LoadSchemaRegistry(fsys fs.FS) (*SchemaRegistry, error) {
+
+	r := NewSchemaRegistry()
+	filenames, err := fs.Glob(fsys, path.Join(SCHEMA_DIRECTORY, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]map[int]*JSONSchema)
+	for _, filename := range filenames {
+		topic, version, ok := parseSchemaFilename(path.Base(filename))
+		if !ok {
+			return nil, fmt.Errorf("schema file name '%s' is not <topic>.v<version>.json", filename)
+		}
+		data, err := fs.ReadFile(fsys, filename)
+		if err != nil {
+			return nil, err
+		}
+		var schema JSONSchema
+		if err := json.Unmarshal(data, &schema); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schema file: %s, %v", filename, err)
+		}
+		if found[topic] == nil {
+			found[topic] = make(map[int]*JSONSchema)
+		}
+		found[topic][version] = &schema
+	}
+
+	// Versions are numbered from 1 without gaps
+	for topic, versions := range found {
+		for version := 1; version <= len(versions); version++ {
+			schema, ok := versions[version]
+			if !ok {
+				return nil, fmt.Errorf("topic '%s' has %d schema versions but no version %d", topic, len(versions), version)
+			}
+			r.versions[topic] = append(r.versions[topic], schema)
+		}
+	}
+	return r, nil
+}
+
+// loadEmbeddedSchemaRegistry loads the schemas built into the application, or none if they cannot be loaded, logging why to log
+func loadEmbeddedSchemaRegistry(fsys fs.FS, log io.Writer) *SchemaRegistry {
+
+	r, err := LoadSchemaRegistry(fsys)
+	if err != nil {
+		fmt.Fprintf(log, "failed to load schemas: %v\n", err)
+		return NewSchemaRegistry()
+	}
+	return r
+}
+
+// parseSchemaFilename splits a schema file name into its topic and version
+func parseSchemaFilename(filename string) (string, int, bool) {
+
+	name := strings.TrimSuffix(filename, ".json")
+	i := strings.LastIndex(name, ".v")
+	if i <= 0 || name == filename {
+		return "", 0, false
+	}
+	version, err := strconv.Atoi(name[i+2:])
+	if err != nil || version < 1 {
+		return "", 0, false
+	}
+	return name[:i], version, true
+}
+
+// schemaFilename returns the file name of a topic's schema version
+func schemaFilename(topic string, version int) string {
+
+	return fmt.Sprintf("%s.v%d.json", topic, version)
+}
+
+// Topics returns every topic with a registered schema, in name order
+func (r *SchemaRegistry) Topics() []string {
+
+	topics := make([]string, 0, len(r.versions))
+	for topic := range r.versions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// LatestVersion returns the number of the latest registered version of a topic's schema, or 0 when there is none
+func (r *SchemaRegistry) LatestVersion(topic string) int {
+
+	if r == nil {
+		return 0
+	}
+	return len(r.versions[topic])
+}
+
+// Version returns a registered version of a topic's schema
+func (r *SchemaRegistry) Version(topic string, version int) (*JSONSchema, bool) {
+
+	if version < 1 || version > r.LatestVersion(topic) {
+		return nil, false
+	}
+	return r.versions[topic][version-1], true
+}
+
+// Validate checks a payload against the latest registered version of its topic's schema.
+// topics without a registered schema accept any payload
+func (r *SchemaRegistry) Validate(topic string, payload []byte) error {
+
+	schema, ok := r.Version(topic, r.LatestVersion(topic))
+	if !ok {
+		return nil
+	}
+	if err := schema.ValidateJSON(payload); err != nil {
+		return fmt.Errorf("payload does not match schema %s: %v", schema.ID, err)
+	}
+	return nil
+}
+
+// ValidateEntry checks an entry's payload, in whichever format it was written, against the latest registered version of its topic's schema
+func (r *SchemaRegistry) ValidateEntry(topic string, entry ms.Entry) error {
+
+	payload, err := decodeMessageEnvelope(entry).JSONPayload(topic)
+	if err != nil {
+		return err
+	}
+	return r.Validate(topic, payload)
+}
+
+// generateTopicSchema generates the schema of a topic's payload type, as the code currently defines it
+func generateTopicSchema(topicDefinition TopicDefinition, version int) *JSONSchema {
+
+	schema := generateJSONSchema(reflect.TypeOf(topicDefinition.NewPayload()).Elem())
+	schema.Schema = JSON_SCHEMA_DIALECT
+	schema.ID = strings.TrimSuffix(schemaFilename(topicDefinition.Name, version), ".json")
+	schema.Title = topicDefinition.PayloadType
+	return schema
+}
+
+// registerGeneratedSchemas writes a new schema version to dir for every topic whose payload type no longer matches its latest registered schema,
+// returning the topics and versions written
+func (r *SchemaRegistry) registerGeneratedSchemas(dir string) (map[string]int, error) {
+
+	written := make(map[string]int)
+	for _, topicDefinition := range topicDefinitions() {
+		latest := r.LatestVersion(topicDefinition.Name)
+		if schema, ok := r.Version(topicDefinition.Name, latest); ok {
+			registeredJSON, _ := json.Marshal(schema)
+			generatedJSON, _ := json.Marshal(generateTopicSchema(topicDefinition, latest))
+			if string(registeredJSON) == string(generatedJSON) {
+				continue
+			}
+		}
+
+		version := latest + 1
+		data, err := json.MarshalIndent(generateTopicSchema(topicDefinition, version), "", "  ")
+		if err != nil {
+			return written, fmt.Errorf("failed to marshal schema: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, schemaFilename(topicDefinition.Name, version)), append(data, '\n'), 0644); err != nil {
+			return written, fmt.Errorf("failed to write schema file: %v", err)
+		}
+		written[topicDefinition.Name] = version
+	}
+	return written, nil
+}
+
+// SchemaValidatingMessageStore rejects entries whose payload does not match its topic's schema
+type SchemaValidatingMessageStore struct {
+	MockableMessageStore
+	Schemas *SchemaRegistry
+}
+
+// NewSchemaValidatingMessageStore creates a new instance of SchemaValidatingMessageStore
+func NewSchemaValidatingMessageStore(store MockableMessageStore, schemas *SchemaRegistry) *SchemaValidatingMessageStore {
+
+	return &SchemaValidatingMessageStore{
+		MockableMessageStore: store,
+		Schemas:              schemas,
+	}
+}
+
+// SaveEntry validates an entry's payload, then saves it
+func (s *SchemaValidatingMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	if err := s.Schemas.ValidateEntry(topic, entry); err != nil {
+		return 0, err
+	}
+	return s.MockableMessageStore.SaveEntry(topic, entry)
+}
+
+// SaveEntries validates every entry's payload, then saves them as one batch, so no entry is saved if any is invalid
+func (s *SchemaValidatingMessageStore) SaveEntries(topic string, entries []ms.Entry) (int64, error) {
+
+	for i, entry := range entries {
+		if err := s.Schemas.ValidateEntry(topic, entry); err != nil {
+			return -1, fmt.Errorf("entry %d: %v", i, err)
+		}
+	}
+	return asBatchMessageStore(s.MockableMessageStore).SaveEntries(topic, entries)
+}
+
+// ReadEntries reads up to max entries
+func (s *SchemaValidatingMessageStore) ReadEntries(topic string, offset int64, max int) ([]ms.Entry, error) {
+
+	return asBatchMessageStore(s.MockableMessageStore).ReadEntries(topic, offset, max)
+}
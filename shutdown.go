@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Shutdown flushes buffered producers, commits consumer offsets and closes the message store.
+// it should only be called once every producer and consumer has stopped
+func (b *Backend) Shutdown(ctx context.Context) error {
+
+	var firstErr error
+	record := func(step string, err error) {
+		if err != nil {
+			fmt.Fprintln(b.Log, "shutdown: failed to ", step, ": ", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		record("flush trace exporter", b.Tracer.Shutdown())
+		record("commit consumer offsets", b.Offsets.Close())
+		if closer, ok := b.MessageStore.(io.Closer); ok {
+			record("close message store", closer.Close())
+		}
+	}()
+
+	select {
+	case <-done:
+		return firstErr
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown did not complete: %v", ctx.Err())
+	}
+}
+
+// waitUntil waits for done to be closed, returning false if ctx is done first
+func waitUntil(ctx context.Context, done <-chan struct{}) bool {
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Define the structure of SubjectDocuments, the documents held about a subject across every region
+type SubjectDocuments struct {
+	SubjectIdentifier string                  `json:"subjectIdentifier" protobuf:"1" encrypt:"true"`
+	Documents         []SubjectRegionDocument `json:"documents" protobuf:"2"`
+	UserName          string                  `json:"userName" protobuf:"3"`
+	Regions           int                     `json:"regions" protobuf:"4"`
+	Withheld          int                     `json:"withheld,omitempty" protobuf:"5"`
+}
+
+// NewSubjectDocuments creates a new instance of SubjectDocuments
+func NewSubjectDocuments(subjectIdentifier string, documents []SubjectRegionDocument, userName string, regions int) *SubjectDocuments {
+
+	return &SubjectDocuments{
+		SubjectIdentifier: subjectIdentifier,
+		Documents:         documents,
+		UserName:          userName,
+		Regions:           regions,
+	}
+}
+
+// Define the structure of pendingSubjectDocuments, the responses received so far for one request fan-out
+type pendingSubjectDocuments struct {
+	documents []SubjectRegionDocument
+	regions   map[int]bool
+	started   time.Time
+}
+
+// DocumentConsolidator collects the region responses to each subject's request fan-out, until every region requested has responded.
+// pending fan-outs are held in memory, so any which are incomplete when the application stops are lost
+type DocumentConsolidator struct {
+	mu      sync.Mutex
+	pending map[string]*pendingSubjectDocuments
+	timeout time.Duration
+}
+
+// NewDocumentConsolidator creates a new instance of DocumentConsolidator
+func NewDocumentConsolidator(timeout time.Duration) *DocumentConsolidator {
+
+	return &DocumentConsolidator{
+		pending: make(map[string]*pendingSubjectDocuments),
+		timeout: timeout,
+	}
+}
+
+// Add adds a region response to the fan-out it belongs to, which is identified by its trace,
+// and returns the consolidated documents once every region the fan-out requested has responded.
+// a response without a trace cannot be told apart from the responses to other fan-outs, so is not consolidated
+func (c *DocumentConsolidator) Add(traceID string, subjectRegionDocumentResponse SubjectRegionDocumentResponse, regions int) (*SubjectDocuments, bool) {
+
+	if traceID == "" {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	key := traceID + "/" + subjectRegionDocumentResponse.UserName + "/" + subjectRegionDocumentResponse.SubjectIdentifier
+	pending, ok := c.pending[key]
+	if !ok {
+		pending = &pendingSubjectDocuments{
+			regions: make(map[int]bool),
+			started: now,
+		}
+		c.pending[key] = pending
+	}
+	pending.regions[subjectRegionDocumentResponse.Region] = true
+	pending.documents = append(pending.documents, subjectRegionDocumentResponse.Documents...)
+
+	if len(pending.regions) < regions {
+		return nil, false
+	}
+	delete(c.pending, key)
+	return NewSubjectDocuments(subjectRegionDocumentResponse.SubjectIdentifier,
+		pending.documents,
+		subjectRegionDocumentResponse.UserName,
+		len(pending.regions)), true
+}
+
+// Expire discards fan-outs which some region has not responded to within the timeout, and returns a description of each
+func (c *DocumentConsolidator) Expire(now time.Time) []string {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var discarded []string
+	for key, pending := range c.pending {
+		if now.Sub(pending.started) > c.timeout {
+			discarded = append(discarded, fmt.Sprintf("discarding subject documents '%s', only %d regions responded", key, len(pending.regions)))
+			delete(c.pending, key)
+		}
+	}
+	return discarded
+}
+
+// consolidateSubjectRegionDocumentResponse adds a region response to its fan-out, and sends the subject's documents once every region requested has responded
+func (b *Backend) consolidateSubjectRegionDocumentResponse(ctx context.Context, subjectRegionDocumentResponse SubjectRegionDocumentResponse) {
+
+	ctx, span := b.Tracer.Start(ctx, "consolidateSubjectRegionDocumentResponse", SPAN_KIND_INTERNAL)
+	defer span.End()
+	span.SetAttribute("region", subjectRegionDocumentResponse.Region)
+
+	// the fan-out skipped the regions whose records of the subject are sealed as a whole
+	regions := len(b.Consent.UnsealedRegions(subjectRegionDocumentResponse.SubjectIdentifier, allRegions()))
+
+	for _, discarded := range b.Consolidator.Expire(time.Now()) {
+		fmt.Fprintln(b.Log, discarded)
+	}
+	spanContext, ok := spanContextFromContext(ctx)
+	if !ok {
+		fmt.Fprintf(b.Log, "not consolidating subjectRegionDocumentResponse from region %d, it has no trace to identify its fan-out\n", subjectRegionDocumentResponse.Region)
+		return
+	}
+	subjectDocuments, ok := b.Consolidator.Add(spanContext.TraceIDString(), subjectRegionDocumentResponse, regions)
+	if !ok {
+		return
+	}
+	b.applyEntitlements(ctx, subjectDocuments)
+	b.sendSubjectDocuments(ctx, *subjectDocuments)
+}
+
+// applyEntitlements withholds the documents the requesting user is not entitled to see, recording how many were withheld
+func (b *Backend) applyEntitlements(ctx context.Context, subjectDocuments *SubjectDocuments) {
+
+	documents, withheld := b.Entitlements.FilterDocuments(subjectDocuments.UserName, subjectDocuments.Documents)
+	subjectDocuments.Documents = documents
+	subjectDocuments.Withheld = withheld
+	if withheld > 0 {
+		auditEvent := fmt.Sprintf("%d documents withheld, user not entitled to their category or specialty", withheld)
+		b.sendSystemAuditEvent(ctx, *NewSystemAuditEventWithSubject(subjectDocuments.UserName, subjectDocuments.SubjectIdentifier, auditEvent))
+	}
+}
+
+// sendSubjectDocuments sends a subject's consolidated documents to a topic
+func (b *Backend) sendSubjectDocuments(ctx context.Context, subjectDocuments SubjectDocuments) {
+
+	topic := SUBJECT_DOCUMENTS_TOPIC
+
+	ctx, span := b.Tracer.Start(ctx, "sendSubjectDocuments", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(subjectDocuments)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to marshal event: %v", err)
+	}
+
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, subjectDocuments.UserName, codec, payload)
+
+	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "SaveEntry for topic '%s' failed: %v", topic, err)
+	}
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved subjectDocuments to topic ", topic, " at offset ", offset)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDocumentConsolidator checks a fan-out's documents are consolidated once every region requested has responded, apart from
+// other fan-outs for the same user and subject, and responses without a trace are not consolidated
+func TestDocumentConsolidator(t *testing.T) {
+
+	c := NewDocumentConsolidator(time.Minute)
+	lothian := *NewSubjectRegionDocumentResponse("0101700008", []SubjectRegionDocument{{DocumentIdentifier: "d1"}}, "jbloggs", LOTHIAN_REGION, nil)
+	tayside := *NewSubjectRegionDocumentResponse("0101700008", []SubjectRegionDocument{{DocumentIdentifier: "d2"}}, "jbloggs", TAYSIDE_REGION, nil)
+
+	if _, ok := c.Add("trace-1", lothian, 2); ok {
+		t.Fatal("consolidated after one of two regions responded")
+	}
+	if _, ok := c.Add("trace-2", tayside, 2); ok {
+		t.Fatal("consolidated a response with another fan-out's")
+	}
+	subjectDocuments, ok := c.Add("trace-1", tayside, 2)
+	if !ok || subjectDocuments.Regions != 2 || len(subjectDocuments.Documents) != 2 || subjectDocuments.SubjectIdentifier != "0101700008" {
+		t.Fatalf("consolidated %+v, %v, want the documents of both regions", subjectDocuments, ok)
+	}
+	if len(c.pending) != 1 {
+		t.Errorf("%d pending, want only the other fan-out", len(c.pending))
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, ok := c.Add("", lothian, 1); ok {
+			t.Fatal("consolidated a response without a trace")
+		}
+	}
+	if len(c.pending) != 1 {
+		t.Errorf("%d pending, want responses without a trace not held", len(c.pending))
+	}
+}
+
+// TestDocumentConsolidatorExpires checks fan-outs are kept within the timeout, and discarded and described after it
+func TestDocumentConsolidatorExpires(t *testing.T) {
+
+	c := NewDocumentConsolidator(time.Minute)
+	c.Add("trace", *NewSubjectRegionDocumentResponse("0101700008", nil, "jbloggs", LOTHIAN_REGION, nil), 2)
+
+	if discarded := c.Expire(time.Now().Add(30 * time.Second)); len(discarded) != 0 || len(c.pending) != 1 {
+		t.Fatalf("discarded %v, want the fan-out kept within the timeout", discarded)
+	}
+	discarded := c.Expire(time.Now().Add(2 * time.Minute))
+	if len(discarded) != 1 || !strings.Contains(discarded[0], "only 1 regions responded") || len(c.pending) != 0 {
+		t.Errorf("discarded %v, want the fan-out discarded after the timeout", discarded)
+	}
+}
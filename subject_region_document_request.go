@@ -2,19 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math/rand"
-	"time"
 
 	ms "github.com/mmcnicol/message-store"
 )
 
 // Define the structure of SubjectRegionDocumentRequest
 type SubjectRegionDocumentRequest struct {
-	SubjectIdentifier string `json:"subjectIdentifier"`
-	Region            int    `json:"region"`
-	UserName          string `json:"userName"`
+	SubjectIdentifier string `json:"subjectIdentifier" protobuf:"1" encrypt:"true"`
+	Region            int    `json:"region" protobuf:"2"`
+	UserName          string `json:"userName" protobuf:"3"`
 }
 
 // NewSubjectRegionDocumentRequest creates a new instance of SubjectRegionDocumentRequest
@@ -27,85 +25,123 @@ func NewSubjectRegionDocumentRequest(subjectIdentifier string, region int, userN
 	}
 }
 
+// Validate checks that a SubjectRegionDocumentRequest has every required field
+func (s SubjectRegionDocumentRequest) Validate() error {
+
+	if s.UserName == "" {
+		return fmt.Errorf("userName is required")
+	}
+	if !isValidRegion(s.Region) {
+		return fmt.Errorf("unknown region %d", s.Region)
+	}
+	return validateSubjectIdentifier(s.SubjectIdentifier)
+}
+
 // sendSubjectRegionDocumentRequest sends a subject region document request to a topic
-func (b *Backend) sendSubjectRegionDocumentRequest(subjectRegionDocumentRequest SubjectRegionDocumentRequest) {
+func (b *Backend) sendSubjectRegionDocumentRequest(ctx context.Context, subjectRegionDocumentRequest SubjectRegionDocumentRequest) error {
 
 	topic := SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC
 
-	eventJSON, err := json.Marshal(subjectRegionDocumentRequest)
+	ctx, span := b.Tracer.Start(ctx, "sendSubjectRegionDocumentRequest", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(subjectRegionDocumentRequest)
 	if err != nil {
-		fmt.Printf("failed to marshal event: %v", err)
+		span.RecordError(err)
+		return fmt.Errorf("failed to marshal subjectRegionDocumentRequest: %v", err)
 	}
 
-	messageStoreEntry := ms.Entry{}
-	messageStoreEntry.Key = []byte(subjectRegionDocumentRequest.UserName)
-	messageStoreEntry.Value = []byte(eventJSON)
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, subjectRegionDocumentRequest.UserName, codec, payload)
 
 	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
 	if err != nil {
-		fmt.Printf("SaveEntry for topic '%s' failed: %v", topic, err)
+		span.RecordError(err)
+		return fmt.Errorf("SaveEntry for topic '%s' failed: %v", topic, err)
 	}
-	fmt.Println("saved subjectRegionDocumentRequest to topic ", topic, " at offset ", offset)
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved subjectRegionDocumentRequest to topic ", topic, " at offset ", offset)
+	return nil
 }
 
-// pollSubjectRegionDocumentRequest polls the topic for subject region document requests
-func (b *Backend) pollSubjectRegionDocumentRequest(ctx context.Context) {
+// sendSubjectRegionDocumentRequests sends subject region document requests to a topic as one batch
+func (b *Backend) sendSubjectRegionDocumentRequests(ctx context.Context, subjectRegionDocumentRequests []SubjectRegionDocumentRequest) {
 
 	topic := SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC
-	offset := int64(-1)
-	pollDuration := 100 * time.Millisecond
-
-	// Loop until the context is canceled
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Polling canceled")
-			return
-		default:
-			//entry, err := b.MessageStore.ReadEntry(topic, offset)
-			entry, err := b.MessageStore.PollForNextEntry(topic, offset, pollDuration)
-			if err != nil {
-				// do nothing - as this just means there are no unread entries in the topic
-				//fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned error: ", err)
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-			}
-			if entry == nil {
-				//fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned nil entry.Value")
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-				continue // Continue to the next iteration of the loop
-			}
-			fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned an entry")
-			offset++
-			b.processEntryFromPollSubjectRegionDocumentRequest(*entry)
+
+	ctx, span := b.Tracer.Start(ctx, "sendSubjectRegionDocumentRequests", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+	span.SetAttribute("messaging.batch.message_count", len(subjectRegionDocumentRequests))
+
+	codec := b.Codecs.For(topic)
+	var messageStoreEntries []ms.Entry
+	for _, subjectRegionDocumentRequest := range subjectRegionDocumentRequests {
+		payload, err := codec.Marshal(subjectRegionDocumentRequest)
+		if err != nil {
+			span.RecordError(err)
+			fmt.Fprintf(b.Log, "failed to marshal event: %v", err)
 		}
+		messageStoreEntries = append(messageStoreEntries, newMessageStoreEntry(ctx, topic, subjectRegionDocumentRequest.UserName, codec, payload))
 	}
+
+	offset, err := asBatchMessageStore(b.MessageStore).SaveEntries(topic, messageStoreEntries)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "SaveEntries for topic '%s' failed: %v", topic, err)
+	}
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved ", len(messageStoreEntries), " subjectRegionDocumentRequests to topic ", topic, " from offset ", offset)
+}
+
+// pollSubjectRegionDocumentRequest polls the topic for subject region document requests
+func (b *Backend) pollSubjectRegionDocumentRequest(ctx context.Context) {
+
+	b.pollTopic(ctx, SUBJECT_REGION_DOCUMENT_REQUEST_CONSUMER, SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC, b.processEntryFromPollSubjectRegionDocumentRequest)
 }
 
 // processEntryFromPollSubjectRegionDocumentRequest processes an entry from polling a topic
-func (b *Backend) processEntryFromPollSubjectRegionDocumentRequest(entry ms.Entry) {
+func (b *Backend) processEntryFromPollSubjectRegionDocumentRequest(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	ctx, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPollSubjectRegionDocumentRequest", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC)
 
 	var subjectRegionDocumentRequest SubjectRegionDocumentRequest
-	if err := json.Unmarshal(entry.Value, &subjectRegionDocumentRequest); err != nil {
-		fmt.Printf("failed to unmarshal subjectRegionDocumentRequest: %v", err)
+	if err := envelope.DecodePayload(&subjectRegionDocumentRequest); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal subjectRegionDocumentRequest: %v", err)
 	}
-	fmt.Printf("Received subjectRegionDocumentRequest: %v\n", subjectRegionDocumentRequest)
+	fmt.Fprintf(b.Log, "Received subjectRegionDocumentRequest: %v\n", subjectRegionDocumentRequest)
 
-	b.processSubjectRegionDocumentRequest(subjectRegionDocumentRequest)
+	b.processSubjectRegionDocumentRequest(ctx, subjectRegionDocumentRequest)
 }
 
 // processSubjectRegionDocumentRequest processes a subject region document request
-func (b *Backend) processSubjectRegionDocumentRequest(subjectRegionDocumentRequest SubjectRegionDocumentRequest) {
+func (b *Backend) processSubjectRegionDocumentRequest(ctx context.Context, subjectRegionDocumentRequest SubjectRegionDocumentRequest) {
+
+	ctx, span := b.Tracer.Start(ctx, "processSubjectRegionDocumentRequest", SPAN_KIND_INTERNAL)
+	defer span.End()
+	span.SetAttribute("region", subjectRegionDocumentRequest.Region)
 
 	// Generate a random number between 0 and 99
 	randomNumber := rand.Intn(100)
 
 	// Determine if the response should be successful or an error
 	var subjectRegionDocumentResponse *SubjectRegionDocumentResponse
-	if randomNumber < 80 {
+	if b.RegionOutages.IsDown(subjectRegionDocumentRequest.Region) {
+		// Response is an error, as the region is unavailable
+		subjectRegionDocumentResponse = NewSubjectRegionDocumentResponse(subjectRegionDocumentRequest.SubjectIdentifier,
+			nil,
+			subjectRegionDocumentRequest.UserName,
+			subjectRegionDocumentRequest.Region,
+			fmt.Errorf("system unavailable"))
+	} else if randomNumber < 80 {
 		// Response is successful
-		documents := b.generateRandomSubjectRegionDocuments(subjectRegionDocumentRequest.Region)
+		documents := b.lookupSubjectRegionDocuments(subjectRegionDocumentRequest.SubjectIdentifier, subjectRegionDocumentRequest.Region)
+		documents = b.withholdSealedDocuments(ctx, subjectRegionDocumentRequest, documents)
 		subjectRegionDocumentResponse = NewSubjectRegionDocumentResponse(subjectRegionDocumentRequest.SubjectIdentifier,
 			documents,
 			subjectRegionDocumentRequest.UserName,
@@ -122,5 +158,16 @@ func (b *Backend) processSubjectRegionDocumentRequest(subjectRegionDocumentReque
 	}
 
 	// Send the response
-	b.sendSubjectRegionDocumentResponse(*subjectRegionDocumentResponse)
+	b.sendSubjectRegionDocumentResponse(ctx, *subjectRegionDocumentResponse)
+}
+
+// withholdSealedDocuments removes the documents whose category is sealed for the subject in the region, recording how many were withheld
+func (b *Backend) withholdSealedDocuments(ctx context.Context, subjectRegionDocumentRequest SubjectRegionDocumentRequest, documents []SubjectRegionDocument) []SubjectRegionDocument {
+
+	shared, withheld := b.Consent.FilterDocuments(subjectRegionDocumentRequest.SubjectIdentifier, subjectRegionDocumentRequest.Region, documents)
+	if withheld > 0 {
+		auditEvent := fmt.Sprintf("%d documents of region %s withheld, categories sealed", withheld, regionName(subjectRegionDocumentRequest.Region))
+		b.sendSystemAuditEvent(ctx, *NewSystemAuditEventWithSubject(subjectRegionDocumentRequest.UserName, subjectRegionDocumentRequest.SubjectIdentifier, auditEvent))
+	}
+	return shared
 }
@@ -2,10 +2,8 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math/rand"
-	"strconv"
 	"time"
 
 	ms "github.com/mmcnicol/message-store"
@@ -13,22 +11,22 @@ import (
 
 // Define the structure of SubjectRegionDocument
 type SubjectRegionDocument struct {
-	DocumentIdentifier    string    `json:"documentIdentifier"`
-	DocumentDate          time.Time `json:"documentDate"`
-	DocumentCategoryCode  string    `json:"documentCategoryCode"`
-	DocumentCategory      string    `json:"documentCategory"`
-	DocumentSpecialtyCode string    `json:"documentSpecialtyCode"`
-	DocumentSpecialty     string    `json:"documentSpecialty"`
-	Region                int       `json:"region"`
+	DocumentIdentifier    string    `json:"documentIdentifier" protobuf:"1" encrypt:"true"`
+	DocumentDate          time.Time `json:"documentDate" protobuf:"2"`
+	DocumentCategoryCode  string    `json:"documentCategoryCode" protobuf:"3" encrypt:"true"`
+	DocumentCategory      string    `json:"documentCategory" protobuf:"4" encrypt:"true"`
+	DocumentSpecialtyCode string    `json:"documentSpecialtyCode" protobuf:"5" encrypt:"true"`
+	DocumentSpecialty     string    `json:"documentSpecialty" protobuf:"6" encrypt:"true"`
+	Region                int       `json:"region" protobuf:"7"`
 }
 
 // Define the structure of SubjectRegionDocumentResponse
 type SubjectRegionDocumentResponse struct {
-	SubjectIdentifier string                  `json:"subjectIdentifier"`
-	Documents         []SubjectRegionDocument `json:"documents"`
-	UserName          string                  `json:"userName"`
-	Region            int                     `json:"region"`
-	Err               error                   `json:"error"`
+	SubjectIdentifier string                  `json:"subjectIdentifier" protobuf:"1" encrypt:"true"`
+	Documents         []SubjectRegionDocument `json:"documents" protobuf:"2"`
+	UserName          string                  `json:"userName" protobuf:"3"`
+	Region            int                     `json:"region" protobuf:"4"`
+	Error             string                  `json:"error,omitempty" protobuf:"5"`
 }
 
 // NewSubjectRegionDocumentResponse creates a new instance of SubjectRegionDocumentResponse
@@ -39,51 +37,17 @@ func NewSubjectRegionDocumentResponse(subjectIdentifier string, documents []Subj
 		Documents:         documents,
 		UserName:          userName,
 		Region:            region,
-		Err:               err,
+		Error:             errorMessage(err),
 	}
 }
 
-// generateRandomSubjectRegionDocuments generates a random number of SubjectRegionDocument structs
-func (b *Backend) generateRandomSubjectRegionDocuments(region int) []SubjectRegionDocument {
-	var documents []SubjectRegionDocument
-	numDocuments := rand.Intn(6) // Generate a random number between 0 and 5
-	for i := 0; i < numDocuments; i++ {
-		documents = append(documents, SubjectRegionDocument{
-			DocumentIdentifier:    b.generateRandomDocumentIdentifier(12),
-			DocumentDate:          b.generateRandomDocumentDate(),
-			DocumentCategoryCode:  "CategoryCode" + strconv.Itoa(i),
-			DocumentCategory:      "Category" + strconv.Itoa(i),
-			DocumentSpecialtyCode: "SpecialtyCode" + strconv.Itoa(i),
-			DocumentSpecialty:     "Specialty" + strconv.Itoa(i),
-			Region:                region,
-		})
-	}
-	return documents
-}
-
-// generateRandomDocumentIdentifier generates a random document identifier consisting of digits 0 to 9
-func (b *Backend) generateRandomDocumentIdentifier(documentIdentifierLength int) string {
+// errorMessage returns the message of an error, or an empty string for no error
+func errorMessage(err error) string {
 
-	// Define the character set
-	charset := "0123456789"
-	documentIdentifier := make([]byte, documentIdentifierLength)
-
-	// Generate each character of the document identifier randomly from the character set
-	for i := 0; i < documentIdentifierLength; i++ {
-		randomIndex := rand.Intn(len(charset))
-		documentIdentifier[i] = charset[randomIndex]
+	if err == nil {
+		return ""
 	}
-
-	return string(documentIdentifier)
-}
-
-// generateRandomDocumentDate generates a random document date
-func (b *Backend) generateRandomDocumentDate() time.Time {
-	min := time.Date(1990, 1, 0, 0, 0, 0, 0, time.UTC).Unix()
-	max := time.Now().Unix()
-	delta := max - min
-	sec := rand.Int63n(delta) + min
-	return time.Unix(sec, 0)
+	return err.Error()
 }
 
 // generateRandomError generates a random error
@@ -100,68 +64,53 @@ func generateRandomError() error {
 }
 
 // sendSubjectRegionDocumentResponse sends a user subject region document response to a topic
-func (b *Backend) sendSubjectRegionDocumentResponse(subjectRegionDocumentResponse SubjectRegionDocumentResponse) {
+func (b *Backend) sendSubjectRegionDocumentResponse(ctx context.Context, subjectRegionDocumentResponse SubjectRegionDocumentResponse) {
 
 	topic := SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC
 
-	eventJSON, err := json.Marshal(subjectRegionDocumentResponse)
+	ctx, span := b.Tracer.Start(ctx, "sendSubjectRegionDocumentResponse", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(subjectRegionDocumentResponse)
 	if err != nil {
-		fmt.Printf("failed to marshal event: %v", err)
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to marshal event: %v", err)
 	}
 
-	messageStoreEntry := ms.Entry{}
-	messageStoreEntry.Key = []byte(subjectRegionDocumentResponse.UserName)
-	messageStoreEntry.Value = []byte(eventJSON)
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, subjectRegionDocumentResponse.UserName, codec, payload)
 
 	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
 	if err != nil {
-		fmt.Printf("SaveEntry for topic '%s' failed: %v", topic, err)
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "SaveEntry for topic '%s' failed: %v", topic, err)
 	}
-	fmt.Println("saved SubjectRegionDocumentResponse to topic ", topic, " at offset ", offset)
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved SubjectRegionDocumentResponse to topic ", topic, " at offset ", offset)
 }
 
 // pollSubjectRegionDocumentResponse polls the topic for subject region document responses
 func (b *Backend) pollSubjectRegionDocumentResponse(ctx context.Context) {
 
-	topic := SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC
-	offset := int64(-1)
-	pollDuration := 100 * time.Millisecond
-
-	// Loop until the context is canceled
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Polling canceled")
-			return
-		default:
-			//entry, err := b.MessageStore.ReadEntry(topic, offset)
-			entry, err := b.MessageStore.PollForNextEntry(topic, offset, pollDuration)
-			if err != nil {
-				fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned error: ", err)
-				// Wait for a short duration
-				time.Sleep(100 * time.Millisecond)
-			}
-			if entry == nil {
-				// do nothing - as this just means there are no unread entries in the topic
-
-				//fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned nil entry.Value")
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-				continue // Continue to the next iteration of the loop
-			}
-			fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned an entry")
-			offset++
-			b.processEntryFromPollSubjectRegionDocumentResponse(*entry)
-		}
-	}
+	b.pollTopic(ctx, SUBJECT_REGION_DOCUMENT_RESPONSE_CONSUMER, SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC, b.processEntryFromPollSubjectRegionDocumentResponse)
 }
 
 // processEntryFromPollSubjectRegionDocumentResponse processes an entry from polling a topic
-func (b *Backend) processEntryFromPollSubjectRegionDocumentResponse(entry ms.Entry) {
+func (b *Backend) processEntryFromPollSubjectRegionDocumentResponse(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	ctx, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPollSubjectRegionDocumentResponse", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC)
 
 	var subjectRegionDocumentResponse SubjectRegionDocumentResponse
-	if err := json.Unmarshal(entry.Value, &subjectRegionDocumentResponse); err != nil {
-		fmt.Printf("failed to unmarshal subjectRegionDocumentResponse: %v", err)
+	if err := envelope.DecodePayload(&subjectRegionDocumentResponse); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal subjectRegionDocumentResponse: %v", err)
 	}
-	fmt.Printf("received subjectRegionDocumentResponse: %v\n", subjectRegionDocumentResponse)
+	fmt.Fprintf(b.Log, "received subjectRegionDocumentResponse: %v\n", subjectRegionDocumentResponse)
+
+	b.consolidateSubjectRegionDocumentResponse(ctx, subjectRegionDocumentResponse)
+	b.requestDocumentContent(ctx, subjectRegionDocumentResponse)
 }
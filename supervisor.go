@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Define the structure of CrashEvent
+type CrashEvent struct {
+	Name   string
+	Reason string
+	Time   time.Time
+}
+
+// Supervisor runs named workers, recovering panics and restarting them with backoff
+type Supervisor struct {
+	mu          sync.Mutex
+	wg          sync.WaitGroup
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	CrashBudget int
+	CrashWindow time.Duration
+	crashes     []time.Time
+	onCrash     func(crashEvent CrashEvent)
+	escalate    func(reason string)
+	after       func(d time.Duration) <-chan time.Time // waits out a backoff, replaceable so tests need not sleep
+	Log         io.Writer
+}
+
+// NewSupervisor creates a new instance of Supervisor, which calls escalate once more than crashBudget crashes occur within crashWindow
+func NewSupervisor(crashBudget int, crashWindow time.Duration, onCrash func(crashEvent CrashEvent), escalate func(reason string)) *Supervisor {
+
+	return &Supervisor{
+		MinBackoff:  500 * time.Millisecond,
+		MaxBackoff:  30 * time.Second,
+		CrashBudget: crashBudget,
+		CrashWindow: crashWindow,
+		onCrash:     onCrash,
+		escalate:    escalate,
+		after:       time.After,
+		Log:         os.Stdout,
+	}
+}
+
+// Go starts a supervised worker, which is restarted whenever it panics or returns before ctx is canceled,
+// and returns a channel which is closed once the worker has stopped for good
+func (s *Supervisor) Go(ctx context.Context, name string, run func(ctx context.Context)) <-chan struct{} {
+
+	done := make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(done)
+		s.supervise(ctx, name, run)
+	}()
+	return done
+}
+
+// Done returns a channel which is closed once every supervised worker has stopped for good
+func (s *Supervisor) Done() <-chan struct{} {
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// supervise runs a worker until ctx is canceled or the crash budget is exhausted
+func (s *Supervisor) supervise(ctx context.Context, name string, run func(ctx context.Context)) {
+
+	backoff := s.MinBackoff
+
+	for {
+		started := time.Now()
+		reason := s.runOnce(ctx, run)
+		if ctx.Err() != nil {
+			return
+		}
+		if reason == "" {
+			reason = "returned unexpectedly"
+		}
+
+		crashEvent := CrashEvent{Name: name, Reason: reason, Time: time.Now()}
+		fmt.Fprintln(s.Log, "supervisor: worker '", name, "' crashed: ", reason)
+		if s.onCrash != nil {
+			s.onCrash(crashEvent)
+		}
+
+		if s.recordCrash(crashEvent.Time) {
+			s.escalate(fmt.Sprintf("crash budget of %d crashes in %s exhausted, last by worker '%s'", s.CrashBudget, s.CrashWindow, name))
+			return
+		}
+
+		// a worker which ran for longer than the maximum backoff is considered to have recovered
+		if time.Since(started) > s.MaxBackoff {
+			backoff = s.MinBackoff
+		}
+
+		fmt.Fprintln(s.Log, "supervisor: restarting worker '", name, "' in ", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.after(backoff):
+		}
+
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// runOnce runs a worker, returning the reason for a panic or an empty string when it returned
+func (s *Supervisor) runOnce(ctx context.Context, run func(ctx context.Context)) (reason string) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			reason = fmt.Sprintf("panic: %v", r)
+			fmt.Fprintf(s.Log, "%s\n%s", reason, debug.Stack())
+		}
+	}()
+
+	run(ctx)
+	return ""
+}
+
+// recordCrash records a crash and reports whether the crash budget is exhausted
+func (s *Supervisor) recordCrash(crashTime time.Time) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// forget crashes which have fallen out of the window
+	recent := s.crashes[:0]
+	for _, t := range s.crashes {
+		if crashTime.Sub(t) <= s.CrashWindow {
+			recent = append(recent, t)
+		}
+	}
+	s.crashes = append(recent, crashTime)
+
+	return len(s.crashes) > s.CrashBudget
+}
+
+// recordCrashEvent counts a worker crash against its consumer health and records it in the audit trail
+func (b *Backend) recordCrashEvent(crashEvent CrashEvent) {
+
+	b.Health.RecordCrash(crashEvent.Name)
+	systemAuditEvent := NewSystemAuditEvent(SUPERVISOR_USER_NAME, fmt.Sprintf("worker '%s' crashed: %s", crashEvent.Name, crashEvent.Reason))
+	b.sendSystemAuditEvent(context.Background(), *systemAuditEvent)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestSupervisor creates a supervisor with millisecond backoffs, recording the crashes it reports and the reason it escalates with
+func newTestSupervisor(crashBudget int, crashWindow time.Duration) (*Supervisor, func() []CrashEvent, <-chan string) {
+
+	var mu sync.Mutex
+	var crashEvents []CrashEvent
+	escalated := make(chan string, 1)
+	s := NewSupervisor(crashBudget, crashWindow, func(crashEvent CrashEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		crashEvents = append(crashEvents, crashEvent)
+	}, func(reason string) {
+		escalated <- reason
+	})
+	s.MinBackoff = 10 * time.Millisecond
+	s.MaxBackoff = 40 * time.Millisecond
+	return s, func() []CrashEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]CrashEvent(nil), crashEvents...)
+	}, escalated
+}
+
+// waitClosed fails the test unless a channel is closed within a second
+func waitClosed(t *testing.T, done <-chan struct{}, what string) {
+
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not stop", what)
+	}
+}
+
+// TestSupervisorRestartsCrashedWorker checks a worker which panics or returns is restarted, each crash being reported with its reason
+func TestSupervisorRestartsCrashedWorker(t *testing.T) {
+
+	s, crashEvents, escalated := newTestSupervisor(5, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := make(chan int, 10)
+	run := 0
+	done := s.Go(ctx, "worker", func(ctx context.Context) {
+		run++
+		runs <- run
+		switch run {
+		case 1:
+			panic("boom")
+		case 2:
+			return
+		}
+		<-ctx.Done()
+	})
+
+	for want := 1; want <= 3; want++ {
+		select {
+		case got := <-runs:
+			if got != want {
+				t.Fatalf("run %d, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("worker was not restarted for run %d", want)
+		}
+	}
+
+	events := crashEvents()
+	if len(events) != 2 {
+		t.Fatalf("%d crashes reported, want 2", len(events))
+	}
+	if events[0].Name != "worker" || events[0].Reason != "panic: boom" {
+		t.Errorf("first crash %+v, want the worker's panic", events[0])
+	}
+	if events[1].Reason != "returned unexpectedly" {
+		t.Errorf("second crash reason %q, want returned unexpectedly", events[1].Reason)
+	}
+
+	cancel()
+	waitClosed(t, done, "worker")
+	waitClosed(t, s.Done(), "supervisor")
+	select {
+	case reason := <-escalated:
+		t.Errorf("escalated within the crash budget: %s", reason)
+	default:
+	}
+}
+
+// TestSupervisorBackoff checks restarts wait the minimum backoff, doubling after each crash up to the maximum
+func TestSupervisorBackoff(t *testing.T) {
+
+	s, _, _ := newTestSupervisor(10, time.Minute)
+	var backoffs []time.Duration
+	s.after = func(d time.Duration) <-chan time.Time {
+		backoffs = append(backoffs, d)
+		ready := make(chan time.Time, 1)
+		ready <- time.Now()
+		return ready
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := 0
+	done := s.Go(ctx, "worker", func(ctx context.Context) {
+		runs++
+		if runs == 6 {
+			cancel()
+			return
+		}
+		panic("boom")
+	})
+	waitClosed(t, done, "worker")
+
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond}
+	if len(backoffs) != len(want) {
+		t.Fatalf("backoffs %v, want %v", backoffs, want)
+	}
+	for i := range want {
+		if backoffs[i] != want[i] {
+			t.Errorf("backoffs %v, want %v", backoffs, want)
+			break
+		}
+	}
+}
+
+// TestSupervisorEscalatesOnceCrashBudgetExhausted checks a worker crashing more often than the budget allows is escalated and not restarted
+func TestSupervisorEscalatesOnceCrashBudgetExhausted(t *testing.T) {
+
+	s, crashEvents, escalated := newTestSupervisor(2, time.Minute)
+	done := s.Go(context.Background(), "worker", func(ctx context.Context) {
+		panic("boom")
+	})
+
+	select {
+	case reason := <-escalated:
+		if !strings.Contains(reason, "crash budget of 2 crashes") || !strings.Contains(reason, "'worker'") {
+			t.Errorf("escalated with %q, want the exhausted budget and the last worker to crash", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("supervisor did not escalate")
+	}
+	waitClosed(t, done, "worker")
+	if events := crashEvents(); len(events) != 3 {
+		t.Errorf("%d crashes reported, want 3, the third exhausting the budget", len(events))
+	}
+}
+
+// TestSupervisorRecordCrashWindow checks crashes which have fallen out of the window no longer count against the budget
+func TestSupervisorRecordCrashWindow(t *testing.T) {
+
+	s := NewSupervisor(2, time.Minute, nil, nil)
+	start := time.Now()
+	tests := []struct {
+		at        time.Duration
+		exhausted bool
+	}{
+		{0, false},
+		{10 * time.Second, false},
+		{20 * time.Second, true},
+		{70 * time.Second, true},  // the crashes at 10s and 20s are still within the window
+		{90 * time.Second, false}, // only the crash at 70s is still within the window
+	}
+	for _, test := range tests {
+		if exhausted := s.recordCrash(start.Add(test.at)); exhausted != test.exhausted {
+			t.Errorf("crash at %s: exhausted %v, want %v", test.at, exhausted, test.exhausted)
+		}
+	}
+}
+
+// TestRecordCrashEvent checks a crash is counted against the consumer's health and recorded in the audit trail
+func TestRecordCrashEvent(t *testing.T) {
+
+	b := NewBackend()
+	store := newMemoryMessageStore()
+	b.MessageStore = store
+	b.Health.RegisterConsumer(SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC)
+
+	b.recordCrashEvent(CrashEvent{Name: SYSTEM_AUDIT_EVENT_CONSUMER, Reason: "panic: boom", Time: time.Now()})
+
+	if consumer := findConsumerHealth(t, b.Health, SYSTEM_AUDIT_EVENT_CONSUMER); consumer.Crashes != 1 {
+		t.Errorf("crashes %d, want 1", consumer.Crashes)
+	}
+	entry, err := store.ReadEntry(SYSTEM_AUDIT_EVENT_TOPIC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var systemAuditEvent SystemAuditEvent
+	if err := decodeMessageEnvelope(*entry).DecodePayload(&systemAuditEvent); err != nil {
+		t.Fatal(err)
+	}
+	if systemAuditEvent.UserName != SUPERVISOR_USER_NAME || !strings.Contains(systemAuditEvent.AuditEvent, "panic: boom") {
+		t.Errorf("audit event %+v, want the crash recorded by the supervisor", systemAuditEvent)
+	}
+}
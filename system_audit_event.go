@@ -2,18 +2,18 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"time"
 
 	ms "github.com/mmcnicol/message-store"
 )
 
 // Define the structure of SystemAuditEvent
 type SystemAuditEvent struct {
-	UserName          string `json:"userName"`
-	SubjectIdentifier string `json:"subjectIdentifier"`
-	AuditEvent        string `json:"auditEvent"`
+	UserName           string `json:"userName" protobuf:"1"`
+	SubjectIdentifier  string `json:"subjectIdentifier" protobuf:"2" encrypt:"true"`
+	AuditEvent         string `json:"auditEvent" protobuf:"3"`
+	DocumentIdentifier string `json:"documentIdentifier,omitempty" protobuf:"4" encrypt:"true"`
+	SessionIdentifier  string `json:"sessionIdentifier,omitempty" protobuf:"5"`
 }
 
 // NewSystemAuditEvent creates a new instance of SystemAuditEvent
@@ -35,69 +35,72 @@ func NewSystemAuditEventWithSubject(userName, subjectIdentifier, auditEvent stri
 	}
 }
 
+// NewSystemAuditEventWithDocument creates a new instance of SystemAuditEvent with subject identifier and document identifier
+func NewSystemAuditEventWithDocument(userName, subjectIdentifier, documentIdentifier, auditEvent string) *SystemAuditEvent {
+
+	return &SystemAuditEvent{
+		UserName:           userName,
+		SubjectIdentifier:  subjectIdentifier,
+		AuditEvent:         auditEvent,
+		DocumentIdentifier: documentIdentifier,
+	}
+}
+
+// NewSystemAuditEventWithSession creates a new instance of SystemAuditEvent with session identifier
+func NewSystemAuditEventWithSession(userName, sessionIdentifier, auditEvent string) *SystemAuditEvent {
+
+	return &SystemAuditEvent{
+		UserName:          userName,
+		AuditEvent:        auditEvent,
+		SessionIdentifier: sessionIdentifier,
+	}
+}
+
 // sendSystemAuditEvent sends a system audit event to a topic
-func (b *Backend) sendSystemAuditEvent(systemAuditEvent SystemAuditEvent) {
+func (b *Backend) sendSystemAuditEvent(ctx context.Context, systemAuditEvent SystemAuditEvent) {
 
 	topic := SYSTEM_AUDIT_EVENT_TOPIC
 
-	eventJSON, err := json.Marshal(systemAuditEvent)
+	ctx, span := b.Tracer.Start(ctx, "sendSystemAuditEvent", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(systemAuditEvent)
 	if err != nil {
-		fmt.Printf("failed to marshal event: %v", err)
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to marshal event: %v", err)
 	}
 
-	messageStoreEntry := ms.Entry{}
-	messageStoreEntry.Key = []byte(systemAuditEvent.UserName)
-	messageStoreEntry.Value = []byte(eventJSON)
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, systemAuditEvent.UserName, codec, payload)
 
 	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
 	if err != nil {
-		fmt.Printf("SaveEntry for topic '%s' failed: %v", topic, err)
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "SaveEntry for topic '%s' failed: %v", topic, err)
 	}
-	fmt.Println("saved SystemAuditEvent to topic ", topic, " at offset ", offset)
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved SystemAuditEvent to topic ", topic, " at offset ", offset)
 }
 
 // pollSystemAuditEvent polls the topic for system audit events
 func (b *Backend) pollSystemAuditEvent(ctx context.Context) {
 
-	topic := SYSTEM_AUDIT_EVENT_TOPIC
-	offset := int64(-1)
-	pollDuration := 100 * time.Millisecond
-
-	// Loop until the context is canceled
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Polling canceled")
-			return
-		default:
-			//entry, err := b.MessageStore.ReadEntry(topic, offset)
-			entry, err := b.MessageStore.PollForNextEntry(topic, offset, pollDuration)
-			if err != nil {
-				fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned error: ", err)
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-			}
-			if entry == nil {
-				// do nothing - as this just means there are no unread entries in the topic
-
-				//fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned nil entry.Value")
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-				continue // Continue to the next iteration of the loop
-			}
-			fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned an entry")
-			offset++
-			b.processEntryFromPollSystemAuditEvent(*entry)
-		}
-	}
+	b.pollTopic(ctx, SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_TOPIC, b.processEntryFromPollSystemAuditEvent)
 }
 
 // processEntryFromPollSystemAuditEvent processes an entry from polling a topic
-func (b *Backend) processEntryFromPollSystemAuditEvent(entry ms.Entry) {
+func (b *Backend) processEntryFromPollSystemAuditEvent(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	_, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPollSystemAuditEvent", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", SYSTEM_AUDIT_EVENT_TOPIC)
 
 	var systemAuditEvent SystemAuditEvent
-	if err := json.Unmarshal(entry.Value, &systemAuditEvent); err != nil {
-		fmt.Printf("failed to unmarshal systemAuditEvent: %v", err)
+	if err := envelope.DecodePayload(&systemAuditEvent); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal systemAuditEvent: %v", err)
 	}
-	fmt.Printf("received systemAuditEvent: %v\n", systemAuditEvent)
+	fmt.Fprintf(b.Log, "received systemAuditEvent: %v\n", systemAuditEvent)
 }
@@ -7,6 +7,9 @@ del *.data /q
 REM Delete files with .data.idx suffix
 del *.data.idx /q
 
+REM Delete the committed consumer offsets, which refer to the deleted topics
+del consumer.offsets.json /q
+
 REM Display message after completion
-echo Files with .data and .data.idx suffixes deleted.
+echo Files with .data and .data.idx suffixes, and consumer offsets, deleted.
 pause
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define constants for the names of components which are neither consumers nor generators, but use topics
+const (
+	SUPERVISOR_COMPONENT       = "supervisor"
+	REIDENTIFICATION_COMPONENT = "reidentification-service"
+	FHIR_EXPORT_COMPONENT      = "fhir-exporter"
+	SESSION_SWEEPER_COMPONENT  = "user-session-sweeper"
+)
+
+// Define constants for the operations a topic ACL controls
+const (
+	PRODUCE_OPERATION = "produce"
+	CONSUME_OPERATION = "consume"
+)
+
+// Define the structure of TopicACL, the components which may produce to and consume from a topic
+type TopicACL struct {
+	Topic     string   `json:"topic"`
+	Producers []string `json:"producers"`
+	Consumers []string `json:"consumers"`
+}
+
+// topicACLs returns the ACL of every topic. components may only produce to and consume from the topics listed for them here
+func topicACLs() []TopicACL {
+
+	return []TopicACL{
+		{
+			Topic: SYSTEM_AUDIT_EVENT_TOPIC,
+			Producers: []string{
+				USER_LOGIN_ATTEMPT_GENERATOR, USER_LOGIN_ATTEMPT_CONSUMER, USER_LOGIN_ATTEMPT_OUTCOME_CONSUMER,
+				USER_SUBJECT_ACCESS_ATTEMPT_CONSUMER, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_CONSUMER, SUBJECT_REGION_DOCUMENT_REQUEST_CONSUMER,
+				SUBJECT_REGION_DOCUMENT_RESPONSE_CONSUMER, DOCUMENT_CONTENT_REQUEST_CONSUMER, DOCUMENT_CONTENT_RESPONSE_CONSUMER,
+				SUPERVISOR_COMPONENT, REIDENTIFICATION_COMPONENT, SESSION_SWEEPER_COMPONENT,
+			},
+			Consumers: []string{SYSTEM_AUDIT_EVENT_CONSUMER, SYSTEM_AUDIT_EVENT_PSEUDONYMISER},
+		},
+		{
+			Topic:     USER_LOGIN_ATTEMPT_TOPIC,
+			Producers: []string{USER_LOGIN_ATTEMPT_GENERATOR},
+			Consumers: []string{USER_LOGIN_ATTEMPT_CONSUMER},
+		},
+		{
+			Topic:     USER_LOGIN_ATTEMPT_OUTCOME_TOPIC,
+			Producers: []string{USER_LOGIN_ATTEMPT_CONSUMER},
+			Consumers: []string{USER_LOGIN_ATTEMPT_OUTCOME_CONSUMER},
+		},
+		{
+			Topic:     USER_SUBJECT_ACCESS_ATTEMPT_TOPIC,
+			Producers: []string{USER_LOGIN_ATTEMPT_OUTCOME_CONSUMER},
+			Consumers: []string{USER_SUBJECT_ACCESS_ATTEMPT_CONSUMER},
+		},
+		{
+			Topic:     USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC,
+			Producers: []string{USER_SUBJECT_ACCESS_ATTEMPT_CONSUMER},
+			Consumers: []string{USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_CONSUMER, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER},
+		},
+		{
+			Topic:     SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC,
+			Producers: []string{USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_CONSUMER},
+			Consumers: []string{SUBJECT_REGION_DOCUMENT_REQUEST_CONSUMER},
+		},
+		{
+			Topic:     SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC,
+			Producers: []string{SUBJECT_REGION_DOCUMENT_REQUEST_CONSUMER},
+			Consumers: []string{SUBJECT_REGION_DOCUMENT_RESPONSE_CONSUMER},
+		},
+		{
+			Topic:     SUBJECT_DOCUMENTS_TOPIC,
+			Producers: []string{SUBJECT_REGION_DOCUMENT_RESPONSE_CONSUMER, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_CONSUMER},
+			Consumers: []string{FHIR_EXPORT_COMPONENT},
+		},
+		{
+			Topic:     DOCUMENT_CONTENT_REQUEST_TOPIC,
+			Producers: []string{SUBJECT_REGION_DOCUMENT_RESPONSE_CONSUMER},
+			Consumers: []string{DOCUMENT_CONTENT_REQUEST_CONSUMER},
+		},
+		{
+			Topic:     DOCUMENT_CONTENT_RESPONSE_TOPIC,
+			Producers: []string{DOCUMENT_CONTENT_REQUEST_CONSUMER},
+			Consumers: []string{DOCUMENT_CONTENT_RESPONSE_CONSUMER},
+		},
+		{
+			Topic:     USER_SESSION_EVENT_TOPIC,
+			Producers: []string{USER_LOGIN_ATTEMPT_OUTCOME_CONSUMER, SESSION_SWEEPER_COMPONENT},
+			Consumers: []string{USER_SUBJECT_ACCESS_ATTEMPT_CONSUMER, SESSION_SWEEPER_COMPONENT},
+		},
+		{
+			Topic:     PSEUDONYMISED_SYSTEM_AUDIT_EVENT_TOPIC,
+			Producers: []string{SYSTEM_AUDIT_EVENT_PSEUDONYMISER},
+		},
+		{
+			Topic:     PSEUDONYMISED_USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC,
+			Producers: []string{USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER},
+		},
+		{
+			Topic:     PSEUDONYM_VAULT_TOPIC,
+			Producers: []string{SYSTEM_AUDIT_EVENT_PSEUDONYMISER, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER},
+			Consumers: []string{SYSTEM_AUDIT_EVENT_PSEUDONYMISER, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER, REIDENTIFICATION_COMPONENT},
+		},
+	}
+}
+
+// findTopicACL returns the ACL of a topic
+func findTopicACL(topic string) (TopicACL, bool) {
+
+	for _, topicACL := range topicACLs() {
+		if topicACL.Topic == topic {
+			return topicACL, true
+		}
+	}
+	return TopicACL{}, false
+}
+
+// isTopicOperationAllowed reports whether a component may produce to, or consume from, a topic. topics without an ACL allow no component
+func isTopicOperationAllowed(component, operation, topic string) bool {
+
+	topicACL, ok := findTopicACL(topic)
+	if !ok {
+		return false
+	}
+	components := topicACL.Producers
+	if operation == CONSUME_OPERATION {
+		components = topicACL.Consumers
+	}
+	for _, allowed := range components {
+		if allowed == component {
+			return true
+		}
+	}
+	return false
+}
+
+// Define the structure of TopicAccessViolation, an operation on a topic a component is not allowed
+type TopicAccessViolation struct {
+	Component string
+	Operation string
+	Topic     string
+}
+
+// Error describes the violation
+func (v TopicAccessViolation) Error() string {
+
+	return fmt.Sprintf("component '%s' may not %s topic '%s'", v.Component, v.Operation, v.Topic)
+}
+
+// TopicACLMessageStore rejects the operations of a component on topics its ACL does not allow, reporting each distinct violation once,
+// so a misconfigured consumer retrying its poll does not flood the audit trail
+type TopicACLMessageStore struct {
+	MockableMessageStore
+	Component string
+	report    func(violation TopicAccessViolation)
+	mu        sync.Mutex
+	reported  map[TopicAccessViolation]bool
+}
+
+// NewTopicACLMessageStore creates a new instance of TopicACLMessageStore
+func NewTopicACLMessageStore(store MockableMessageStore, component string, report func(violation TopicAccessViolation)) *TopicACLMessageStore {
+
+	return &TopicACLMessageStore{
+		MockableMessageStore: store,
+		Component:            component,
+		report:               report,
+		reported:             make(map[TopicAccessViolation]bool),
+	}
+}
+
+// check returns a violation if the component may not perform the operation on the topic, reporting it if it is new
+func (s *TopicACLMessageStore) check(operation, topic string) error {
+
+	if isTopicOperationAllowed(s.Component, operation, topic) {
+		return nil
+	}
+	violation := TopicAccessViolation{Component: s.Component, Operation: operation, Topic: topic}
+	s.mu.Lock()
+	isNew := !s.reported[violation]
+	s.reported[violation] = true
+	s.mu.Unlock()
+	if isNew && s.report != nil {
+		s.report(violation)
+	}
+	return violation
+}
+
+// SaveEntry saves an entry, if the component may produce to the topic
+func (s *TopicACLMessageStore) SaveEntry(topic string, entry ms.Entry) (int64, error) {
+
+	if err := s.check(PRODUCE_OPERATION, topic); err != nil {
+		return 0, err
+	}
+	return s.MockableMessageStore.SaveEntry(topic, entry)
+}
+
+// SaveEntries saves entries as one batch, if the component may produce to the topic
+func (s *TopicACLMessageStore) SaveEntries(topic string, entries []ms.Entry) (int64, error) {
+
+	if err := s.check(PRODUCE_OPERATION, topic); err != nil {
+		return -1, err
+	}
+	return asBatchMessageStore(s.MockableMessageStore).SaveEntries(topic, entries)
+}
+
+// ReadEntry reads an entry, if the component may consume from the topic
+func (s *TopicACLMessageStore) ReadEntry(topic string, offset int64) (*ms.Entry, error) {
+
+	if err := s.check(CONSUME_OPERATION, topic); err != nil {
+		return nil, err
+	}
+	return s.MockableMessageStore.ReadEntry(topic, offset)
+}
+
+// ReadEntries reads up to max entries, if the component may consume from the topic
+func (s *TopicACLMessageStore) ReadEntries(topic string, offset int64, max int) ([]ms.Entry, error) {
+
+	if err := s.check(CONSUME_OPERATION, topic); err != nil {
+		return nil, err
+	}
+	return asBatchMessageStore(s.MockableMessageStore).ReadEntries(topic, offset, max)
+}
+
+// PollForNextEntry polls for an entry, if the component may consume from the topic
+func (s *TopicACLMessageStore) PollForNextEntry(topic string, offset int64, pollDuration time.Duration) (*ms.Entry, error) {
+
+	if err := s.check(CONSUME_OPERATION, topic); err != nil {
+		return nil, err
+	}
+	return s.MockableMessageStore.PollForNextEntry(topic, offset, pollDuration)
+}
+
+// Subscribe subscribes to the topic through the wrapped store, when it can notify subscribers. without notifications, wake is nil
+func (s *TopicACLMessageStore) Subscribe(topic string) (<-chan struct{}, func()) {
+
+	if notifier, ok := s.MockableMessageStore.(EntryNotifier); ok {
+		return notifier.Subscribe(topic)
+	}
+	return nil, func() {}
+}
+
+// ForComponent returns a view of the backend for a named component, whose message store only allows the operations the topic ACLs give it.
+// the backend itself is not restricted, as it is used by the operator's commands
+func (b *Backend) ForComponent(component string) *Backend {
+
+	view := *b
+	view.MessageStore = NewTopicACLMessageStore(b.MessageStore, component, b.recordTopicAccessViolation)
+	return &view
+}
+
+// recordTopicAccessViolation records a rejected topic operation in the audit trail, as the component which attempted it
+func (b *Backend) recordTopicAccessViolation(violation TopicAccessViolation) {
+
+	fmt.Fprintln(b.Log, "rejected: ", violation.Error())
+	systemAuditEvent := NewSystemAuditEvent(violation.Component, "topic access denied: "+violation.Error())
+	b.sendSystemAuditEvent(context.Background(), *systemAuditEvent)
+}
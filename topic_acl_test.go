@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// TestTopicACLsCoverEveryTopic checks every topic has an ACL, and every ACL is of a topic
+func TestTopicACLsCoverEveryTopic(t *testing.T) {
+
+	for _, topicDefinition := range topicDefinitions() {
+		if _, ok := findTopicACL(topicDefinition.Name); !ok {
+			t.Errorf("%s: no ACL, so no component may use it", topicDefinition.Name)
+		}
+	}
+	for _, topicACL := range topicACLs() {
+		if _, ok := findTopicDefinition(topicACL.Topic); !ok {
+			t.Errorf("ACL of unknown topic '%s'", topicACL.Topic)
+		}
+	}
+}
+
+// TestIsTopicOperationAllowed checks a component may only produce to and consume from the topics listed for it
+func TestIsTopicOperationAllowed(t *testing.T) {
+
+	tests := []struct {
+		component string
+		operation string
+		topic     string
+		allowed   bool
+	}{
+		{USER_LOGIN_ATTEMPT_GENERATOR, PRODUCE_OPERATION, USER_LOGIN_ATTEMPT_TOPIC, true},
+		{USER_LOGIN_ATTEMPT_GENERATOR, CONSUME_OPERATION, USER_LOGIN_ATTEMPT_TOPIC, false},
+		{USER_LOGIN_ATTEMPT_CONSUMER, CONSUME_OPERATION, USER_LOGIN_ATTEMPT_TOPIC, true},
+		{USER_LOGIN_ATTEMPT_CONSUMER, PRODUCE_OPERATION, USER_LOGIN_ATTEMPT_TOPIC, false},
+		{USER_LOGIN_ATTEMPT_CONSUMER, PRODUCE_OPERATION, SYSTEM_AUDIT_EVENT_TOPIC, true},
+		{REIDENTIFICATION_COMPONENT, CONSUME_OPERATION, PSEUDONYM_VAULT_TOPIC, true},
+		{REIDENTIFICATION_COMPONENT, PRODUCE_OPERATION, PSEUDONYM_VAULT_TOPIC, false},
+		{FHIR_EXPORT_COMPONENT, CONSUME_OPERATION, SUBJECT_DOCUMENTS_TOPIC, true},
+		{FHIR_EXPORT_COMPONENT, CONSUME_OPERATION, SYSTEM_AUDIT_EVENT_TOPIC, false},
+		{SESSION_SWEEPER_COMPONENT, CONSUME_OPERATION, USER_SESSION_EVENT_TOPIC, true},
+		{SYSTEM_AUDIT_EVENT_CONSUMER, CONSUME_OPERATION, PSEUDONYMISED_SYSTEM_AUDIT_EVENT_TOPIC, false},
+		{USER_LOGIN_ATTEMPT_GENERATOR, PRODUCE_OPERATION, "no.such.topic", false},
+		{"", PRODUCE_OPERATION, SYSTEM_AUDIT_EVENT_TOPIC, false},
+	}
+	for _, test := range tests {
+		if allowed := isTopicOperationAllowed(test.component, test.operation, test.topic); allowed != test.allowed {
+			t.Errorf("%s %s %s: allowed %v, want %v", test.component, test.operation, test.topic, allowed, test.allowed)
+		}
+	}
+}
+
+// TestTopicACLMessageStoreAllows checks operations the ACL allows pass through to the wrapped store unchanged, without a violation reported
+func TestTopicACLMessageStoreAllows(t *testing.T) {
+
+	memory := newMemoryMessageStore()
+	var violations []TopicAccessViolation
+	report := func(violation TopicAccessViolation) { violations = append(violations, violation) }
+	producer := NewTopicACLMessageStore(memory, USER_LOGIN_ATTEMPT_GENERATOR, report)
+	consumer := NewTopicACLMessageStore(memory, USER_LOGIN_ATTEMPT_CONSUMER, report)
+
+	entry := ms.Entry{Key: []byte("jbloggs"), Value: []byte(`{"userName":"jbloggs"}`)}
+	if offset, err := producer.SaveEntry(USER_LOGIN_ATTEMPT_TOPIC, entry); err != nil || offset != 0 {
+		t.Fatalf("saved at offset %d, %v, want offset 0", offset, err)
+	}
+	if _, err := producer.SaveEntries(USER_LOGIN_ATTEMPT_TOPIC, []ms.Entry{entry}); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := consumer.ReadEntry(USER_LOGIN_ATTEMPT_TOPIC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read.Key, entry.Key) || !bytes.Equal(read.Value, entry.Value) {
+		t.Errorf("read %q, want the entry saved", read.Value)
+	}
+	entries, err := consumer.ReadEntries(USER_LOGIN_ATTEMPT_TOPIC, 0, 10)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("read %d entries, %v, want 2", len(entries), err)
+	}
+	polled, err := consumer.PollForNextEntry(USER_LOGIN_ATTEMPT_TOPIC, 0, time.Millisecond)
+	if err != nil || polled == nil || !bytes.Equal(polled.Value, entry.Value) {
+		t.Errorf("polled %v, %v, want the entry saved", polled, err)
+	}
+	if len(violations) != 0 {
+		t.Errorf("violations %v reported for allowed operations", violations)
+	}
+}
+
+// TestTopicACLMessageStoreRejects checks produce and consume operations outside the ACL are rejected before reaching the wrapped store,
+// and each distinct violation is reported once however often it is attempted
+func TestTopicACLMessageStoreRejects(t *testing.T) {
+
+	memory := newMemoryMessageStore()
+	reported := make(map[TopicAccessViolation]int)
+	store := NewTopicACLMessageStore(memory, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER, func(violation TopicAccessViolation) { reported[violation]++ })
+	entry := ms.Entry{Key: []byte("jbloggs"), Value: []byte(`{}`)}
+	if _, err := memory.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	operations := []struct {
+		name      string
+		operation string
+		attempt   func() error
+	}{
+		{"SaveEntry", PRODUCE_OPERATION, func() error { _, err := store.SaveEntry(SYSTEM_AUDIT_EVENT_TOPIC, entry); return err }},
+		{"SaveEntries", PRODUCE_OPERATION, func() error { _, err := store.SaveEntries(SYSTEM_AUDIT_EVENT_TOPIC, []ms.Entry{entry}); return err }},
+		{"ReadEntry", CONSUME_OPERATION, func() error { _, err := store.ReadEntry(SYSTEM_AUDIT_EVENT_TOPIC, 0); return err }},
+		{"ReadEntries", CONSUME_OPERATION, func() error { _, err := store.ReadEntries(SYSTEM_AUDIT_EVENT_TOPIC, 0, 10); return err }},
+		{"PollForNextEntry", CONSUME_OPERATION, func() error {
+			_, err := store.PollForNextEntry(SYSTEM_AUDIT_EVENT_TOPIC, 0, time.Millisecond)
+			return err
+		}},
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		for _, operation := range operations {
+			err := operation.attempt()
+			var violation TopicAccessViolation
+			if !errors.As(err, &violation) {
+				t.Fatalf("%s: error %v, want a topic access violation", operation.name, err)
+			}
+			want := TopicAccessViolation{Component: USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_PSEUDONYMISER, Operation: operation.operation, Topic: SYSTEM_AUDIT_EVENT_TOPIC}
+			if violation != want {
+				t.Errorf("%s: violation %+v, want %+v", operation.name, violation, want)
+			}
+		}
+	}
+
+	if length := topicLength(memory, SYSTEM_AUDIT_EVENT_TOPIC); length != 1 {
+		t.Errorf("%d entries in the topic, want only the 1 saved directly", length)
+	}
+	if len(reported) != 2 {
+		t.Errorf("reported %v, want the produce and consume violations", reported)
+	}
+	for violation, count := range reported {
+		if count != 1 {
+			t.Errorf("%s reported %d times, want once", violation.Error(), count)
+		}
+	}
+}
+
+// TestForComponentAuditsViolations checks a component's view of the backend is restricted by the ACLs, each violation being audited once
+// by the unrestricted backend, while the backend itself is not restricted
+func TestForComponentAuditsViolations(t *testing.T) {
+
+	b := NewBackend()
+	b.MessageStore = newMemoryMessageStore()
+	view := b.ForComponent(USER_LOGIN_ATTEMPT_GENERATOR)
+
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := view.MessageStore.ReadEntry(USER_LOGIN_ATTEMPT_TOPIC, 0); err == nil {
+			t.Fatal("generator consumed its own topic, want a violation")
+		}
+	}
+	if _, err := view.MessageStore.SaveEntry(USER_LOGIN_ATTEMPT_TOPIC, ms.Entry{Key: []byte("jbloggs"), Value: []byte(`{}`)}); err != nil {
+		t.Errorf("generator could not produce to its topic: %v", err)
+	}
+	if _, err := b.MessageStore.ReadEntry(USER_LOGIN_ATTEMPT_TOPIC, 0); err != nil {
+		t.Errorf("backend restricted by a component's view: %v", err)
+	}
+
+	systemAuditEvents := readSystemAuditEvents(t, b, 0)
+	if len(systemAuditEvents) != 1 {
+		t.Fatalf("%d audit events, want 1", len(systemAuditEvents))
+	}
+	systemAuditEvent := systemAuditEvents[0]
+	want := TopicAccessViolation{Component: USER_LOGIN_ATTEMPT_GENERATOR, Operation: CONSUME_OPERATION, Topic: USER_LOGIN_ATTEMPT_TOPIC}
+	if systemAuditEvent.UserName != USER_LOGIN_ATTEMPT_GENERATOR || !strings.HasSuffix(systemAuditEvent.AuditEvent, want.Error()) {
+		t.Errorf("audit event %+v, want the violation recorded as the generator", systemAuditEvent)
+	}
+}
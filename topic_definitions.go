@@ -0,0 +1,40 @@
+package main
+
+// Define the structure of TopicDefinition
+type TopicDefinition struct {
+	Name        string
+	PayloadType string
+	NewPayload  func() interface{}
+}
+
+// topicDefinitions returns the definition of every topic the application produces
+func topicDefinitions() []TopicDefinition {
+
+	return []TopicDefinition{
+		{SYSTEM_AUDIT_EVENT_TOPIC, "SystemAuditEvent", func() interface{} { return &SystemAuditEvent{} }},
+		{USER_LOGIN_ATTEMPT_TOPIC, "UserLoginAttempt", func() interface{} { return &UserLoginAttempt{} }},
+		{USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, "UserLoginAttemptOutcome", func() interface{} { return &UserLoginAttemptOutcome{} }},
+		{USER_SUBJECT_ACCESS_ATTEMPT_TOPIC, "UserSubjectAccessAttempt", func() interface{} { return &UserSubjectAccessAttempt{} }},
+		{USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, "UserSubjectAccessAttemptOutcome", func() interface{} { return &UserSubjectAccessAttemptOutcome{} }},
+		{SUBJECT_REGION_DOCUMENT_REQUEST_TOPIC, "SubjectRegionDocumentRequest", func() interface{} { return &SubjectRegionDocumentRequest{} }},
+		{SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC, "SubjectRegionDocumentResponse", func() interface{} { return &SubjectRegionDocumentResponse{} }},
+		{SUBJECT_DOCUMENTS_TOPIC, "SubjectDocuments", func() interface{} { return &SubjectDocuments{} }},
+		{DOCUMENT_CONTENT_REQUEST_TOPIC, "DocumentContentRequest", func() interface{} { return &DocumentContentRequest{} }},
+		{DOCUMENT_CONTENT_RESPONSE_TOPIC, "DocumentContentResponse", func() interface{} { return &DocumentContentResponse{} }},
+		{USER_SESSION_EVENT_TOPIC, "UserSessionEvent", func() interface{} { return &UserSessionEvent{} }},
+		{PSEUDONYMISED_SYSTEM_AUDIT_EVENT_TOPIC, "PseudonymisedSystemAuditEvent", func() interface{} { return &PseudonymisedSystemAuditEvent{} }},
+		{PSEUDONYMISED_USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, "PseudonymisedUserSubjectAccessAttemptOutcome", func() interface{} { return &PseudonymisedUserSubjectAccessAttemptOutcome{} }},
+		{PSEUDONYM_VAULT_TOPIC, "PseudonymVaultEntry", func() interface{} { return &PseudonymVaultEntry{} }},
+	}
+}
+
+// findTopicDefinition returns the definition of a topic
+func findTopicDefinition(topic string) (TopicDefinition, bool) {
+
+	for _, topicDefinition := range topicDefinitions() {
+		if topicDefinition.Name == topic {
+			return topicDefinition, true
+		}
+	}
+	return TopicDefinition{}, false
+}
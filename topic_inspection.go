@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the structure of DecodedEntry
+type DecodedEntry struct {
+	Topic       string            `json:"topic"`
+	Offset      int64             `json:"offset"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Key         string            `json:"key"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	UpcastFrom  int               `json:"upcastFrom,omitempty"`
+	PayloadType string            `json:"payloadType,omitempty"`
+	Payload     interface{}       `json:"payload"`
+	DecodeError string            `json:"decodeError,omitempty"`
+}
+
+// decodeEntry unwraps an entry's envelope, upcasts its payload to the current version and decodes it using the topic's payload type
+func decodeEntry(topic string, offset int64, entry ms.Entry) DecodedEntry {
+
+	envelope := decodeMessageEnvelope(entry)
+	decodedEntry := DecodedEntry{
+		Topic:     topic,
+		Offset:    offset,
+		Timestamp: entry.Timestamp,
+		Key:       string(entry.Key),
+		Headers:   envelope.Headers,
+		Payload:   envelope.Payload,
+	}
+
+	upcast, err := upcastEntry(topic, entry)
+	if err != nil {
+		decodedEntry.DecodeError = err.Error()
+		return decodedEntry
+	}
+	if version := envelope.SchemaVersion(); version != currentPayloadVersion(topic) && currentPayloadVersion(topic) > 0 {
+		decodedEntry.UpcastFrom = version
+		envelope = decodeMessageEnvelope(upcast)
+	}
+
+	topicDefinition, ok := findTopicDefinition(topic)
+	if !ok {
+		return decodedEntry
+	}
+	decodedEntry.PayloadType = topicDefinition.PayloadType
+
+	payload := topicDefinition.NewPayload()
+	if err := envelope.DecodePayload(payload); err != nil {
+		decodedEntry.DecodeError = err.Error()
+		return decodedEntry
+	}
+	decodedEntry.Payload = payload
+	return decodedEntry
+}
+
+// printDecodedEntry prints a decoded entry either as a single line of JSON or as a readable block
+func printDecodedEntry(decodedEntry DecodedEntry, asJSON bool) {
+
+	if asJSON {
+		entryJSON, err := json.Marshal(decodedEntry)
+		if err != nil {
+			fmt.Printf("failed to marshal entry: %v\n", err)
+			return
+		}
+		fmt.Println(string(entryJSON))
+		return
+	}
+
+	fmt.Printf("%s @ %d  %s  key=%s\n", decodedEntry.Topic, decodedEntry.Offset, decodedEntry.Timestamp.Format(time.RFC3339Nano), decodedEntry.Key)
+	if sc, err := parseTraceparent(decodedEntry.Headers[TRACEPARENT_HEADER]); err == nil {
+		fmt.Printf("  trace=%s span=%s\n", sc.TraceIDString(), sc.SpanIDString())
+	}
+	if contentType := decodedEntry.Headers[CONTENT_TYPE_HEADER]; contentType != "" && contentType != JSON_CONTENT_TYPE {
+		fmt.Printf("  content-type=%s\n", contentType)
+	}
+	if encoding := decodedEntry.Headers[CONTENT_ENCODING_HEADER]; encoding != "" {
+		fmt.Printf("  content-encoding=%s\n", encoding)
+	}
+	if wrappedDataKey := decodedEntry.Headers[ENCRYPTION_KEY_HEADER]; wrappedDataKey != "" {
+		fmt.Printf("  encrypted with key %s\n", wrappedDataKeyID(wrappedDataKey))
+	}
+	if decodedEntry.UpcastFrom > 0 {
+		fmt.Printf("  upcast from version %d\n", decodedEntry.UpcastFrom)
+	}
+	if decodedEntry.DecodeError != "" {
+		fmt.Printf("  failed to decode %s: %s\n", decodedEntry.PayloadType, decodedEntry.DecodeError)
+	}
+	payloadJSON, err := json.MarshalIndent(decodedEntry.Payload, "  ", "  ")
+	if err != nil {
+		fmt.Printf("  failed to marshal payload: %v\n", err)
+		return
+	}
+	if decodedEntry.PayloadType != "" {
+		fmt.Printf("  %s ", decodedEntry.PayloadType)
+	} else {
+		fmt.Print("  ")
+	}
+	fmt.Println(string(payloadJSON))
+}
+
+// topicLength returns the number of entries in a topic, finding the first offset which cannot be read
+// with an exponential then binary search, as offsets in a topic are contiguous from zero
+func topicLength(store MockableMessageStore, topic string) int64 {
+
+	readable := func(offset int64) bool {
+		entry, err := store.ReadEntry(topic, offset)
+		return err == nil && entry != nil
+	}
+
+	if !readable(0) {
+		return 0
+	}
+
+	// readable(low) holds and readable(high) does not
+	low, high := int64(0), int64(1)
+	for readable(high) {
+		low = high
+		high *= 2
+	}
+	for high-low > 1 {
+		mid := low + (high-low)/2
+		if readable(mid) {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return low + 1
+}
+
+// discoverTopics returns every known topic, and any other topic with a data file in the current directory
+func discoverTopics() []string {
+
+	seen := make(map[string]bool)
+	var topics []string
+	for _, topicDefinition := range topicDefinitions() {
+		seen[topicDefinition.Name] = true
+		topics = append(topics, topicDefinition.Name)
+	}
+
+	filenames, _ := filepath.Glob("*.data")
+	for _, filename := range filenames {
+		topic := strings.TrimSuffix(filename, ".data")
+		if !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+
+	sort.Strings(topics)
+	return topics
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Define constants for span kinds, using the OTLP enumeration values
+const (
+	SPAN_KIND_INTERNAL = 1
+	SPAN_KIND_PRODUCER = 4
+	SPAN_KIND_CONSUMER = 5
+)
+
+// Define constants for span status codes, using the OTLP enumeration values
+const (
+	SPAN_STATUS_UNSET = 0
+	SPAN_STATUS_OK    = 1
+	SPAN_STATUS_ERROR = 2
+)
+
+// Define the structure of SpanContext
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid reports whether the span context has a trace id and a span id
+func (sc SpanContext) IsValid() bool {
+
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceIDString returns the trace id as a hex string
+func (sc SpanContext) TraceIDString() string {
+
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// SpanIDString returns the span id as a hex string
+func (sc SpanContext) SpanIDString() string {
+
+	return hex.EncodeToString(sc.SpanID[:])
+}
+
+// Define the structure of Span
+type Span struct {
+	tracer        *Tracer
+	mu            sync.Mutex
+	Context       SpanContext
+	ParentSpanID  [8]byte
+	Name          string
+	Kind          int
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]interface{}
+	StatusCode    int
+	StatusMessage string
+	ended         bool
+}
+
+// SetAttribute records an attribute on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// RecordError marks the span as failed with the given error
+func (s *Span) RecordError(err error) {
+
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.StatusCode = SPAN_STATUS_ERROR
+	s.StatusMessage = err.Error()
+}
+
+// End completes the span and hands it to the tracer's exporter
+func (s *Span) End() {
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.exporter.ExportSpan(s)
+}
+
+// Define the structure of Tracer
+type Tracer struct {
+	ServiceName string
+	exporter    SpanExporter
+	Log         io.Writer // where failures to generate trace and span ids are logged
+}
+
+// NewTracer creates a new instance of Tracer
+func NewTracer(serviceName string, exporter SpanExporter) *Tracer {
+
+	if exporter == nil {
+		exporter = NewNoopSpanExporter()
+	}
+	return &Tracer{
+		ServiceName: serviceName,
+		exporter:    exporter,
+		Log:         os.Stdout,
+	}
+}
+
+// spanContextKey is the context key under which the current span context is stored
+type spanContextKey struct{}
+
+// Start creates a span which is a child of the span context in ctx, or a new trace when ctx has none
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+
+	parent, _ := ctx.Value(spanContextKey{}).(SpanContext)
+
+	span := &Span{
+		tracer:     t,
+		Name:       name,
+		Kind:       kind,
+		StartTime:  time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		t.randomBytes(span.Context.TraceID[:])
+	}
+	t.randomBytes(span.Context.SpanID[:])
+
+	return context.WithValue(ctx, spanContextKey{}, span.Context), span
+}
+
+// StartFromHeaders creates a span which continues the trace carried in message envelope headers
+func (t *Tracer) StartFromHeaders(ctx context.Context, headers map[string]string, name string, kind int) (context.Context, *Span) {
+
+	if sc, err := parseTraceparent(headers[TRACEPARENT_HEADER]); err == nil {
+		ctx = context.WithValue(ctx, spanContextKey{}, sc)
+	}
+	return t.Start(ctx, name, kind)
+}
+
+// Shutdown flushes any spans buffered by the exporter
+func (t *Tracer) Shutdown() error {
+
+	return t.exporter.Shutdown()
+}
+
+// spanContextFromContext returns the current span context, if any
+func spanContextFromContext(ctx context.Context) (SpanContext, bool) {
+
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// injectTraceContext writes the current span context into message envelope headers as a W3C traceparent
+func injectTraceContext(ctx context.Context, headers map[string]string) {
+
+	sc, ok := spanContextFromContext(ctx)
+	if !ok {
+		return
+	}
+	headers[TRACEPARENT_HEADER] = fmt.Sprintf("00-%s-%s-01", sc.TraceIDString(), sc.SpanIDString())
+}
+
+// parseTraceparent parses a W3C traceparent header value
+func parseTraceparent(traceparent string) (SpanContext, error) {
+
+	var sc SpanContext
+
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return sc, fmt.Errorf("invalid traceparent '%s'", traceparent)
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return sc, fmt.Errorf("invalid trace id in traceparent '%s'", traceparent)
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return sc, fmt.Errorf("invalid span id in traceparent '%s'", traceparent)
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent '%s'", traceparent)
+	}
+	return sc, nil
+}
+
+// randomBytes fills b with random bytes
+func (t *Tracer) randomBytes(b []byte) {
+
+	if _, err := rand.Read(b); err != nil {
+		fmt.Fprintf(t.Log, "failed to generate random id: %v\n", err)
+	}
+}
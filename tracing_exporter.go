@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SpanExporter receives completed spans
+type SpanExporter interface {
+	ExportSpan(span *Span)
+	Shutdown() error
+}
+
+// Define the structure of NoopSpanExporter
+type NoopSpanExporter struct{}
+
+// NewNoopSpanExporter creates a new instance of NoopSpanExporter
+func NewNoopSpanExporter() *NoopSpanExporter {
+
+	return &NoopSpanExporter{}
+}
+
+// ExportSpan discards the span
+func (e *NoopSpanExporter) ExportSpan(span *Span) {}
+
+// Shutdown does nothing
+func (e *NoopSpanExporter) Shutdown() error {
+
+	return nil
+}
+
+// Define the structure of OTLPJSONExporter, which buffers spans and writes them as OTLP/JSON export requests
+type OTLPJSONExporter struct {
+	mu            sync.Mutex
+	spans         []*Span
+	batchSize     int
+	write         func(request []byte) error
+	stop          chan struct{}
+	stopped       chan struct{}
+	shutdownOnce  sync.Once
+	closeResource func() error
+	Log           io.Writer // where failures to export spans are logged
+}
+
+// newOTLPJSONExporter creates a new instance of OTLPJSONExporter which flushes on a timer or when a batch is full
+func newOTLPJSONExporter(write func(request []byte) error, closeResource func() error) *OTLPJSONExporter {
+
+	e := &OTLPJSONExporter{
+		batchSize:     512,
+		write:         write,
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		closeResource: closeResource,
+		Log:           os.Stdout,
+	}
+	go e.flushPeriodically(5 * time.Second)
+	return e
+}
+
+// NewOTLPFileExporter creates an exporter which appends one OTLP/JSON export request per line to a file,
+// the same layout the OpenTelemetry Collector file exporter writes and its otlpjsonfile receiver reads
+func NewOTLPFileExporter(filename string) (*OTLPJSONExporter, error) {
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %v", err)
+	}
+
+	write := func(request []byte) error {
+		_, err := fmt.Fprintf(file, "%s\n", request)
+		return err
+	}
+	return newOTLPJSONExporter(write, file.Close), nil
+}
+
+// NewOTLPHTTPExporter creates an exporter which posts OTLP/JSON export requests to a collector, e.g. http://localhost:4318/v1/traces
+func NewOTLPHTTPExporter(endpoint string) *OTLPJSONExporter {
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	write := func(request []byte) error {
+		response, err := client.Post(endpoint, "application/json", bytes.NewReader(request))
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode/100 != 2 {
+			return fmt.Errorf("collector returned status %s", response.Status)
+		}
+		return nil
+	}
+	return newOTLPJSONExporter(write, nil)
+}
+
+// ExportSpan buffers the span, flushing when the batch is full
+func (e *OTLPJSONExporter) ExportSpan(span *Span) {
+
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	full := len(e.spans) >= e.batchSize
+	e.mu.Unlock()
+
+	if full {
+		e.Flush()
+	}
+}
+
+// Flush writes all buffered spans
+func (e *OTLPJSONExporter) Flush() error {
+
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	request, err := json.Marshal(newOTLPExportRequest(spans))
+	if err != nil {
+		return fmt.Errorf("failed to marshal spans: %v", err)
+	}
+	if err := e.write(request); err != nil {
+		fmt.Fprintf(e.Log, "failed to export %d spans: %v\n", len(spans), err)
+		return err
+	}
+	return nil
+}
+
+// flushPeriodically flushes buffered spans until the exporter is shut down
+func (e *OTLPJSONExporter) flushPeriodically(interval time.Duration) {
+
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			e.Flush()
+		}
+	}
+}
+
+// Shutdown stops the periodic flush and writes any remaining spans
+func (e *OTLPJSONExporter) Shutdown() error {
+
+	var err error
+	e.shutdownOnce.Do(func() {
+		close(e.stop)
+		<-e.stopped
+		err = e.Flush()
+		if e.closeResource != nil {
+			if closeErr := e.closeResource(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// Define the structures of an OTLP/JSON trace export request
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// newOTLPExportRequest converts spans into an OTLP/JSON export request, grouped by service name
+func newOTLPExportRequest(spans []*Span) otlpExportRequest {
+
+	var request otlpExportRequest
+	resourceIndex := make(map[string]int)
+
+	for _, span := range spans {
+		serviceName := span.tracer.ServiceName
+		i, ok := resourceIndex[serviceName]
+		if !ok {
+			i = len(request.ResourceSpans)
+			resourceIndex[serviceName] = i
+			request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{
+					Attributes: []otlpKeyValue{newOTLPKeyValue("service.name", serviceName)},
+				},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: SERVICE_NAME}}},
+			})
+		}
+		scopeSpans := &request.ResourceSpans[i].ScopeSpans[0]
+		scopeSpans.Spans = append(scopeSpans.Spans, newOTLPSpan(span))
+	}
+
+	return request
+}
+
+// newOTLPSpan converts a span into its OTLP/JSON representation
+func newOTLPSpan(span *Span) otlpSpan {
+
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	s := otlpSpan{
+		TraceID:           span.Context.TraceIDString(),
+		SpanID:            span.Context.SpanIDString(),
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		Status:            otlpStatus{Code: span.StatusCode, Message: span.StatusMessage},
+	}
+	if span.ParentSpanID != [8]byte{} {
+		s.ParentSpanID = SpanContext{SpanID: span.ParentSpanID}.SpanIDString()
+	}
+	for key, value := range span.Attributes {
+		s.Attributes = append(s.Attributes, newOTLPKeyValue(key, value))
+	}
+	return s
+}
+
+// newOTLPKeyValue converts an attribute into its OTLP/JSON representation
+func newOTLPKeyValue(key string, value interface{}) otlpKeyValue {
+
+	var v otlpValue
+	switch typed := value.(type) {
+	case string:
+		v.StringValue = &typed
+	case int:
+		s := strconv.Itoa(typed)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(typed, 10)
+		v.IntValue = &s
+	case bool:
+		v.BoolValue = &typed
+	case float64:
+		v.DoubleValue = &typed
+	default:
+		s := fmt.Sprint(typed)
+		v.StringValue = &s
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
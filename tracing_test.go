@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestParseTraceparent checks well formed traceparents parse, and malformed, unsupported and all-zero ones are rejected
+func TestParseTraceparent(t *testing.T) {
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name        string
+		traceparent string
+		valid       bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true},
+		{"empty", "", false},
+		{"missing flags", "00-" + traceID + "-" + spanID, false},
+		{"extra field", "00-" + traceID + "-" + spanID + "-01-00", false},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", false},
+		{"unknown version", "01-" + traceID + "-" + spanID + "-01", false},
+		{"trace id not hex", "00-" + strings.Repeat("z", 32) + "-" + spanID + "-01", false},
+		{"trace id short", "00-" + traceID[:30] + "-" + spanID + "-01", false},
+		{"span id not hex", "00-" + traceID + "-" + strings.Repeat("z", 16) + "-01", false},
+		{"span id long", "00-" + traceID + "-" + spanID + "00-01", false},
+		{"all-zero trace id", "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01", false},
+		{"all-zero span id", "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", false},
+	}
+	for _, test := range tests {
+		sc, err := parseTraceparent(test.traceparent)
+		if test.valid {
+			if err != nil {
+				t.Errorf("%s: error %v, want it parsed", test.name, err)
+				continue
+			}
+			if sc.TraceIDString() != traceID || sc.SpanIDString() != spanID {
+				t.Errorf("%s: got %s-%s, want %s-%s", test.name, sc.TraceIDString(), sc.SpanIDString(), traceID, spanID)
+			}
+		} else if err == nil {
+			t.Errorf("%s: parsed %+v, want an error", test.name, sc)
+		}
+	}
+}
+
+// TestTraceparentRoundTrip checks a span context written into headers parses back unchanged, and a span started from
+// those headers continues the trace as a child of the span which wrote them
+func TestTraceparentRoundTrip(t *testing.T) {
+
+	tracer := NewTracer(SERVICE_NAME, nil)
+	ctx, parent := tracer.Start(context.Background(), "produce", SPAN_KIND_PRODUCER)
+	headers := make(map[string]string)
+	injectTraceContext(ctx, headers)
+
+	sc, err := parseTraceparent(headers[TRACEPARENT_HEADER])
+	if err != nil {
+		t.Fatalf("traceparent %q: %v", headers[TRACEPARENT_HEADER], err)
+	}
+	if sc != parent.Context {
+		t.Errorf("parsed %+v, want %+v", sc, parent.Context)
+	}
+
+	_, child := tracer.StartFromHeaders(context.Background(), headers, "consume", SPAN_KIND_CONSUMER)
+	if child.Context.TraceID != parent.Context.TraceID || child.ParentSpanID != parent.Context.SpanID || child.Context.SpanID == parent.Context.SpanID {
+		t.Errorf("child %+v, want a new span in trace %s under span %s", child.Context, parent.Context.TraceIDString(), parent.Context.SpanIDString())
+	}
+
+	empty := make(map[string]string)
+	injectTraceContext(context.Background(), empty)
+	if len(empty) != 0 {
+		t.Errorf("headers %v, want none without a span context", empty)
+	}
+}
+
+// TestOTLPJSONExporter checks buffered spans are written as one OTLP/JSON export request, and a failed write is
+// returned and logged to the exporter's log writer
+func TestOTLPJSONExporter(t *testing.T) {
+
+	var requests [][]byte
+	var failure error
+	exporter := newOTLPJSONExporter(func(request []byte) error {
+		requests = append(requests, request)
+		return failure
+	}, nil)
+	var log bytes.Buffer
+	exporter.Log = &log
+	defer exporter.Shutdown()
+
+	tracer := NewTracer(SERVICE_NAME, exporter)
+	_, span := tracer.Start(context.Background(), "consume", SPAN_KIND_CONSUMER)
+	span.SetAttribute("offset", 7)
+	span.End()
+	if err := exporter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var request otlpExportRequest
+	if len(requests) != 1 {
+		t.Fatalf("%d requests written, want 1", len(requests))
+	}
+	if err := json.Unmarshal(requests[0], &request); err != nil {
+		t.Fatal(err)
+	}
+	if spans := request.ResourceSpans[0].ScopeSpans[0].Spans; len(spans) != 1 || spans[0].TraceID != span.Context.TraceIDString() || spans[0].Name != "consume" {
+		t.Errorf("spans %+v, want the ended span", spans)
+	}
+	if log.Len() != 0 {
+		t.Errorf("logged %q, want nothing", log.String())
+	}
+
+	failure = fmt.Errorf("collector unreachable")
+	_, span = tracer.Start(context.Background(), "consume", SPAN_KIND_CONSUMER)
+	span.End()
+	if err := exporter.Flush(); err != failure {
+		t.Errorf("error %v, want %v", err, failure)
+	}
+	if want := "failed to export 1 spans: collector unreachable\n"; log.String() != want {
+		t.Errorf("logged %q, want %q", log.String(), want)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the structure of Upcaster, which transforms a payload from one version of its topic's schema to the next
+type Upcaster struct {
+	Topic       string
+	FromVersion int
+	Upcast      func(payload map[string]interface{}) error
+}
+
+// upcasters returns every upcaster, each of which is kept for as long as entries of its version may remain in a topic
+func upcasters() []Upcaster {
+
+	return []Upcaster{
+		{SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC, 1, upcastSubjectRegionDocumentResponseV1},
+		{SUBJECT_DOCUMENTS_TOPIC, 1, upcastSubjectDocumentsV1},
+		{SYSTEM_AUDIT_EVENT_TOPIC, 1, upcastSystemAuditEventV1},
+		{SYSTEM_AUDIT_EVENT_TOPIC, 2, upcastSystemAuditEventV2},
+		{USER_SUBJECT_ACCESS_ATTEMPT_TOPIC, 1, upcastUserSubjectAccessAttemptV1},
+	}
+}
+
+// findUpcaster returns the upcaster from a version of a topic's schema
+func findUpcaster(topic string, fromVersion int) (Upcaster, bool) {
+
+	for _, upcaster := range upcasters() {
+		if upcaster.Topic == topic && upcaster.FromVersion == fromVersion {
+			return upcaster, true
+		}
+	}
+	return Upcaster{}, false
+}
+
+// currentPayloadVersion returns the version of a topic's schema the code writes, or 0 for a topic without a schema
+func currentPayloadVersion(topic string) int {
+
+	return embeddedSchemas.LatestVersion(topic)
+}
+
+// upcastPayload transforms a payload written with an older version of its topic's schema, one version at a time, to the current version
+func upcastPayload(topic string, version int, payload json.RawMessage) (json.RawMessage, error) {
+
+	current := currentPayloadVersion(topic)
+	if version > current {
+		return nil, fmt.Errorf("payload version %d of topic '%s' is newer than the current version %d", version, topic, current)
+	}
+	if version == current {
+		return payload, nil
+	}
+
+	// Decode numbers as json.Number, so large integers survive the round trip
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload version %d of topic '%s': %v", version, topic, err)
+	}
+
+	for ; version < current; version++ {
+		upcaster, ok := findUpcaster(topic, version)
+		if !ok {
+			return nil, fmt.Errorf("no upcaster from payload version %d of topic '%s'", version, topic)
+		}
+		if err := upcaster.Upcast(fields); err != nil {
+			return nil, fmt.Errorf("failed to upcast payload version %d of topic '%s': %v", version, topic, err)
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// upcastEntry rewrites an entry whose payload was written with an older version of its topic's schema to the current version, as JSON
+func upcastEntry(topic string, entry ms.Entry) (ms.Entry, error) {
+
+	envelope := decodeMessageEnvelope(entry)
+	version := envelope.SchemaVersion()
+	if version == currentPayloadVersion(topic) || currentPayloadVersion(topic) == 0 {
+		return entry, nil
+	}
+
+	payload, err := envelope.JSONPayload(topic)
+	if err != nil {
+		return entry, err
+	}
+	if codec, _ := envelope.Codec(); !isProtobufCodec(codec) {
+		// a protobuf payload is decoded into the current payload type, its field numbers keeping older versions readable
+		payload, err = upcastPayload(topic, version, payload)
+		if err != nil {
+			return entry, err
+		}
+	}
+
+	headers := make(map[string]string)
+	for name, value := range envelope.Headers {
+		headers[name] = value
+	}
+	headers[SCHEMA_VERSION_HEADER] = strconv.Itoa(currentPayloadVersion(topic))
+	headers[CONTENT_TYPE_HEADER] = JSON_CONTENT_TYPE
+	delete(headers, CONTENT_ENCODING_HEADER)
+	envelopeJSON, err := json.Marshal(NewMessageEnvelope(headers, payload))
+	if err != nil {
+		return entry, fmt.Errorf("failed to marshal message envelope: %v", err)
+	}
+	entry.Value = envelopeJSON
+	return entry, nil
+}
+
+// isProtobufCodec reports whether a codec writes protobuf
+func isProtobufCodec(codec Codec) bool {
+
+	_, ok := codec.(ProtobufCodec)
+	return ok
+}
+
+// upcastSubjectRegionDocumentResponseV1 replaces the error object of version 1, which marshalled Go errors as an empty object
+// and so lost their message, with an error message, which is omitted when there was no error
+func upcastSubjectRegionDocumentResponseV1(payload map[string]interface{}) error {
+
+	switch value := payload["error"].(type) {
+	case nil:
+		delete(payload, "error")
+	case string:
+		// already a message
+	case map[string]interface{}:
+		payload["error"] = "unknown error"
+	default:
+		return fmt.Errorf("unexpected error value %v", value)
+	}
+	return nil
+}
+
+// upcastSubjectDocumentsV1 leaves the payload as it is. version 1 documents were delivered without applying entitlements,
+// so none were withheld, which version 2 represents by omitting withheld
+func upcastSubjectDocumentsV1(payload map[string]interface{}) error {
+
+	return nil
+}
+
+// upcastSystemAuditEventV1 leaves the payload as it is. version 1 events were about a subject at most, never a document,
+// which version 2 represents by omitting documentIdentifier
+func upcastSystemAuditEventV1(payload map[string]interface{}) error {
+
+	return nil
+}
+
+// upcastSystemAuditEventV2 leaves the payload as it is. version 2 events were written before sessions,
+// which version 3 represents by omitting sessionIdentifier
+func upcastSystemAuditEventV2(payload map[string]interface{}) error {
+
+	return nil
+}
+
+// upcastUserSubjectAccessAttemptV1 gives attempts of version 1, which were made before sessions, an empty session identifier.
+// no session has an empty identifier, so a replayed attempt of version 1 is rejected rather than accepted without a session
+func upcastUserSubjectAccessAttemptV1(payload map[string]interface{}) error {
+
+	payload["sessionIdentifier"] = ""
+	return nil
+}
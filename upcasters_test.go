@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	ms "github.com/mmcnicol/message-store"
+)
+
+// Define the directory of fixture entries, testdata/upcast/<topic>/v<version>[-<variant>].json,
+// where a -legacy variant is a bare payload written before envelopes were introduced
+const UPCAST_FIXTURE_DIRECTORY = "testdata/upcast"
+
+// readUpcastFixtures returns every fixture entry of a topic, keyed by file name
+func readUpcastFixtures(t *testing.T, topic string) map[string]ms.Entry {
+
+	t.Helper()
+	filenames, err := filepath.Glob(filepath.Join(UPCAST_FIXTURE_DIRECTORY, topic, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures := make(map[string]ms.Entry)
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixtures[filepath.Base(filename)] = ms.Entry{Key: []byte("jbloggs"), Value: bytes.TrimSpace(data)}
+	}
+	return fixtures
+}
+
+// fixtureVersion returns the payload version a fixture file name declares
+func fixtureVersion(filename string) (int, bool) {
+
+	name := strings.TrimSuffix(filename, ".json")
+	if i := strings.Index(name, "-"); i >= 0 {
+		name = name[:i]
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(name, "v"))
+	return version, err == nil && strings.HasPrefix(name, "v")
+}
+
+// TestUpcastFixturesCoverEveryVersion checks there is a fixture entry of every registered version of every topic's schema
+func TestUpcastFixturesCoverEveryVersion(t *testing.T) {
+
+	for _, topicDefinition := range topicDefinitions() {
+		covered := make(map[int]bool)
+		for filename := range readUpcastFixtures(t, topicDefinition.Name) {
+			version, ok := fixtureVersion(filename)
+			if !ok {
+				t.Errorf("%s: fixture '%s' is not named v<version>[-<variant>].json", topicDefinition.Name, filename)
+			}
+			covered[version] = true
+		}
+		for version := 1; version <= embeddedSchemas.LatestVersion(topicDefinition.Name); version++ {
+			if !covered[version] {
+				t.Errorf("%s: no fixture entry of version %d", topicDefinition.Name, version)
+			}
+		}
+	}
+}
+
+// TestUpcastEntry checks every fixture entry is upcast to the current version, matches the current schema and decodes into the current struct
+func TestUpcastEntry(t *testing.T) {
+
+	for _, topicDefinition := range topicDefinitions() {
+		current := currentPayloadVersion(topicDefinition.Name)
+		for filename, entry := range readUpcastFixtures(t, topicDefinition.Name) {
+			t.Run(topicDefinition.Name+"/"+filename, func(t *testing.T) {
+
+				upcast, err := upcastEntry(topicDefinition.Name, entry)
+				if err != nil {
+					t.Fatalf("upcastEntry: %v", err)
+				}
+
+				envelope := decodeMessageEnvelope(upcast)
+				if envelope.SchemaVersion() != current {
+					t.Errorf("schema version %d, want %d", envelope.SchemaVersion(), current)
+				}
+				if err := embeddedSchemas.Validate(topicDefinition.Name, envelope.Payload); err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+
+				decoder := json.NewDecoder(bytes.NewReader(envelope.Payload))
+				decoder.DisallowUnknownFields()
+				if err := decoder.Decode(topicDefinition.NewPayload()); err != nil {
+					t.Errorf("failed to decode into %s: %v", topicDefinition.PayloadType, err)
+				}
+
+				if original := decodeMessageEnvelope(entry); original.Headers[TRACEPARENT_HEADER] != envelope.Headers[TRACEPARENT_HEADER] {
+					t.Errorf("traceparent %q, want %q", envelope.Headers[TRACEPARENT_HEADER], original.Headers[TRACEPARENT_HEADER])
+				}
+			})
+		}
+	}
+}
+
+// TestUpcastSubjectRegionDocumentResponseV1 checks the error of version 1 becomes a message, or is omitted when there was no error
+func TestUpcastSubjectRegionDocumentResponseV1(t *testing.T) {
+
+	fixtures := readUpcastFixtures(t, SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC)
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"v1.json", ""},
+		{"v1-error.json", "unknown error"},
+		{"v1-legacy.json", "unknown error"},
+		{"v2.json", "system unavailable"},
+	}
+	for _, test := range tests {
+		upcast, err := upcastEntry(SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC, fixtures[test.filename])
+		if err != nil {
+			t.Fatalf("%s: upcastEntry: %v", test.filename, err)
+		}
+		var subjectRegionDocumentResponse SubjectRegionDocumentResponse
+		if err := json.Unmarshal(decodeMessageEnvelope(upcast).Payload, &subjectRegionDocumentResponse); err != nil {
+			t.Fatalf("%s: %v", test.filename, err)
+		}
+		if subjectRegionDocumentResponse.Error != test.want {
+			t.Errorf("%s: error %q, want %q", test.filename, subjectRegionDocumentResponse.Error, test.want)
+		}
+	}
+}
+
+// TestUpcastPayloadRejectsNewerVersion checks an entry written by a newer version of the application is not silently misread
+func TestUpcastPayloadRejectsNewerVersion(t *testing.T) {
+
+	topic := SUBJECT_REGION_DOCUMENT_RESPONSE_TOPIC
+	if _, err := upcastPayload(topic, currentPayloadVersion(topic)+1, json.RawMessage(`{}`)); err == nil {
+		t.Error("expected an error upcasting a newer payload version")
+	}
+}
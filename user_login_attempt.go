@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -13,8 +12,8 @@ import (
 
 // Define the structure of UserLoginAttempt
 type UserLoginAttempt struct {
-	UserName     string `json:"userName"`
-	UserPassword string `json:"userPassword"`
+	UserName     string `json:"userName" protobuf:"1"`
+	UserPassword string `json:"userPassword" protobuf:"2"`
 }
 
 // NewUserLoginAttempt creates a new instance of UserLoginAttempt
@@ -26,6 +25,18 @@ func NewUserLoginAttempt(userName, userPassword string) *UserLoginAttempt {
 	}
 }
 
+// Validate checks that a UserLoginAttempt has every required field
+func (u UserLoginAttempt) Validate() error {
+
+	if u.UserName == "" {
+		return fmt.Errorf("userName is required")
+	}
+	if u.UserPassword == "" {
+		return fmt.Errorf("userPassword is required")
+	}
+	return nil
+}
+
 // generateUserLoginAttempts generates user login events
 func (b *Backend) generateUserLoginAttempts(ctx context.Context) {
 
@@ -33,23 +44,38 @@ func (b *Backend) generateUserLoginAttempts(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			fmt.Println("Polling canceled")
+			fmt.Fprintln(b.Log, "Polling canceled")
 			return
 		default:
-			b.generateUserLoginAttempt()
-			// Wait for a short duration
-			time.Sleep(10 * time.Second)
+			b.generateUserLoginAttempt(ctx)
+			// Wait for a short duration, or until the context is canceled
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Second):
+			}
 		}
 	}
 }
 
 // generateUserLoginAttempt generates a user login attempt
-func (b *Backend) generateUserLoginAttempt() {
+func (b *Backend) generateUserLoginAttempt(ctx context.Context) {
 
 	userLoginAttempt := NewUserLoginAttempt(b.generateRandomUserName(), b.generateRandomPassword(8))
-	b.sendUserLoginAttempt(*userLoginAttempt)
+	b.startUserLoginAttempt(ctx, *userLoginAttempt)
+}
+
+// startUserLoginAttempt sends a user login attempt and its audit event, which starts a new trace
+func (b *Backend) startUserLoginAttempt(ctx context.Context, userLoginAttempt UserLoginAttempt) {
+
+	ctx, span := b.Tracer.Start(ctx, "generateUserLoginAttempt", SPAN_KIND_INTERNAL)
+	defer span.End()
+
+	span.SetAttribute("user.name", userLoginAttempt.UserName)
+	if err := b.sendUserLoginAttempt(ctx, userLoginAttempt); err != nil {
+		fmt.Fprintln(b.Log, err)
+	}
 	systemAuditEvent := NewSystemAuditEvent(userLoginAttempt.UserName, "login attempt")
-	b.sendSystemAuditEvent(*systemAuditEvent)
+	b.sendSystemAuditEvent(ctx, *systemAuditEvent)
 }
 
 // generateRandomUserName generates a random userName
@@ -86,78 +112,65 @@ func (b *Backend) generateRandomPassword(userPasswordLength int) string {
 }
 
 // sendUserLoginAttempt sends a user login attempt to a topic
-func (b *Backend) sendUserLoginAttempt(userLoginAttempt UserLoginAttempt) {
+func (b *Backend) sendUserLoginAttempt(ctx context.Context, userLoginAttempt UserLoginAttempt) error {
 
 	topic := USER_LOGIN_ATTEMPT_TOPIC
 
-	eventJSON, err := json.Marshal(userLoginAttempt)
+	ctx, span := b.Tracer.Start(ctx, "sendUserLoginAttempt", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(userLoginAttempt)
 	if err != nil {
-		fmt.Printf("failed to marshal event: %v", err)
+		span.RecordError(err)
+		return fmt.Errorf("failed to marshal userLoginAttempt: %v", err)
 	}
 
-	messageStoreEntry := ms.Entry{}
-	messageStoreEntry.Key = []byte(userLoginAttempt.UserName)
-	messageStoreEntry.Value = []byte(eventJSON)
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, userLoginAttempt.UserName, codec, payload)
 
 	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
 	if err != nil {
-		fmt.Printf("SaveEntry for topic '%s' failed: %v", topic, err)
+		span.RecordError(err)
+		return fmt.Errorf("SaveEntry for topic '%s' failed: %v", topic, err)
 	}
-	fmt.Println("saved userLoginAttempt to topic ", topic, " at offset ", offset)
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved userLoginAttempt to topic ", topic, " at offset ", offset)
+	return nil
 }
 
 // pollUserLoginAttempt polls the topic for user login attempts
 func (b *Backend) pollUserLoginAttempt(ctx context.Context) {
 
-	topic := USER_LOGIN_ATTEMPT_TOPIC
-	offset := int64(-1)
-	pollDuration := 100 * time.Millisecond
-
-	// Loop until the context is canceled
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Polling canceled")
-			return
-		default:
-			//entry, err := b.MessageStore.ReadEntry(topic, offset)
-			entry, err := b.MessageStore.PollForNextEntry(topic, offset, pollDuration)
-			if err != nil {
-				// do nothing - as this just means there are no unread entries in the topic
-				//fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned error: ", err)
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-			}
-			if entry == nil {
-				//fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned nil entry.Value")
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-				continue // Continue to the next iteration of the loop
-			}
-			fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned an entry")
-			offset++
-			b.processEntryFromPollUserLoginAttempt(*entry)
-		}
-	}
+	b.pollTopic(ctx, USER_LOGIN_ATTEMPT_CONSUMER, USER_LOGIN_ATTEMPT_TOPIC, b.processEntryFromPollUserLoginAttempt)
 }
 
 // processEntryFromPollUserLoginAttempt processes an entry from polling a topic
-func (b *Backend) processEntryFromPollUserLoginAttempt(entry ms.Entry) {
+func (b *Backend) processEntryFromPollUserLoginAttempt(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	ctx, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPollUserLoginAttempt", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", USER_LOGIN_ATTEMPT_TOPIC)
 
 	var userLoginAttempt UserLoginAttempt
-	if err := json.Unmarshal(entry.Value, &userLoginAttempt); err != nil {
-		fmt.Printf("failed to unmarshal userLoginAttempt: %v", err)
+	if err := envelope.DecodePayload(&userLoginAttempt); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal userLoginAttempt: %v", err)
 	}
-	fmt.Printf("Received userLoginAttempt: %v\n", userLoginAttempt)
+	fmt.Fprintf(b.Log, "Received userLoginAttempt: %v\n", userLoginAttempt)
 
-	b.processUserLoginAttempt(userLoginAttempt)
+	b.processUserLoginAttempt(ctx, userLoginAttempt)
 }
 
 // processUserLoginAttempt processes a user login attempt
-func (b *Backend) processUserLoginAttempt(userLoginAttempt UserLoginAttempt) {
+func (b *Backend) processUserLoginAttempt(ctx context.Context, userLoginAttempt UserLoginAttempt) {
+
+	ctx, span := b.Tracer.Start(ctx, "processUserLoginAttempt", SPAN_KIND_INTERNAL)
+	defer span.End()
 
-	userLoginAttemptOutcome := NewUserLoginAttemptOutcome(userLoginAttempt.UserName, b.getUserLoginAttemptOutcome())
-	b.sendUserLoginAttemptOutcome(*userLoginAttemptOutcome)
+	userLoginAttemptOutcome := NewUserLoginAttemptOutcome(userLoginAttempt.UserName, b.getUserLoginAttemptOutcome(userLoginAttempt))
+	b.sendUserLoginAttemptOutcome(ctx, *userLoginAttemptOutcome)
 
 	var auditEvent string
 	if userLoginAttemptOutcome.Outcome {
@@ -165,6 +178,7 @@ func (b *Backend) processUserLoginAttempt(userLoginAttempt UserLoginAttempt) {
 	} else {
 		auditEvent = "login failed"
 	}
+	span.SetAttribute("login.outcome", userLoginAttemptOutcome.Outcome)
 	systemAuditEvent := NewSystemAuditEvent(userLoginAttempt.UserName, auditEvent)
-	b.sendSystemAuditEvent(*systemAuditEvent)
+	b.sendSystemAuditEvent(ctx, *systemAuditEvent)
 }
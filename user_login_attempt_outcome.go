@@ -2,18 +2,16 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math/rand"
-	"time"
 
 	ms "github.com/mmcnicol/message-store"
 )
 
 // Define the structure of UserLoginAttemptOutcome
 type UserLoginAttemptOutcome struct {
-	UserName string `json:"userName"`
-	Outcome  bool   `json:"outcome"`
+	UserName string `json:"userName" protobuf:"1"`
+	Outcome  bool   `json:"outcome" protobuf:"2"`
 }
 
 // NewUserLoginAttemptOutcome creates a new instance of UserLoginAttemptOutcome
@@ -25,8 +23,12 @@ func NewUserLoginAttemptOutcome(userName string, outcome bool) *UserLoginAttempt
 	}
 }
 
-// getUserLoginAttemptOutcome returns a user login attempt outcome
-func (b *Backend) getUserLoginAttemptOutcome() bool {
+// getUserLoginAttemptOutcome returns a user login attempt outcome, checking the password when the user is a known persona
+func (b *Backend) getUserLoginAttemptOutcome(userLoginAttempt UserLoginAttempt) bool {
+
+	if persona, ok := b.Personas.Find(userLoginAttempt.UserName); ok {
+		return userLoginAttempt.UserPassword == persona.Password
+	}
 
 	// Generate a random number between 0 and 99
 	randomNumber := rand.Intn(100)
@@ -36,71 +38,65 @@ func (b *Backend) getUserLoginAttemptOutcome() bool {
 }
 
 // sendUserLoginAttemptOutcome sends a user login attempt outcome to a topic
-func (b *Backend) sendUserLoginAttemptOutcome(userLoginAttemptOutcome UserLoginAttemptOutcome) {
+func (b *Backend) sendUserLoginAttemptOutcome(ctx context.Context, userLoginAttemptOutcome UserLoginAttemptOutcome) {
 
 	topic := USER_LOGIN_ATTEMPT_OUTCOME_TOPIC
 
-	eventJSON, err := json.Marshal(userLoginAttemptOutcome)
+	ctx, span := b.Tracer.Start(ctx, "sendUserLoginAttemptOutcome", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(userLoginAttemptOutcome)
 	if err != nil {
-		fmt.Printf("failed to marshal event: %v", err)
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to marshal event: %v", err)
 	}
 
-	messageStoreEntry := ms.Entry{}
-	messageStoreEntry.Key = []byte(userLoginAttemptOutcome.UserName)
-	messageStoreEntry.Value = []byte(eventJSON)
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, userLoginAttemptOutcome.UserName, codec, payload)
 
 	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
 	if err != nil {
-		fmt.Printf("SaveEntry for topic '%s' failed: %v", topic, err)
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "SaveEntry for topic '%s' failed: %v", topic, err)
 	}
-	fmt.Println("saved UserLoginAttemptOutcome to topic ", topic, " at offset ", offset)
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved UserLoginAttemptOutcome to topic ", topic, " at offset ", offset)
 }
 
 // pollUserLoginAttemptOutcome polls the topic for user login attempt outcomes
 func (b *Backend) pollUserLoginAttemptOutcome(ctx context.Context) {
 
-	topic := USER_LOGIN_ATTEMPT_OUTCOME_TOPIC
-	offset := int64(-1)
-	pollDuration := 100 * time.Millisecond
-
-	// Loop until the context is canceled
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Polling canceled")
-			return
-		default:
-			//entry, err := b.MessageStore.ReadEntry(topic, offset)
-			entry, err := b.MessageStore.PollForNextEntry(topic, offset, pollDuration)
-			if err != nil {
-				fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned error: ", err)
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-			}
-			if entry == nil {
-				// do nothing - as this just means there are no unread entries in the topic
-
-				//fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned nil entry.Value")
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-				continue // Continue to the next iteration of the loop
-			}
-			fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned an entry")
-			offset++
-			b.processEntryFromPollUserLoginAttemptOutcome(*entry)
-		}
-	}
+	b.pollTopic(ctx, USER_LOGIN_ATTEMPT_OUTCOME_CONSUMER, USER_LOGIN_ATTEMPT_OUTCOME_TOPIC, b.processEntryFromPollUserLoginAttemptOutcome)
 }
 
 // processEntryFromPollUserLoginAttemptOutcome processes an entry from polling a topic
-func (b *Backend) processEntryFromPollUserLoginAttemptOutcome(entry ms.Entry) {
+func (b *Backend) processEntryFromPollUserLoginAttemptOutcome(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	ctx, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPollUserLoginAttemptOutcome", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", USER_LOGIN_ATTEMPT_OUTCOME_TOPIC)
 
 	var userLoginAttemptOutcome UserLoginAttemptOutcome
-	if err := json.Unmarshal(entry.Value, &userLoginAttemptOutcome); err != nil {
-		fmt.Printf("failed to unmarshal userLoginAttemptOutcome: %v", err)
+	if err := envelope.DecodePayload(&userLoginAttemptOutcome); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal userLoginAttemptOutcome: %v", err)
 	}
-	fmt.Printf("received userLoginAttemptOutcome: %v\n", userLoginAttemptOutcome)
+	fmt.Fprintf(b.Log, "received userLoginAttemptOutcome: %v\n", userLoginAttemptOutcome)
 	if userLoginAttemptOutcome.Outcome {
-		b.generateUserSubjectAccessAttempt(userLoginAttemptOutcome.UserName)
+		session, err := b.startUserSession(ctx, userLoginAttemptOutcome.UserName)
+		if err != nil {
+			span.RecordError(err)
+			fmt.Fprintf(b.Log, "failed to start session: %v\n", err)
+			return
+		}
+		accessesPerLogin := 1
+		if persona, ok := b.Personas.Find(userLoginAttemptOutcome.UserName); ok && persona.AccessesPerLogin > 0 {
+			accessesPerLogin = persona.AccessesPerLogin
+		}
+		for i := 0; i < accessesPerLogin; i++ {
+			b.generateUserSubjectAccessAttempt(ctx, userLoginAttemptOutcome.UserName, session.SessionIdentifier)
+		}
 	}
 }
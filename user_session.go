@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Define constants for user sessions, which a successful login starts, and which expire after the session TTL
+const (
+	DEFAULT_SESSION_TTL     = 2 * time.Minute
+	SESSION_SWEEP_INTERVAL  = 5 * time.Second
+	SESSION_IDENTIFIER_SIZE = 16
+	SESSION_STARTED         = "started"
+	SESSION_ENDED           = "ended"
+	SESSION_EXPIRED         = "expired"
+)
+
+// Define the structure of UserSessionEvent, the start, end or expiry of a session
+type UserSessionEvent struct {
+	SessionIdentifier string    `json:"sessionIdentifier" protobuf:"1"`
+	UserName          string    `json:"userName" protobuf:"2"`
+	Event             string    `json:"event" protobuf:"3"`
+	ExpiresAt         time.Time `json:"expiresAt" protobuf:"4"`
+	Reason            string    `json:"reason,omitempty" protobuf:"5"`
+}
+
+// NewUserSessionEvent creates a new instance of UserSessionEvent
+func NewUserSessionEvent(session UserSession, event, reason string) *UserSessionEvent {
+
+	return &UserSessionEvent{
+		SessionIdentifier: session.SessionIdentifier,
+		UserName:          session.UserName,
+		Event:             event,
+		ExpiresAt:         session.ExpiresAt,
+		Reason:            reason,
+	}
+}
+
+// Define the structure of UserSession, a session which a user's subject access attempts present
+type UserSession struct {
+	SessionIdentifier string    `json:"sessionIdentifier"`
+	UserName          string    `json:"userName"`
+	StartedAt         time.Time `json:"startedAt"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+}
+
+// UserSessions holds the sessions which have started and have not yet ended or expired. the session event topic is the record of
+// sessions, which is read from where it was last read up to before sessions are used, so sessions outlive restarts and are shared
+// by every process using the topics
+type UserSessions struct {
+	mu       sync.Mutex
+	TTL      time.Duration
+	offset   int64
+	sessions map[string]UserSession
+}
+
+// NewUserSessions creates a new instance of UserSessions
+func NewUserSessions(ttl time.Duration) *UserSessions {
+
+	return &UserSessions{
+		TTL:      ttl,
+		sessions: make(map[string]UserSession),
+	}
+}
+
+// apply applies a session event
+func (s *UserSessions) apply(userSessionEvent UserSessionEvent, timestamp time.Time) {
+
+	switch userSessionEvent.Event {
+	case SESSION_STARTED:
+		s.sessions[userSessionEvent.SessionIdentifier] = UserSession{
+			SessionIdentifier: userSessionEvent.SessionIdentifier,
+			UserName:          userSessionEvent.UserName,
+			StartedAt:         timestamp,
+			ExpiresAt:         userSessionEvent.ExpiresAt,
+		}
+	case SESSION_ENDED, SESSION_EXPIRED:
+		delete(s.sessions, userSessionEvent.SessionIdentifier)
+	}
+}
+
+// syncUserSessions applies the session events saved since the topic was last read. the caller holds the sessions' lock
+func (b *Backend) syncUserSessions() {
+
+	s := b.Sessions
+	store := asBatchMessageStore(b.MessageStore)
+	for {
+		entries, err := store.ReadEntries(USER_SESSION_EVENT_TOPIC, s.offset, 100)
+		if err != nil || len(entries) == 0 {
+			// the end of the topic has been reached
+			return
+		}
+		for _, entry := range entries {
+			s.offset++
+			admitted, err := b.admitEntry(USER_SESSION_EVENT_TOPIC, entry)
+			if err != nil {
+				fmt.Fprintln(b.Log, "skipping session event at offset ", s.offset-1, ": ", err)
+				continue
+			}
+			var userSessionEvent UserSessionEvent
+			if err := decodeMessageEnvelope(admitted).DecodePayload(&userSessionEvent); err != nil {
+				fmt.Fprintf(b.Log, "failed to unmarshal userSessionEvent: %v\n", err)
+				continue
+			}
+			s.apply(userSessionEvent, entry.Timestamp)
+		}
+	}
+}
+
+// ActiveUserSessions returns the sessions which have started and have not yet ended or expired, the earliest started first
+func (b *Backend) ActiveUserSessions() []UserSession {
+
+	b.Sessions.mu.Lock()
+	defer b.Sessions.mu.Unlock()
+	b.syncUserSessions()
+
+	sessions := make([]UserSession, 0, len(b.Sessions.sessions))
+	for _, session := range b.Sessions.sessions {
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	return sessions
+}
+
+// startUserSession starts a session for a user who has logged in, which expires after the session TTL
+func (b *Backend) startUserSession(ctx context.Context, userName string) (UserSession, error) {
+
+	sessionIdentifier := make([]byte, SESSION_IDENTIFIER_SIZE)
+	if _, err := rand.Read(sessionIdentifier); err != nil {
+		return UserSession{}, fmt.Errorf("failed to generate session identifier: %v", err)
+	}
+	now := time.Now().UTC()
+	session := UserSession{
+		SessionIdentifier: hex.EncodeToString(sessionIdentifier),
+		UserName:          userName,
+		StartedAt:         now,
+		ExpiresAt:         now.Add(b.Sessions.TTL),
+	}
+	b.publishUserSessionEvent(ctx, *NewUserSessionEvent(session, SESSION_STARTED, ""),
+		fmt.Sprintf("session started, expires at %s", session.ExpiresAt.Format(time.RFC3339)))
+	return session, nil
+}
+
+// validateUserSession returns an error unless a session has started for the user, and has not yet ended or expired
+func (b *Backend) validateUserSession(sessionIdentifier, userName string) error {
+
+	if sessionIdentifier == "" {
+		return fmt.Errorf("no session")
+	}
+
+	b.Sessions.mu.Lock()
+	defer b.Sessions.mu.Unlock()
+	b.syncUserSessions()
+
+	session, ok := b.Sessions.sessions[sessionIdentifier]
+	switch {
+	case !ok:
+		return fmt.Errorf("session unknown, ended or expired")
+	case session.UserName != userName:
+		return fmt.Errorf("session belongs to another user")
+	case !time.Now().Before(session.ExpiresAt):
+		return fmt.Errorf("session expired")
+	}
+	return nil
+}
+
+// endUserSession ends a session before it expires, e.g. when its user logs out
+func (b *Backend) endUserSession(ctx context.Context, sessionIdentifier, reason string) (UserSession, error) {
+
+	b.Sessions.mu.Lock()
+	b.syncUserSessions()
+	session, ok := b.Sessions.sessions[sessionIdentifier]
+	b.Sessions.mu.Unlock()
+	if !ok {
+		return UserSession{}, fmt.Errorf("session '%s' is unknown, or has already ended or expired", sessionIdentifier)
+	}
+	b.publishUserSessionEvent(ctx, *NewUserSessionEvent(session, SESSION_ENDED, reason), "session ended, reason: "+reason)
+	return session, nil
+}
+
+// sweepUserSessions publishes the expiry of each session once its expiry time has passed, until the context is canceled
+func (b *Backend) sweepUserSessions(ctx context.Context) {
+
+	ticker := time.NewTicker(SESSION_SWEEP_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.expireUserSessions(ctx, now)
+		}
+	}
+}
+
+// expireUserSessions publishes the expiry of each session whose expiry time has passed by a time. the expiry is applied when the topic
+// is next read, so a session is only expired once
+func (b *Backend) expireUserSessions(ctx context.Context, now time.Time) {
+
+	var expired []UserSession
+	b.Sessions.mu.Lock()
+	b.syncUserSessions()
+	for _, session := range b.Sessions.sessions {
+		if !now.Before(session.ExpiresAt) {
+			expired = append(expired, session)
+		}
+	}
+	b.Sessions.mu.Unlock()
+
+	for _, session := range expired {
+		b.publishUserSessionEvent(ctx, *NewUserSessionEvent(session, SESSION_EXPIRED, ""), "session expired")
+	}
+}
+
+// publishUserSessionEvent sends a session event, and records it as a system audit event
+func (b *Backend) publishUserSessionEvent(ctx context.Context, userSessionEvent UserSessionEvent, auditEvent string) {
+
+	b.sendUserSessionEvent(ctx, userSessionEvent)
+	systemAuditEvent := NewSystemAuditEventWithSession(userSessionEvent.UserName, userSessionEvent.SessionIdentifier, auditEvent)
+	b.sendSystemAuditEvent(ctx, *systemAuditEvent)
+}
+
+// sendUserSessionEvent sends a user session event to a topic
+func (b *Backend) sendUserSessionEvent(ctx context.Context, userSessionEvent UserSessionEvent) {
+
+	topic := USER_SESSION_EVENT_TOPIC
+
+	ctx, span := b.Tracer.Start(ctx, "sendUserSessionEvent", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+	span.SetAttribute("session.event", userSessionEvent.Event)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(userSessionEvent)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to marshal event: %v", err)
+	}
+
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, userSessionEvent.UserName, codec, payload)
+
+	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "SaveEntry for topic '%s' failed: %v", topic, err)
+	}
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved userSessionEvent to topic ", topic, " at offset ", offset)
+}
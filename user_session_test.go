@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// newSessionTestBackend creates a backend whose store is in memory
+func newSessionTestBackend(store *memoryMessageStore) *Backend {
+
+	b := NewBackend()
+	b.Log = io.Discard
+	b.MessageStore = store
+	return b
+}
+
+// readUserSessionEvents returns the session events saved to the topic
+func readUserSessionEvents(t *testing.T, b *Backend) []UserSessionEvent {
+
+	t.Helper()
+	var userSessionEvents []UserSessionEvent
+	for offset := int64(0); offset < topicLength(b.MessageStore, USER_SESSION_EVENT_TOPIC); offset++ {
+		entry, err := b.MessageStore.ReadEntry(USER_SESSION_EVENT_TOPIC, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var userSessionEvent UserSessionEvent
+		if err := decodeMessageEnvelope(*entry).DecodePayload(&userSessionEvent); err != nil {
+			t.Fatal(err)
+		}
+		userSessionEvents = append(userSessionEvents, userSessionEvent)
+	}
+	return userSessionEvents
+}
+
+// TestValidateUserSession checks a session is only valid for its user until it ends or expires
+func TestValidateUserSession(t *testing.T) {
+
+	b := newSessionTestBackend(newMemoryMessageStore())
+	ctx := context.Background()
+	session, err := b.startUserSession(ctx, "jbloggs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ended, err := b.startUserSession(ctx, "jbloggs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.endUserSession(ctx, ended.SessionIdentifier, "logged out"); err != nil {
+		t.Fatal(err)
+	}
+	b.Sessions.TTL = -time.Second
+	expired, err := b.startUserSession(ctx, "jbloggs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name              string
+		sessionIdentifier string
+		userName          string
+		err               string
+	}{
+		{"valid", session.SessionIdentifier, "jbloggs", ""},
+		{"no session", "", "jbloggs", "no session"},
+		{"unknown", "0123456789abcdef0123456789abcdef", "jbloggs", "session unknown, ended or expired"},
+		{"other user", session.SessionIdentifier, "asmith", "session belongs to another user"},
+		{"ended", ended.SessionIdentifier, "jbloggs", "session unknown, ended or expired"},
+		{"expired, not yet swept", expired.SessionIdentifier, "jbloggs", "session expired"},
+	}
+	for _, test := range tests {
+		err := b.validateUserSession(test.sessionIdentifier, test.userName)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v, want the session valid", test.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	b.expireUserSessions(ctx, time.Now())
+	if err := b.validateUserSession(expired.SessionIdentifier, "jbloggs"); err == nil || err.Error() != "session unknown, ended or expired" {
+		t.Errorf("expired, swept: error %v, want the session unknown", err)
+	}
+	if _, err := b.endUserSession(ctx, ended.SessionIdentifier, "logged out again"); err == nil {
+		t.Error("ended a session which had already ended")
+	}
+}
+
+// TestUserSessionsResyncFromOffset checks sessions are read from where the topic was last read, so a process sees the sessions
+// started and ended by another, and events already applied are not applied again
+func TestUserSessionsResyncFromOffset(t *testing.T) {
+
+	store := newMemoryMessageStore()
+	b := newSessionTestBackend(store)
+	other := newSessionTestBackend(store)
+	ctx := context.Background()
+
+	session, err := b.startUserSession(ctx, "jbloggs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.validateUserSession(session.SessionIdentifier, "jbloggs"); err != nil {
+		t.Fatalf("session started by another process: %v", err)
+	}
+	if other.Sessions.offset != 1 {
+		t.Errorf("offset %d, want the 1 event read", other.Sessions.offset)
+	}
+
+	// an event read already is not applied again, so a session removed since stays removed until a later event restores it
+	delete(other.Sessions.sessions, session.SessionIdentifier)
+	if sessions := other.ActiveUserSessions(); len(sessions) != 0 {
+		t.Errorf("sessions %+v, want the events read already not applied again", sessions)
+	}
+
+	later, err := b.startUserSession(ctx, "asmith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessions := other.ActiveUserSessions(); len(sessions) != 1 || sessions[0].SessionIdentifier != later.SessionIdentifier {
+		t.Errorf("sessions %+v, want only the session started since", sessions)
+	}
+	if _, err := other.endUserSession(ctx, later.SessionIdentifier, "logged out"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.validateUserSession(later.SessionIdentifier, "asmith"); err == nil {
+		t.Error("session ended by another process still valid")
+	}
+	if sessions := other.ActiveUserSessions(); len(sessions) != 0 {
+		t.Errorf("sessions %+v, want the session ended", sessions)
+	}
+	if b.Sessions.offset != 3 || other.Sessions.offset != 3 {
+		t.Errorf("offsets %d and %d, want every event read by both", b.Sessions.offset, other.Sessions.offset)
+	}
+}
+
+// TestExpireUserSessions checks a sweep publishes and audits the expiry of each session whose expiry time has passed, exactly once
+func TestExpireUserSessions(t *testing.T) {
+
+	b := newSessionTestBackend(newMemoryMessageStore())
+	ctx := context.Background()
+	session, err := b.startUserSession(ctx, "jbloggs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Sessions.TTL = time.Hour
+	unexpired, err := b.startUserSession(ctx, "asmith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := topicLength(b.MessageStore, SYSTEM_AUDIT_EVENT_TOPIC)
+
+	b.expireUserSessions(ctx, session.ExpiresAt.Add(-time.Second))
+	if length := topicLength(b.MessageStore, USER_SESSION_EVENT_TOPIC); length != 2 {
+		t.Errorf("%d session events, want no expiry before the expiry time", length)
+	}
+	for i := 0; i < 3; i++ {
+		b.expireUserSessions(ctx, session.ExpiresAt)
+	}
+
+	userSessionEvents := readUserSessionEvents(t, b)
+	if len(userSessionEvents) != 3 {
+		t.Fatalf("%d session events, want 3", len(userSessionEvents))
+	}
+	if expiry := userSessionEvents[2]; expiry.Event != SESSION_EXPIRED || expiry.SessionIdentifier != session.SessionIdentifier ||
+		expiry.UserName != "jbloggs" || !expiry.ExpiresAt.Equal(session.ExpiresAt) {
+		t.Errorf("session event %+v, want the expiry of jbloggs's session", expiry)
+	}
+	systemAuditEvents := readSystemAuditEvents(t, b, from)
+	if len(systemAuditEvents) != 1 || systemAuditEvents[0].AuditEvent != "session expired" ||
+		systemAuditEvents[0].UserName != "jbloggs" || systemAuditEvents[0].SessionIdentifier != session.SessionIdentifier {
+		t.Errorf("audit events %+v, want one recording the expiry", systemAuditEvents)
+	}
+	if err := b.validateUserSession(unexpired.SessionIdentifier, "asmith"); err != nil {
+		t.Errorf("session not yet expired: %v", err)
+	}
+}
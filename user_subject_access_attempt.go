@@ -2,39 +2,65 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math/rand"
-	"time"
 
 	ms "github.com/mmcnicol/message-store"
 )
 
 // Define the structure of UserSubjectAccessAttempt
 type UserSubjectAccessAttempt struct {
-	UserName          string `json:"userName"`
-	SubjectIdentifier string `json:"subjectIdentifier"`
+	UserName          string `json:"userName" protobuf:"1"`
+	SubjectIdentifier string `json:"subjectIdentifier" protobuf:"2" encrypt:"true"`
+	SessionIdentifier string `json:"sessionIdentifier" protobuf:"3"`
 }
 
 // NewUserSubjectAccessAttempt creates a new instance of UserSubjectAccessAttempt
-func NewUserSubjectAccessAttempt(userName, subjectIdentifier string) *UserSubjectAccessAttempt {
+func NewUserSubjectAccessAttempt(userName, subjectIdentifier, sessionIdentifier string) *UserSubjectAccessAttempt {
 
 	return &UserSubjectAccessAttempt{
 		UserName:          userName,
 		SubjectIdentifier: subjectIdentifier,
+		SessionIdentifier: sessionIdentifier,
 	}
 }
 
-// generateUserSubjectAccessAttempt generates a user subject access attempt
-func (b *Backend) generateUserSubjectAccessAttempt(userName string) {
+// Validate checks that a UserSubjectAccessAttempt has every required field
+func (u UserSubjectAccessAttempt) Validate() error {
 
-	userSubjectAccessAttempt := NewUserSubjectAccessAttempt(userName, b.generateRandomSubjectIdentifier(10))
-	b.sendUserSubjectAccessAttempt(*userSubjectAccessAttempt)
+	if u.UserName == "" {
+		return fmt.Errorf("userName is required")
+	}
+	if u.SessionIdentifier == "" {
+		return fmt.Errorf("sessionIdentifier is required")
+	}
+	return validateSubjectIdentifier(u.SubjectIdentifier)
+}
+
+// generateUserSubjectAccessAttempt generates a user subject access attempt, made within a session of the user
+func (b *Backend) generateUserSubjectAccessAttempt(ctx context.Context, userName, sessionIdentifier string) {
+
+	ctx, span := b.Tracer.Start(ctx, "generateUserSubjectAccessAttempt", SPAN_KIND_INTERNAL)
+	defer span.End()
+
+	subjectIdentifier := b.generateRandomSubjectIdentifier(10)
+	if subject, ok := b.Population.Pick(); ok {
+		subjectIdentifier = subject.SubjectIdentifier
+	}
+	if persona, ok := b.Personas.Find(userName); ok && len(persona.Subjects) > 0 {
+		subjectIdentifier = persona.Subjects[rand.Intn(len(persona.Subjects))]
+	}
+
+	userSubjectAccessAttempt := NewUserSubjectAccessAttempt(userName, subjectIdentifier, sessionIdentifier)
+	if err := b.sendUserSubjectAccessAttempt(ctx, *userSubjectAccessAttempt); err != nil {
+		fmt.Fprintln(b.Log, err)
+	}
 	systemAuditEvent := NewSystemAuditEventWithSubject(userSubjectAccessAttempt.UserName, userSubjectAccessAttempt.SubjectIdentifier, "login attempt")
-	b.sendSystemAuditEvent(*systemAuditEvent)
+	systemAuditEvent.SessionIdentifier = sessionIdentifier
+	b.sendSystemAuditEvent(ctx, *systemAuditEvent)
 }
 
-// generateRandomSubjectIdentifier generates a random subject identifier consisting of digits 0 to 9
+// generateRandomSubjectIdentifier generates a random subject identifier consisting of digits 0 to 9, for when there is no population
 func (b *Backend) generateRandomSubjectIdentifier(subjectIdentifierLength int) string {
 
 	// Define the character set
@@ -50,79 +76,91 @@ func (b *Backend) generateRandomSubjectIdentifier(subjectIdentifierLength int) s
 	return string(subjectIdentifier)
 }
 
+// validateSubjectIdentifier checks that a subject identifier consists of 10 digits
+func validateSubjectIdentifier(subjectIdentifier string) error {
+
+	if len(subjectIdentifier) != 10 {
+		return fmt.Errorf("subjectIdentifier must be 10 digits")
+	}
+	for _, c := range subjectIdentifier {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("subjectIdentifier must be 10 digits")
+		}
+	}
+	return nil
+}
+
 // sendUserSubjectAccessAttempt sends a user subject access attempt to a topic
-func (b *Backend) sendUserSubjectAccessAttempt(userSubjectAccessAttempt UserSubjectAccessAttempt) {
+func (b *Backend) sendUserSubjectAccessAttempt(ctx context.Context, userSubjectAccessAttempt UserSubjectAccessAttempt) error {
 
 	topic := USER_SUBJECT_ACCESS_ATTEMPT_TOPIC
 
-	eventJSON, err := json.Marshal(userSubjectAccessAttempt)
+	ctx, span := b.Tracer.Start(ctx, "sendUserSubjectAccessAttempt", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(userSubjectAccessAttempt)
 	if err != nil {
-		fmt.Printf("failed to marshal event: %v", err)
+		span.RecordError(err)
+		return fmt.Errorf("failed to marshal userSubjectAccessAttempt: %v", err)
 	}
 
-	messageStoreEntry := ms.Entry{}
-	messageStoreEntry.Key = []byte(userSubjectAccessAttempt.UserName)
-	messageStoreEntry.Value = []byte(eventJSON)
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, userSubjectAccessAttempt.UserName, codec, payload)
 
 	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
 	if err != nil {
-		fmt.Printf("SaveEntry for topic '%s' failed: %v", topic, err)
+		span.RecordError(err)
+		return fmt.Errorf("SaveEntry for topic '%s' failed: %v", topic, err)
 	}
-	fmt.Println("saved userSubjectAccessAttempt to topic ", topic, " at offset ", offset)
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved userSubjectAccessAttempt to topic ", topic, " at offset ", offset)
+	return nil
 }
 
 // pollUserSubjectAccessAttempt polls the topic for user subject access attempts
 func (b *Backend) pollUserSubjectAccessAttempt(ctx context.Context) {
 
-	topic := USER_SUBJECT_ACCESS_ATTEMPT_TOPIC
-	offset := int64(-1)
-	pollDuration := 100 * time.Millisecond
-
-	// Loop until the context is canceled
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Polling canceled")
-			return
-		default:
-			//entry, err := b.MessageStore.ReadEntry(topic, offset)
-			entry, err := b.MessageStore.PollForNextEntry(topic, offset, pollDuration)
-			if err != nil {
-				// do nothing - as this just means there are no unread entries in the topic
-				//fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned error: ", err)
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-			}
-			if entry == nil {
-				//fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned nil entry.Value")
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-				continue // Continue to the next iteration of the loop
-			}
-			fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned an entry")
-			offset++
-			b.processEntryFromPollUserSubjectAccessAttempt(*entry)
-		}
-	}
+	b.pollTopic(ctx, USER_SUBJECT_ACCESS_ATTEMPT_CONSUMER, USER_SUBJECT_ACCESS_ATTEMPT_TOPIC, b.processEntryFromPollUserSubjectAccessAttempt)
 }
 
 // processEntryFromPollUserSubjectAccessAttempt processes an entry from polling a topic
-func (b *Backend) processEntryFromPollUserSubjectAccessAttempt(entry ms.Entry) {
+func (b *Backend) processEntryFromPollUserSubjectAccessAttempt(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	ctx, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPollUserSubjectAccessAttempt", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", USER_SUBJECT_ACCESS_ATTEMPT_TOPIC)
 
 	var userSubjectAccessAttempt UserSubjectAccessAttempt
-	if err := json.Unmarshal(entry.Value, &userSubjectAccessAttempt); err != nil {
-		fmt.Printf("failed to unmarshal userSubjectAccessAttempt: %v", err)
+	if err := envelope.DecodePayload(&userSubjectAccessAttempt); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal userSubjectAccessAttempt: %v", err)
 	}
-	fmt.Printf("Received userSubjectAccessAttempt: %v\n", userSubjectAccessAttempt)
+	fmt.Fprintf(b.Log, "Received userSubjectAccessAttempt: %v\n", userSubjectAccessAttempt)
 
-	b.processUserSubjectAccessAttempt(userSubjectAccessAttempt)
+	b.processUserSubjectAccessAttempt(ctx, userSubjectAccessAttempt)
 }
 
-// processUserSubjectAccessAttempt processes a user subject access attempt
-func (b *Backend) processUserSubjectAccessAttempt(userSubjectAccessAttempt UserSubjectAccessAttempt) {
+// processUserSubjectAccessAttempt processes a user subject access attempt, which is rejected unless it presents a valid session of the user
+func (b *Backend) processUserSubjectAccessAttempt(ctx context.Context, userSubjectAccessAttempt UserSubjectAccessAttempt) {
+
+	ctx, span := b.Tracer.Start(ctx, "processUserSubjectAccessAttempt", SPAN_KIND_INTERNAL)
+	defer span.End()
+
+	if err := b.validateUserSession(userSubjectAccessAttempt.SessionIdentifier, userSubjectAccessAttempt.UserName); err != nil {
+		span.RecordError(err)
+		span.SetAttribute("subject.access.outcome", false)
+		b.sendUserSubjectAccessAttemptOutcome(ctx, *NewUserSubjectAccessAttemptOutcome(userSubjectAccessAttempt.UserName, userSubjectAccessAttempt.SubjectIdentifier, false))
+		systemAuditEvent := NewSystemAuditEventWithSubject(userSubjectAccessAttempt.UserName, userSubjectAccessAttempt.SubjectIdentifier,
+			fmt.Sprintf("user subject access attempt rejected, %v", err))
+		systemAuditEvent.SessionIdentifier = userSubjectAccessAttempt.SessionIdentifier
+		b.sendSystemAuditEvent(ctx, *systemAuditEvent)
+		return
+	}
 
-	userSubjectAccessAttemptOutcome := NewUserSubjectAccessAttemptOutcome(userSubjectAccessAttempt.UserName, userSubjectAccessAttempt.SubjectIdentifier, b.getUserSubjectAccessAttemptOutcome())
-	b.sendUserSubjectAccessAttemptOutcome(*userSubjectAccessAttemptOutcome)
+	userSubjectAccessAttemptOutcome := NewUserSubjectAccessAttemptOutcome(userSubjectAccessAttempt.UserName, userSubjectAccessAttempt.SubjectIdentifier, b.getUserSubjectAccessAttemptOutcome(userSubjectAccessAttempt))
+	b.sendUserSubjectAccessAttemptOutcome(ctx, *userSubjectAccessAttemptOutcome)
 
 	var auditEvent string
 	if userSubjectAccessAttemptOutcome.Outcome {
@@ -130,6 +168,8 @@ func (b *Backend) processUserSubjectAccessAttempt(userSubjectAccessAttempt UserS
 	} else {
 		auditEvent = "user subject access attempt failed"
 	}
+	span.SetAttribute("subject.access.outcome", userSubjectAccessAttemptOutcome.Outcome)
 	systemAuditEvent := NewSystemAuditEventWithSubject(userSubjectAccessAttempt.UserName, userSubjectAccessAttempt.SubjectIdentifier, auditEvent)
-	b.sendSystemAuditEvent(*systemAuditEvent)
+	systemAuditEvent.SessionIdentifier = userSubjectAccessAttempt.SessionIdentifier
+	b.sendSystemAuditEvent(ctx, *systemAuditEvent)
 }
@@ -2,19 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math/rand"
-	"time"
 
 	ms "github.com/mmcnicol/message-store"
 )
 
 // Define the structure of UserSubjectAccessAttemptOutcome
 type UserSubjectAccessAttemptOutcome struct {
-	UserName          string `json:"userName"`
-	SubjectIdentifier string `json:"subjectIdentifier"`
-	Outcome           bool   `json:"outcome"`
+	UserName          string `json:"userName" protobuf:"1"`
+	SubjectIdentifier string `json:"subjectIdentifier" protobuf:"2" encrypt:"true"`
+	Outcome           bool   `json:"outcome" protobuf:"3"`
 }
 
 // NewUserSubjectAccessAttemptOutcome creates a new instance of UserSubjectAccessAttemptOutcome
@@ -27,8 +25,12 @@ func NewUserSubjectAccessAttemptOutcome(userName string, subjectIdentifier strin
 	}
 }
 
-// getUserSubjectAccessAttemptOutcome returns a user subject access attempt outcome
-func (b *Backend) getUserSubjectAccessAttemptOutcome() bool {
+// getUserSubjectAccessAttemptOutcome returns a user subject access attempt outcome, using the access grant rate of a known persona
+func (b *Backend) getUserSubjectAccessAttemptOutcome(userSubjectAccessAttempt UserSubjectAccessAttempt) bool {
+
+	if persona, ok := b.Personas.Find(userSubjectAccessAttempt.UserName); ok && persona.AccessGrantRate != nil {
+		return rand.Float64() < *persona.AccessGrantRate
+	}
 
 	// Generate a random number between 0 and 99
 	randomNumber := rand.Intn(100)
@@ -38,91 +40,73 @@ func (b *Backend) getUserSubjectAccessAttemptOutcome() bool {
 }
 
 // sendUserSubjectAccessAttemptOutcome sends a user subject access attempt outcome to a topic
-func (b *Backend) sendUserSubjectAccessAttemptOutcome(userSubjectAccessAttemptOutcome UserSubjectAccessAttemptOutcome) {
+func (b *Backend) sendUserSubjectAccessAttemptOutcome(ctx context.Context, userSubjectAccessAttemptOutcome UserSubjectAccessAttemptOutcome) {
 
 	topic := USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC
 
-	eventJSON, err := json.Marshal(userSubjectAccessAttemptOutcome)
+	ctx, span := b.Tracer.Start(ctx, "sendUserSubjectAccessAttemptOutcome", SPAN_KIND_PRODUCER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", topic)
+
+	codec := b.Codecs.For(topic)
+	payload, err := codec.Marshal(userSubjectAccessAttemptOutcome)
 	if err != nil {
-		fmt.Printf("failed to marshal event: %v", err)
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to marshal event: %v", err)
 	}
 
-	messageStoreEntry := ms.Entry{}
-	messageStoreEntry.Key = []byte(userSubjectAccessAttemptOutcome.UserName)
-	messageStoreEntry.Value = []byte(eventJSON)
+	messageStoreEntry := newMessageStoreEntry(ctx, topic, userSubjectAccessAttemptOutcome.UserName, codec, payload)
 
 	offset, err := b.MessageStore.SaveEntry(topic, messageStoreEntry)
 	if err != nil {
-		fmt.Printf("SaveEntry for topic '%s' failed: %v", topic, err)
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "SaveEntry for topic '%s' failed: %v", topic, err)
 	}
-	fmt.Println("saved UserSubjectAccessAttemptOutcome to topic ", topic, " at offset ", offset)
+	span.SetAttribute("messaging.message.offset", offset)
+	fmt.Fprintln(b.Log, "saved UserSubjectAccessAttemptOutcome to topic ", topic, " at offset ", offset)
 }
 
 // pollUserSubjectAccessAttemptOutcome polls the topic for user subject access attempt outcomes
 func (b *Backend) pollUserSubjectAccessAttemptOutcome(ctx context.Context) {
 
-	topic := USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC
-	offset := int64(-1)
-	pollDuration := 100 * time.Millisecond
-
-	// Loop until the context is canceled
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Polling canceled")
-			return
-		default:
-			//entry, err := b.MessageStore.ReadEntry(topic, offset)
-			entry, err := b.MessageStore.PollForNextEntry(topic, offset, pollDuration)
-			if err != nil {
-				fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned error: ", err)
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-			}
-			if entry == nil {
-				// do nothing - as this just means there are no unread entries in the topic
-
-				//fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned nil entry.Value")
-				// Wait for a short duration
-				time.Sleep(500 * time.Millisecond)
-				continue // Continue to the next iteration of the loop
-			}
-			fmt.Println("PollForNextEntry for topic '", topic, "', offset ", offset, ", returned an entry")
-			offset++
-			b.processEntryFromPollUserSubjectAccessAttemptOutcome(*entry)
-		}
-	}
+	b.pollTopic(ctx, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_CONSUMER, USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC, b.processEntryFromPollUserSubjectAccessAttemptOutcome)
 }
 
 // processEntryFromPollUserSubjectAccessAttemptOutcome processes an entry from polling a topic
-func (b *Backend) processEntryFromPollUserSubjectAccessAttemptOutcome(entry ms.Entry) {
+func (b *Backend) processEntryFromPollUserSubjectAccessAttemptOutcome(ctx context.Context, entry ms.Entry) {
+
+	envelope := decodeMessageEnvelope(entry)
+	ctx, span := b.Tracer.StartFromHeaders(ctx, envelope.Headers, "processEntryFromPollUserSubjectAccessAttemptOutcome", SPAN_KIND_CONSUMER)
+	defer span.End()
+	span.SetAttribute("messaging.destination.name", USER_SUBJECT_ACCESS_ATTEMPT_OUTCOME_TOPIC)
 
 	var userSubjectAccessAttemptOutcome UserSubjectAccessAttemptOutcome
-	if err := json.Unmarshal(entry.Value, &userSubjectAccessAttemptOutcome); err != nil {
-		fmt.Printf("failed to unmarshal userSubjectAccessAttemptOutcome: %v", err)
+	if err := envelope.DecodePayload(&userSubjectAccessAttemptOutcome); err != nil {
+		span.RecordError(err)
+		fmt.Fprintf(b.Log, "failed to unmarshal userSubjectAccessAttemptOutcome: %v", err)
 	}
-	fmt.Printf("received userSubjectAccessAttemptOutcome: %v\n", userSubjectAccessAttemptOutcome)
+	fmt.Fprintf(b.Log, "received userSubjectAccessAttemptOutcome: %v\n", userSubjectAccessAttemptOutcome)
 	if userSubjectAccessAttemptOutcome.Outcome {
-		regions := []int{
-			AYRSHIRE_AND_ARRAN_REGION,
-			BORDERS_REGION,
-			DUMFRIES_AND_GALLOWAY_REGION,
-			FIFE_REGION,
-			FORTH_VALLEY_REGION,
-			GRAMPIAN_REGION,
-			GREATER_GLASGOW_AND_CYLDE_REGION,
-			HIGHLAND_REGION,
-			LOTHIAN_REGION,
-			LANARKSHIRE_REGION,
-			ORKNEY_REGION,
-			SHETLAND_REGION,
-			TAYSIDE_REGION,
-			WESTERN_ISLES_REGION,
+		// Consult the record seals first, skipping the regions whose records of the subject are sealed as a whole
+		for _, region := range allRegions() {
+			if seal, sealed := b.Consent.SealedRegion(userSubjectAccessAttemptOutcome.SubjectIdentifier, region); sealed {
+				auditEvent := fmt.Sprintf("region %s skipped, records sealed, reason code: %s", regionName(region), seal.ReasonCode)
+				b.sendSystemAuditEvent(ctx, *NewSystemAuditEventWithSubject(userSubjectAccessAttemptOutcome.UserName, userSubjectAccessAttemptOutcome.SubjectIdentifier, auditEvent))
+			}
+		}
+		// the same regions the consolidator expects a response from
+		regions := b.Consent.UnsealedRegions(userSubjectAccessAttemptOutcome.SubjectIdentifier, allRegions())
+		if len(regions) == 0 {
+			// there is no region to request documents from, so the subject has none to share
+			b.sendSubjectDocuments(ctx, *NewSubjectDocuments(userSubjectAccessAttemptOutcome.SubjectIdentifier, nil, userSubjectAccessAttemptOutcome.UserName, 0))
+			return
 		}
 
+		var subjectRegionDocumentRequests []SubjectRegionDocumentRequest
 		for _, region := range regions {
 			subjectRegionDocumentRequest := NewSubjectRegionDocumentRequest(userSubjectAccessAttemptOutcome.SubjectIdentifier, region, userSubjectAccessAttemptOutcome.UserName)
-			b.sendSubjectRegionDocumentRequest(*subjectRegionDocumentRequest)
+			subjectRegionDocumentRequests = append(subjectRegionDocumentRequests, *subjectRegionDocumentRequest)
 		}
+		b.sendSubjectRegionDocumentRequests(ctx, subjectRegionDocumentRequests)
 	}
 }